@@ -0,0 +1,74 @@
+package clone
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/pktline"
+)
+
+// RefAdvertisement is the list of refs the server sends at the start of a protocol v0/v1
+// conversation, along with the capabilities it supports
+type RefAdvertisement struct {
+	Refs         []GitRef
+	Capabilities Capabilities
+}
+
+// ParseRefAdvertisement reads the ref advertisement of the upload-pack service
+//
+//	001e# service=git-upload-pack\n   (only over smart HTTP)
+//	0000
+//	<len><hash> HEAD\0<capabilities>\n
+//	<len><hash> refs/heads/main\n
+//	0000
+//
+// An empty repository advertises the capabilities on a `capabilities^{}` line with a
+// zero hash instead of a ref.
+func ParseRefAdvertisement(r io.Reader) (*RefAdvertisement, error) {
+	reader := pktline.NewReader(r)
+	advertisement := &RefAdvertisement{}
+	first := true
+	for {
+		packetType, line, err := reader.ReadLine()
+		if errors.Is(err, io.EOF) {
+			if first {
+				return nil, fmt.Errorf("parse ref advertisement: empty response")
+			}
+			return nil, fmt.Errorf("parse ref advertisement: missing flush-pkt")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse ref advertisement: %w", err)
+		}
+		if packetType != pktline.Data {
+			// the flush-pkt after the service line comes before any ref
+			if first {
+				continue
+			}
+			return advertisement, nil
+		}
+		if strings.HasPrefix(line, "# service=") || line == "version 1" {
+			continue
+		}
+		if strings.HasPrefix(line, "ERR ") {
+			return nil, fmt.Errorf("remote error: %s", strings.TrimPrefix(line, "ERR "))
+		}
+
+		refLine := line
+		if first {
+			var capabilities string
+			refLine, capabilities, _ = strings.Cut(line, "\x00")
+			advertisement.Capabilities = strings.Fields(capabilities)
+			first = false
+		}
+		hash, name, found := strings.Cut(refLine, " ")
+		if !found || len(hash) != 40 || name == "" {
+			return nil, fmt.Errorf("parse ref advertisement: malformed ref line %q", line)
+		}
+		if name == "capabilities^{}" {
+			continue
+		}
+		advertisement.Refs = append(advertisement.Refs, GitRef{Hash: hash, Name: name})
+	}
+}
@@ -0,0 +1,119 @@
+package clone
+
+import "strings"
+
+// Version is the version of mygit, sent to the server in the agent capability
+const Version = "0.1.0"
+
+// agent identifies the client to the server
+const agent = "mygit/" + Version
+
+// Capabilities are the capabilities of the server (or the ones requested by the client),
+// either a plain name like `ofs-delta` or a `name=value` pair like `agent=git/2.43.0`
+type Capabilities []string
+
+// Has reports whether the capability is in the list, with or without a value
+func (c Capabilities) Has(name string) bool {
+	_, found := c.Value(name)
+	return found
+}
+
+// Value returns the value of the `name=value` capability, the value is empty for a
+// capability without one
+func (c Capabilities) Value(name string) (string, bool) {
+	for _, capability := range c {
+		key, value, _ := strings.Cut(capability, "=")
+		if key == name {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// Values returns the values of a capability which can be given more than once,
+// like `symref=HEAD:refs/heads/main`
+func (c Capabilities) Values(name string) []string {
+	var values []string
+	for _, capability := range c {
+		if key, value, _ := strings.Cut(capability, "="); key == name {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// NegotiationOptions are the choices of the client which change the capabilities it asks for
+type NegotiationOptions struct {
+	// NoProgress asks the server not to send the progress messages
+	NoProgress bool
+	// Shallow is needed when the repository is shallow or the fetch deepens the history
+	Shallow bool
+	// DeepenSince and DeepenNot are needed for these limits of a shallow fetch
+	DeepenSince bool
+	DeepenNot   bool
+	// Filter is needed for a partial clone
+	Filter bool
+}
+
+// NegotiateCapabilities picks the capabilities the client asks for out of the ones the
+// server advertised, in the order git sends them
+//
+// The client understands ofs-delta, side-band-64k (or side-band), thin-pack, include-tag
+// and multi_ack_detailed, the shallow and filter capabilities are only asked for when
+// needed. The agent is always sent, as servers accept it even without advertising it.
+func NegotiateCapabilities(server Capabilities, opts NegotiationOptions) Capabilities {
+	var negotiated Capabilities
+	if server.Has("multi_ack_detailed") {
+		negotiated = append(negotiated, "multi_ack_detailed")
+	}
+	if server.Has("side-band-64k") {
+		negotiated = append(negotiated, "side-band-64k")
+	} else if server.Has("side-band") {
+		negotiated = append(negotiated, "side-band")
+	}
+	// these are arguments of the protocol v2 fetch command, which every v2 server
+	// understands without advertising them
+	v2 := server.Has("fetch")
+	for _, name := range []string{"thin-pack", "no-progress", "include-tag", "ofs-delta"} {
+		if name == "no-progress" && !opts.NoProgress {
+			continue
+		}
+		if v2 || server.Has(name) {
+			negotiated = append(negotiated, name)
+		}
+	}
+	if opts.Shallow && server.Has("shallow") {
+		negotiated = append(negotiated, "shallow")
+	}
+	if opts.DeepenSince && server.Has("deepen-since") {
+		negotiated = append(negotiated, "deepen-since")
+	}
+	if opts.DeepenNot && server.Has("deepen-not") {
+		negotiated = append(negotiated, "deepen-not")
+	}
+	negotiated = append(negotiated, "agent="+agent)
+	if opts.Filter && server.Has("filter") {
+		negotiated = append(negotiated, "filter")
+	}
+	return negotiated
+}
+
+// UniqueWants returns the hashes of the refs without duplicates, in the order of the refs
+//
+// HEAD usually has the same hash as the branch it points to, and the peeled entries
+// (`refs/tags/v1^{}`) of the advertisement are not refs of their own.
+func UniqueWants(refs []GitRef) []string {
+	seen := map[string]bool{}
+	var wants []string
+	for _, ref := range refs {
+		if seen[ref.Hash] || strings.HasSuffix(ref.Name, "^{}") || ref.Hash == zeroHash {
+			continue
+		}
+		seen[ref.Hash] = true
+		wants = append(wants, ref.Hash)
+	}
+	return wants
+}
+
+// zeroHash is advertised for the refs which do not exist yet (e.g. an unborn HEAD)
+const zeroHash = "0000000000000000000000000000000000000000"
@@ -0,0 +1,52 @@
+package clone
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CheckFilter validates the object filter of a partial clone, the filters understood by
+// the servers are
+//
+//	blob:none          no blobs
+//	blob:limit=<n>     the blobs smaller than n bytes, n can end with k, m or g
+//	tree:<depth>       the trees up to depth, tree:0 sends the commits alone
+//
+// The objects left out are fetched from the promisor remote when they are needed.
+func CheckFilter(spec string) error {
+	switch kind, arg, _ := strings.Cut(spec, ":"); {
+	case spec == "blob:none":
+		return nil
+	case kind == "blob" && strings.HasPrefix(arg, "limit="):
+		limit := strings.TrimPrefix(arg, "limit=")
+		if limit != "" && strings.ContainsAny(limit[len(limit)-1:], "kmgKMG") {
+			limit = limit[:len(limit)-1]
+		}
+		if _, err := strconv.ParseUint(limit, 10, 64); err == nil {
+			return nil
+		}
+	case kind == "tree":
+		if _, err := strconv.ParseUint(arg, 10, 64); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid filter-spec '%s'", spec)
+}
+
+// checkFilterSupport fails when the server does not filter the objects it sends, which
+// git servers only do with uploadpack.allowFilter set
+func checkFilterSupport(discovery *Discovery, req FetchRequest) error {
+	if req.Filter == "" {
+		return nil
+	}
+	supported := discovery.Capabilities.Has("filter")
+	if discovery.Version == 2 {
+		fetch, _ := discovery.Capabilities.Value("fetch")
+		supported = strings.Contains(" "+fetch+" ", " filter ")
+	}
+	if !supported {
+		return fmt.Errorf("the server does not support filters")
+	}
+	return nil
+}
@@ -1,8 +1,23 @@
 package clone
 
 import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"os"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/common"
 )
 
 // TestDecodeLength tests the packObjectSize function with various scenarios.
@@ -127,13 +142,733 @@ func TestDecodeLength(t *testing.T) {
 	})
 }
 
-func TestReadPackFile(t *testing.T) {
-	content, err := os.ReadFile("../../testdata/pack-response.txt")
+func TestStorePackResponse(t *testing.T) {
+	response, err := os.Open("../../testdata/pack-response.txt")
+	if err != nil {
+		t.Fatalf("error in opening pack response: %v", err)
+	}
+	defer response.Close()
+	packPath, err := StorePack(t.TempDir(), response, io.Discard)
+	if err != nil {
+		t.Fatalf("StorePack() error = %v", err)
+	}
+	stored, err := common.OpenPack(strings.TrimSuffix(packPath, ".pack") + ".idx")
+	if err != nil {
+		t.Fatalf("OpenPack() error = %v", err)
+	}
+	defer stored.Close()
+	// testdata/pack-response.info lists 332 entries
+	if len(stored.Index.Entries) != 332 {
+		t.Errorf("index has %d entries, expected 332", len(stored.Index.Entries))
+	}
+}
+
+func TestStorePack(t *testing.T) {
+	pack, err := os.Open("../../testdata/ofs-delta.pack")
+	if err != nil {
+		t.Fatalf("error in opening packfile: %v", err)
+	}
+	defer pack.Close()
+	dir := t.TempDir()
+
+	packPath, err := StorePack(dir, pack, io.Discard)
+	if err != nil {
+		t.Fatalf("StorePack() error = %v", err)
+	}
+	stored, err := common.OpenPack(strings.TrimSuffix(packPath, ".pack") + ".idx")
+	if err != nil {
+		t.Fatalf("OpenPack() error = %v", err)
+	}
+	defer stored.Close()
+
+	info, err := os.ReadFile("../../testdata/ofs-delta.info")
+	if err != nil {
+		t.Fatalf("error in reading pack info: %v", err)
+	}
+	objects := 0
+	for _, line := range strings.Split(string(info), "\n") {
+		// <hash> <type> <size> <size-in-pack> <offset> [<depth> <base>]
+		fields := strings.Fields(line)
+		if len(fields) < 5 || len(fields[0]) != 40 {
+			continue
+		}
+		objects++
+		hash, offset := fields[0], fields[4]
+		found, ok := stored.Index.Lookup(hash)
+		if !ok || strconv.FormatInt(found, 10) != offset {
+			t.Errorf("Lookup(%s) = %d, %v, expected %s", hash, found, ok, offset)
+			continue
+		}
+		content, objType, _, err := stored.Object(hash)
+		if err != nil {
+			t.Errorf("Object(%s) error = %v", hash, err)
+			continue
+		}
+		computed, _ := common.CalculateEncodedSHA(common.FormatGitObjectContent(objType, content))
+		if computed != hash || objType != fields[1] {
+			t.Errorf("Object(%s) is a %s with hash %s", hash, objType, computed)
+		}
+	}
+	if len(stored.Index.Entries) != objects {
+		t.Errorf("index has %d entries, expected %d", len(stored.Index.Entries), objects)
+	}
+}
+
+func TestPackReaderOversizedObject(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  []byte
+		content []byte
+	}{
+		// a blob whose header claims a size close to 2^63 bytes
+		{"huge size", []byte{0xbf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}, []byte("hello\n")},
+		// a 10 byte blob whose data inflates to 64 MiB
+		{"huge content", []byte{0x3a}, make([]byte, 64<<20)},
+	}
+	for _, test := range tests {
+		var pack bytes.Buffer
+		pack.WriteString("PACK\x00\x00\x00\x02\x00\x00\x00\x01")
+		pack.Write(test.header)
+		zw := zlib.NewWriter(&pack)
+		zw.Write(test.content)
+		zw.Close()
+		checksum := sha1.Sum(pack.Bytes())
+		pack.Write(checksum[:])
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		reader, err := NewPackReader(&pack)
+		if err != nil {
+			t.Fatalf("%s: NewPackReader() error = %v", test.name, err)
+		}
+		err = reader.ForEach(func(GitObject) error { return nil })
+		if err == nil || !strings.Contains(err.Error(), "size mismatch") {
+			t.Errorf("%s: ForEach() error = %v, expected a size mismatch", test.name, err)
+		}
+		runtime.ReadMemStats(&after)
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 8<<20 {
+			t.Errorf("%s: ForEach() allocated %d bytes", test.name, allocated)
+		}
+	}
+}
+
+// sideBandResponse builds an upload-pack response with the pack multiplexed
+// on side-band-64k, along with a progress message
+func sideBandResponse(pack []byte, chunkSize int) []byte {
+	pktLine := func(channel byte, payload []byte) []byte {
+		line := []byte(fmt.Sprintf("%04x", len(payload)+5))
+		line = append(line, channel)
+		return append(line, payload...)
+	}
+	response := []byte("0008NAK\n")
+	progress := "Counting objects: 50% (1/2)\rCounting objects: 100% (2/2), done.\n"
+	response = append(response, pktLine(2, []byte(progress))...)
+	for len(pack) > 0 {
+		chunk := pack[:min(chunkSize, len(pack))]
+		pack = pack[len(chunk):]
+		response = append(response, pktLine(1, chunk)...)
+	}
+	return append(response, "0000"...)
+}
+
+func TestSideBandDemultiplexing(t *testing.T) {
+	pack, err := os.ReadFile("../../testdata/ofs-delta.pack")
+	if err != nil {
+		t.Fatalf("error in reading packfile: %v", err)
+	}
+	var progress bytes.Buffer
+	packPath, err := StorePack(t.TempDir(), bytes.NewReader(sideBandResponse(pack, 100)), &progress)
 	if err != nil {
-		t.Errorf("error in reading packfile: %v", err)
+		t.Fatalf("StorePack() error = %v", err)
+	}
+	expectedProgress := "remote: Counting objects: 50% (1/2)\r" +
+		"remote: Counting objects: 100% (2/2), done.\n"
+	if !strings.HasPrefix(progress.String(), expectedProgress) {
+		t.Errorf("progress = %q, expected it to start with %q", progress.String(), expectedProgress)
 	}
-	_, err = ReadPackFile(content)
+	stored, err := os.ReadFile(packPath)
+	if err != nil || !bytes.Equal(stored, pack) {
+		t.Errorf("StorePack() stored a different pack, %v", err)
+	}
+}
+
+func TestSideBandWithoutProgress(t *testing.T) {
+	pack, err := os.ReadFile("../../testdata/pack.txt")
 	if err != nil {
-		t.Errorf("error in reading packfile: %v", err)
+		t.Fatalf("error in reading packfile: %v", err)
+	}
+	// with no-progress the first pkt-line is pack data, bigger than the read buffer
+	var response []byte
+	for len(pack) > 0 {
+		chunk := pack[:min(65515, len(pack))]
+		pack = pack[len(chunk):]
+		response = append(fmt.Appendf(response, "%04x\x01", len(chunk)+5), chunk...)
+	}
+	response = append(response, "0000"...)
+	if _, err := StorePack(t.TempDir(), bytes.NewReader(response), io.Discard); err != nil {
+		t.Errorf("StorePack() error = %v", err)
+	}
+}
+
+func TestSideBandRemoteError(t *testing.T) {
+	response := []byte("0008NAK\n0018\x03upload-pack: oops\n0000")
+	_, err := StorePack(t.TempDir(), bytes.NewReader(response), io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "remote error: upload-pack: oops") {
+		t.Errorf("StorePack() error = %v, expected the remote error", err)
+	}
+}
+
+func TestPackDataRemoteError(t *testing.T) {
+	response := pktLines("NAK\n", "ERR upload-pack: not our ref 1234\n")
+	_, err := packData(bufio.NewReader(strings.NewReader(response)), io.Discard)
+	if err == nil || err.Error() != "remote error: upload-pack: not our ref 1234" {
+		t.Errorf("packData() error = %v, expected the remote error", err)
+	}
+}
+
+func TestParseRefAdvertisement(t *testing.T) {
+	content, err := os.ReadFile("../../testdata/code-crafter-response.txt")
+	if err != nil {
+		t.Fatalf("error in reading the advertisement: %v", err)
+	}
+	advertisement, err := ParseRefAdvertisement(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("ParseRefAdvertisement() error = %v", err)
+	}
+	expectedRefs := []GitRef{
+		{Hash: "47b37f1a82bfe85f6d8df52b6258b75e4343b7fd", Name: "HEAD"},
+		{Hash: "47b37f1a82bfe85f6d8df52b6258b75e4343b7fd", Name: "refs/heads/master"},
+	}
+	if !slices.Equal(advertisement.Refs, expectedRefs) {
+		t.Errorf("Refs = %v, expected %v", advertisement.Refs, expectedRefs)
+	}
+	capabilities := advertisement.Capabilities
+	if !capabilities.Has("side-band-64k") || !capabilities.Has("ofs-delta") {
+		t.Errorf("Capabilities = %v, expected side-band-64k and ofs-delta", capabilities)
+	}
+	if symref, _ := capabilities.Value("symref"); symref != "HEAD:refs/heads/master" {
+		t.Errorf("symref = %q, expected HEAD:refs/heads/master", symref)
+	}
+	if capabilities.Has("side-band-6") {
+		t.Errorf("Has() matched a capability prefix")
+	}
+
+	// rclone advertises many refs along with peeled tags
+	content, err = os.ReadFile("../../testdata/response.txt")
+	if err != nil {
+		t.Fatalf("error in reading the advertisement: %v", err)
+	}
+	advertisement, err = ParseRefAdvertisement(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("ParseRefAdvertisement() error = %v", err)
+	}
+	if len(advertisement.Refs) < 100 || advertisement.Refs[0].Name != "HEAD" {
+		t.Errorf("ParseRefAdvertisement() returned %d refs", len(advertisement.Refs))
+	}
+}
+
+func TestParseRefAdvertisementEmptyRepository(t *testing.T) {
+	line := strings.Repeat("0", 40) + " capabilities^{}\x00ofs-delta agent=git/2\n"
+	input := "001e# service=git-upload-pack\n0000" + fmt.Sprintf("%04x", len(line)+4) + line + "0000"
+	advertisement, err := ParseRefAdvertisement(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseRefAdvertisement() error = %v", err)
+	}
+	if len(advertisement.Refs) != 0 {
+		t.Errorf("Refs = %v, expected none", advertisement.Refs)
+	}
+	if agent, _ := advertisement.Capabilities.Value("agent"); agent != "git/2" {
+		t.Errorf("agent = %q, expected git/2", agent)
+	}
+}
+
+func TestParseRefAdvertisementMalformed(t *testing.T) {
+	tests := map[string]string{
+		"empty":           "",
+		"not a pkt-line":  "<html>Not Found</html>",
+		"missing space":   "0031" + strings.Repeat("a", 40) + "HEAD\n0000",
+		"short hash":      "000eabc HEAD\n0000",
+		"no flush-pkt":    "0032" + strings.Repeat("a", 40) + " HEAD\n\x00",
+		"server error":    "0011ERR not ours",
+		"truncated":       "0032" + strings.Repeat("a", 10),
+		"invalid length":  "0003",
+		"only flush-pkts": "00000000",
+	}
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseRefAdvertisement(strings.NewReader(input)); err == nil {
+				t.Errorf("ParseRefAdvertisement(%q) did not fail", input)
+			}
+		})
+	}
+}
+
+// pktLines frames every line as a pkt-line, "0000" and "0001" are written as is
+func pktLines(lines ...string) string {
+	var builder strings.Builder
+	for _, line := range lines {
+		if line == "0000" || line == "0001" {
+			builder.WriteString(line)
+			continue
+		}
+		fmt.Fprintf(&builder, "%04x%s", len(line)+4, line)
+	}
+	return builder.String()
+}
+
+func TestParseDiscovery(t *testing.T) {
+	v2 := pktLines(
+		"version 2\n",
+		"agent=git/2.39.5\n",
+		"ls-refs=unborn\n",
+		"fetch=shallow wait-for-done\n",
+		"object-format=sha1\n",
+		"0000",
+	)
+	discovery, err := ParseDiscovery([]byte(v2))
+	if err != nil {
+		t.Fatalf("ParseDiscovery() error = %v", err)
+	}
+	if discovery.Version != 2 || len(discovery.Refs) != 0 {
+		t.Errorf("ParseDiscovery() = version %d with %d refs", discovery.Version, len(discovery.Refs))
+	}
+	if fetch, _ := discovery.Capabilities.Value("fetch"); fetch != "shallow wait-for-done" {
+		t.Errorf("fetch capability = %q", fetch)
+	}
+
+	// a server without the protocol v2 answers with the ref advertisement
+	v0, err := os.ReadFile("../../testdata/code-crafter-response.txt")
+	if err != nil {
+		t.Fatalf("error in reading the advertisement: %v", err)
+	}
+	discovery, err = ParseDiscovery(v0)
+	if err != nil {
+		t.Fatalf("ParseDiscovery() error = %v", err)
+	}
+	if discovery.Version != 0 || len(discovery.Refs) != 2 || !discovery.Capabilities.Has("ofs-delta") {
+		t.Errorf("ParseDiscovery() = %+v", discovery)
+	}
+
+	if _, err := ParseDiscovery([]byte(pktLines("version 2\n", "ls-refs\n"))); err == nil {
+		t.Errorf("ParseDiscovery() of a truncated advertisement did not fail")
+	}
+}
+
+func TestParseLsRefs(t *testing.T) {
+	main := strings.Repeat("a", 40)
+	tag, peeled := strings.Repeat("b", 40), strings.Repeat("c", 40)
+	response := pktLines(
+		main+" HEAD symref-target:refs/heads/main\n",
+		main+" refs/heads/main\n",
+		tag+" refs/tags/v1 peeled:"+peeled+"\n",
+		"0000",
+	)
+	refs, err := parseLsRefs(strings.NewReader(response))
+	if err != nil {
+		t.Fatalf("parseLsRefs() error = %v", err)
+	}
+	expected := []GitRef{
+		{Hash: main, Name: "HEAD", Target: "refs/heads/main"},
+		{Hash: main, Name: "refs/heads/main"},
+		{Hash: tag, Name: "refs/tags/v1", Peeled: peeled},
+	}
+	if !slices.Equal(refs, expected) {
+		t.Errorf("parseLsRefs() = %v, expected %v", refs, expected)
+	}
+
+	for _, malformed := range []string{pktLines("abc HEAD\n", "0000"), pktLines(main + " HEAD\n")} {
+		if _, err := parseLsRefs(strings.NewReader(malformed)); err == nil {
+			t.Errorf("parseLsRefs(%q) did not fail", malformed)
+		}
+	}
+}
+
+func TestFetchV2Response(t *testing.T) {
+	pack, err := os.ReadFile("../../testdata/ofs-delta.pack")
+	if err != nil {
+		t.Fatalf("error in reading packfile: %v", err)
+	}
+	// the side-band response starts with a NAK, which has no place in a v2 response
+	sideBand := strings.TrimPrefix(string(sideBandResponse(pack, 1000)), "0008NAK\n")
+	response := pktLines("acknowledgments\n", "NAK\n", "0001", "packfile\n") + sideBand
+
+	body := strings.NewReader(response)
+	if _, err := skipToPackfile(body); err != nil {
+		t.Fatalf("skipToPackfile() error = %v", err)
+	}
+	if _, err := StorePack(t.TempDir(), body, io.Discard); err != nil {
+		t.Errorf("StorePack() error = %v", err)
+	}
+
+	for _, response := range []string{pktLines("acknowledgments\n", "NAK\n", "0000"), pktLines("ERR no\n")} {
+		if _, err := skipToPackfile(strings.NewReader(response)); err == nil {
+			t.Errorf("skipToPackfile(%q) did not fail", response)
+		}
+	}
+}
+
+func TestNegotiateCapabilities(t *testing.T) {
+	tests := []struct {
+		name   string
+		server Capabilities
+		opts   NegotiationOptions
+		want   Capabilities
+	}{
+		{
+			name: "everything",
+			server: Capabilities{
+				"ofs-delta", "include-tag", "no-progress", "thin-pack", "side-band",
+				"side-band-64k", "multi_ack", "multi_ack_detailed", "agent=git/2.43.0",
+			},
+			want: Capabilities{
+				"multi_ack_detailed", "side-band-64k", "thin-pack", "include-tag", "ofs-delta",
+				"agent=mygit/" + Version,
+			},
+		},
+		{
+			name:   "side-band fallback and no-progress",
+			server: Capabilities{"side-band", "no-progress", "ofs-delta"},
+			opts:   NegotiationOptions{NoProgress: true},
+			want:   Capabilities{"side-band", "no-progress", "ofs-delta", "agent=mygit/" + Version},
+		},
+		{
+			name:   "protocol v2",
+			server: Capabilities{"agent=git/2.43.0", "ls-refs=unborn", "fetch=shallow wait-for-done"},
+			want: Capabilities{
+				"thin-pack", "include-tag", "ofs-delta", "agent=mygit/" + Version,
+			},
+		},
+		{
+			name:   "shallow",
+			server: Capabilities{"shallow", "deepen-since", "deepen-not", "ofs-delta"},
+			opts:   NegotiationOptions{Shallow: true, DeepenNot: true},
+			want:   Capabilities{"ofs-delta", "shallow", "deepen-not", "agent=mygit/" + Version},
+		},
+		{
+			name:   "filter",
+			server: Capabilities{"filter", "ofs-delta"},
+			opts:   NegotiationOptions{Filter: true},
+			want:   Capabilities{"ofs-delta", "agent=mygit/" + Version, "filter"},
+		},
+		{
+			name: "nothing advertised",
+			want: Capabilities{"agent=mygit/" + Version},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NegotiateCapabilities(tt.server, tt.opts); !slices.Equal(got, tt.want) {
+				t.Errorf("NegotiateCapabilities() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefDiscoveryRequest(t *testing.T) {
+	head := strings.Repeat("a", 40)
+	tag := strings.Repeat("b", 40)
+	refs := []GitRef{
+		{Hash: head, Name: "HEAD"},
+		{Hash: head, Name: "refs/heads/main"},
+		{Hash: tag, Name: "refs/tags/v1"},
+		{Hash: head, Name: "refs/tags/v1^{}"},
+		{Hash: zeroHash, Name: "refs/heads/unborn"},
+	}
+	wants := UniqueWants(refs)
+	if !slices.Equal(wants, []string{head, tag}) {
+		t.Fatalf("UniqueWants() = %v", wants)
+	}
+
+	req := FetchRequest{Wants: wants, Capabilities: Capabilities{"side-band-64k", "ofs-delta"}}
+	request := generateRefDiscoveryRequest(req, nil, true)
+	want := pktLines(
+		"want "+head+" side-band-64k ofs-delta\n",
+		"want "+tag+"\n",
+		"0000",
+		"done\n",
+	)
+	if string(request) != want {
+		t.Errorf("generateRefDiscoveryRequest() = %q, want %q", request, want)
+	}
+}
+
+func TestStoreThinPack(t *testing.T) {
+	dir := t.TempDir()
+	store := common.NewObjectStore(dir)
+	base := []byte("the base object, which the repository already has\n")
+	baseHash, err := store.Put("blob", base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := append(slices.Clone(base), "and a new line\n"...)
+	targetHash, err := common.CalculateEncodedSHA(common.FormatGitObjectContent("blob", target))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the delta copies the whole base and inserts the new line
+	added := target[len(base):]
+	delta := []byte{byte(len(base)), byte(len(target)), 0x90, byte(len(base)), byte(len(added))}
+	delta = append(delta, added...)
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(delta)
+	zw.Close()
+	baseSHA, _ := hex.DecodeString(baseHash)
+
+	// a pack of a single ref delta, whose size takes two bytes of the entry header
+	pack := []byte{'P', 'A', 'C', 'K', 0, 0, 0, 2, 0, 0, 0, 1}
+	pack = append(pack, byte(OBJ_REF_DELTA)<<4|byte(len(delta)&0x0f)|0x80, byte(len(delta)>>4))
+	pack = append(pack, baseSHA...)
+	pack = append(pack, compressed.Bytes()...)
+	sum := sha1.Sum(pack)
+	pack = append(pack, sum[:]...)
+
+	var progressOut bytes.Buffer
+	packPath, err := StorePack(dir, bytes.NewReader(pack), &progressOut)
+	if err != nil {
+		t.Fatalf("StorePack() error = %v", err)
+	}
+	if !strings.Contains(progressOut.String(), "completed with 1 local objects") {
+		t.Errorf("progress = %q", progressOut.String())
+	}
+	stored, err := common.OpenPack(strings.TrimSuffix(packPath, ".pack") + ".idx")
+	if err != nil {
+		t.Fatalf("OpenPack() error = %v", err)
+	}
+	defer stored.Close()
+	for hash, want := range map[string][]byte{baseHash: base, targetHash: target} {
+		content, objType, found, err := stored.Object(hash)
+		if err != nil || !found {
+			t.Fatalf("Object(%s) found = %v, error = %v", hash, found, err)
+		}
+		if objType != "blob" || !bytes.Equal(content, want) {
+			t.Errorf("Object(%s) = %s %q, want blob %q", hash, objType, content, want)
+		}
+	}
+}
+
+func TestParseAcks(t *testing.T) {
+	common, ready := strings.Repeat("a", 40), strings.Repeat("b", 40)
+	response := pktLines("ACK "+common+" common\n", "ACK "+ready+" ready\n", "NAK\n")
+	result, err := parseAcks(strings.NewReader(response))
+	if err != nil {
+		t.Fatalf("parseAcks() error = %v", err)
+	}
+	if !slices.Equal(result.acked, []string{common, ready}) || !result.ready {
+		t.Errorf("parseAcks() = %+v", result)
+	}
+
+	// without multi_ack the server stops after the first ACK
+	result, err = parseAcks(strings.NewReader(pktLines("ACK " + common + "\n")))
+	if err != nil || !slices.Equal(result.acked, []string{common}) || result.ready {
+		t.Errorf("parseAcks() = %+v, error = %v", result, err)
+	}
+	if _, err := parseAcks(strings.NewReader(pktLines("ACK nope\n"))); err == nil {
+		t.Errorf("parseAcks() did not fail on a malformed ACK")
+	}
+}
+
+func TestParseAcknowledgments(t *testing.T) {
+	common := strings.Repeat("a", 40)
+	response := pktLines("acknowledgments\n", "ACK "+common+"\n", "0000")
+	result, err := parseAcknowledgments(strings.NewReader(response))
+	if err != nil || !slices.Equal(result.acked, []string{common}) || result.ready {
+		t.Errorf("parseAcknowledgments() = %+v, error = %v", result, err)
+	}
+
+	// once ready the packfile section follows
+	response = pktLines("acknowledgments\n", "ACK "+common+"\n", "ready\n", "0001", "packfile\n")
+	body := strings.NewReader(response)
+	result, err = parseAcknowledgments(body)
+	if err != nil || !result.ready {
+		t.Fatalf("parseAcknowledgments() = %+v, error = %v", result, err)
+	}
+	if _, err := skipToPackfile(body); err != nil {
+		t.Errorf("skipToPackfile() error = %v", err)
+	}
+
+	for _, response := range []string{
+		pktLines("acknowledgments\n", "NAK\n", "0001", "packfile\n"),
+		pktLines("packfile\n"),
+	} {
+		if _, err := parseAcknowledgments(strings.NewReader(response)); err == nil {
+			t.Errorf("parseAcknowledgments(%q) did not fail", response)
+		}
+	}
+}
+
+func TestFetchRequestHaves(t *testing.T) {
+	want, have := strings.Repeat("a", 40), strings.Repeat("b", 40)
+	req := FetchRequest{Wants: []string{want}, Capabilities: Capabilities{"multi_ack_detailed"}}
+	request := generateRefDiscoveryRequest(req, []string{have}, false)
+	expected := pktLines("want "+want+" multi_ack_detailed\n", "0000", "have "+have+"\n", "0000")
+	if string(request) != expected {
+		t.Errorf("generateRefDiscoveryRequest() = %q, want %q", request, expected)
+	}
+
+	server := Capabilities{"fetch", "object-format=sha1"}
+	req = FetchRequest{Wants: []string{want}, Capabilities: Capabilities{"ofs-delta"}}
+	request, err := fetchV2Request(server, req, []string{have}, true)
+	if err != nil {
+		t.Fatalf("fetchV2Request() error = %v", err)
+	}
+	expected = pktLines(
+		"command=fetch\n", "object-format=sha1\n", "0001",
+		"ofs-delta\n", "want "+want+"\n", "have "+have+"\n", "done\n", "0000",
+	)
+	if string(request) != expected {
+		t.Errorf("fetchV2Request() = %q, want %q", request, expected)
+	}
+}
+
+func TestShallowRequest(t *testing.T) {
+	want, shallow := strings.Repeat("a", 40), strings.Repeat("c", 40)
+	req := FetchRequest{
+		Wants:        []string{want},
+		Capabilities: Capabilities{"shallow"},
+		Shallow:      []string{shallow},
+		Deepen:       Deepen{Depth: 2, Since: time.Unix(1700000000, 0), Not: []string{"dev"}},
+	}
+	request := generateRefDiscoveryRequest(req, nil, true)
+	expected := pktLines(
+		"want "+want+" shallow\n", "shallow "+shallow+"\n", "deepen 2\n",
+		"deepen-since 1700000000\n", "deepen-not dev\n", "0000", "done\n",
+	)
+	if string(request) != expected {
+		t.Errorf("generateRefDiscoveryRequest() = %q, want %q", request, expected)
+	}
+
+	server := Capabilities{"fetch=shallow"}
+	req.Capabilities = nil
+	request, err := fetchV2Request(server, req, nil, true)
+	if err != nil {
+		t.Fatalf("fetchV2Request() error = %v", err)
+	}
+	expected = pktLines(
+		"command=fetch\n", "0001", "want "+want+"\n", "shallow "+shallow+"\n", "deepen 2\n",
+		"deepen-since 1700000000\n", "deepen-not dev\n", "done\n", "0000",
+	)
+	if string(request) != expected {
+		t.Errorf("fetchV2Request() = %q, want %q", request, expected)
+	}
+}
+
+func TestCheckShallowSupport(t *testing.T) {
+	tests := []struct {
+		name      string
+		discovery Discovery
+		deepen    Deepen
+		ok        bool
+	}{
+		{"v0 depth", Discovery{Capabilities: Capabilities{"shallow"}}, Deepen{Depth: 1}, true},
+		{"v0 no shallow", Discovery{Capabilities: Capabilities{"ofs-delta"}}, Deepen{Depth: 1}, false},
+		{"v0 no deepen-not", Discovery{Capabilities: Capabilities{"shallow"}}, Deepen{Not: []string{"dev"}}, false},
+		{"v2", Discovery{Version: 2, Capabilities: Capabilities{"fetch=shallow wait-for-done"}}, Deepen{Depth: 1}, true},
+		{"v2 no shallow", Discovery{Version: 2, Capabilities: Capabilities{"fetch"}}, Deepen{Depth: 1}, false},
+		{"not shallow", Discovery{}, Deepen{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkShallowSupport(&tt.discovery, FetchRequest{Deepen: tt.deepen})
+			if (err == nil) != tt.ok {
+				t.Errorf("checkShallowSupport() error = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestReadShallowInfo(t *testing.T) {
+	shallow, unshallow := strings.Repeat("a", 40), strings.Repeat("b", 40)
+	want := ShallowInfo{Shallow: []string{shallow}, Unshallow: []string{unshallow}}
+
+	body := strings.NewReader(pktLines("shallow "+shallow+"\n", "unshallow "+unshallow+"\n", "0000") + "0008NAK\n")
+	info, err := readShallowInfo(body)
+	if err != nil || !reflect.DeepEqual(info, want) {
+		t.Errorf("readShallowInfo() = %+v, error = %v", info, err)
+	}
+	if rest, _ := io.ReadAll(body); string(rest) != "0008NAK\n" {
+		t.Errorf("readShallowInfo() read past the flush-pkt, left %q", rest)
+	}
+
+	// protocol v2 sends them in the shallow-info section before the packfile
+	response := pktLines(
+		"shallow-info\n", "shallow "+shallow+"\n", "unshallow "+unshallow+"\n", "0001", "packfile\n",
+	)
+	info, err = skipToPackfile(strings.NewReader(response))
+	if err != nil || !reflect.DeepEqual(info, want) {
+		t.Errorf("skipToPackfile() = %+v, error = %v", info, err)
+	}
+
+	for _, response := range []string{pktLines("shallow nope\n", "0000"), pktLines("NAK\n")} {
+		if _, err := readShallowInfo(strings.NewReader(response)); err == nil {
+			t.Errorf("readShallowInfo(%q) did not fail", response)
+		}
+	}
+}
+
+func TestCheckFilter(t *testing.T) {
+	for _, spec := range []string{"blob:none", "blob:limit=1024", "blob:limit=1k", "blob:limit=2M", "tree:0", "tree:3"} {
+		if err := CheckFilter(spec); err != nil {
+			t.Errorf("CheckFilter(%q) error = %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"", "blob", "blob:some", "blob:limit=", "blob:limit=1kk", "blob:limit=k", "tree:", "tree:-1", "sparse"} {
+		if err := CheckFilter(spec); err == nil {
+			t.Errorf("CheckFilter(%q) did not fail", spec)
+		}
+	}
+}
+
+func TestFilterRequest(t *testing.T) {
+	want := strings.Repeat("a", 40)
+	req := FetchRequest{Wants: []string{want}, Capabilities: Capabilities{"filter"}, Filter: "blob:none"}
+	request := generateRefDiscoveryRequest(req, nil, true)
+	expected := pktLines("want "+want+" filter\n", "filter blob:none\n", "0000", "done\n")
+	if string(request) != expected {
+		t.Errorf("generateRefDiscoveryRequest() = %q, want %q", request, expected)
+	}
+
+	req.Capabilities = nil
+	request, err := fetchV2Request(Capabilities{"fetch=shallow filter"}, req, nil, true)
+	if err != nil {
+		t.Fatalf("fetchV2Request() error = %v", err)
+	}
+	expected = pktLines("command=fetch\n", "0001", "want "+want+"\n", "filter blob:none\n", "done\n", "0000")
+	if string(request) != expected {
+		t.Errorf("fetchV2Request() = %q, want %q", request, expected)
+	}
+
+	for _, discovery := range []*Discovery{
+		{Capabilities: Capabilities{"ofs-delta"}},
+		{Version: 2, Capabilities: Capabilities{"fetch=shallow"}},
+	} {
+		if err := checkFilterSupport(discovery, req); err == nil {
+			t.Errorf("checkFilterSupport(%v) did not fail", discovery.Capabilities)
+		}
+	}
+}
+
+func TestRemoteHead(t *testing.T) {
+	main, dev := strings.Repeat("a", 40), strings.Repeat("b", 40)
+	branches := []GitRef{{Hash: dev, Name: "refs/heads/dev"}, {Hash: main, Name: "refs/heads/main"}}
+	tests := []struct {
+		name   string
+		refs   []GitRef
+		server Capabilities
+		want   string
+	}{
+		{"ls-refs symref-target", append([]GitRef{{Hash: main, Name: "HEAD", Target: "refs/heads/main"}}, branches...), nil, "refs/heads/main"},
+		{"symref capability", append([]GitRef{{Hash: main, Name: "HEAD"}}, branches...), Capabilities{"symref=HEAD:refs/heads/main"}, "refs/heads/main"},
+		{"guessed from the hash", append([]GitRef{{Hash: main, Name: "HEAD"}}, branches...), nil, "refs/heads/main"},
+		{"detached", append([]GitRef{{Hash: strings.Repeat("c", 40), Name: "HEAD"}}, branches...), nil, ""},
+		{"empty repository", nil, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RemoteHead(tt.refs, tt.server); got != tt.want {
+				t.Errorf("RemoteHead() = %q, want %q", got, tt.want)
+			}
+		})
 	}
 }
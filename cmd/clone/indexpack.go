@@ -0,0 +1,310 @@
+package clone
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/common"
+)
+
+// StorePack saves the pack of the upload-pack response in the object store of the
+// repository at dir, along with its index, and returns the path of the .pack file
+//
+// The objects stay compressed and deltified in the pack, which is stored as
+// .git/objects/pack/pack-<checksum>.pack like git does. The pack is indexed while it is
+// received, the progress is written to progressOut.
+//
+// A thin pack, which has deltas against objects the repository already has, is completed
+// with these base objects like `git index-pack --fix-thin` does.
+func StorePack(dir string, response io.Reader, progressOut io.Writer) (string, error) {
+	pack, err := packData(bufio.NewReader(response), progressOut)
+	if err != nil {
+		return "", fmt.Errorf("StorePack: %w", err)
+	}
+	packDir := filepath.Join(common.GitDir(dir), "objects", "pack")
+	if err := os.MkdirAll(packDir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("StorePack: %w", err)
+	}
+	tmpPack, err := os.CreateTemp(packDir, "tmp_pack_")
+	if err != nil {
+		return "", fmt.Errorf("StorePack: %w", err)
+	}
+	tmpPath := tmpPack.Name()
+	tmpIndex := tmpPath + ".idx"
+	// nothing is left behind if anything fails, the renamed files are not removed
+	defer os.Remove(tmpPath)
+	defer os.Remove(tmpIndex)
+
+	indexer, err := readPackEntries(io.TeeReader(pack, tmpPack), progressOut)
+	if err == nil {
+		// the remote messages sent after the pack, and the end of the side-band
+		_, err = io.Copy(io.Discard, pack)
+	}
+	if closeErr := tmpPack.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("StorePack: %w", err)
+	}
+	indexer.store = common.NewObjectStore(dir)
+	if err := indexer.resolveAndWrite(tmpPath, tmpIndex, progressOut); err != nil {
+		return "", fmt.Errorf("StorePack: %w", err)
+	}
+
+	name := filepath.Join(packDir, "pack-"+indexer.checksum)
+	if err := os.Rename(tmpPath, name+".pack"); err != nil {
+		return "", fmt.Errorf("StorePack: %w", err)
+	}
+	if err := os.Rename(tmpIndex, name+".idx"); err != nil {
+		return "", fmt.Errorf("StorePack: %w", err)
+	}
+	return name + ".pack", nil
+}
+
+// IndexPack builds the version 2 index of the pack file, the equivalent of
+// `git index-pack <pack>`. The index is written next to the pack with the .idx
+// extension and the hex checksum of the pack is returned.
+func IndexPack(packPath string) (string, error) {
+	file, err := os.Open(packPath)
+	if err != nil {
+		return "", fmt.Errorf("IndexPack: %w", err)
+	}
+	defer file.Close()
+	indexer, err := readPackEntries(file, io.Discard)
+	if err != nil {
+		return "", fmt.Errorf("IndexPack: %w", err)
+	}
+	idxPath := strings.TrimSuffix(packPath, ".pack") + ".idx"
+	if err := indexer.resolveAndWrite(packPath, idxPath, io.Discard); err != nil {
+		return "", fmt.Errorf("IndexPack: %w", err)
+	}
+	return indexer.checksum, nil
+}
+
+// packIndexer builds the index of a pack in two passes, first reading the pack as a
+// stream to find the entries along with their CRC32 and the hashes of the full objects,
+// then reading the pack file at random to resolve the deltas
+type packIndexer struct {
+	entries []common.PackIndexEntry
+	// deltas has the position in entries of the objects whose hash is not known yet
+	deltas       []int
+	offsetByHash map[string]int64
+	// refBases has the hash of the base of the ref deltas by their position in entries
+	refBases map[int]string
+	// checksum is the hex checksum of the pack
+	checksum    string
+	rawChecksum [20]byte
+	// packEnd is the offset of the pack checksum, where the missing bases of a thin pack
+	// are appended
+	packEnd int64
+
+	// store has the bases of a thin pack, a thin pack is an error without it
+	store common.ObjectStore
+	// localObjects is the number of objects appended to complete a thin pack
+	localObjects int
+}
+
+// readPackEntries is the first pass over the pack
+func readPackEntries(r io.Reader, progressOut io.Writer) (*packIndexer, error) {
+	packReader, err := NewPackReader(r)
+	if err != nil {
+		return nil, err
+	}
+	// the number of objects comes from the remote, so the entries are not allocated
+	// upfront for it
+	total := int(packReader.Header.NumOfObjects)
+	indexer := &packIndexer{
+		offsetByHash: map[string]int64{},
+		refBases:     map[int]string{},
+	}
+	receiving := newProgress(progressOut, "Receiving objects", total)
+	err = packReader.ForEach(func(obj GitObject) error {
+		entry := common.PackIndexEntry{Offset: int64(obj.Offset), CRC32: obj.CRC32}
+		if obj.ObjectType == OBJ_REF_DELTA {
+			indexer.refBases[len(indexer.entries)] = obj.Base
+		}
+		if obj.IsDelta() {
+			indexer.deltas = append(indexer.deltas, len(indexer.entries))
+		} else {
+			sha, err := common.CalculateSHA(
+				common.FormatGitObjectContent(obj.ObjectType.String(), obj.Content),
+			)
+			if err != nil {
+				return err
+			}
+			entry.SHA = sha
+			indexer.offsetByHash[hex.EncodeToString(sha[:])] = entry.Offset
+		}
+		indexer.entries = append(indexer.entries, entry)
+		receiving.update(len(indexer.entries))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	receiving.done()
+	indexer.rawChecksum = packReader.Checksum()
+	indexer.checksum = hex.EncodeToString(indexer.rawChecksum[:])
+	indexer.packEnd = int64(packReader.stream.offset)
+	return indexer, nil
+}
+
+// resolveAndWrite is the second pass over the pack, once the hashes of the deltas are
+// known the index is written to idxPath
+//
+// The pack is only read, unless it is a thin pack: it is then reopened for writing to
+// append the bases it lacks.
+func (ix *packIndexer) resolveAndWrite(packPath, idxPath string, progressOut io.Writer) error {
+	file, err := os.Open(packPath)
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+	writable := false
+
+	lookup := func(hash string) (int64, bool) {
+		offset, found := ix.offsetByHash[hash]
+		return offset, found
+	}
+	pack := common.NewPackFile(file, lookup)
+	resolving := newProgress(progressOut, "Resolving deltas", len(ix.deltas))
+	resolved := 0
+	// a ref delta can use a delta which comes later in the pack as its base, so the
+	// deltas are resolved in rounds until no more progress is made
+	deltas := ix.deltas
+	for len(deltas) > 0 {
+		var unresolved []int
+		for _, i := range deltas {
+			objType, content, err := pack.ObjectAt(ix.entries[i].Offset)
+			if err != nil {
+				unresolved = append(unresolved, i)
+				continue
+			}
+			sha, err := common.CalculateSHA(common.FormatGitObjectContent(objType, content))
+			if err != nil {
+				return err
+			}
+			ix.entries[i].SHA = sha
+			ix.offsetByHash[hex.EncodeToString(sha[:])] = ix.entries[i].Offset
+			resolved++
+			resolving.update(resolved)
+		}
+		if len(unresolved) == len(deltas) {
+			if ix.store != nil && !writable {
+				file.Close()
+				if file, err = os.OpenFile(packPath, os.O_RDWR, 0); err != nil {
+					return err
+				}
+				pack = common.NewPackFile(file, lookup)
+				writable = true
+			}
+			appended, err := ix.appendLocalBases(file, unresolved)
+			if err != nil {
+				return err
+			}
+			if appended == 0 {
+				_, _, err := pack.ObjectAt(ix.entries[unresolved[0]].Offset)
+				return fmt.Errorf("%d unresolved deltas: %w", len(unresolved), err)
+			}
+		}
+		deltas = unresolved
+	}
+	if ix.localObjects > 0 {
+		if err := ix.fixThinPack(file); err != nil {
+			return err
+		}
+		fmt.Fprintf(progressOut, "%s: 100%% (%d/%d), completed with %d local objects.\n",
+			resolving.title, resolving.total, resolving.total, ix.localObjects)
+	} else if len(ix.deltas) > 0 {
+		resolving.done()
+	}
+
+	idxFile, err := os.Create(idxPath)
+	if err != nil {
+		return err
+	}
+	err = common.WritePackIndex(idxFile, ix.entries, ix.rawChecksum)
+	if closeErr := idxFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(idxPath)
+		return err
+	}
+	return nil
+}
+
+// appendLocalBases appends to the pack the bases of the unresolved ref deltas which are
+// in the object store, and returns how many were appended
+func (ix *packIndexer) appendLocalBases(file *os.File, unresolved []int) (int, error) {
+	if ix.store == nil {
+		return 0, nil
+	}
+	appended := 0
+	for _, i := range unresolved {
+		base, ok := ix.refBases[i]
+		if _, inPack := ix.offsetByHash[base]; !ok || inPack {
+			continue
+		}
+		content, objType, err := ix.store.Get(base)
+		if errors.Is(err, common.ErrObjectNotFound) {
+			continue
+		}
+		if err != nil {
+			return appended, fmt.Errorf("read base object %s: %w", base, err)
+		}
+		entry, err := encodePackEntry(StringToObjectType(objType), content)
+		if err != nil {
+			return appended, err
+		}
+		if _, err := file.WriteAt(entry, ix.packEnd); err != nil {
+			return appended, err
+		}
+		sha, err := hex.DecodeString(base)
+		if err != nil {
+			return appended, err
+		}
+		ix.entries = append(ix.entries, common.PackIndexEntry{
+			SHA:    [20]byte(sha),
+			Offset: ix.packEnd,
+			CRC32:  crc32.ChecksumIEEE(entry),
+		})
+		ix.offsetByHash[base] = ix.packEnd
+		ix.packEnd += int64(len(entry))
+		ix.localObjects++
+		appended++
+	}
+	return appended, nil
+}
+
+// fixThinPack updates the object count in the header of a completed thin pack and
+// writes its new checksum, the pack is named after the new checksum
+func (ix *packIndexer) fixThinPack(file *os.File) error {
+	count := make([]byte, 4)
+	binary.BigEndian.PutUint32(count, uint32(len(ix.entries)))
+	if _, err := file.WriteAt(count, 8); err != nil {
+		return err
+	}
+	if err := file.Truncate(ix.packEnd); err != nil {
+		return err
+	}
+	hasher := sha1.New()
+	if _, err := io.Copy(hasher, io.NewSectionReader(file, 0, ix.packEnd)); err != nil {
+		return err
+	}
+	copy(ix.rawChecksum[:], hasher.Sum(nil))
+	ix.checksum = hex.EncodeToString(ix.rawChecksum[:])
+	if _, err := file.WriteAt(ix.rawChecksum[:], ix.packEnd); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package clone
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/pktline"
+)
+
+// Haves gives the commits the client offers to the server during the negotiation, from
+// the newest to the oldest
+type Haves interface {
+	// Next returns the next commit to offer, false once there are none left
+	Next() (string, bool, error)
+	// Common tells that the server has the commit, so none of its ancestors need to be
+	// offered anymore
+	Common(hash string)
+}
+
+const (
+	// firstRoundHaves is the number of haves of the first round, every round doubles it
+	// up to maxRoundHaves
+	firstRoundHaves = 16
+	maxRoundHaves   = 256
+	// maxHavesWithoutAck is the number of haves after which the client gives up when the
+	// server did not acknowledge any of them, the server then sends a bigger pack
+	maxHavesWithoutAck = 256
+)
+
+// FetchRequest is what the client asks the server for
+type FetchRequest struct {
+	Wants []string
+	// Haves offers the local commits, nil for a new repository
+	Haves Haves
+	// Capabilities are the ones the client picked with NegotiateCapabilities
+	Capabilities Capabilities
+	// Shallow are the shallow commits of the repository, the server does not send what
+	// is beyond them unless it is asked to deepen the history
+	Shallow []string
+	Deepen  Deepen
+	// Filter leaves objects out of the pack, as checked by CheckFilter
+	Filter string
+}
+
+// FetchResponse is the response of the server, the reader is at the start of the pack
+// which can be given to StorePack
+type FetchResponse struct {
+	io.ReadCloser
+	ShallowInfo
+}
+
+// FetchPack negotiates the objects to fetch with the server and returns its response
+//
+// The wants are sent along with the haves in rounds, the server acknowledges the haves it
+// has until it is ready to send the pack. Over HTTP the server keeps no state between the
+// rounds, so every request repeats the wants, the shallow lines and the haves found in
+// common so far. Without haves it is a single request, like a clone.
+//
+// It is the caller's responsibility to close the returned body.
+func FetchPack(repoLink string, discovery *Discovery, req FetchRequest) (*FetchResponse, error) {
+	if len(req.Wants) == 0 {
+		return nil, fmt.Errorf("FetchPack: nothing to fetch")
+	}
+	if err := checkShallowSupport(discovery, req); err != nil {
+		return nil, fmt.Errorf("FetchPack: %w", err)
+	}
+	if err := checkFilterSupport(discovery, req); err != nil {
+		return nil, fmt.Errorf("FetchPack: %w", err)
+	}
+	n := &negotiation{repoLink: repoLink, discovery: discovery, req: req}
+	if req.Haves != nil {
+		response, err := n.run(req.Haves)
+		if err != nil || response != nil {
+			return response, err
+		}
+	}
+	if discovery.Version == 2 {
+		return fetchV2(repoLink, discovery.Capabilities, req, n.common)
+	}
+	request := generateRefDiscoveryRequest(req, n.common, true)
+	response, err := postUploadPack(repoLink, request, false)
+	if err != nil {
+		return nil, fmt.Errorf("FetchPack: %w", err)
+	}
+	if !req.expectsShallowInfo() {
+		return &FetchResponse{ReadCloser: response}, nil
+	}
+	body := bufio.NewReader(response)
+	shallowInfo, err := readShallowInfo(body)
+	if err != nil {
+		response.Close()
+		return nil, fmt.Errorf("FetchPack: %w", err)
+	}
+	return &FetchResponse{
+		ReadCloser:  readCloser{Reader: body, Closer: response},
+		ShallowInfo: shallowInfo,
+	}, nil
+}
+
+// negotiation keeps the state of the have rounds
+type negotiation struct {
+	repoLink  string
+	discovery *Discovery
+	req       FetchRequest
+	// common are the haves the server acknowledged, they are sent again every round
+	common []string
+}
+
+// roundResult is the answer of the server to a round of haves
+type roundResult struct {
+	acked []string
+	// ready is set once the server has found enough common commits to send the pack
+	ready bool
+	// response is set when the server sent the pack right away, which a protocol v2
+	// server does once it is ready
+	response *FetchResponse
+}
+
+// run sends the haves until the server is ready, the haves run out or too many of them
+// were not acknowledged. The response is only returned when the server already sent the pack.
+func (n *negotiation) run(haves Haves) (*FetchResponse, error) {
+	// without multi_ack the server stops at the first common commit
+	capabilities := n.req.Capabilities
+	multiAck := n.discovery.Version == 2 ||
+		capabilities.Has("multi_ack_detailed") || capabilities.Has("multi_ack")
+	batch, sinceAck := firstRoundHaves, 0
+	for {
+		var round []string
+		for len(round) < batch {
+			have, ok, err := haves.Next()
+			if err != nil {
+				return nil, fmt.Errorf("negotiation: %w", err)
+			}
+			if !ok {
+				break
+			}
+			round = append(round, have)
+		}
+		if len(round) == 0 {
+			return nil, nil
+		}
+		result, err := n.sendRound(round)
+		if err != nil {
+			return nil, fmt.Errorf("negotiation: %w", err)
+		}
+		if result.response != nil {
+			return result.response, nil
+		}
+		for _, hash := range result.acked {
+			if !slices.Contains(n.common, hash) {
+				n.common = append(n.common, hash)
+				haves.Common(hash)
+				sinceAck = 0
+			}
+		}
+		sinceAck += len(round)
+		if result.ready || (!multiAck && len(result.acked) > 0) || sinceAck >= maxHavesWithoutAck {
+			return nil, nil
+		}
+		batch = min(batch*2, maxRoundHaves)
+	}
+}
+
+// sendRound sends the common haves along with a new round of haves
+func (n *negotiation) sendRound(round []string) (roundResult, error) {
+	haves := append(append([]string(nil), n.common...), round...)
+	if n.discovery.Version == 2 {
+		request, err := fetchV2Request(n.discovery.Capabilities, n.req, haves, false)
+		if err != nil {
+			return roundResult{}, err
+		}
+		response, err := postUploadPack(n.repoLink, request, true)
+		if err != nil {
+			return roundResult{}, err
+		}
+		body := bufio.NewReader(response)
+		result, err := parseAcknowledgments(body)
+		if err != nil || !result.ready {
+			response.Close()
+			return result, err
+		}
+		shallowInfo, err := skipToPackfile(body)
+		if err != nil {
+			response.Close()
+			return result, err
+		}
+		result.response = &FetchResponse{
+			ReadCloser:  readCloser{Reader: body, Closer: response},
+			ShallowInfo: shallowInfo,
+		}
+		return result, nil
+	}
+
+	request := generateRefDiscoveryRequest(n.req, haves, false)
+	response, err := postUploadPack(n.repoLink, request, false)
+	if err != nil {
+		return roundResult{}, err
+	}
+	defer response.Close()
+	return parseAcks(response)
+}
+
+// parseAcks parses the answer of a protocol v0 server to a round of haves
+//
+//	ACK <hash> common   the server has the commit
+//	ACK <hash> ready    the server has enough to send the pack
+//	ACK <hash> continue (multi_ack) or ACK <hash> (no multi_ack)
+//	NAK                 the end of the round
+//
+// the shallow lines which come first when the client is shallow are skipped, only the
+// ones of the last response matter
+func parseAcks(r io.Reader) (roundResult, error) {
+	reader := pktline.NewReader(r)
+	result := roundResult{}
+	for {
+		packetType, line, err := reader.ReadLine()
+		if errors.Is(err, io.EOF) {
+			// a server without multi_ack stops after its first ACK
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		if packetType != pktline.Data || strings.HasPrefix(line, "shallow ") ||
+			strings.HasPrefix(line, "unshallow ") {
+			continue
+		}
+		if line == "NAK" {
+			return result, nil
+		}
+		if strings.HasPrefix(line, "ERR ") {
+			return result, fmt.Errorf("remote error: %s", strings.TrimPrefix(line, "ERR "))
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "ACK" || len(fields[1]) != 40 {
+			return result, fmt.Errorf("unexpected line %q", line)
+		}
+		result.acked = append(result.acked, fields[1])
+		if len(fields) > 2 && fields[2] == "ready" {
+			result.ready = true
+		}
+	}
+}
+
+// parseAcknowledgments parses the acknowledgments section of a protocol v2 fetch
+// response, which ends with a flush-pkt or, when the server is ready, with a delim-pkt
+// followed by the packfile section
+//
+//	acknowledgments
+//	NAK | ACK <hash>...
+//	[ready]
+func parseAcknowledgments(r io.Reader) (roundResult, error) {
+	reader := pktline.NewReader(r)
+	result := roundResult{}
+	packetType, line, err := reader.ReadLine()
+	if err != nil {
+		return result, err
+	}
+	if strings.HasPrefix(line, "ERR ") {
+		return result, fmt.Errorf("remote error: %s", strings.TrimPrefix(line, "ERR "))
+	}
+	if packetType != pktline.Data || line != "acknowledgments" {
+		return result, fmt.Errorf("expected the acknowledgments section, got %q", line)
+	}
+	for {
+		packetType, line, err := reader.ReadLine()
+		if err != nil {
+			return result, err
+		}
+		switch {
+		case packetType == pktline.Flush:
+			return result, nil
+		case packetType == pktline.Delim:
+			if !result.ready {
+				return result, fmt.Errorf("a section follows the acknowledgments without ready")
+			}
+			return result, nil
+		case line == "NAK":
+		case line == "ready":
+			result.ready = true
+		case strings.HasPrefix(line, "ACK ") && len(line) == 44:
+			result.acked = append(result.acked, line[4:])
+		default:
+			return result, fmt.Errorf("unexpected line %q", line)
+		}
+	}
+}
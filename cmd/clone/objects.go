@@ -60,6 +60,17 @@ type GitObject struct {
 	// Size the decompressed size
 	Size    int
 	Content []byte
-	// Base would be hash of the base object in case of DELTA objects
+	// Base would be hash of the base object in case of REF_DELTA objects
 	Base string
+	// Offset is the position of the object entry from the start of the pack file
+	Offset int
+	// BaseOffset is the position of the base object in case of OFS_DELTA objects
+	BaseOffset int
+	// CRC32 is the checksum of the raw (compressed) entry in the pack file
+	CRC32 uint32
+}
+
+// IsDelta reports whether the object content is a delta against a base object
+func (o GitObject) IsDelta() bool {
+	return o.ObjectType == OBJ_OFS_DELTA || o.ObjectType == OBJ_REF_DELTA
 }
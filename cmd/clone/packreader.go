@@ -0,0 +1,229 @@
+package clone
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/common"
+)
+
+// maxHeaderBytes is the maximum number of bytes the variable length integers of an entry
+// header can take (a 64 bit size needs 10 bytes)
+const maxHeaderBytes = 10
+
+// maxSizeHint caps the buffer allocated upfront for an object, the size in the entry
+// header comes from the remote and the buffer grows past it when the object is larger
+const maxSizeHint = 1 << 20
+
+// PackReader decodes a pack file from a stream, one object at a time
+//
+// Only the object being decoded is kept in memory, the caller is expected to store
+// it before asking for the next one. The pack checksum is verified after the last object.
+type PackReader struct {
+	stream *packStream
+	Header PackHeader
+	// read is the number of objects decoded so far
+	read uint32
+	// checksum is the trailing SHA-1 of the pack, set once all the objects are read
+	checksum [sha1.Size]byte
+}
+
+// NewPackReader reads the pack header from r and returns the reader for its objects
+func NewPackReader(r io.Reader) (*PackReader, error) {
+	stream := &packStream{
+		r:      bufio.NewReaderSize(r, 64*1024),
+		hasher: sha1.New(),
+		crc:    crc32.NewIEEE(),
+	}
+	header := make([]byte, packHeaderSize)
+	if _, err := io.ReadFull(stream, header); err != nil {
+		return nil, fmt.Errorf("NewPackReader: read header: %w", err)
+	}
+	if !bytes.Equal(header[:4], []byte{'P', 'A', 'C', 'K'}) {
+		return nil, fmt.Errorf("NewPackReader: first 4 bytes must be PACK: %q", header[:4])
+	}
+	version := readBigEndian([4]byte(header[4:8]))
+	if version != 2 && version != 3 {
+		return nil, fmt.Errorf("NewPackReader: invalid pack file version: %d", version)
+	}
+	return &PackReader{
+		stream: stream,
+		Header: PackHeader{
+			Version:      version,
+			NumOfObjects: readBigEndian([4]byte(header[8:12])),
+		},
+	}, nil
+}
+
+// Next decodes the next object of the pack, it returns io.EOF once all the objects
+// are read and the trailing checksum of the pack is verified
+func (p *PackReader) Next() (GitObject, error) {
+	if p.read == p.Header.NumOfObjects {
+		if err := p.verifyChecksum(); err != nil {
+			return GitObject{}, err
+		}
+		return GitObject{}, io.EOF
+	}
+	obj, err := p.readObject()
+	if err != nil {
+		return GitObject{}, fmt.Errorf("PackReader: object %d: %w", p.read, err)
+	}
+	p.read++
+	return obj, nil
+}
+
+// ForEach calls fn for every object in the pack in the order they appear
+func (p *PackReader) ForEach(fn func(GitObject) error) error {
+	for {
+		obj, err := p.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(obj); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *PackReader) readObject() (GitObject, error) {
+	obj := GitObject{Offset: p.stream.offset}
+	p.stream.crc.Reset()
+	header, err := p.stream.peek(maxHeaderBytes)
+	if err != nil {
+		return obj, fmt.Errorf("read object header: %w", err)
+	}
+	size, objType, used, err := packObjectSize(header)
+	if err != nil {
+		return obj, fmt.Errorf("read object header: %w", err)
+	}
+	p.stream.discard(used)
+	obj.ObjectType = objType
+
+	switch objType {
+	case OBJ_TAG, OBJ_BLOB, OBJ_COMMIT, OBJ_TREE:
+	case OBJ_REF_DELTA:
+		base := make([]byte, 20)
+		if _, err := io.ReadFull(p.stream, base); err != nil {
+			return obj, fmt.Errorf("read base object hash: %w", err)
+		}
+		obj.Base = hex.EncodeToString(base)
+	case OBJ_OFS_DELTA:
+		header, err := p.stream.peek(maxHeaderBytes)
+		if err != nil {
+			return obj, fmt.Errorf("read base offset: %w", err)
+		}
+		distance, used, err := common.ReadOfsDeltaOffset(header)
+		if err != nil {
+			return obj, fmt.Errorf("read base offset: %w", err)
+		}
+		p.stream.discard(used)
+		obj.BaseOffset = obj.Offset - distance
+		if obj.BaseOffset < packHeaderSize {
+			return obj, fmt.Errorf("base offset %d is before the first object", obj.BaseOffset)
+		}
+	default:
+		return obj, fmt.Errorf("invalid object type %s", objType)
+	}
+
+	zlibReader, err := zlib.NewReader(p.stream)
+	if err != nil {
+		return obj, fmt.Errorf("creating zlib reader: %w", err)
+	}
+	defer zlibReader.Close()
+	// the content is read up to one byte past the size of the header, so that a stream
+	// inflating to more than it claims is stopped there
+	content := bytes.NewBuffer(make([]byte, 0, min(size, maxSizeHint)))
+	if _, err := io.Copy(content, io.LimitReader(zlibReader, int64(min(size, 1<<62))+1)); err != nil {
+		return obj, fmt.Errorf("reading uncompressed data: %w", err)
+	}
+	if uint64(content.Len()) != size {
+		return obj, fmt.Errorf("object size mismatch: header %d, content %d", size, content.Len())
+	}
+	obj.Content, obj.Size = content.Bytes(), content.Len()
+	obj.CRC32 = p.stream.crc.Sum32()
+	return obj, nil
+}
+
+// verifyChecksum compares the trailing SHA-1 of the pack with the SHA-1 of what was read
+func (p *PackReader) verifyChecksum() error {
+	computed := p.stream.hasher.Sum(nil)
+	trailer := make([]byte, sha1.Size)
+	if _, err := io.ReadFull(p.stream.r, trailer); err != nil {
+		return fmt.Errorf("PackReader: read pack checksum: %w", err)
+	}
+	if !bytes.Equal(computed, trailer) {
+		return fmt.Errorf("PackReader: pack checksum mismatch: %x != %x", computed, trailer)
+	}
+	p.checksum = [sha1.Size]byte(trailer)
+	return nil
+}
+
+// Checksum returns the trailing SHA-1 of the pack, it is only known after Next
+// returned io.EOF
+func (p *PackReader) Checksum() [sha1.Size]byte {
+	return p.checksum
+}
+
+// packStream keeps track of the offset and the checksum of the consumed pack bytes
+//
+// It implements io.ByteReader, so that the zlib reader does not read past the end of
+// the compressed data of an object
+type packStream struct {
+	r      *bufio.Reader
+	hasher hash.Hash
+	// crc is the CRC-32 of the current entry, as stored in the pack index
+	crc    hash.Hash32
+	offset int
+	// single avoids an allocation for every byte read through ReadByte
+	single [1]byte
+}
+
+func (s *packStream) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.consumed(p[:n])
+	return n, err
+}
+
+func (s *packStream) ReadByte() (byte, error) {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	s.single[0] = b
+	s.consumed(s.single[:])
+	return b, nil
+}
+
+// peek returns up to n bytes without consuming them, fewer bytes are only returned
+// at the end of the stream
+func (s *packStream) peek(n int) ([]byte, error) {
+	peeked, err := s.r.Peek(n)
+	if len(peeked) > 0 && errors.Is(err, io.EOF) {
+		return peeked, nil
+	}
+	return peeked, err
+}
+
+// discard consumes n bytes which were already peeked
+func (s *packStream) discard(n int) {
+	peeked, _ := s.r.Peek(n)
+	s.consumed(peeked)
+	_, _ = s.r.Discard(n)
+}
+
+func (s *packStream) consumed(p []byte) {
+	s.hasher.Write(p)
+	s.crc.Write(p)
+	s.offset += len(p)
+}
@@ -0,0 +1,40 @@
+package clone
+
+import (
+	"fmt"
+	"io"
+)
+
+// progress shows the progress of a counted task the way git does,
+//
+//	Receiving objects:  42% (21/50)
+//
+// the line is updated in place with '\r' and ends with ", done." once complete
+type progress struct {
+	w       io.Writer
+	title   string
+	total   int
+	percent int
+}
+
+func newProgress(w io.Writer, title string, total int) *progress {
+	return &progress{w: w, title: title, total: total, percent: -1}
+}
+
+// update shows the number of items done so far, the line is only written when the
+// percentage changes
+func (p *progress) update(done int) {
+	percent := 100
+	if p.total > 0 {
+		percent = done * 100 / p.total
+	}
+	if percent == p.percent {
+		return
+	}
+	p.percent = percent
+	fmt.Fprintf(p.w, "%s: %3d%% (%d/%d)\r", p.title, percent, done, p.total)
+}
+
+func (p *progress) done() {
+	fmt.Fprintf(p.w, "%s: 100%% (%d/%d), done.\n", p.title, p.total, p.total)
+}
@@ -0,0 +1,309 @@
+package clone
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/pktline"
+)
+
+// gitProtocolV2 is the Git-Protocol header asking the server for the protocol v2
+const gitProtocolV2 = "version=2"
+
+// Discovery is the answer of the server to the info/refs request
+//
+// A server which understands the protocol v2 only advertises its capabilities, the
+// refs are then listed with LsRefs. Older servers advertise all their refs right away.
+type Discovery struct {
+	// Version is the protocol version the server answered with, 0 for v0/v1
+	Version      int
+	Capabilities Capabilities
+	// Refs are only set for the protocol v0/v1
+	Refs []GitRef
+}
+
+// Discover requests the info/refs of the upload-pack service, asking for the protocol v2
+func Discover(repoLink string) (*Discovery, error) {
+	refURL := fmt.Sprintf("%s/info/refs?service=%s", repoLink, gitUploadPack)
+	request, err := http.NewRequest("GET", refURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("discover refs: %w", err)
+	}
+	request.Header.Set("Git-Protocol", gitProtocolV2)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("discover refs: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("discover refs: invalid status code %s", response.Status)
+	}
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("discover refs: read response: %w", err)
+	}
+	return ParseDiscovery(content)
+}
+
+// ParseDiscovery parses the info/refs response, either the protocol v2 capability
+// advertisement
+//
+//	000eversion 2\n
+//	0013ls-refs=unborn\n
+//	0020fetch=shallow wait-for-done\n
+//	0000
+//
+// or the protocol v0/v1 ref advertisement (see ParseRefAdvertisement)
+func ParseDiscovery(content []byte) (*Discovery, error) {
+	reader := pktline.NewReader(bytes.NewReader(content))
+	for {
+		packetType, line, err := reader.ReadLine()
+		if err != nil {
+			break
+		}
+		// the service line and its flush-pkt are only sent to the v0 clients
+		if packetType != pktline.Data || strings.HasPrefix(line, "# service=") {
+			continue
+		}
+		if line != "version 2" {
+			break
+		}
+		capabilities, err := readCapabilityLines(reader)
+		if err != nil {
+			return nil, fmt.Errorf("parse capability advertisement: %w", err)
+		}
+		return &Discovery{Version: 2, Capabilities: capabilities}, nil
+	}
+
+	advertisement, err := ParseRefAdvertisement(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	return &Discovery{Capabilities: advertisement.Capabilities, Refs: advertisement.Refs}, nil
+}
+
+// readCapabilityLines reads the capabilities of the v2 advertisement up to the flush-pkt
+func readCapabilityLines(reader *pktline.Reader) (Capabilities, error) {
+	var capabilities Capabilities
+	for {
+		packetType, line, err := reader.ReadLine()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("missing flush-pkt")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if packetType == pktline.Flush {
+			return capabilities, nil
+		}
+		if packetType != pktline.Data || line == "" {
+			return nil, fmt.Errorf("unexpected %s", packetType)
+		}
+		capabilities = append(capabilities, line)
+	}
+}
+
+// LsRefs lists the refs of the server with the protocol v2 ls-refs command, only the refs
+// starting with one of the prefixes are sent (all the refs without prefixes)
+//
+// The symbolic refs come with their target and the annotated tags with their peeled hash.
+func LsRefs(repoLink string, server Capabilities, prefixes ...string) ([]GitRef, error) {
+	if !server.Has("ls-refs") {
+		return nil, fmt.Errorf("ls-refs: not supported by the server")
+	}
+	var request bytes.Buffer
+	writer := pktline.NewWriter(&request)
+	writeCommand(writer, "ls-refs", server, Capabilities{"agent=" + agent})
+	writer.WriteLine("peel")
+	writer.WriteLine("symrefs")
+	for _, prefix := range prefixes {
+		writer.WriteLine("ref-prefix %s", prefix)
+	}
+	writer.Flush()
+
+	response, err := postUploadPack(repoLink, request.Bytes(), true)
+	if err != nil {
+		return nil, fmt.Errorf("ls-refs: %w", err)
+	}
+	defer response.Close()
+	refs, err := parseLsRefs(response)
+	if err != nil {
+		return nil, fmt.Errorf("ls-refs: %w", err)
+	}
+	return refs, nil
+}
+
+// parseLsRefs parses the ls-refs response, one ref per line up to a flush-pkt
+//
+//	<hash> <name> [symref-target:<target>] [peeled:<hash>]
+func parseLsRefs(r io.Reader) ([]GitRef, error) {
+	reader := pktline.NewReader(r)
+	var refs []GitRef
+	for {
+		packetType, line, err := reader.ReadLine()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("missing flush-pkt")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if packetType == pktline.Flush {
+			return refs, nil
+		}
+		if strings.HasPrefix(line, "ERR ") {
+			return nil, fmt.Errorf("remote error: %s", strings.TrimPrefix(line, "ERR "))
+		}
+		fields := strings.Split(line, " ")
+		if packetType != pktline.Data || len(fields) < 2 || len(fields[0]) != 40 {
+			return nil, fmt.Errorf("malformed ref line %q", line)
+		}
+		ref := GitRef{Hash: fields[0], Name: fields[1]}
+		for _, attribute := range fields[2:] {
+			key, value, _ := strings.Cut(attribute, ":")
+			switch key {
+			case "symref-target":
+				ref.Target = value
+			case "peeled":
+				ref.Peeled = value
+			}
+		}
+		refs = append(refs, ref)
+	}
+}
+
+// fetchV2 sends the last fetch request of the negotiation, with the haves the server has
+// in common with the client
+func fetchV2(repoLink string, server Capabilities, req FetchRequest, haves []string) (*FetchResponse, error) {
+	request, err := fetchV2Request(server, req, haves, true)
+	if err != nil {
+		return nil, err
+	}
+	response, err := postUploadPack(repoLink, request, true)
+	if err != nil {
+		return nil, fmt.Errorf("fetch: %w", err)
+	}
+	body := bufio.NewReader(response)
+	shallowInfo, err := skipToPackfile(body)
+	if err != nil {
+		response.Close()
+		return nil, fmt.Errorf("fetch: %w", err)
+	}
+	return &FetchResponse{
+		ReadCloser:  readCloser{Reader: body, Closer: response},
+		ShallowInfo: shallowInfo,
+	}, nil
+}
+
+// fetchV2Request builds the fetch command, without done the server only answers with
+// the acknowledgments of the haves unless it is ready to send the pack
+func fetchV2Request(server Capabilities, req FetchRequest, haves []string, done bool) ([]byte, error) {
+	if !server.Has("fetch") {
+		return nil, fmt.Errorf("fetch: not supported by the server")
+	}
+	var request bytes.Buffer
+	writer := pktline.NewWriter(&request)
+	writeCommand(writer, "fetch", server, req.Capabilities)
+	// the protocol v2 always sends the pack on side-band-64k, the other capabilities
+	// of the protocol v0 are arguments of the fetch command
+	for _, feature := range []string{"thin-pack", "no-progress", "include-tag", "ofs-delta"} {
+		if req.Capabilities.Has(feature) {
+			writer.WriteLine(feature)
+		}
+	}
+	for _, want := range req.Wants {
+		writer.WriteLine("want %s", want)
+	}
+	writeShallowLines(writer, req)
+	if req.Filter != "" {
+		writer.WriteLine("filter %s", req.Filter)
+	}
+	for _, have := range haves {
+		writer.WriteLine("have %s", have)
+	}
+	if done {
+		writer.WriteLine("done")
+	}
+	writer.Flush()
+	return request.Bytes(), nil
+}
+
+// skipToPackfile reads the sections of the fetch response up to the packfile section
+//
+//	acknowledgments, shallow-info, wanted-refs, packfile-uris and packfile
+//
+// every section starts with its name and ends with a delim-pkt, only the shallow-info
+// section is kept besides the packfile
+func skipToPackfile(r io.Reader) (ShallowInfo, error) {
+	reader := pktline.NewReader(r)
+	shallowInfo := ShallowInfo{}
+	for {
+		packetType, line, err := reader.ReadLine()
+		if errors.Is(err, io.EOF) {
+			return shallowInfo, fmt.Errorf("no packfile section in the response")
+		}
+		if err != nil {
+			return shallowInfo, err
+		}
+		if strings.HasPrefix(line, "ERR ") {
+			return shallowInfo, fmt.Errorf("remote error: %s", strings.TrimPrefix(line, "ERR "))
+		}
+		if packetType == pktline.Flush {
+			return shallowInfo, fmt.Errorf("no packfile section in the response")
+		}
+		if packetType != pktline.Data {
+			continue
+		}
+		if line == "packfile" {
+			return shallowInfo, nil
+		}
+		if _, err := shallowInfo.add(line); err != nil {
+			return shallowInfo, err
+		}
+	}
+}
+
+// writeCommand writes the command and the capabilities of the request, the agent and
+// the object format are only sent when the server advertised them
+func writeCommand(writer *pktline.Writer, command string, server, negotiated Capabilities) {
+	writer.WriteLine("command=%s", command)
+	if clientAgent, ok := negotiated.Value("agent"); ok && server.Has("agent") {
+		writer.WriteLine("agent=%s", clientAgent)
+	}
+	if format, ok := server.Value("object-format"); ok {
+		writer.WriteLine("object-format=%s", format)
+	}
+	writer.Delim()
+}
+
+// postUploadPack sends the request to the upload-pack service
+func postUploadPack(repoLink string, body []byte, v2 bool) (io.ReadCloser, error) {
+	fullURL := fmt.Sprintf("%s/%s", repoLink, gitUploadPack)
+	request, err := http.NewRequest("POST", fullURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+	request.Header.Set("Accept", "application/x-git-upload-pack-result")
+	if v2 {
+		request.Header.Set("Git-Protocol", gitProtocolV2)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		response.Body.Close()
+		return nil, fmt.Errorf("invalid status code: %s", response.Status)
+	}
+	return response.Body, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,132 @@
+package clone
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/pktline"
+)
+
+// Deepen limits the history of a shallow fetch, the zero value fetches the whole history
+//
+// The commits at the limit become shallow: they are kept without their parents, and the
+// repository lists them in .git/shallow.
+type Deepen struct {
+	// Depth is the number of commits kept from the tips
+	Depth int
+	// Since excludes the commits older than this date
+	Since time.Time
+	// Not excludes the commits reachable from these remote refs
+	Not []string
+}
+
+// IsZero reports whether the whole history is fetched
+func (d Deepen) IsZero() bool {
+	return d.Depth == 0 && d.Since.IsZero() && len(d.Not) == 0
+}
+
+// ShallowInfo is the change of the shallow commits the server sends before the pack
+type ShallowInfo struct {
+	// Shallow are the commits which are now shallow
+	Shallow []string
+	// Unshallow are the commits which got their parents, they are no longer shallow
+	Unshallow []string
+}
+
+// add records a `shallow <hash>` or `unshallow <hash>` line, false is returned for the
+// other lines
+func (s *ShallowInfo) add(line string) (bool, error) {
+	kind, hash, _ := strings.Cut(line, " ")
+	if kind != "shallow" && kind != "unshallow" {
+		return false, nil
+	}
+	if len(hash) != 40 {
+		return false, fmt.Errorf("malformed shallow line %q", line)
+	}
+	if kind == "shallow" {
+		s.Shallow = append(s.Shallow, hash)
+	} else {
+		s.Unshallow = append(s.Unshallow, hash)
+	}
+	return true, nil
+}
+
+// expectsShallowInfo reports whether a protocol v0 server starts its response with the
+// shallow lines, it does when the client is shallow or asks for a shallow fetch
+func (req FetchRequest) expectsShallowInfo() bool {
+	return len(req.Shallow) > 0 || !req.Deepen.IsZero()
+}
+
+// checkShallowSupport fails when the server cannot honour the shallow request
+func checkShallowSupport(discovery *Discovery, req FetchRequest) error {
+	if !req.expectsShallowInfo() {
+		return nil
+	}
+	if discovery.Version == 2 {
+		fetch, _ := discovery.Capabilities.Value("fetch")
+		if !strings.Contains(" "+fetch+" ", " shallow ") {
+			return fmt.Errorf("the server does not support shallow fetches")
+		}
+		return nil
+	}
+	required := map[string]bool{
+		"shallow":      true,
+		"deepen-since": !req.Deepen.Since.IsZero(),
+		"deepen-not":   len(req.Deepen.Not) > 0,
+	}
+	for capability, needed := range required {
+		if needed && !discovery.Capabilities.Has(capability) {
+			return fmt.Errorf("the server does not support %s", capability)
+		}
+	}
+	return nil
+}
+
+// writeShallowLines writes the shallow commits of the client and the deepen arguments,
+// they follow the wants in both protocol versions
+func writeShallowLines(writer *pktline.Writer, req FetchRequest) {
+	for _, hash := range req.Shallow {
+		writer.WriteLine("shallow %s", hash)
+	}
+	if req.Deepen.Depth > 0 {
+		writer.WriteLine("deepen %d", req.Deepen.Depth)
+	}
+	if !req.Deepen.Since.IsZero() {
+		writer.WriteLine("deepen-since %d", req.Deepen.Since.Unix())
+	}
+	for _, ref := range req.Deepen.Not {
+		writer.WriteLine("deepen-not %s", ref)
+	}
+}
+
+// readShallowInfo reads the shallow lines a protocol v0 server sends before the
+// acknowledgments, up to the flush-pkt
+func readShallowInfo(r io.Reader) (ShallowInfo, error) {
+	reader := pktline.NewReader(r)
+	shallowInfo := ShallowInfo{}
+	for {
+		packetType, line, err := reader.ReadLine()
+		if errors.Is(err, io.EOF) {
+			return shallowInfo, fmt.Errorf("read shallow info: missing flush-pkt")
+		}
+		if err != nil {
+			return shallowInfo, fmt.Errorf("read shallow info: %w", err)
+		}
+		if packetType == pktline.Flush {
+			return shallowInfo, nil
+		}
+		if strings.HasPrefix(line, "ERR ") {
+			return shallowInfo, fmt.Errorf("remote error: %s", strings.TrimPrefix(line, "ERR "))
+		}
+		ok, err := shallowInfo.add(line)
+		if err != nil {
+			return shallowInfo, fmt.Errorf("read shallow info: %w", err)
+		}
+		if !ok {
+			return shallowInfo, fmt.Errorf("read shallow info: unexpected line %q", line)
+		}
+	}
+}
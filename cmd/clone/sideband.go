@@ -0,0 +1,142 @@
+package clone
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/pktline"
+)
+
+// The channels of the side-band-64k multiplexing, the first byte of every pkt-line
+const (
+	sideBandData     = 1
+	sideBandProgress = 2
+	sideBandError    = 3
+)
+
+// packData returns the pack file of the upload-pack response
+//
+// The NAK / ACK pkt-lines the server sends first are skipped and an ERR pkt-line is
+// returned as an error, then either the raw pack follows or, when side-band-64k was
+// requested, the pack is multiplexed with the progress messages which are written to
+// progress (prefixed with "remote: " like git does).
+func packData(response *bufio.Reader, progress io.Writer) (io.Reader, error) {
+	for {
+		start, err := response.Peek(4)
+		if err != nil {
+			return nil, fmt.Errorf("read upload-pack response: %w", err)
+		}
+		if bytes.Equal(start, []byte{'P', 'A', 'C', 'K'}) {
+			return response, nil
+		}
+		length, err := strconv.ParseUint(string(start), 16, 16)
+		if err != nil || (length > 0 && length < 5) {
+			return nil, fmt.Errorf("unexpected upload-pack response: %q", start)
+		}
+		if length == 0 {
+			// a flush-pkt between the acknowledgements
+			_, _ = response.Discard(4)
+			continue
+		}
+		// only the channel is needed, a data pkt-line can be bigger than the buffer
+		line, err := response.Peek(5)
+		if err != nil {
+			return nil, fmt.Errorf("read upload-pack response: %w", err)
+		}
+		if channel := line[4]; channel <= sideBandError {
+			return &sideBandReader{
+				packets:  pktline.NewReader(response),
+				progress: &remoteWriter{w: progress},
+			}, nil
+		}
+		if prefix, err := response.Peek(min(int(length), 8)); err == nil && bytes.HasPrefix(prefix[4:], []byte("ERR ")) {
+			_, packet, err := pktline.NewReader(response).ReadPacket()
+			if err != nil {
+				return nil, fmt.Errorf("read upload-pack response: %w", err)
+			}
+			return nil, fmt.Errorf("remote error: %s", bytes.TrimRight(packet[len("ERR "):], "\n"))
+		}
+		if _, err := response.Discard(int(length)); err != nil {
+			return nil, fmt.Errorf("read upload-pack response: %w", err)
+		}
+	}
+}
+
+// sideBandReader gives the content of the data channel of a side-band-64k stream
+type sideBandReader struct {
+	packets  *pktline.Reader
+	progress io.Writer
+	// pending is the part of the current data pkt-line not read yet
+	pending []byte
+	err     error
+}
+
+func (s *sideBandReader) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		s.err = s.readPacket()
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// readPacket reads the next pkt-line, the stream ends with a flush-pkt
+func (s *sideBandReader) readPacket() error {
+	packetType, packet, err := s.packets.ReadPacket()
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return fmt.Errorf("read side-band: %w", err)
+	}
+	if packetType == pktline.Flush {
+		return io.EOF
+	}
+	if packetType != pktline.Data || len(packet) == 0 {
+		return fmt.Errorf("read side-band: unexpected %s", packetType)
+	}
+	switch channel, payload := packet[0], packet[1:]; channel {
+	case sideBandData:
+		s.pending = payload
+	case sideBandProgress:
+		_, _ = s.progress.Write(payload)
+	case sideBandError:
+		return fmt.Errorf("remote error: %s", bytes.TrimRight(payload, "\n"))
+	default:
+		return fmt.Errorf("read side-band: invalid channel %d", channel)
+	}
+	return nil
+}
+
+// remoteWriter prefixes every line of the remote progress messages with "remote: ",
+// the lines end with '\n' or, for the progress updated in place, with '\r'
+type remoteWriter struct {
+	w        io.Writer
+	midLine  bool
+	prefixed bytes.Buffer
+}
+
+func (r *remoteWriter) Write(p []byte) (int, error) {
+	r.prefixed.Reset()
+	for _, b := range p {
+		if !r.midLine {
+			r.prefixed.WriteString("remote: ")
+			r.midLine = true
+		}
+		r.prefixed.WriteByte(b)
+		if b == '\n' || b == '\r' {
+			r.midLine = false
+		}
+	}
+	if _, err := r.w.Write(r.prefixed.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,367 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signature is the identity along with the time stamp used for the author,
+// committer and tagger of the objects
+//
+//	<name> <<email>> <unix-timestamp> <+-hhmm>
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+	// raw is the signature as it was found while parsing, it is written back as is
+	// when the signature is unchanged, so unusual signatures (e.g. "-0000" time zone)
+	// survive a parse and serialize round trip
+	raw string
+}
+
+// ParseSignature parses the value of an author, committer or tagger header
+func ParseSignature(value string) (Signature, error) {
+	emailStart, emailEnd := strings.IndexByte(value, '<'), strings.LastIndexByte(value, '>')
+	if emailStart == -1 || emailEnd < emailStart {
+		return Signature{}, fmt.Errorf("malformed signature %q", value)
+	}
+	sig := Signature{
+		Name:  strings.TrimSpace(value[:emailStart]),
+		Email: value[emailStart+1 : emailEnd],
+		raw:   value,
+	}
+	// a missing or broken date is kept as the zero time, the raw value still has it
+	fields := strings.Fields(value[emailEnd+1:])
+	if len(fields) != 2 {
+		return sig, nil
+	}
+	seconds, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return sig, nil
+	}
+	offset, ok := parseTimeZone(fields[1])
+	if !ok {
+		return sig, nil
+	}
+	sig.When = time.Unix(seconds, 0).In(time.FixedZone("", offset))
+	return sig, nil
+}
+
+// parseTimeZone parses the `+hhmm` time zone and returns the offset in seconds
+func parseTimeZone(tz string) (int, bool) {
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') {
+		return 0, false
+	}
+	hours, err := strconv.Atoi(tz[1:3])
+	if err != nil {
+		return 0, false
+	}
+	minutes, err := strconv.Atoi(tz[3:5])
+	if err != nil {
+		return 0, false
+	}
+	offset := (hours*60 + minutes) * 60
+	if tz[0] == '-' {
+		offset = -offset
+	}
+	return offset, true
+}
+
+// String formats the signature the way it is stored in the objects
+func (s Signature) String() string {
+	if s.raw != "" {
+		if parsed, err := ParseSignature(s.raw); err == nil && parsed.equal(s) {
+			return s.raw
+		}
+	}
+	_, offset := s.When.Zone()
+	sign := '+'
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	return fmt.Sprintf(
+		"%s <%s> %d %c%02d%02d",
+		s.Name,
+		s.Email,
+		s.When.Unix(),
+		sign,
+		offset/3600,
+		(offset%3600)/60,
+	)
+}
+
+func (s Signature) equal(other Signature) bool {
+	_, offset := s.When.Zone()
+	_, otherOffset := other.When.Zone()
+	return s.Name == other.Name && s.Email == other.Email && s.When.Equal(other.When) &&
+		offset == otherOffset
+}
+
+func (s Signature) isZero() bool {
+	return s.Name == "" && s.Email == "" && s.raw == "" && s.When.IsZero()
+}
+
+// Header is a header line of a commit or tag object, multi line values
+// (e.g. gpgsig) are stored with the lines joined by '\n'
+type Header struct {
+	Key   string
+	Value string
+	// bare is set when the line only had the key, without the space before the value,
+	// the line is written back the same way while the value stays empty
+	bare bool
+}
+
+// Commit is the parsed form of a commit object
+//
+// Parsing and serializing an object gives back the exact same bytes, the order of the
+// headers and any unknown header is remembered while parsing.
+type Commit struct {
+	// Tree and Parents are 40 character hex hashes
+	Tree      string
+	Parents   []string
+	Author    Signature
+	Committer Signature
+	Encoding  string
+	// MergeTags are the tag objects embedded by merging a signed tag
+	MergeTags []string
+	// GPGSig is the signature of the commit, the object is signed without this header
+	GPGSig       string
+	ExtraHeaders []Header
+	Message      string
+
+	// headerOrder has the header keys in the order they were found while parsing
+	headerOrder []string
+	// bareKeys are the single value headers found without a space after the key
+	bareKeys map[string]bool
+	// noMessage is set when the object did not have the empty line after the headers
+	noMessage bool
+}
+
+// ParseCommit parses the body of a commit object (without the `commit <size>\0` header)
+func ParseCommit(content []byte) (*Commit, error) {
+	headers, message, hasMessage, err := parseObjectHeaders(content)
+	if err != nil {
+		return nil, fmt.Errorf("parse commit: %w", err)
+	}
+	commit := &Commit{Message: message, noMessage: !hasMessage}
+	seen := map[string]bool{}
+	for _, header := range headers {
+		key := header.Key
+		switch {
+		case key == "parent":
+			commit.Parents = append(commit.Parents, header.Value)
+		case key == "mergetag":
+			commit.MergeTags = append(commit.MergeTags, header.Value)
+		case seen[key]:
+			// duplicated single value headers are kept as extra headers
+			key = ""
+		case key == "tree":
+			commit.Tree = header.Value
+		case key == "author" || key == "committer":
+			sig, err := ParseSignature(header.Value)
+			if err != nil {
+				return nil, fmt.Errorf("parse commit: %s: %w", key, err)
+			}
+			if key == "author" {
+				commit.Author = sig
+			} else {
+				commit.Committer = sig
+			}
+		case key == "encoding":
+			commit.Encoding = header.Value
+		case key == "gpgsig":
+			commit.GPGSig = header.Value
+		default:
+			key = ""
+		}
+		if key == "" {
+			commit.ExtraHeaders = append(commit.ExtraHeaders, header)
+		} else if header.bare {
+			commit.bareKeys = addBareKey(commit.bareKeys, key)
+		}
+		seen[key] = true
+		commit.headerOrder = append(commit.headerOrder, key)
+	}
+	if len(commit.Tree) != 40 {
+		return nil, fmt.Errorf("parse commit: missing or invalid tree %q", commit.Tree)
+	}
+	return commit, nil
+}
+
+// Serialize returns the body of the commit object
+func (c *Commit) Serialize() []byte {
+	var buffer bytes.Buffer
+	w := headerWriter{buffer: &buffer}
+	parents, mergeTags, extras := c.Parents, c.MergeTags, c.ExtraHeaders
+	written := map[string]bool{}
+	writeKey := func(key string) {
+		switch key {
+		case "parent":
+			if len(parents) > 0 {
+				w.write("parent", parents[0])
+				parents = parents[1:]
+			}
+			return
+		case "mergetag":
+			if len(mergeTags) > 0 {
+				w.write("mergetag", mergeTags[0])
+				mergeTags = mergeTags[1:]
+			}
+			return
+		case "":
+			if len(extras) > 0 {
+				w.writeHeader(extras[0])
+				extras = extras[1:]
+			}
+			return
+		}
+		if written[key] {
+			return
+		}
+		written[key] = true
+		switch key {
+		case "tree":
+			w.write("tree", c.Tree)
+		case "author":
+			w.writeSignature("author", c.Author)
+		case "committer":
+			w.writeSignature("committer", c.Committer)
+		case "encoding":
+			w.writeOptional(Header{Key: "encoding", Value: c.Encoding, bare: c.bareKeys["encoding"]})
+		case "gpgsig":
+			w.writeOptional(Header{Key: "gpgsig", Value: c.GPGSig, bare: c.bareKeys["gpgsig"]})
+		}
+	}
+
+	for _, key := range c.headerOrder {
+		writeKey(key)
+	}
+	// whatever was not written in the original order goes in the order git uses
+	for _, key := range []string{"tree", "author", "committer", "encoding", "gpgsig"} {
+		if key == "author" {
+			for len(parents) > 0 {
+				writeKey("parent")
+			}
+		}
+		if key == "gpgsig" {
+			for len(mergeTags) > 0 {
+				writeKey("mergetag")
+			}
+		}
+		writeKey(key)
+	}
+	for len(extras) > 0 {
+		writeKey("")
+	}
+
+	if !c.noMessage || c.Message != "" {
+		buffer.WriteByte('\n')
+	}
+	buffer.WriteString(c.Message)
+	return buffer.Bytes()
+}
+
+// Subject is the first paragraph of the message joined in a single line
+func (c *Commit) Subject() string {
+	return messageSubject(c.Message)
+}
+
+// Body is the message without the subject paragraph
+func (c *Commit) Body() string {
+	return messageBody(c.Message)
+}
+
+func messageSubject(message string) string {
+	paragraph, _, _ := strings.Cut(strings.TrimLeft(message, "\n"), "\n\n")
+	lines := strings.Split(strings.TrimRight(paragraph, "\n"), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " \t")
+	}
+	return strings.Join(lines, " ")
+}
+
+func messageBody(message string) string {
+	_, body, _ := strings.Cut(strings.TrimLeft(message, "\n"), "\n\n")
+	body = strings.TrimLeft(body, "\n")
+	if body == "" {
+		return ""
+	}
+	return strings.TrimRight(body, "\n") + "\n"
+}
+
+// parseObjectHeaders splits the commit or tag object into the headers and the message
+//
+// A header line is `<key> <value>`, the lines starting with a space are the continuation of
+// the previous header. The headers end at the first empty line and the rest is the message.
+func parseObjectHeaders(content []byte) ([]Header, string, bool, error) {
+	var headers []Header
+	rest := string(content)
+	for rest != "" {
+		line, remaining, found := strings.Cut(rest, "\n")
+		if !found {
+			return nil, "", false, fmt.Errorf("unterminated header line %q", line)
+		}
+		rest = remaining
+		if line == "" {
+			return headers, rest, true, nil
+		}
+		if line[0] == ' ' {
+			if len(headers) == 0 {
+				return nil, "", false, fmt.Errorf("continuation line without a header")
+			}
+			headers[len(headers)-1].Value += "\n" + line[1:]
+			continue
+		}
+		key, value, found := strings.Cut(line, " ")
+		headers = append(headers, Header{Key: key, Value: value, bare: !found})
+	}
+	return headers, "", false, nil
+}
+
+// addBareKey adds the key to the set of headers found without a value
+func addBareKey(keys map[string]bool, key string) map[string]bool {
+	if keys == nil {
+		keys = map[string]bool{}
+	}
+	keys[key] = true
+	return keys
+}
+
+// headerWriter writes the headers of commit and tag objects
+type headerWriter struct {
+	buffer *bytes.Buffer
+}
+
+func (w headerWriter) write(key, value string) {
+	w.buffer.WriteString(key)
+	w.buffer.WriteByte(' ')
+	w.buffer.WriteString(strings.ReplaceAll(value, "\n", "\n "))
+	w.buffer.WriteByte('\n')
+}
+
+// writeHeader writes the header, a bare header keeps its line without a space
+func (w headerWriter) writeHeader(header Header) {
+	if header.bare && header.Value == "" {
+		w.buffer.WriteString(header.Key)
+		w.buffer.WriteByte('\n')
+		return
+	}
+	w.write(header.Key, header.Value)
+}
+
+// writeOptional writes the header unless its value is empty and it was not in the
+// parsed object
+func (w headerWriter) writeOptional(header Header) {
+	if header.Value != "" || header.bare {
+		w.writeHeader(header)
+	}
+}
+
+func (w headerWriter) writeSignature(key string, sig Signature) {
+	if !sig.isZero() {
+		w.write(key, sig.String())
+	}
+}
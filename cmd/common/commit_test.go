@@ -0,0 +1,162 @@
+package common
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+const signedMergeCommit = `tree 5b1c8f3dd6c2f9a6f1f4c3c2b0a0d4b3a2e1f0c9
+parent 47b37f1a82bfe85f6d8df52b6258b75e4343b7fd
+parent 915afa45210c408ad4f05cc19fe18acb4375128a
+author A U Thor <author@example.com> 1700000000 +0530
+committer C O Mitter <committer@example.com> 1700000100 -0000
+encoding ISO-8859-1
+mergetag object 915afa45210c408ad4f05cc19fe18acb4375128a
+ type commit
+ tag v1.0
+ tagger T Agger <tagger@example.com> 1690000000 +0000
+ 
+ release v1.0
+gpgsig -----BEGIN PGP SIGNATURE-----
+ 
+ iQEzBAABCAAdFiEE
+ =abcd
+ -----END PGP SIGNATURE-----
+x-custom-header some value
+
+Merge tag 'v1.0'
+
+* tag 'v1.0':
+  release
+`
+
+func TestCommitRoundTrip(t *testing.T) {
+	commit, err := ParseCommit([]byte(signedMergeCommit))
+	if err != nil {
+		t.Fatalf("ParseCommit() error = %v", err)
+	}
+	if len(commit.Parents) != 2 || len(commit.MergeTags) != 1 {
+		t.Fatalf("ParseCommit() parents = %v, mergetags = %d", commit.Parents, len(commit.MergeTags))
+	}
+	if commit.Encoding != "ISO-8859-1" || commit.GPGSig == "" || len(commit.ExtraHeaders) != 1 {
+		t.Errorf("ParseCommit() missing headers: %+v", commit)
+	}
+	if commit.Author.Name != "A U Thor" || commit.Author.When.Unix() != 1700000000 {
+		t.Errorf("ParseCommit() author = %+v", commit.Author)
+	}
+	if _, offset := commit.Author.When.Zone(); offset != 5*3600+30*60 {
+		t.Errorf("ParseCommit() author time zone offset = %d", offset)
+	}
+	if commit.Subject() != "Merge tag 'v1.0'" {
+		t.Errorf("Subject() = %q", commit.Subject())
+	}
+	if got := commit.Serialize(); !bytes.Equal(got, []byte(signedMergeCommit)) {
+		t.Errorf("Serialize() round trip mismatch\ngot:\n%s\nexpected:\n%s", got, signedMergeCommit)
+	}
+}
+
+func TestCommitSerializeUnusualOrder(t *testing.T) {
+	content := "tree 5b1c8f3dd6c2f9a6f1f4c3c2b0a0d4b3a2e1f0c9\n" +
+		"author A <a@example.com> 1700000000 +0000\n" +
+		"x-first 1\n" +
+		"committer C <c@example.com> 1700000000 +0000\n" +
+		"gpgsig sig\n" +
+		"encoding UTF-8\n" +
+		"\nmessage\n"
+	commit, err := ParseCommit([]byte(content))
+	if err != nil {
+		t.Fatalf("ParseCommit() error = %v", err)
+	}
+	if got := string(commit.Serialize()); got != content {
+		t.Errorf("Serialize() round trip mismatch\ngot:\n%s\nexpected:\n%s", got, content)
+	}
+
+	// a changed value is written in place of the old one
+	commit.Parents = append(commit.Parents, "47b37f1a82bfe85f6d8df52b6258b75e4343b7fd")
+	commit.Author.When = time.Unix(1700000001, 0).In(time.FixedZone("", -3600))
+	expected := "tree 5b1c8f3dd6c2f9a6f1f4c3c2b0a0d4b3a2e1f0c9\n" +
+		"author A <a@example.com> 1700000001 -0100\n" +
+		"x-first 1\n" +
+		"committer C <c@example.com> 1700000000 +0000\n" +
+		"gpgsig sig\n" +
+		"encoding UTF-8\n" +
+		"parent 47b37f1a82bfe85f6d8df52b6258b75e4343b7fd\n" +
+		"\nmessage\n"
+	if got := string(commit.Serialize()); got != expected {
+		t.Errorf("Serialize() after change\ngot:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestCommitBareHeaders(t *testing.T) {
+	// headers without a value, with and without the space after the key
+	content := "tree 5b1c8f3dd6c2f9a6f1f4c3c2b0a0d4b3a2e1f0c9\n" +
+		"author A <a@example.com> 1700000000 +0000\n" +
+		"committer C <c@example.com> 1700000000 +0000\n" +
+		"encoding\n" +
+		"x-bare\n" +
+		"x-empty \n" +
+		"\nmessage\n"
+	commit, err := ParseCommit([]byte(content))
+	if err != nil {
+		t.Fatalf("ParseCommit() error = %v", err)
+	}
+	if got := string(commit.Serialize()); got != content {
+		t.Errorf("Serialize() round trip mismatch\ngot:\n%q\nexpected:\n%q", got, content)
+	}
+
+	commit.Encoding = "UTF-8"
+	commit.ExtraHeaders[0].Value = "1"
+	expected := strings.Replace(strings.Replace(content, "encoding\n", "encoding UTF-8\n", 1), "x-bare\n", "x-bare 1\n", 1)
+	if got := string(commit.Serialize()); got != expected {
+		t.Errorf("Serialize() after change\ngot:\n%q\nexpected:\n%q", got, expected)
+	}
+
+	tagContent := "object 915afa45210c408ad4f05cc19fe18acb4375128a\ntype commit\ntag\n\nmessage\n"
+	tag, err := ParseTag([]byte(tagContent))
+	if err != nil {
+		t.Fatalf("ParseTag() error = %v", err)
+	}
+	if got := string(tag.Serialize()); got != tagContent {
+		t.Errorf("Serialize() of the tag round trip mismatch\ngot:\n%q\nexpected:\n%q", got, tagContent)
+	}
+}
+
+func TestNewCommitSerialize(t *testing.T) {
+	when := time.Unix(1700000000, 0).In(time.FixedZone("", 3600))
+	commit := Commit{
+		Tree:      "5b1c8f3dd6c2f9a6f1f4c3c2b0a0d4b3a2e1f0c9",
+		Parents:   []string{"47b37f1a82bfe85f6d8df52b6258b75e4343b7fd"},
+		Author:    Signature{Name: "A", Email: "a@example.com", When: when},
+		Committer: Signature{Name: "C", Email: "c@example.com", When: when},
+		Message:   "subject\n",
+	}
+	expected := "tree 5b1c8f3dd6c2f9a6f1f4c3c2b0a0d4b3a2e1f0c9\n" +
+		"parent 47b37f1a82bfe85f6d8df52b6258b75e4343b7fd\n" +
+		"author A <a@example.com> 1700000000 +0100\n" +
+		"committer C <c@example.com> 1700000000 +0100\n" +
+		"\nsubject\n"
+	if got := string(commit.Serialize()); got != expected {
+		t.Errorf("Serialize()\ngot:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestTagRoundTrip(t *testing.T) {
+	content := "object 915afa45210c408ad4f05cc19fe18acb4375128a\n" +
+		"type commit\n" +
+		"tag v1.0\n" +
+		"tagger T Agger <tagger@example.com> 1690000000 +0000\n" +
+		"\nrelease v1.0\n" +
+		"-----BEGIN PGP SIGNATURE-----\n\nabc\n-----END PGP SIGNATURE-----\n"
+	tag, err := ParseTag([]byte(content))
+	if err != nil {
+		t.Fatalf("ParseTag() error = %v", err)
+	}
+	if tag.Name != "v1.0" || tag.Type != "commit" || tag.Tagger.Email != "tagger@example.com" {
+		t.Errorf("ParseTag() = %+v", tag)
+	}
+	if got := string(tag.Serialize()); got != content {
+		t.Errorf("Serialize() round trip mismatch\ngot:\n%s\nexpected:\n%s", got, content)
+	}
+}
@@ -0,0 +1,79 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateLayouts are the absolute date formats ParseDate understands, ISO 8601 like and
+// RFC 2822 like, along with the default format of `git log`
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC1123Z,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 -0700",
+	"Mon Jan 2 15:04:05 2006 -0700",
+}
+
+// relativeUnits are the units of the relative dates like "2 weeks ago"
+var relativeUnits = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+	"week":   7 * 24 * time.Hour,
+	"month":  30 * 24 * time.Hour,
+	"year":   365 * 24 * time.Hour,
+}
+
+// ParseDate parses the dates git accepts on the command line: a unix timestamp
+// (`@1700000000` or `1700000000`) optionally followed by a `+hhmm` time zone, an ISO 8601
+// or RFC 2822 date, or a date relative to now like `3 days ago`. The dates without a time
+// zone are in the local time zone.
+func ParseDate(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if timestamp, tz, found := strings.Cut(value, " "); found {
+		if offset, ok := parseTimeZone(tz); ok {
+			parsed, err := ParseDate(timestamp, now)
+			if err == nil && (strings.HasPrefix(timestamp, "@") || isDigits(timestamp)) {
+				return parsed.In(time.FixedZone("", offset)), nil
+			}
+		}
+	}
+	if digits, ok := strings.CutPrefix(value, "@"); ok || isDigits(value) && len(value) >= 9 {
+		seconds, err := strconv.ParseInt(digits, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date %q", value)
+		}
+		return time.Unix(seconds, 0), nil
+	}
+	for _, layout := range dateLayouts {
+		if parsed, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return parsed, nil
+		}
+	}
+	if fields := strings.Fields(value); len(fields) == 3 && fields[2] == "ago" {
+		count, err := strconv.Atoi(fields[0])
+		unit, known := relativeUnits[strings.TrimSuffix(fields[1], "s")]
+		if err == nil && known {
+			return now.Add(-time.Duration(count) * unit), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+func isDigits(value string) bool {
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return value != ""
+}
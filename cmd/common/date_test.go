@@ -0,0 +1,36 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := map[string]int64{
+		"@1700000000":                     1700000000,
+		"1700000000":                      1700000000,
+		"2023-11-14T22:13:20Z":            1700000000,
+		"2023-11-14 23:13:20 +0100":       1700000000,
+		"Tue, 14 Nov 2023 22:13:20 +0000": 1700000000,
+		"@1700000000 +0100":               1700000000,
+		"1700000000 -0230":                1700000000,
+		"2023-11-14T23:13:20+0100":        1700000000,
+		"2 days ago":                      now.Unix() - 2*24*3600,
+		"1 week ago":                      now.Unix() - 7*24*3600,
+	}
+	for value, want := range tests {
+		got, err := ParseDate(value, now)
+		if err != nil || got.Unix() != want {
+			t.Errorf("ParseDate(%q) = %v, %v, want %d", value, got.Unix(), err, want)
+		}
+	}
+	if got, _ := ParseDate("@1700000000 -0230", now); got.Format("-0700") != "-0230" {
+		t.Errorf("ParseDate() lost the time zone, got %s", got.Format("-0700"))
+	}
+	for _, invalid := range []string{"", "yesterday-ish", "2 fortnights ago", "@x", "@x +0100"} {
+		if _, err := ParseDate(invalid, now); err == nil {
+			t.Errorf("ParseDate(%q) did not fail", invalid)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package common
+
+import "fmt"
+
+// maxResultSizeHint caps the buffer allocated upfront for the result of a delta, the size
+// in the delta comes from the pack and the buffer grows up to it as the result is written
+const maxResultSizeHint = 1 << 20
+
+// readVarInt is reading the size of data in the same way as we did in `ReadPackEntryHeader`. The
+// difference here is that in `ReadPackEntryHeader` first byte also contains the information about object
+// type whereas here we directly read the 7 bytes as size bytes and MSB as way to continue or not
+func readVarInt(data []byte, offset int) (size int, newOffset int, err error) {
+	result, shift := 0, 0
+	for {
+		if offset >= len(data) {
+			return 0, 0, fmt.Errorf("unexpected end of data while reading variable-length integer")
+		}
+		b := data[offset]
+		offset++
+
+		// 0x7f -> 0b01111111
+		// 0x80 -> 0b10000000
+		result |= (int(b) & 0x7f) << shift
+		if (b & 0x80) == 0 {
+			break
+		}
+
+		shift += 7
+
+		if shift >= 63 {
+			return 0, 0, fmt.Errorf("variable-length integer too large or malformed")
+		}
+	}
+	return result, offset, nil
+}
+
+// ApplyDelta applies the delta instructions to the base object content and returns
+// the content of the resulting object
+//
+// The delta format is described in the
+// [git documentation](https://git-scm.com/docs/gitformat-pack#_deltified_representation)
+func ApplyDelta(baseContent, deltaInstructions []byte) ([]byte, error) {
+	deltaOffset := 0
+
+	baseSizeFromDelta, deltaOffset, err := readVarInt(deltaInstructions, deltaOffset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read base object size from delta: %w", err)
+	}
+
+	if baseSizeFromDelta != len(baseContent) {
+		return nil, fmt.Errorf(
+			"base object size mismatch: delta expects %d bytes, actual base is %d bytes",
+			baseSizeFromDelta,
+			len(baseContent),
+		)
+	}
+
+	resultSize, deltaOffset, err := readVarInt(deltaInstructions, deltaOffset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read result object size from delta: %w", err)
+	}
+
+	result := make([]byte, 0, min(resultSize, maxResultSizeHint))
+
+	for deltaOffset < len(deltaInstructions) {
+		commandByte := deltaInstructions[deltaOffset]
+		deltaOffset++
+
+		// 0x80 -> 0b10000000
+		// 0x7f -> 0b01111111
+		// 0x01 -> 0b00000001
+		// 0x02 -> 0b00000010
+		// 0x04 -> 0b00000100
+		// 0x08 -> 0b00001000
+		// 0x10 -> 0b00010000
+		// 0x20 -> 0b00100000
+		// 0x40 -> 0b01000000
+		// 0x10000 -> 0b00010000000000000000 (65536)
+
+		// MSB is 0: Add literal data
+		if (commandByte & 0x80) == 0 {
+			length := int(commandByte & 0x7f)
+			if length == 0 {
+				// Special case for length encoded in subsequent bytes
+				// This is a simplified handler. A full implementation would read a varint for
+				// length here. For now, if we encounter this, it means the delta is more complex
+				// than this simplified parser handles. Git uses a single byte for small literal
+				// lengths (0-127). For lengths > 127, it encodes them
+				// as a varint where the first byte is 0, and the actual length follows as a varint.
+				// This would involve another call to readVarInt here.
+				// For many common deltas, this case might not be hit, but it's important for full
+				// compliance.
+				return nil, fmt.Errorf(
+					"unsupported literal data length encoding (command byte 0x00). A varint for length is expected here",
+				)
+			}
+
+			if deltaOffset+length > len(deltaInstructions) {
+				return nil, fmt.Errorf(
+					"delta instructions truncated: literal data length %d exceeds remaining delta bytes at offset %d",
+					length,
+					deltaOffset-1,
+				)
+			}
+			if len(result)+length > resultSize {
+				return nil, fmt.Errorf("delta result exceeds its declared size of %d bytes", resultSize)
+			}
+
+			result = append(
+				result,
+				deltaInstructions[deltaOffset:deltaOffset+length]...,
+			)
+			deltaOffset += length
+			continue
+		}
+		// MSB is 1: Copy from base command
+		offset := 0
+		size := 0x10000 // Default size if no size bits are set
+
+		// Read bytes for the offset
+		// Bits 0-3 of the command byte determine how many bytes contribute to the offset.
+		// Each bit, if set, means the next byte in the delta instructions contributes to the
+		// offset.
+		// The bytes are read in little-endian order.
+		lowerBits := [...]byte{0x01, 0x02, 0x04, 0x08}
+		for i, bit := range lowerBits {
+			if (commandByte & bit) == 0 {
+				continue
+			}
+			if deltaOffset >= len(deltaInstructions) {
+				return nil, fmt.Errorf("delta instructions truncated while reading offset byte %d", i+1)
+			}
+			offset |= int(deltaInstructions[deltaOffset]) << (8 * i)
+			deltaOffset++
+		}
+
+		// Read bytes for the size
+		// Bits 4-6 of the command byte determine how many bytes contribute to the size.
+		// If none are set, the size defaults to 0x10000.
+		// The bytes are read in little-endian order.
+		// IMPORTANT: If a size byte is read, it *initializes* `size`,
+		// otherwise the default `0x10000` is used.
+		sizeBytesRead := 0
+		higherBits := [...]byte{0x10, 0x20, 0x40}
+		for i, bit := range higherBits {
+			if (commandByte & bit) == 0 {
+				continue
+			}
+			if deltaOffset >= len(deltaInstructions) {
+				return nil, fmt.Errorf("delta instructions truncated while reading size byte %d", i+1)
+			}
+			// if it's the first byte read then initialize size
+			if i == 0 || sizeBytesRead == 0 {
+				size = int(deltaInstructions[deltaOffset]) << (8 * i)
+				deltaOffset++
+				sizeBytesRead++
+				continue
+			}
+			// Otherwise OR it
+			size |= int(deltaInstructions[deltaOffset]) << (8 * i)
+
+			deltaOffset++
+			sizeBytesRead++
+		}
+
+		// Validate that the copy operation is within the bounds of the base content.
+		if offset < 0 || size < 0 || offset+size > len(baseContent) {
+			return nil, fmt.Errorf("copy command out of bounds: offset %d, size %d, base content length %d", offset, size, len(baseContent))
+		}
+		if len(result)+size > resultSize {
+			return nil, fmt.Errorf("delta result exceeds its declared size of %d bytes", resultSize)
+		}
+
+		result = append(result, baseContent[offset:offset+size]...)
+	}
+
+	if len(result) != resultSize {
+		return nil, fmt.Errorf(
+			"resolved content size mismatch: expected %d bytes, actual %d bytes",
+			resultSize,
+			len(result),
+		)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,34 @@
+package common
+
+import "testing"
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("hello world")
+	tests := []struct {
+		name  string
+		delta []byte
+		want  string
+	}{
+		// copy "hello" from the base, then insert "!"
+		{name: "copy and insert", delta: []byte{11, 6, 0x90, 5, 1, '!'}, want: "hello!"},
+		{name: "insert only", delta: []byte{11, 2, 2, 'h', 'i'}, want: "hi"},
+		{name: "insert past the size", delta: []byte{11, 1, 2, 'h', 'i'}},
+		{name: "copy past the size", delta: []byte{11, 4, 0x90, 5}},
+		{name: "shorter than the size", delta: []byte{11, 3, 2, 'h', 'i'}},
+		{name: "base size mismatch", delta: []byte{10, 2, 2, 'h', 'i'}},
+		// a huge declared size is not allocated upfront
+		{name: "huge size", delta: []byte{11, 0xff, 0xff, 0xff, 0xff, 0x0f, 2, 'h', 'i'}},
+	}
+	for _, test := range tests {
+		result, err := ApplyDelta(base, test.delta)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%s: ApplyDelta() = %q, expected an error", test.name, result)
+			}
+			continue
+		}
+		if err != nil || string(result) != test.want {
+			t.Errorf("%s: ApplyDelta() = %q, %v, expected %q", test.name, result, err, test.want)
+		}
+	}
+}
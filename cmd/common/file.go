@@ -84,7 +84,7 @@ func CreateEmptyObjectFile(baseDir, hash string) (*os.File, error) {
 	if len(hash) != 40 {
 		return nil, fmt.Errorf("invalid length of sha object: %d", len(hash))
 	}
-	dir := filepath.Join(baseDir, ".git", "objects", hash[:2])
+	dir := filepath.Join(GitDir(baseDir), "objects", hash[:2])
 	err := os.MkdirAll(dir, os.ModePerm)
 	if err != nil && !os.IsExist(err) {
 		return nil, err
@@ -96,16 +96,18 @@ func CreateEmptyObjectFile(baseDir, hash string) (*os.File, error) {
 // GetFileFromHash splits the hash into git object format
 //
 // e.g. "23abcdefgh...." -> ./git/objects/23/<remaniing_38_chars>
+//
+// Only loose objects have a file, ReadObject also finds the objects in pack files
 func GetFileFromHash(basdir, objHash string) (*os.File, error) {
 	if len(objHash) != 40 {
 		return nil, fmt.Errorf("invalid object hash: %q", objHash)
 	}
 	dir, rest := objHash[0:2], objHash[2:]
-	path := filepath.Join(basdir, ".git", "objects", dir, rest)
+	path := filepath.Join(GitDir(basdir), "objects", dir, rest)
 	file, err := os.Open(path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return nil, fmt.Errorf("no such object: %q & %q", objHash, path)
+			return nil, fmt.Errorf("no such object: %q & %q: %w", objHash, path, err)
 		}
 		return nil, fmt.Errorf("could not open the object file %q: %w", objHash, err)
 	}
@@ -137,11 +139,6 @@ func ReadObjectFile(r io.Reader) ([]byte, string, error) {
 func ReadCompressed(r io.Reader) ([]byte, error) {
 	zlibReader, err := zlib.NewReader(r)
 	if err != nil {
-		full, err2 := io.ReadAll(r)
-		fmt.Println(err2)
-		fmt.Println("----------------DEBUG-------------------------------------")
-		fmt.Printf("%s", full)
-		fmt.Println("----------------DEBUG-------------------------------------")
 		return nil, fmt.Errorf("read compressed: create zlib reader: %w", err)
 	}
 	defer func() {
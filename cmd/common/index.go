@@ -0,0 +1,323 @@
+package common
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	indexSignature = "DIRC"
+	// indexEntryFixedSize is the size of an index entry without the path name
+	// 10 uint32 stat fields + 20 byte sha + 2 byte flags
+	indexEntryFixedSize = 62
+	indexNameMask       = 0x0fff
+	indexExtendedFlag   = 0x4000
+	indexStageMask      = 0x3000
+	indexStageShift     = 12
+
+	// indexEntryMinSize is the size of an entry with a one letter name and its padding
+	indexEntryMinSize = 64
+)
+
+// Index is the in memory representation of the `.git/index` file (the staging area)
+//
+// The on disk format is described in the
+// [git documentation](https://git-scm.com/docs/index-format). We are able to read
+// version 2 and 3 of the format and we always write version 2.
+type Index struct {
+	Version uint32
+	// Entries are kept sorted by name (and stage), which is what git expects
+	Entries []IndexEntry
+}
+
+// IndexEntry is a single staged path along with the stat data captured when it was staged
+type IndexEntry struct {
+	CTimeSec  uint32
+	CTimeNano uint32
+	MTimeSec  uint32
+	MTimeNano uint32
+	Dev       uint32
+	Ino       uint32
+	// Mode is the git object mode e.g. 0100644, 0100755, 0120000
+	Mode uint32
+	UID  uint32
+	GID  uint32
+	// Size is the on-disk size of the file truncated to 32 bits
+	Size uint32
+	// SHA is the raw 20 byte sha of the blob
+	SHA [20]byte
+	// Flags holds the assume-valid bit and the merge stage, the name length
+	// is computed while writing
+	Flags uint16
+	// Name is the slash separated path relative to the repository root
+	Name string
+}
+
+// Stage returns the merge stage of the entry (0 for normal entries)
+func (e IndexEntry) Stage() int {
+	return int(e.Flags&indexStageMask) >> indexStageShift
+}
+
+// NewIndexEntry creates an entry for the given path from its stat data and blob sha
+func NewIndexEntry(name string, info os.FileInfo, sha [20]byte) IndexEntry {
+	entry := IndexEntry{
+		Name: filepath.ToSlash(name),
+		Mode: GitModeFromFileInfo(info),
+		Size: uint32(info.Size()),
+		SHA:  sha,
+	}
+	fillStatData(&entry, info)
+	return entry
+}
+
+// StatMatches reports whether the file has the stat data recorded in the entry, in which
+// case its content is taken as unchanged without hashing it again. The mode is left out,
+// a change of the executable bit is a change of its own.
+func (e IndexEntry) StatMatches(info os.FileInfo) bool {
+	current := NewIndexEntry(e.Name, info, e.SHA)
+	return current.Size == e.Size &&
+		current.MTimeSec == e.MTimeSec && current.MTimeNano == e.MTimeNano &&
+		current.CTimeSec == e.CTimeSec && current.CTimeNano == e.CTimeNano &&
+		current.Ino == e.Ino && current.Dev == e.Dev &&
+		current.UID == e.UID && current.GID == e.GID
+}
+
+// IsRacy reports whether the file may have changed in the same instant the index was
+// written, its stat data then cannot be trusted and the content has to be hashed
+func (e IndexEntry) IsRacy(indexTime time.Time) bool {
+	mtime := time.Unix(int64(e.MTimeSec), int64(e.MTimeNano))
+	return !mtime.Before(indexTime)
+}
+
+// GitModeFromFileInfo returns the mode git would record for the given file
+func GitModeFromFileInfo(info os.FileInfo) uint32 {
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return 0120000
+	case info.Mode().Perm()&0111 != 0:
+		return 0100755
+	default:
+		return 0100644
+	}
+}
+
+// Entry returns the stage 0 entry for the given name
+func (idx *Index) Entry(name string) (IndexEntry, bool) {
+	i, found := idx.search(name)
+	if !found {
+		return IndexEntry{}, false
+	}
+	return idx.Entries[i], true
+}
+
+// Add inserts the entry in the index replacing the existing entry with the same name
+func (idx *Index) Add(entry IndexEntry) {
+	i, found := idx.search(entry.Name)
+	if found {
+		idx.Entries[i] = entry
+		return
+	}
+	idx.Entries = append(idx.Entries, IndexEntry{})
+	copy(idx.Entries[i+1:], idx.Entries[i:])
+	idx.Entries[i] = entry
+}
+
+// Remove removes the entry with the given name, and reports if it was present
+func (idx *Index) Remove(name string) bool {
+	i, found := idx.search(name)
+	if !found {
+		return false
+	}
+	idx.Entries = append(idx.Entries[:i], idx.Entries[i+1:]...)
+	return true
+}
+
+func (idx *Index) search(name string) (int, bool) {
+	i := sort.Search(len(idx.Entries), func(i int) bool {
+		return idx.Entries[i].Name >= name
+	})
+	return i, i < len(idx.Entries) && idx.Entries[i].Name == name
+}
+
+// ReadIndex reads the `index` file of the git directory
+//
+// If the index does not exist yet (e.g. freshly initialized repository) an empty index
+// is returned
+func ReadIndex(gitDir string) (*Index, error) {
+	content, err := os.ReadFile(filepath.Join(gitDir, "index"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &Index{Version: 2}, nil
+		}
+		return nil, fmt.Errorf("read index: %w", err)
+	}
+	idx, err := ParseIndex(content)
+	if err != nil {
+		return nil, fmt.Errorf("read index: %w", err)
+	}
+	return idx, nil
+}
+
+// ParseIndex parses the content of an index file, verifying the trailing checksum
+func ParseIndex(content []byte) (*Index, error) {
+	if len(content) < 12+sha1.Size {
+		return nil, fmt.Errorf("index file too short: %d bytes", len(content))
+	}
+	body, checksum := content[:len(content)-sha1.Size], content[len(content)-sha1.Size:]
+	if sum := sha1.Sum(body); !bytes.Equal(sum[:], checksum) {
+		return nil, fmt.Errorf("index checksum mismatch")
+	}
+	if string(body[:4]) != indexSignature {
+		return nil, fmt.Errorf("invalid index signature: %q", body[:4])
+	}
+	idx := &Index{Version: binary.BigEndian.Uint32(body[4:8])}
+	if idx.Version != 2 && idx.Version != 3 {
+		return nil, fmt.Errorf("unsupported index version: %d", idx.Version)
+	}
+	count := binary.BigEndian.Uint32(body[8:12])
+	offset := 12
+	// the count comes from the file, it cannot be more than the entries the body has room for
+	idx.Entries = make([]IndexEntry, 0, min(int(count), (len(body)-offset)/indexEntryMinSize))
+	for i := range count {
+		entry, n, err := parseIndexEntry(body[offset:])
+		if err != nil {
+			return nil, fmt.Errorf("index entry %d: %w", i, err)
+		}
+		idx.Entries = append(idx.Entries, entry)
+		offset += n
+	}
+	// whatever remains are the extensions, optional ones (starting with an upper case letter)
+	// are safe to skip, they will be regenerated by git when needed
+	for offset < len(body) {
+		if offset+8 > len(body) {
+			return nil, fmt.Errorf("truncated index extension header")
+		}
+		signature := body[offset : offset+4]
+		size := int(binary.BigEndian.Uint32(body[offset+4 : offset+8]))
+		if signature[0] < 'A' || signature[0] > 'Z' {
+			return nil, fmt.Errorf("unsupported index extension: %q", signature)
+		}
+		offset += 8 + size
+	}
+	if offset != len(body) {
+		return nil, fmt.Errorf("truncated index extension data")
+	}
+	return idx, nil
+}
+
+// parseIndexEntry parses a single entry and returns it with the number of bytes used
+// including the padding
+func parseIndexEntry(content []byte) (IndexEntry, int, error) {
+	if len(content) < indexEntryFixedSize {
+		return IndexEntry{}, 0, fmt.Errorf("truncated entry")
+	}
+	var fields [10]uint32
+	for i := range fields {
+		fields[i] = binary.BigEndian.Uint32(content[i*4 : i*4+4])
+	}
+	entry := IndexEntry{
+		CTimeSec:  fields[0],
+		CTimeNano: fields[1],
+		MTimeSec:  fields[2],
+		MTimeNano: fields[3],
+		Dev:       fields[4],
+		Ino:       fields[5],
+		Mode:      fields[6],
+		UID:       fields[7],
+		GID:       fields[8],
+		Size:      fields[9],
+	}
+	copy(entry.SHA[:], content[40:60])
+	entry.Flags = binary.BigEndian.Uint16(content[60:62])
+	offset := indexEntryFixedSize
+	if entry.Flags&indexExtendedFlag != 0 {
+		// version 3 extended flags, we do not make use of them
+		offset += 2
+	}
+	nameEnd := bytes.IndexByte(content[offset:], 0)
+	if nameEnd == -1 {
+		return IndexEntry{}, 0, fmt.Errorf("unterminated entry name")
+	}
+	entry.Name = string(content[offset : offset+nameEnd])
+	entry.Flags &^= indexNameMask | indexExtendedFlag
+	// entries are padded with 1-8 null bytes to keep the entry size a multiple of 8
+	size := (offset + nameEnd + 8) &^ 7
+	if size > len(content) {
+		return IndexEntry{}, 0, fmt.Errorf("truncated entry padding")
+	}
+	return entry, size, nil
+}
+
+// WriteTo writes the index in the version 2 format along with the trailing checksum
+func (idx *Index) WriteTo(w io.Writer) (int64, error) {
+	var buffer bytes.Buffer
+	buffer.WriteString(indexSignature)
+	_ = binary.Write(&buffer, binary.BigEndian, uint32(2))
+	_ = binary.Write(&buffer, binary.BigEndian, uint32(len(idx.Entries)))
+	for _, entry := range idx.Entries {
+		fields := [10]uint32{
+			entry.CTimeSec, entry.CTimeNano, entry.MTimeSec, entry.MTimeNano,
+			entry.Dev, entry.Ino, entry.Mode, entry.UID, entry.GID, entry.Size,
+		}
+		_ = binary.Write(&buffer, binary.BigEndian, fields)
+		buffer.Write(entry.SHA[:])
+		nameLength := min(len(entry.Name), indexNameMask)
+		flags := entry.Flags&^(indexNameMask|indexExtendedFlag) | uint16(nameLength)
+		_ = binary.Write(&buffer, binary.BigEndian, flags)
+		buffer.WriteString(entry.Name)
+		size := indexEntryFixedSize + len(entry.Name)
+		buffer.Write(make([]byte, (size+8)&^7-size))
+	}
+	checksum := sha1.Sum(buffer.Bytes())
+	buffer.Write(checksum[:])
+	return buffer.WriteTo(w)
+}
+
+// WriteIndex writes the index to the `index` file of the git directory
+//
+// The content is first written to `index.lock` and then renamed so that a
+// concurrent reader never sees a partially written index
+func WriteIndex(gitDir string, idx *Index) error {
+	sort.SliceStable(idx.Entries, func(i, j int) bool {
+		if idx.Entries[i].Name != idx.Entries[j].Name {
+			return idx.Entries[i].Name < idx.Entries[j].Name
+		}
+		return idx.Entries[i].Stage() < idx.Entries[j].Stage()
+	})
+	indexPath := filepath.Join(gitDir, "index")
+	lockPath := indexPath + ".lock"
+	lockFile, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("write index: create lock file: %w", err)
+	}
+	_, err = idx.WriteTo(lockFile)
+	closeErr := lockFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(lockPath)
+		return fmt.Errorf("write index: %w", err)
+	}
+	if err := os.Rename(lockPath, indexPath); err != nil {
+		os.Remove(lockPath)
+		return fmt.Errorf("write index: rename lock file: %w", err)
+	}
+	return nil
+}
+
+// fillModTime is the portable fallback for the stat data, it uses the modification
+// time for both ctime and mtime
+func fillModTime(entry *IndexEntry, info os.FileInfo) {
+	mtime := info.ModTime()
+	entry.MTimeSec, entry.MTimeNano = uint32(mtime.Unix()), uint32(mtime.Nanosecond())
+	entry.CTimeSec, entry.CTimeNano = entry.MTimeSec, entry.MTimeNano
+}
@@ -0,0 +1,21 @@
+//go:build linux
+
+package common
+
+import (
+	"os"
+	"syscall"
+)
+
+// fillStatData copies the stat information git keeps in the index to detect changes
+func fillStatData(entry *IndexEntry, info os.FileInfo) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		fillModTime(entry, info)
+		return
+	}
+	entry.CTimeSec, entry.CTimeNano = uint32(stat.Ctim.Sec), uint32(stat.Ctim.Nsec)
+	entry.MTimeSec, entry.MTimeNano = uint32(stat.Mtim.Sec), uint32(stat.Mtim.Nsec)
+	entry.Dev, entry.Ino = uint32(stat.Dev), uint32(stat.Ino)
+	entry.UID, entry.GID = stat.Uid, stat.Gid
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package common
+
+import "os"
+
+// fillStatData only has the modification time available on non linux platforms
+func fillStatData(entry *IndexEntry, info os.FileInfo) {
+	fillModTime(entry, info)
+}
@@ -0,0 +1,103 @@
+package common
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIndexRoundTrip(t *testing.T) {
+	idx := &Index{Version: 2}
+	idx.Add(IndexEntry{Name: "b.txt", Mode: 0100644, Size: 3, SHA: [20]byte{1}})
+	idx.Add(IndexEntry{Name: "a/very/long/path/name.go", Mode: 0100755, SHA: [20]byte{2}})
+	idx.Add(IndexEntry{Name: "a.txt", Mode: 0100644, MTimeSec: 1700000000, SHA: [20]byte{3}})
+
+	var buffer bytes.Buffer
+	if _, err := idx.WriteTo(&buffer); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	parsed, err := ParseIndex(buffer.Bytes())
+	if err != nil {
+		t.Fatalf("ParseIndex() error = %v", err)
+	}
+	if len(parsed.Entries) != len(idx.Entries) {
+		t.Fatalf("ParseIndex() got %d entries, expected %d", len(parsed.Entries), len(idx.Entries))
+	}
+	for i := range idx.Entries {
+		if parsed.Entries[i] != idx.Entries[i] {
+			t.Errorf("entry %d = %+v, expected %+v", i, parsed.Entries[i], idx.Entries[i])
+		}
+	}
+	if parsed.Entries[0].Name != "a.txt" {
+		t.Errorf("entries are not sorted, first entry is %q", parsed.Entries[0].Name)
+	}
+}
+
+func TestParseIndexChecksum(t *testing.T) {
+	idx := &Index{Version: 2}
+	idx.Add(IndexEntry{Name: "file", Mode: 0100644})
+	var buffer bytes.Buffer
+	if _, err := idx.WriteTo(&buffer); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	content := buffer.Bytes()
+	content[len(content)-1] ^= 0xff
+	if _, err := ParseIndex(content); err == nil {
+		t.Errorf("ParseIndex() error = nil, expected checksum error")
+	}
+}
+
+func TestParseIndexEntryCount(t *testing.T) {
+	idx := &Index{Version: 2}
+	idx.Add(IndexEntry{Name: "file", Mode: 0100644})
+	var buffer bytes.Buffer
+	if _, err := idx.WriteTo(&buffer); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	// a count far past the size of the file fails on the missing entries
+	content := buffer.Bytes()[:buffer.Len()-20]
+	binary.BigEndian.PutUint32(content[8:12], 0xffffffff)
+	sum := sha1.Sum(content)
+	if _, err := ParseIndex(append(content, sum[:]...)); err == nil {
+		t.Errorf("ParseIndex() error = nil, expected an error for the missing entries")
+	}
+}
+
+func TestStatMatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("one"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := NewIndexEntry("file", info, [20]byte{1})
+	if !entry.StatMatches(info) {
+		t.Errorf("StatMatches() = false for the same file")
+	}
+	if entry.IsRacy(info.ModTime().Add(time.Second)) {
+		t.Errorf("IsRacy() = true for an index written after the file")
+	}
+	if !entry.IsRacy(info.ModTime()) {
+		t.Errorf("IsRacy() = false for an index written with the file")
+	}
+
+	later := info.ModTime().Add(time.Hour)
+	if err := os.WriteFile(path, []byte("two!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = os.Lstat(path); err != nil {
+		t.Fatal(err)
+	}
+	if entry.StatMatches(info) {
+		t.Errorf("StatMatches() = true for a rewritten file")
+	}
+}
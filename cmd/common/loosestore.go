@@ -0,0 +1,163 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// LooseStore keeps every object zlib compressed in its own file,
+// .git/objects/<first 2 hex characters>/<remaining 38 hex characters>
+type LooseStore struct {
+	baseDir string
+}
+
+// NewLooseStore returns the loose objects of the repository at baseDir
+func NewLooseStore(baseDir string) *LooseStore {
+	return &LooseStore{baseDir: baseDir}
+}
+
+func (s *LooseStore) path(hash string) (string, error) {
+	if len(hash) != 40 {
+		return "", fmt.Errorf("invalid object hash: %q", hash)
+	}
+	return filepath.Join(GitDir(s.baseDir), "objects", hash[:2], hash[2:]), nil
+}
+
+func (s *LooseStore) Has(hash string) (bool, error) {
+	path, err := s.path(hash)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *LooseStore) Get(hash string) ([]byte, string, error) {
+	file, err := GetFileFromHash(s.baseDir, hash)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, "", fmt.Errorf("%s: %w", hash, ErrObjectNotFound)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+	return ReadObjectFile(file)
+}
+
+func (s *LooseStore) Put(objType string, content []byte) (string, error) {
+	hash, fullContent, err := hashObject(objType, content)
+	if err != nil {
+		return "", err
+	}
+	if found, err := s.Has(hash); err != nil || found {
+		return hash, err
+	}
+	path, err := s.path(hash)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return "", fmt.Errorf("create object file: %w", err)
+	}
+	// the object is written to a temporary file first, so that an interrupted write
+	// does not leave a truncated object Has would then report as present
+	file, err := os.CreateTemp(filepath.Dir(filepath.Dir(path)), "tmp_obj_")
+	if err != nil {
+		return "", fmt.Errorf("create object file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	err = WriteCompactContent(file, bytes.NewReader(fullContent))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// loose objects are read-only, like the ones git writes
+		err = os.Chmod(file.Name(), 0444)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("write object %s: %w", hash, err)
+	}
+	return hash, nil
+}
+
+// Stat only inflates the `<type> <size>\0` header of the object
+func (s *LooseStore) Stat(hash string) (ObjectInfo, error) {
+	file, err := GetFileFromHash(s.baseDir, hash)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ObjectInfo{}, fmt.Errorf("%s: %w", hash, ErrObjectNotFound)
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer file.Close()
+	zlibReader, err := zlib.NewReader(file)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("stat %s: %w", hash, err)
+	}
+	defer zlibReader.Close()
+	header, err := bufio.NewReader(zlibReader).ReadString(0)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("stat %s: read header: %w", hash, err)
+	}
+	objType, size, found := bytes.Cut([]byte(header[:len(header)-1]), []byte{' '})
+	if !found {
+		return ObjectInfo{}, fmt.Errorf("stat %s: malformed header %q", hash, header)
+	}
+	parsedSize, err := strconv.ParseInt(string(size), 10, 64)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("stat %s: malformed size %q", hash, size)
+	}
+	return ObjectInfo{Type: string(objType), Size: parsedSize}, nil
+}
+
+func (s *LooseStore) Iterate(fn func(hash string) error) error {
+	objectsDir := filepath.Join(GitDir(s.baseDir), "objects")
+	dirs, err := os.ReadDir(objectsDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 || !isHex(dir.Name()) {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(objectsDir, dir.Name()))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			hash := dir.Name() + file.Name()
+			if len(hash) != 40 || !isHex(file.Name()) {
+				continue
+			}
+			if err := fn(hash); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package common
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// MemoryStore keeps the objects in memory, it is meant for tests and for embedding
+// without a repository on disk
+type MemoryStore struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+type memoryObject struct {
+	objType string
+	content []byte
+}
+
+// NewMemoryStore returns an empty in-memory object store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{objects: map[string]memoryObject{}}
+}
+
+func (s *MemoryStore) Has(hash string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, found := s.objects[hash]
+	return found, nil
+}
+
+func (s *MemoryStore) Get(hash string) ([]byte, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, found := s.objects[hash]
+	if !found {
+		return nil, "", fmt.Errorf("%s: %w", hash, ErrObjectNotFound)
+	}
+	return slices.Clone(obj.content), obj.objType, nil
+}
+
+func (s *MemoryStore) Put(objType string, content []byte) (string, error) {
+	hash, _, err := hashObject(objType, content)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.objects[hash]; !found {
+		s.objects[hash] = memoryObject{objType: objType, content: slices.Clone(content)}
+	}
+	return hash, nil
+}
+
+func (s *MemoryStore) Stat(hash string) (ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, found := s.objects[hash]
+	if !found {
+		return ObjectInfo{}, fmt.Errorf("%s: %w", hash, ErrObjectNotFound)
+	}
+	return ObjectInfo{Type: obj.objType, Size: int64(len(obj.content))}, nil
+}
+
+// Iterate gives the hashes in sorted order, fn may add objects to the store
+func (s *MemoryStore) Iterate(fn func(hash string) error) error {
+	s.mu.RLock()
+	hashes := make([]string, 0, len(s.objects))
+	for hash := range s.objects {
+		hashes = append(hashes, hash)
+	}
+	s.mu.RUnlock()
+	slices.Sort(hashes)
+	for _, hash := range hashes {
+		if err := fn(hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
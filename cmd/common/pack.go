@@ -0,0 +1,180 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Pack is a pack file along with its index, opened for reading objects
+type Pack struct {
+	// Path is the path of the .pack file
+	Path  string
+	Index *PackIndex
+
+	file *os.File
+	data *PackFile
+	// idxInfo tells when the index was replaced since the pack was opened
+	idxInfo os.FileInfo
+	// users counts the readers of a pack kept in openPacks, a retired pack is no longer
+	// kept and is closed once its last reader releases it
+	users   int
+	retired bool
+}
+
+// OpenPack opens the pack file of the given .idx file
+func OpenPack(idxPath string) (*Pack, error) {
+	idxFile, err := os.Open(idxPath)
+	if err != nil {
+		return nil, fmt.Errorf("open pack index: %w", err)
+	}
+	defer idxFile.Close()
+	idxInfo, err := idxFile.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("open pack index: %w", err)
+	}
+	idx, err := ReadPackIndex(idxFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", idxPath, err)
+	}
+
+	packPath := strings.TrimSuffix(idxPath, ".idx") + ".pack"
+	file, err := os.Open(packPath)
+	if err != nil {
+		return nil, fmt.Errorf("open pack: %w", err)
+	}
+	return &Pack{
+		Path:    packPath,
+		Index:   idx,
+		file:    file,
+		data:    NewPackFile(file, idx.Lookup),
+		idxInfo: idxInfo,
+	}, nil
+}
+
+// Object returns the content and the type of the object, found is false when
+// the object is not in this pack
+func (p *Pack) Object(hash string) (content []byte, objType string, found bool, err error) {
+	offset, ok := p.Index.Lookup(hash)
+	if !ok {
+		return nil, "", false, nil
+	}
+	objType, content, err = p.data.ObjectAt(offset)
+	if err != nil {
+		return nil, "", true, fmt.Errorf("%s: object %s: %w", filepath.Base(p.Path), hash, err)
+	}
+	return content, objType, true, nil
+}
+
+// Info returns the type and the size of the object, found is false when the object
+// is not in this pack
+func (p *Pack) Info(hash string) (info ObjectInfo, found bool, err error) {
+	offset, ok := p.Index.Lookup(hash)
+	if !ok {
+		return ObjectInfo{}, false, nil
+	}
+	objType, size, err := p.data.InfoAt(offset)
+	if err != nil {
+		return ObjectInfo{}, true, fmt.Errorf("%s: object %s: %w", filepath.Base(p.Path), hash, err)
+	}
+	return ObjectInfo{Type: objType, Size: size}, true, nil
+}
+
+// Close closes the pack file
+func (p *Pack) Close() error {
+	return p.file.Close()
+}
+
+// openPacks keeps the packs opened by the pack stores, reading the index and keeping the
+// cache of delta bases for every read would make walking a packed repository very slow
+var openPacks = struct {
+	sync.Mutex
+	byIndex map[string]*Pack
+}{byIndex: map[string]*Pack{}}
+
+// repositoryPacks returns the packs of the repository at baseDir, the packs are only
+// opened the first time they are seen, and opened again when their index was replaced
+//
+// The caller releases the packs with releasePacks once it is done reading them, the packs
+// whose index is gone or replaced are closed after their last reader released them.
+func repositoryPacks(baseDir string) ([]*Pack, error) {
+	// the packs are kept by their absolute path, the working directory changes during a clone
+	absDir, err := filepath.Abs(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	packDir := filepath.Join(GitDir(absDir), "objects", "pack")
+	idxPaths, err := filepath.Glob(filepath.Join(packDir, "*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	openPacks.Lock()
+	defer openPacks.Unlock()
+	for idxPath, pack := range openPacks.byIndex {
+		if filepath.Dir(idxPath) == packDir && !slices.Contains(idxPaths, idxPath) {
+			retirePack(idxPath, pack)
+		}
+	}
+	packs := make([]*Pack, 0, len(idxPaths))
+	for _, idxPath := range idxPaths {
+		pack, ok := openPacks.byIndex[idxPath]
+		if ok {
+			info, err := os.Stat(idxPath)
+			if errors.Is(err, fs.ErrNotExist) {
+				// removed since the directory was listed
+				retirePack(idxPath, pack)
+				continue
+			}
+			if err != nil {
+				releaseLocked(packs)
+				return nil, err
+			}
+			if !os.SameFile(info, pack.idxInfo) || !info.ModTime().Equal(pack.idxInfo.ModTime()) {
+				retirePack(idxPath, pack)
+				ok = false
+			}
+		}
+		if !ok {
+			if pack, err = OpenPack(idxPath); err != nil {
+				releaseLocked(packs)
+				return nil, err
+			}
+			openPacks.byIndex[idxPath] = pack
+		}
+		pack.users++
+		packs = append(packs, pack)
+	}
+	return packs, nil
+}
+
+// releasePacks tells that the packs returned by repositoryPacks are no longer read
+func releasePacks(packs []*Pack) {
+	openPacks.Lock()
+	defer openPacks.Unlock()
+	releaseLocked(packs)
+}
+
+// releaseLocked releases the packs, with openPacks locked
+func releaseLocked(packs []*Pack) {
+	for _, pack := range packs {
+		pack.users--
+		if pack.retired && pack.users == 0 {
+			pack.Close()
+		}
+	}
+}
+
+// retirePack forgets the pack, with openPacks locked, it is closed right away unless a
+// reader still has it
+func retirePack(idxPath string, pack *Pack) {
+	delete(openPacks.byIndex, idxPath)
+	pack.retired = true
+	if pack.users == 0 {
+		pack.Close()
+	}
+}
@@ -0,0 +1,327 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// The object types of the pack file entries
+const (
+	packObjCommit   = 1
+	packObjTree     = 2
+	packObjBlob     = 3
+	packObjTag      = 4
+	packObjOfsDelta = 6
+	packObjRefDelta = 7
+)
+
+// maxPackEntryHeader is the maximum size of an entry header, a 64 bit object size takes
+// 10 bytes and the ofs-delta base offset another 10 bytes (or 20 bytes for a ref-delta)
+const maxPackEntryHeader = 32
+
+// packCacheLimit is the number of bytes of resolved objects a PackFile keeps around,
+// which are mostly the bases of the delta chains
+const packCacheLimit = 32 << 20
+
+var errInvalidSize = errors.New("invalid size")
+
+// ReadPackEntryHeader will read the object type and size of a pack entry according
+// to the [git documentation](https://git-scm.com/docs/gitformat-pack)
+//
+// The first byte has the MSB as the continuation bit, 3 bits of type and the lowest
+// 4 bits of the size, the following bytes have the continuation bit and 7 bits of size
+func ReadPackEntryHeader(content []byte) (length uint64, objType byte, bytesRead int, err error) {
+	if len(content) == 0 {
+		return 0, 0, 0, fmt.Errorf("%w: no content", errInvalidSize)
+	}
+	b := content[bytesRead]
+	objType = (b >> 4) & 0x07
+	sizeFromFirstByte := b & 0x0F
+	length += uint64(sizeFromFirstByte)
+	bytesRead++
+
+	more := ((b >> 7) & 1) == 1
+	if !more {
+		return uint64(sizeFromFirstByte), objType, 1, nil
+	}
+
+	bitShift := 4
+	for more {
+		if bytesRead >= len(content) || bitShift >= 64 {
+			return 0, 0, 0, errors.New("unexpected end of content")
+		}
+		b := content[bytesRead]
+		// formula is for every new byte 2 ^ bitshift
+		// byteshift starts with 4 bits, then 4 + 7 (as 1st bit is for more size)
+		additonalLength := uint64(b&0x7F) * (1 << bitShift)
+		length += uint64(additonalLength)
+		more = ((b >> 7) & 1) == 1
+		bytesRead++
+		bitShift += 7
+	}
+	return length, objType, bytesRead, nil
+}
+
+// ReadOfsDeltaOffset reads the negative offset of the base object of an OBJ_OFS_DELTA entry
+//
+// Unlike the other variable length integers, every continuation byte adds one before
+// shifting, so that there is exactly one encoding for every offset
+//
+//	offset = byte & 0x7f
+//	while byte & 0x80: offset = ((offset + 1) << 7) | (next_byte & 0x7f)
+func ReadOfsDeltaOffset(content []byte) (offset int, bytesRead int, err error) {
+	if len(content) == 0 {
+		return 0, 0, fmt.Errorf("%w: no content for the base offset", errInvalidSize)
+	}
+	b := content[0]
+	offset = int(b & 0x7f)
+	bytesRead = 1
+	for b&0x80 != 0 {
+		if bytesRead >= len(content) || bytesRead > 8 {
+			return 0, 0, fmt.Errorf("%w: malformed base offset", errInvalidSize)
+		}
+		b = content[bytesRead]
+		offset = ((offset + 1) << 7) | int(b&0x7f)
+		bytesRead++
+	}
+	return offset, bytesRead, nil
+}
+
+// packObjectTypeName returns the object type name of a non delta pack entry type
+func packObjectTypeName(objType byte) (string, bool) {
+	switch objType {
+	case packObjCommit:
+		return "commit", true
+	case packObjTree:
+		return "tree", true
+	case packObjBlob:
+		return "blob", true
+	case packObjTag:
+		return "tag", true
+	default:
+		return "", false
+	}
+}
+
+// PackFile gives random access to the objects of a pack file by their offset
+//
+// Delta objects are resolved by following the chain of their bases, the base of an
+// OBJ_REF_DELTA is found through the lookup function (usually the pack index).
+type PackFile struct {
+	r io.ReaderAt
+	// lookup returns the offset of the object with the given hex hash in this pack
+	lookup func(hash string) (int64, bool)
+
+	// mu guards the cache, a pack is shared by every store of the repository
+	mu        sync.Mutex
+	cache     map[int64]packedObject
+	cacheSize int
+}
+
+type packedObject struct {
+	objType string
+	content []byte
+}
+
+// NewPackFile creates a reader over the pack content in r
+func NewPackFile(r io.ReaderAt, lookup func(hash string) (int64, bool)) *PackFile {
+	return &PackFile{r: r, lookup: lookup, cache: map[int64]packedObject{}}
+}
+
+// ObjectAt returns the type and the content of the object stored at the offset
+func (p *PackFile) ObjectAt(offset int64) (string, []byte, error) {
+	objType, content, err := p.objectAt(offset, 0)
+	// the content may be the one in the cache, the caller gets a copy it is free to change
+	return objType, bytes.Clone(content), err
+}
+
+func (p *PackFile) objectAt(offset int64, depth int) (string, []byte, error) {
+	if cached, ok := p.cached(offset); ok {
+		return cached.objType, cached.content, nil
+	}
+	// git limits the delta chains to 4095 as well
+	if depth > 4095 {
+		return "", nil, fmt.Errorf("delta chain too long at offset %d", offset)
+	}
+	entry, err := p.readEntry(offset)
+	if err != nil {
+		return "", nil, fmt.Errorf("pack entry at offset %d: %w", offset, err)
+	}
+
+	objType, content := entry.objType, entry.content
+	if entry.isDelta {
+		baseType, baseContent, err := p.objectAt(entry.baseOffset, depth+1)
+		if err != nil {
+			return "", nil, err
+		}
+		content, err = ApplyDelta(baseContent, entry.content)
+		if err != nil {
+			return "", nil, fmt.Errorf("pack entry at offset %d: %w", offset, err)
+		}
+		objType = baseType
+	}
+	p.remember(offset, objType, content)
+	return objType, content, nil
+}
+
+// InfoAt returns the type and the size of the object stored at the offset, without
+// inflating it: the size is in the entry header, or at the start of the delta data for
+// a delta entry, whose type is the one of the full object at the end of its chain
+func (p *PackFile) InfoAt(offset int64) (string, int64, error) {
+	if cached, ok := p.cached(offset); ok {
+		return cached.objType, int64(len(cached.content)), nil
+	}
+	entry, err := p.readEntryHeader(offset)
+	if err != nil {
+		return "", 0, fmt.Errorf("pack entry at offset %d: %w", offset, err)
+	}
+	if !entry.isDelta {
+		return entry.objType, int64(entry.size), nil
+	}
+	// the delta data starts with the size of the base and the size of the result, up to
+	// 10 bytes each
+	prefix, err := p.inflate(entry, 20)
+	if err != nil {
+		return "", 0, fmt.Errorf("pack entry at offset %d: %w", offset, err)
+	}
+	_, sizeOffset, err := readVarInt(prefix, 0)
+	if err != nil {
+		return "", 0, fmt.Errorf("pack entry at offset %d: %w", offset, err)
+	}
+	size, _, err := readVarInt(prefix, sizeOffset)
+	if err != nil {
+		return "", 0, fmt.Errorf("pack entry at offset %d: %w", offset, err)
+	}
+	for depth := 0; entry.isDelta; depth++ {
+		if depth > 4095 {
+			return "", 0, fmt.Errorf("delta chain too long at offset %d", offset)
+		}
+		if cached, ok := p.cached(entry.baseOffset); ok {
+			return cached.objType, int64(size), nil
+		}
+		if entry, err = p.readEntryHeader(entry.baseOffset); err != nil {
+			return "", 0, fmt.Errorf("pack entry at offset %d: %w", offset, err)
+		}
+	}
+	return entry.objType, int64(size), nil
+}
+
+// cached returns the object at the offset when it is in the cache
+func (p *PackFile) cached(offset int64) (packedObject, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cached, ok := p.cache[offset]
+	return cached, ok
+}
+
+// remember caches the object, the cache is simply dropped once it grows past the limit
+func (p *PackFile) remember(offset int64, objType string, content []byte) {
+	if len(content) > packCacheLimit/4 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cacheSize+len(content) > packCacheLimit {
+		p.cache, p.cacheSize = map[int64]packedObject{}, 0
+	}
+	p.cache[offset] = packedObject{objType: objType, content: content}
+	p.cacheSize += len(content)
+}
+
+type packEntry struct {
+	objType    string
+	isDelta    bool
+	baseOffset int64
+	// size is the size of the object, or of the delta instructions for a delta entry
+	size uint64
+	// dataStart is the offset of the compressed data
+	dataStart int64
+	// content is the inflated data, the delta instructions for a delta entry
+	content []byte
+}
+
+// readEntry reads and inflates the raw entry at the offset without resolving deltas
+func (p *PackFile) readEntry(offset int64) (packEntry, error) {
+	entry, err := p.readEntryHeader(offset)
+	if err != nil {
+		return packEntry{}, err
+	}
+	// the size is not trusted for the allocation, the content is read up to one byte
+	// past it to tell when the data is larger
+	if entry.content, err = p.inflate(entry, min(entry.size, 1<<62)+1); err != nil {
+		return packEntry{}, err
+	}
+	if uint64(len(entry.content)) != entry.size {
+		return packEntry{}, fmt.Errorf("object size mismatch: header %d, content %d", entry.size, len(entry.content))
+	}
+	return entry, nil
+}
+
+// readEntryHeader reads the type, the size and the base of the entry at the offset
+func (p *PackFile) readEntryHeader(offset int64) (packEntry, error) {
+	header := make([]byte, maxPackEntryHeader)
+	n, err := p.r.ReadAt(header, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return packEntry{}, fmt.Errorf("read entry header: %w", err)
+	}
+	header = header[:n]
+	size, rawType, used, err := ReadPackEntryHeader(header)
+	if err != nil {
+		return packEntry{}, fmt.Errorf("read entry header: %w", err)
+	}
+
+	entry := packEntry{size: size}
+	switch rawType {
+	case packObjOfsDelta:
+		distance, ofsUsed, err := ReadOfsDeltaOffset(header[used:])
+		if err != nil {
+			return packEntry{}, err
+		}
+		used += ofsUsed
+		entry.isDelta, entry.baseOffset = true, offset-int64(distance)
+		if entry.baseOffset <= 0 {
+			return packEntry{}, fmt.Errorf("invalid base offset %d", entry.baseOffset)
+		}
+	case packObjRefDelta:
+		if len(header) < used+20 {
+			return packEntry{}, fmt.Errorf("truncated base object hash")
+		}
+		baseHash := hex.EncodeToString(header[used : used+20])
+		used += 20
+		baseOffset, found := p.lookup(baseHash)
+		if !found {
+			return packEntry{}, fmt.Errorf("base object %s is not in the pack", baseHash)
+		}
+		entry.isDelta, entry.baseOffset = true, baseOffset
+	default:
+		typeName, ok := packObjectTypeName(rawType)
+		if !ok {
+			return packEntry{}, fmt.Errorf("invalid object type %d", rawType)
+		}
+		entry.objType = typeName
+	}
+	entry.dataStart = offset + int64(used)
+	return entry, nil
+}
+
+// inflate returns up to limit bytes of the inflated data of the entry
+func (p *PackFile) inflate(entry packEntry, limit uint64) ([]byte, error) {
+	zlibReader, err := zlib.NewReader(
+		bufio.NewReader(io.NewSectionReader(p.r, entry.dataStart, 1<<62)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating zlib reader: %w", err)
+	}
+	defer zlibReader.Close()
+	var content bytes.Buffer
+	if _, err := io.Copy(&content, io.LimitReader(zlibReader, int64(limit))); err != nil {
+		return nil, fmt.Errorf("reading uncompressed data: %w", err)
+	}
+	return content.Bytes(), nil
+}
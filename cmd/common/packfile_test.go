@@ -0,0 +1,246 @@
+package common
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadOfsDelta(t *testing.T) {
+	tests := []struct {
+		input          []byte
+		expectedOffset int
+		expectedRead   int
+	}{
+		{input: []byte{0x05}, expectedOffset: 5, expectedRead: 1},
+		{input: []byte{0x7f}, expectedOffset: 127, expectedRead: 1},
+		// 128 is ((0 + 1) << 7) | 0
+		{input: []byte{0x80, 0x00}, expectedOffset: 128, expectedRead: 2},
+		{input: []byte{0x81, 0x23, 0xff}, expectedOffset: (2 << 7) | 0x23, expectedRead: 2},
+	}
+	for _, test := range tests {
+		offset, bytesRead, err := ReadOfsDeltaOffset(test.input)
+		if err != nil {
+			t.Errorf("ReadOfsDeltaOffset(%x) error = %v", test.input, err)
+			continue
+		}
+		if offset != test.expectedOffset || bytesRead != test.expectedRead {
+			t.Errorf(
+				"ReadOfsDeltaOffset(%x) = %d, %d, expected %d, %d",
+				test.input,
+				offset,
+				bytesRead,
+				test.expectedOffset,
+				test.expectedRead,
+			)
+		}
+	}
+}
+
+func TestPackIndexRoundTrip(t *testing.T) {
+	entries := []PackIndexEntry{
+		{SHA: [20]byte{0xab, 1}, Offset: 12, CRC32: 0xdeadbeef},
+		{SHA: [20]byte{0x01, 2}, Offset: 5 << 32, CRC32: 1},
+		{SHA: [20]byte{0xab, 0}, Offset: 300, CRC32: 2},
+		{SHA: [20]byte{0xff, 3}, Offset: 1 << 31, CRC32: 3},
+	}
+	checksum := [20]byte{9, 9, 9}
+	var buffer bytes.Buffer
+	if err := WritePackIndex(&buffer, entries, checksum); err != nil {
+		t.Fatalf("WritePackIndex() error = %v", err)
+	}
+	// header, fanout, 4 shas, crcs and offsets, 2 large offsets, checksums
+	expectedSize := 8 + 256*4 + 4*(20+4+4) + 2*8 + 2*20
+	if buffer.Len() != expectedSize {
+		t.Errorf("WritePackIndex() wrote %d bytes, expected %d", buffer.Len(), expectedSize)
+	}
+
+	idx, err := ReadPackIndex(&buffer)
+	if err != nil {
+		t.Fatalf("ReadPackIndex() error = %v", err)
+	}
+	if idx.PackChecksum != checksum {
+		t.Errorf("PackChecksum = %x, expected %x", idx.PackChecksum, checksum)
+	}
+	if len(idx.Entries) != len(entries) {
+		t.Fatalf("ReadPackIndex() read %d entries, expected %d", len(idx.Entries), len(entries))
+	}
+	for i := 1; i < len(idx.Entries); i++ {
+		if bytes.Compare(idx.Entries[i-1].SHA[:], idx.Entries[i].SHA[:]) >= 0 {
+			t.Errorf("entries are not sorted at %d", i)
+		}
+	}
+	for _, entry := range entries {
+		hash := hex.EncodeToString(entry.SHA[:])
+		offset, found := idx.Lookup(hash)
+		if !found || offset != entry.Offset {
+			t.Errorf("Lookup(%s) = %d, %v, expected %d", hash, offset, found, entry.Offset)
+		}
+	}
+	if _, found := idx.Lookup("0000000000000000000000000000000000000000"); found {
+		t.Errorf("Lookup() found a missing object")
+	}
+}
+
+func TestReadPackIndexChecksum(t *testing.T) {
+	var buffer bytes.Buffer
+	entries := []PackIndexEntry{{SHA: [20]byte{1}, Offset: 12}}
+	if err := WritePackIndex(&buffer, entries, [20]byte{}); err != nil {
+		t.Fatalf("WritePackIndex() error = %v", err)
+	}
+	corrupted := buffer.Bytes()
+	corrupted[8+256*4] ^= 0xff
+	if _, err := ReadPackIndex(bytes.NewReader(corrupted)); err == nil {
+		t.Errorf("ReadPackIndex() accepted a corrupted index")
+	}
+}
+
+func TestReadPackedObject(t *testing.T) {
+	baseDir := t.TempDir()
+	packDir := filepath.Join(GitDir(baseDir), "objects", "pack")
+	if err := os.MkdirAll(packDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for _, ext := range []string{".pack", ".idx"} {
+		content, err := os.ReadFile("../../testdata/ofs-delta" + ext)
+		if err != nil {
+			t.Fatalf("error in reading testdata: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(packDir, "pack-test"+ext), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := os.ReadFile("../../testdata/ofs-delta.info")
+	if err != nil {
+		t.Fatalf("error in reading pack info: %v", err)
+	}
+
+	for _, line := range strings.Split(string(info), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || len(fields[0]) != 40 {
+			continue
+		}
+		hash := fields[0]
+		content, objType, err := ReadObject(baseDir, hash)
+		if err != nil {
+			t.Errorf("ReadObject(%s) error = %v", hash, err)
+			continue
+		}
+		computed, _ := CalculateEncodedSHA(FormatGitObjectContent(objType, content))
+		if computed != hash || objType != fields[1] {
+			t.Errorf("ReadObject(%s) returned a %s with hash %s", hash, objType, computed)
+		}
+		// changing the content does not change the cached object
+		clear(content)
+		again, _, err := ReadObject(baseDir, hash)
+		if computed, _ = CalculateEncodedSHA(FormatGitObjectContent(objType, again)); err != nil || computed != hash {
+			t.Errorf("ReadObject(%s) after changing the content returned hash %s, %v", hash, computed, err)
+		}
+		if !HasObject(baseDir, hash) {
+			t.Errorf("HasObject(%s) = false", hash)
+		}
+		matches, err := FindObjectsByPrefix(baseDir, hash[:7])
+		if err != nil || !slices.Contains(matches, hash) {
+			t.Errorf("FindObjectsByPrefix(%s) = %v, %v", hash[:7], matches, err)
+		}
+	}
+
+	missing := "0000000000000000000000000000000000000000"
+	if _, _, err := ReadObject(baseDir, missing); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReadObject(%s) error = %v, expected os.ErrNotExist", missing, err)
+	}
+	if HasObject(baseDir, missing) {
+		t.Errorf("HasObject(%s) = true", missing)
+	}
+}
+
+func TestReadPackEntrySizeMismatch(t *testing.T) {
+	for _, header := range [][]byte{
+		// a blob of close to 2^63 bytes, then one of 5 bytes
+		{0xbf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f},
+		{0x35},
+	} {
+		var pack bytes.Buffer
+		pack.WriteString("PACK\x00\x00\x00\x02\x00\x00\x00\x01")
+		pack.Write(header)
+		zw := zlib.NewWriter(&pack)
+		zw.Write([]byte("hello\n"))
+		zw.Close()
+
+		packFile := NewPackFile(bytes.NewReader(pack.Bytes()), func(string) (int64, bool) { return 0, false })
+		if _, _, err := packFile.ObjectAt(12); err == nil || !strings.Contains(err.Error(), "size mismatch") {
+			t.Errorf("ObjectAt() with header %x error = %v, expected a size mismatch", header, err)
+		}
+	}
+}
+
+// countingReader counts the bytes read from the pack
+type countingReader struct {
+	r    io.ReaderAt
+	read int
+}
+
+func (c *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.read += n
+	return n, err
+}
+
+func TestPackInfoAt(t *testing.T) {
+	// a random blob of 1 MiB which does not compress, and a delta of one byte against it
+	blob := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(blob)
+	var pack bytes.Buffer
+	pack.WriteString("PACK\x00\x00\x00\x02\x00\x00\x00\x02")
+	// blob of 2^20 bytes: 4 bits, then 7 bits at a time
+	pack.Write([]byte{0xb0, 0x80, 0x80, 0x04})
+	zw := zlib.NewWriter(&pack)
+	zw.Write(blob)
+	zw.Close()
+	deltaOffset := int64(pack.Len())
+	// base size 2^20, result size 1, insert "x"
+	delta := []byte{0x80, 0x80, 0x40, 0x01, 0x01, 'x'}
+	pack.WriteByte(0x60 | byte(len(delta)))
+	distance := deltaOffset - 12
+	offset := []byte{byte(distance & 0x7f)}
+	for distance >>= 7; distance > 0; distance >>= 7 {
+		distance--
+		offset = append([]byte{0x80 | byte(distance&0x7f)}, offset...)
+	}
+	pack.Write(offset)
+	zw = zlib.NewWriter(&pack)
+	zw.Write(delta)
+	zw.Close()
+
+	tests := []struct {
+		offset  int64
+		objType string
+		size    int64
+	}{
+		{12, "blob", 1 << 20},
+		{deltaOffset, "blob", 1},
+	}
+	for _, test := range tests {
+		reader := &countingReader{r: bytes.NewReader(pack.Bytes())}
+		packFile := NewPackFile(reader, func(string) (int64, bool) { return 0, false })
+		objType, size, err := packFile.InfoAt(test.offset)
+		if err != nil || objType != test.objType || size != test.size {
+			t.Errorf("InfoAt(%d) = %s, %d, %v, expected %s %d", test.offset, objType, size, err, test.objType, test.size)
+		}
+		if reader.read > 64<<10 {
+			t.Errorf("InfoAt(%d) read %d bytes of the pack", test.offset, reader.read)
+		}
+		_, content, err := packFile.ObjectAt(test.offset)
+		if err != nil || int64(len(content)) != test.size {
+			t.Errorf("ObjectAt(%d) = %d bytes, %v, expected %d", test.offset, len(content), err, test.size)
+		}
+	}
+}
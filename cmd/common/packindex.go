@@ -0,0 +1,188 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// packIndexMagic starts a version 2 pack index, version 1 indexes have no header
+var packIndexMagic = []byte{0xff, 't', 'O', 'c'}
+
+// packIndexLargeOffset is the MSB of an offset in the 32 bit table, the rest of the
+// value is then the position in the table of 64 bit offsets
+const packIndexLargeOffset = 1 << 31
+
+// PackIndexEntry is an object of a pack file as stored in the pack index
+type PackIndexEntry struct {
+	SHA    [20]byte
+	Offset int64
+	// CRC32 is the checksum of the raw entry in the pack, it lets the entries be
+	// copied between packs without inflating them
+	CRC32 uint32
+}
+
+// PackIndex maps the object hashes of a pack file to their offsets
+//
+// See the [git documentation](https://git-scm.com/docs/gitformat-pack) for the format,
+// only the version 2 is supported.
+type PackIndex struct {
+	// Entries are sorted by SHA
+	Entries      []PackIndexEntry
+	PackChecksum [20]byte
+}
+
+// WritePackIndex writes the version 2 index of the pack entries to w
+//
+//	header      \377tOc, version 2
+//	fanout      256 * 4 bytes, the number of objects with a first byte <= i
+//	shas        n * 20 bytes, sorted
+//	crc32       n * 4 bytes
+//	offsets     n * 4 bytes, MSB set means index into the 64 bit offsets
+//	offsets64   m * 8 bytes
+//	trailer     pack checksum, index checksum
+func WritePackIndex(w io.Writer, entries []PackIndexEntry, packChecksum [20]byte) error {
+	sorted := slices.Clone(entries)
+	slices.SortFunc(sorted, func(a, b PackIndexEntry) int {
+		return bytes.Compare(a.SHA[:], b.SHA[:])
+	})
+
+	hasher := sha1.New()
+	buffered := bufio.NewWriter(io.MultiWriter(w, hasher))
+	write := func(data any) {
+		// the bufio.Writer keeps the first error, it is checked on Flush
+		_ = binary.Write(buffered, binary.BigEndian, data)
+	}
+
+	buffered.Write(packIndexMagic)
+	write(uint32(2))
+	var fanout [256]uint32
+	for _, entry := range sorted {
+		fanout[entry.SHA[0]]++
+	}
+	for i := 1; i < len(fanout); i++ {
+		fanout[i] += fanout[i-1]
+	}
+	write(fanout)
+	for _, entry := range sorted {
+		buffered.Write(entry.SHA[:])
+	}
+	for _, entry := range sorted {
+		write(entry.CRC32)
+	}
+	var largeOffsets []uint64
+	for _, entry := range sorted {
+		if entry.Offset < packIndexLargeOffset {
+			write(uint32(entry.Offset))
+			continue
+		}
+		write(uint32(packIndexLargeOffset | len(largeOffsets)))
+		largeOffsets = append(largeOffsets, uint64(entry.Offset))
+	}
+	write(largeOffsets)
+	buffered.Write(packChecksum[:])
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("write pack index: %w", err)
+	}
+	if _, err := w.Write(hasher.Sum(nil)); err != nil {
+		return fmt.Errorf("write pack index checksum: %w", err)
+	}
+	return nil
+}
+
+// ReadPackIndex reads a version 2 pack index and verifies its checksum
+func ReadPackIndex(r io.Reader) (*PackIndex, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read pack index: %w", err)
+	}
+	// header, fanout and the two checksums
+	const minSize = 8 + 256*4 + 2*20
+	if len(content) < minSize {
+		return nil, fmt.Errorf("read pack index: too short (%d bytes)", len(content))
+	}
+	if !bytes.Equal(content[:4], packIndexMagic) {
+		return nil, fmt.Errorf("read pack index: unsupported version 1 index")
+	}
+	if version := binary.BigEndian.Uint32(content[4:8]); version != 2 {
+		return nil, fmt.Errorf("read pack index: unsupported version %d", version)
+	}
+	body, trailer := content[:len(content)-20], content[len(content)-20:]
+	if sum := sha1.Sum(body); !bytes.Equal(sum[:], trailer) {
+		return nil, fmt.Errorf("read pack index: checksum mismatch")
+	}
+
+	count := int(binary.BigEndian.Uint32(content[8+255*4 : 8+256*4]))
+	shas := 8 + 256*4
+	crcs := shas + count*20
+	offsets := crcs + count*4
+	largeOffsets := offsets + count*4
+	if largeOffsets+40 > len(content) {
+		return nil, fmt.Errorf("read pack index: too short for %d objects", count)
+	}
+
+	idx := &PackIndex{
+		Entries:      make([]PackIndexEntry, count),
+		PackChecksum: [20]byte(content[len(content)-40 : len(content)-20]),
+	}
+	for i := range idx.Entries {
+		entry := &idx.Entries[i]
+		entry.SHA = [20]byte(content[shas+i*20 : shas+(i+1)*20])
+		entry.CRC32 = binary.BigEndian.Uint32(content[crcs+i*4:])
+		offset := binary.BigEndian.Uint32(content[offsets+i*4:])
+		if offset&packIndexLargeOffset == 0 {
+			entry.Offset = int64(offset)
+			continue
+		}
+		position := largeOffsets + int(offset&^packIndexLargeOffset)*8
+		if position+8 > len(content)-40 {
+			return nil, fmt.Errorf("read pack index: invalid 64 bit offset for %x", entry.SHA)
+		}
+		entry.Offset = int64(binary.BigEndian.Uint64(content[position:]))
+	}
+	return idx, nil
+}
+
+// Lookup returns the offset in the pack of the object with the 40 character hex hash
+func (idx *PackIndex) Lookup(hash string) (int64, bool) {
+	raw, err := hex.DecodeString(hash)
+	if err != nil || len(raw) != 20 {
+		return 0, false
+	}
+	i, found := slices.BinarySearchFunc(idx.Entries, raw, func(entry PackIndexEntry, target []byte) int {
+		return bytes.Compare(entry.SHA[:], target)
+	})
+	if !found {
+		return 0, false
+	}
+	return idx.Entries[i].Offset, true
+}
+
+// HashesWithPrefix returns the hex hashes of the objects starting with the abbreviated
+// lowercase hex hash
+func (idx *PackIndex) HashesWithPrefix(prefix string) []string {
+	// the first byte narrows the search down with the sorted entries
+	first, err := hex.DecodeString(prefix[:2])
+	if err != nil {
+		return nil
+	}
+	start, _ := slices.BinarySearchFunc(idx.Entries, first[0], func(entry PackIndexEntry, b byte) int {
+		return int(entry.SHA[0]) - int(b)
+	})
+	var matches []string
+	for _, entry := range idx.Entries[start:] {
+		if entry.SHA[0] != first[0] {
+			break
+		}
+		if hash := hex.EncodeToString(entry.SHA[:]); strings.HasPrefix(hash, prefix) {
+			matches = append(matches, hash)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// PackStore reads the objects from the pack files of a repository
+//
+// New objects are not written to packs, Put always fails.
+type PackStore struct {
+	baseDir string
+}
+
+// NewPackStore returns the packed objects of the repository at baseDir
+func NewPackStore(baseDir string) *PackStore {
+	return &PackStore{baseDir: baseDir}
+}
+
+func (s *PackStore) Has(hash string) (bool, error) {
+	packs, err := repositoryPacks(s.baseDir)
+	if err != nil {
+		return false, err
+	}
+	defer releasePacks(packs)
+	for _, pack := range packs {
+		if _, found := pack.Index.Lookup(hash); found {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *PackStore) Get(hash string) ([]byte, string, error) {
+	packs, err := repositoryPacks(s.baseDir)
+	if err != nil {
+		return nil, "", err
+	}
+	defer releasePacks(packs)
+	for _, pack := range packs {
+		content, objType, found, err := pack.Object(hash)
+		if err != nil || found {
+			return content, objType, err
+		}
+	}
+	return nil, "", fmt.Errorf("%s: %w", hash, ErrObjectNotFound)
+}
+
+func (s *PackStore) Put(objType string, content []byte) (string, error) {
+	return "", fmt.Errorf("write %s to the packs: %w", objType, errReadOnlyStore)
+}
+
+func (s *PackStore) Stat(hash string) (ObjectInfo, error) {
+	packs, err := repositoryPacks(s.baseDir)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer releasePacks(packs)
+	for _, pack := range packs {
+		info, found, err := pack.Info(hash)
+		if err != nil || found {
+			return info, err
+		}
+	}
+	return ObjectInfo{}, fmt.Errorf("%s: %w", hash, ErrObjectNotFound)
+}
+
+// Iterate goes through the packs one after the other, an object found in more than
+// one pack is given once per pack
+func (s *PackStore) Iterate(fn func(hash string) error) error {
+	packs, err := repositoryPacks(s.baseDir)
+	if err != nil {
+		return err
+	}
+	defer releasePacks(packs)
+	for _, pack := range packs {
+		for _, entry := range pack.Index.Entries {
+			if err := fn(hex.EncodeToString(entry.SHA[:])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PromisorStore is the object store of a partial clone, the objects the clone left out
+// are fetched from the promisor remote the first time they are read
+//
+// Has does not fetch anything: an object which is not here yet is missing, as for `git
+// cat-file -e` in a partial clone.
+type PromisorStore struct {
+	ObjectStore
+	// Fetch downloads the objects from the promisor remote into the store
+	Fetch func(hashes []string) error
+}
+
+func (s PromisorStore) Get(hash string) ([]byte, string, error) {
+	content, objType, err := s.ObjectStore.Get(hash)
+	if !errors.Is(err, ErrObjectNotFound) {
+		return content, objType, err
+	}
+	if err := s.fetch(hash); err != nil {
+		return nil, "", err
+	}
+	return s.ObjectStore.Get(hash)
+}
+
+func (s PromisorStore) Stat(hash string) (ObjectInfo, error) {
+	info, err := s.ObjectStore.Stat(hash)
+	if !errors.Is(err, ErrObjectNotFound) {
+		return info, err
+	}
+	if err := s.fetch(hash); err != nil {
+		return ObjectInfo{}, err
+	}
+	return s.ObjectStore.Stat(hash)
+}
+
+// Prefetch fetches the missing objects among the hashes in a single request, which is
+// much faster than fetching them one at a time when they are read
+func (s PromisorStore) Prefetch(hashes []string) error {
+	var missing []string
+	seen := map[string]bool{}
+	for _, hash := range hashes {
+		found, err := s.ObjectStore.Has(hash)
+		if err != nil {
+			return err
+		}
+		if !found && !seen[hash] {
+			seen[hash] = true
+			missing = append(missing, hash)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if err := s.Fetch(missing); err != nil {
+		return fmt.Errorf("could not fetch %d objects from promisor remote: %w", len(missing), err)
+	}
+	return nil
+}
+
+func (s PromisorStore) fetch(hash string) error {
+	if err := s.Fetch([]string{hash}); err != nil {
+		return fmt.Errorf("could not fetch %s from promisor remote: %w", hash, err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// QuotePath quotes the path like git when it has a double quote, a backslash, a control
+// or a non ASCII character, and also a space for the short format
+func QuotePath(name string, quoteSpace bool) string {
+	needsQuote := false
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c == '"' || c == '\\' || c < 0x20 || c >= 0x7f || quoteSpace && c == ' ' {
+			needsQuote = true
+			break
+		}
+	}
+	if !needsQuote {
+		return name
+	}
+	escapes := map[byte]string{
+		'\a': `\a`, '\b': `\b`, '\t': `\t`, '\n': `\n`, '\v': `\v`, '\f': `\f`, '\r': `\r`,
+		'"': `\"`, '\\': `\\`,
+	}
+	var builder strings.Builder
+	builder.WriteByte('"')
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch escaped, ok := escapes[c]; {
+		case ok:
+			builder.WriteString(escaped)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&builder, `\%03o`, c)
+		default:
+			builder.WriteByte(c)
+		}
+	}
+	builder.WriteByte('"')
+	return builder.String()
+}
@@ -0,0 +1,23 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// GitDir returns the git directory of the repository at baseDir: baseDir/.git, or baseDir
+// itself for a bare repository, which has its objects and HEAD at the top
+func GitDir(baseDir string) string {
+	gitDir := filepath.Join(baseDir, ".git")
+	if _, err := os.Stat(gitDir); err == nil {
+		return gitDir
+	}
+	objects, err := os.Stat(filepath.Join(baseDir, "objects"))
+	if err != nil || !objects.IsDir() {
+		return gitDir
+	}
+	if _, err := os.Stat(filepath.Join(baseDir, "HEAD")); err != nil {
+		return gitDir
+	}
+	return baseDir
+}
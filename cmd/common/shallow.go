@@ -0,0 +1,60 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ReadShallow returns the shallow commits of the repository of the git directory, the
+// commits of a shallow clone whose parents were not fetched. They are listed one per line
+// in the shallow file, a missing file means that the repository is complete.
+func ReadShallow(gitDir string) ([]string, error) {
+	content, err := os.ReadFile(shallowPath(gitDir))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read shallow: %w", err)
+	}
+	hashes := strings.Fields(string(content))
+	for _, hash := range hashes {
+		if len(hash) != 40 || !isHex(hash) {
+			return nil, fmt.Errorf("read shallow: invalid commit %q", hash)
+		}
+	}
+	return hashes, nil
+}
+
+// UpdateShallow adds the shallow commits to the shallow file of the git directory and
+// removes the unshallow ones, the file is removed once no commit is shallow anymore
+func UpdateShallow(gitDir string, shallow, unshallow []string) error {
+	current, err := ReadShallow(gitDir)
+	if err != nil {
+		return err
+	}
+	updated := slices.DeleteFunc(append(current, shallow...), func(hash string) bool {
+		return slices.Contains(unshallow, hash)
+	})
+	slices.Sort(updated)
+	updated = slices.Compact(updated)
+	if len(updated) == 0 {
+		if err := os.Remove(shallowPath(gitDir)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("update shallow: %w", err)
+		}
+		return nil
+	}
+	content := strings.Join(updated, "\n") + "\n"
+	if err := os.WriteFile(shallowPath(gitDir), []byte(content), 0644); err != nil {
+		return fmt.Errorf("update shallow: %w", err)
+	}
+	return nil
+}
+
+func shallowPath(gitDir string) string {
+	return filepath.Join(gitDir, "shallow")
+}
@@ -0,0 +1,35 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestUpdateShallow(t *testing.T) {
+	gitDir := t.TempDir()
+	a, b, c := strings.Repeat("a", 40), strings.Repeat("b", 40), strings.Repeat("c", 40)
+
+	if shallow, err := ReadShallow(gitDir); err != nil || shallow != nil {
+		t.Fatalf("ReadShallow() = %v, %v for a complete repository", shallow, err)
+	}
+	if err := UpdateShallow(gitDir, []string{c, a}, nil); err != nil {
+		t.Fatalf("UpdateShallow() error = %v", err)
+	}
+	if err := UpdateShallow(gitDir, []string{b, a}, []string{c}); err != nil {
+		t.Fatalf("UpdateShallow() error = %v", err)
+	}
+	shallow, err := ReadShallow(gitDir)
+	if err != nil || !slices.Equal(shallow, []string{a, b}) {
+		t.Errorf("ReadShallow() = %v, %v", shallow, err)
+	}
+
+	if err := UpdateShallow(gitDir, nil, []string{a, b}); err != nil {
+		t.Fatalf("UpdateShallow() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(gitDir, "shallow")); !os.IsNotExist(err) {
+		t.Errorf("the shallow file still exists without shallow commits")
+	}
+}
@@ -0,0 +1,167 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ErrObjectNotFound is returned by the object stores for a missing object, it matches
+// fs.ErrNotExist (and os.ErrNotExist) as well
+var ErrObjectNotFound = fmt.Errorf("object not found: %w", fs.ErrNotExist)
+
+// errReadOnlyStore is returned when writing to a store that cannot hold new objects
+var errReadOnlyStore = errors.New("object store is read-only")
+
+// ObjectInfo is the type and the size of an object, as given by `git cat-file -t/-s`
+type ObjectInfo struct {
+	Type string
+	Size int64
+}
+
+// ObjectStore is where the objects of a repository are kept
+//
+// The objects are addressed by their 40 character hex hash, and the content is always
+// the object body without the `<type> <size>\0` header.
+type ObjectStore interface {
+	// Has reports whether the object is in the store
+	Has(hash string) (bool, error)
+	// Get returns the content and the type of the object
+	Get(hash string) ([]byte, string, error)
+	// Put stores the object and returns its hash, existing objects are not rewritten
+	Put(objType string, content []byte) (string, error)
+	// Stat returns the type and the size of the object without reading all its content
+	// when possible
+	Stat(hash string) (ObjectInfo, error)
+	// Iterate calls fn with the hash of every object in the store, an error returned
+	// by fn stops the iteration and is returned
+	Iterate(fn func(hash string) error) error
+}
+
+// NewObjectStore returns the object store of the repository at baseDir, the objects
+// are read from the loose objects and the pack files and written as loose objects
+func NewObjectStore(baseDir string) ObjectStore {
+	return MultiStore{NewLooseStore(baseDir), NewPackStore(baseDir)}
+}
+
+// MultiStore looks up the objects in each store in turn, new objects are written to
+// the first store
+type MultiStore []ObjectStore
+
+func (m MultiStore) Has(hash string) (bool, error) {
+	for _, store := range m {
+		found, err := store.Has(hash)
+		if err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+func (m MultiStore) Get(hash string) ([]byte, string, error) {
+	for _, store := range m {
+		content, objType, err := store.Get(hash)
+		if !errors.Is(err, ErrObjectNotFound) {
+			return content, objType, err
+		}
+	}
+	return nil, "", fmt.Errorf("%s: %w", hash, ErrObjectNotFound)
+}
+
+func (m MultiStore) Put(objType string, content []byte) (string, error) {
+	if len(m) == 0 {
+		return "", errReadOnlyStore
+	}
+	return m[0].Put(objType, content)
+}
+
+func (m MultiStore) Stat(hash string) (ObjectInfo, error) {
+	for _, store := range m {
+		info, err := store.Stat(hash)
+		if !errors.Is(err, ErrObjectNotFound) {
+			return info, err
+		}
+	}
+	return ObjectInfo{}, fmt.Errorf("%s: %w", hash, ErrObjectNotFound)
+}
+
+// Iterate calls fn once for every object, even if it is in more than one store
+func (m MultiStore) Iterate(fn func(hash string) error) error {
+	seen := map[string]bool{}
+	for _, store := range m {
+		err := store.Iterate(func(hash string) error {
+			if seen[hash] {
+				return nil
+			}
+			seen[hash] = true
+			return fn(hash)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadObject returns the content and the type of the object with the hash, the object
+// is read from its loose file or from one of the pack files of the repository at baseDir
+func ReadObject(baseDir, hash string) ([]byte, string, error) {
+	return NewObjectStore(baseDir).Get(hash)
+}
+
+// HasObject reports whether the object is in the repository at baseDir, either as a
+// loose object or in a pack file
+func HasObject(baseDir, hash string) bool {
+	found, err := NewObjectStore(baseDir).Has(hash)
+	return err == nil && found
+}
+
+// FindObjectsByPrefix returns the hashes of the loose and packed objects which start with
+// the abbreviated hex hash, the prefix needs at least 2 characters
+func FindObjectsByPrefix(baseDir, prefix string) ([]string, error) {
+	if len(prefix) < 2 {
+		return nil, fmt.Errorf("find objects: prefix %q is too short", prefix)
+	}
+	prefix = strings.ToLower(prefix)
+	seen := map[string]bool{}
+	entries, err := os.ReadDir(filepath.Join(GitDir(baseDir), "objects", prefix[:2]))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("find objects %s: %w", prefix, err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), prefix[2:]) {
+			seen[prefix[:2]+entry.Name()] = true
+		}
+	}
+
+	packs, err := repositoryPacks(baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("find objects %s: %w", prefix, err)
+	}
+	defer releasePacks(packs)
+	for _, pack := range packs {
+		for _, hash := range pack.Index.HashesWithPrefix(prefix) {
+			seen[hash] = true
+		}
+	}
+	matches := make([]string, 0, len(seen))
+	for hash := range seen {
+		matches = append(matches, hash)
+	}
+	slices.Sort(matches)
+	return matches, nil
+}
+
+// hashObject returns the hex hash of the object with the type and content
+func hashObject(objType string, content []byte) (string, []byte, error) {
+	fullContent := FormatGitObjectContent(objType, content)
+	hash, err := CalculateEncodedSHA(fullContent)
+	if err != nil {
+		return "", nil, fmt.Errorf("calculate SHA: %w", err)
+	}
+	return hash, fullContent, nil
+}
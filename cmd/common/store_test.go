@@ -0,0 +1,298 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestObjectStores(t *testing.T) {
+	stores := map[string]ObjectStore{
+		"loose":  NewLooseStore(t.TempDir()),
+		"memory": NewMemoryStore(),
+		"multi":  NewObjectStore(t.TempDir()),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			// `echo 'hello world' | git hash-object --stdin`
+			const expected = "3b18e512dba79e4c8300dd08aeb37f8e728b8dad"
+			hash, err := store.Put("blob", []byte("hello world\n"))
+			if err != nil || hash != expected {
+				t.Fatalf("Put() = %s, %v, expected %s", hash, err, expected)
+			}
+			if _, err := store.Put("blob", []byte("hello world\n")); err != nil {
+				t.Errorf("Put() of an existing object error = %v", err)
+			}
+			if found, err := store.Has(hash); err != nil || !found {
+				t.Errorf("Has() = %v, %v", found, err)
+			}
+			content, objType, err := store.Get(hash)
+			if err != nil || objType != "blob" || string(content) != "hello world\n" {
+				t.Errorf("Get() = %q, %q, %v", content, objType, err)
+			}
+			info, err := store.Stat(hash)
+			if err != nil || info != (ObjectInfo{Type: "blob", Size: 12}) {
+				t.Errorf("Stat() = %+v, %v", info, err)
+			}
+
+			var hashes []string
+			err = store.Iterate(func(hash string) error {
+				hashes = append(hashes, hash)
+				return nil
+			})
+			if err != nil || len(hashes) != 1 || hashes[0] != hash {
+				t.Errorf("Iterate() gave %v, %v", hashes, err)
+			}
+
+			missing := strings.Repeat("0", 40)
+			if found, err := store.Has(missing); err != nil || found {
+				t.Errorf("Has(missing) = %v, %v", found, err)
+			}
+			if _, _, err := store.Get(missing); !errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("Get(missing) error = %v, expected ErrObjectNotFound", err)
+			}
+			if _, err := store.Stat(missing); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("Stat(missing) error = %v, expected os.ErrNotExist", err)
+			}
+		})
+	}
+}
+
+func TestLooseStorePut(t *testing.T) {
+	baseDir := t.TempDir()
+	hash, err := NewLooseStore(baseDir).Put("blob", []byte("hello world\n"))
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	objectsDir := filepath.Join(GitDir(baseDir), "objects")
+	entries, err := os.ReadDir(objectsDir)
+	if err != nil || len(entries) != 1 || entries[0].Name() != hash[:2] {
+		t.Errorf("objects directory has %v, %v, expected only %s", entries, err, hash[:2])
+	}
+	info, err := os.Stat(filepath.Join(objectsDir, hash[:2], hash[2:]))
+	if err != nil || info.Mode().Perm() != 0444 {
+		t.Errorf("object file is %v, %v, expected a read-only file", info, err)
+	}
+}
+
+// writeTestPack copies the ofs-delta test pack into the repository at baseDir and returns
+// the directory of the packs
+func writeTestPack(t *testing.T, baseDir string) string {
+	t.Helper()
+	packDir := filepath.Join(GitDir(baseDir), "objects", "pack")
+	if err := os.MkdirAll(packDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for _, ext := range []string{".pack", ".idx"} {
+		content, err := os.ReadFile("../../testdata/ofs-delta" + ext)
+		if err != nil {
+			t.Fatalf("error in reading testdata: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(packDir, "pack-test"+ext), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return packDir
+}
+
+func TestPackStoreStat(t *testing.T) {
+	baseDir := t.TempDir()
+	writeTestPack(t, baseDir)
+	info, err := os.ReadFile("../../testdata/ofs-delta.info")
+	if err != nil {
+		t.Fatalf("error in reading pack info: %v", err)
+	}
+
+	store := NewPackStore(baseDir)
+	count := 0
+	for _, line := range strings.Split(string(info), "\n") {
+		// <hash> <type> <size> <size-in-pack> <offset> [<depth> <base>]
+		fields := strings.Fields(line)
+		if len(fields) < 5 || len(fields[0]) != 40 {
+			continue
+		}
+		count++
+		// verify-pack gives the size of the delta data for the deltified objects
+		size, _ := strconv.ParseInt(fields[2], 10, 64)
+		if len(fields) > 5 {
+			content, _, err := store.Get(fields[0])
+			if err != nil {
+				t.Fatalf("Get(%s) error = %v", fields[0], err)
+			}
+			size = int64(len(content))
+		}
+		stat, err := store.Stat(fields[0])
+		if err != nil || stat != (ObjectInfo{Type: fields[1], Size: size}) {
+			t.Errorf("Stat(%s) = %+v, %v, expected %s %d", fields[0], stat, err, fields[1], size)
+		}
+	}
+	iterated := 0
+	err = store.Iterate(func(string) error {
+		iterated++
+		return nil
+	})
+	if err != nil || iterated != count {
+		t.Errorf("Iterate() gave %d objects, %v, expected %d", iterated, err, count)
+	}
+	if _, err := store.Put("blob", nil); err == nil {
+		t.Errorf("Put() on a pack store did not fail")
+	}
+}
+
+func TestPackStoreReplacedPack(t *testing.T) {
+	baseDir := t.TempDir()
+	packDir := writeTestPack(t, baseDir)
+	// the first commit of ofs-delta.info
+	const hash = "d0a550cd1b36150ee4661315e7d030cf5b96fb96"
+	store := NewPackStore(baseDir)
+	if found, err := store.Has(hash); err != nil || !found {
+		t.Fatalf("Has() = %v, %v", found, err)
+	}
+
+	// a reader still has the pack while its index is replaced
+	held, err := repositoryPacks(baseDir)
+	if err != nil || len(held) != 1 {
+		t.Fatalf("repositoryPacks() = %d packs, %v", len(held), err)
+	}
+
+	// an index without the objects takes the place of the one already opened
+	var empty bytes.Buffer
+	if err := WritePackIndex(&empty, nil, [20]byte{}); err != nil {
+		t.Fatal(err)
+	}
+	idxPath := filepath.Join(packDir, "pack-test.idx")
+	if err := os.WriteFile(idxPath+".tmp", empty.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(idxPath+".tmp", idxPath); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := store.Has(hash); err != nil || found {
+		t.Errorf("Has() after the index was replaced = %v, %v", found, err)
+	}
+	if _, err := held[0].file.Stat(); err != nil {
+		t.Errorf("the replaced pack is closed while a reader has it: %v", err)
+	}
+	releasePacks(held)
+	if _, err := held[0].file.Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("the replaced pack is not closed once released: %v", err)
+	}
+
+	for _, ext := range []string{".pack", ".idx"} {
+		if err := os.Remove(filepath.Join(packDir, "pack-test"+ext)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	openPacks.Lock()
+	removed := openPacks.byIndex[idxPath]
+	openPacks.Unlock()
+	packs, err := repositoryPacks(baseDir)
+	if err != nil || len(packs) != 0 {
+		t.Errorf("repositoryPacks() after the pack was removed = %d packs, %v", len(packs), err)
+	}
+	openPacks.Lock()
+	_, open := openPacks.byIndex[idxPath]
+	openPacks.Unlock()
+	if open {
+		t.Errorf("the removed pack is still kept open")
+	}
+	if _, err := removed.file.Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("the removed pack is not closed: %v", err)
+	}
+}
+
+func TestPackStoreConcurrentReads(t *testing.T) {
+	baseDir := t.TempDir()
+	writeTestPack(t, baseDir)
+	var hashes []string
+	store := NewPackStore(baseDir)
+	if err := store.Iterate(func(hash string) error {
+		hashes = append(hashes, hash)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// the delta bases are cached by the pack shared between the readers
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, hash := range hashes {
+				if _, _, err := store.Get(hash); err != nil {
+					t.Errorf("Get(%s) error = %v", hash, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestPromisorStore(t *testing.T) {
+	remote, local := NewMemoryStore(), NewMemoryStore()
+	hash, _ := remote.Put("blob", []byte("hello world\n"))
+	other, _ := remote.Put("blob", []byte("other\n"))
+	var requests [][]string
+	store := PromisorStore{ObjectStore: local, Fetch: func(hashes []string) error {
+		requests = append(requests, hashes)
+		for _, hash := range hashes {
+			content, objType, err := remote.Get(hash)
+			if err != nil {
+				return err
+			}
+			if _, err := local.Put(objType, content); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}
+
+	if found, _ := store.Has(hash); found || len(requests) != 0 {
+		t.Errorf("Has() = %v and fetched %v, expected a missing object and no fetch", found, requests)
+	}
+	content, _, err := store.Get(hash)
+	if err != nil || string(content) != "hello world\n" {
+		t.Errorf("Get() = %q, %v", content, err)
+	}
+	if _, _, err := store.Get(hash); err != nil || len(requests) != 1 {
+		t.Errorf("Get() of a fetched object = %v, %d requests", err, len(requests))
+	}
+	if err := store.Prefetch([]string{hash, other, other}); err != nil {
+		t.Errorf("Prefetch() error = %v", err)
+	}
+	if len(requests) != 2 || len(requests[1]) != 1 || requests[1][0] != other {
+		t.Errorf("Prefetch() requests = %v, expected only the missing object", requests)
+	}
+	if _, err := store.Stat(strings.Repeat("0", 40)); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Stat() of an object the remote does not have error = %v", err)
+	}
+}
+
+func TestBareRepository(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "objects"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "HEAD"), []byte("ref: refs/heads/main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if gitDir := GitDir(dir); gitDir != dir {
+		t.Errorf("GitDir() = %q, expected the bare repository %q", gitDir, dir)
+	}
+	hash, err := NewObjectStore(dir).Put("blob", []byte("hello world\n"))
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "objects", hash[:2], hash[2:])); err != nil {
+		t.Errorf("the object is not in the bare repository: %v", err)
+	}
+	if gitDir := GitDir(t.TempDir()); filepath.Base(gitDir) != ".git" {
+		t.Errorf("GitDir() of a new directory = %q, expected its .git", gitDir)
+	}
+}
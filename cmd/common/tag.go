@@ -0,0 +1,120 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Tag is the parsed form of an annotated tag object
+//
+// The signature of a signed tag is a part of the message. Like Commit, parsing and
+// serializing a tag gives back the exact same bytes.
+type Tag struct {
+	// Object is the 40 character hex hash of the tagged object
+	Object string
+	// Type is the type of the tagged object e.g. "commit"
+	Type string
+	// Name is the name of the tag (the `tag` header)
+	Name string
+	// Tagger is missing from some very old tags, it is the zero value then
+	Tagger       Signature
+	ExtraHeaders []Header
+	Message      string
+
+	headerOrder []string
+	bareKeys    map[string]bool
+	noMessage   bool
+}
+
+// ParseTag parses the body of a tag object (without the `tag <size>\0` header)
+func ParseTag(content []byte) (*Tag, error) {
+	headers, message, hasMessage, err := parseObjectHeaders(content)
+	if err != nil {
+		return nil, fmt.Errorf("parse tag: %w", err)
+	}
+	tag := &Tag{Message: message, noMessage: !hasMessage}
+	seen := map[string]bool{}
+	for _, header := range headers {
+		key := header.Key
+		switch {
+		case seen[key]:
+			key = ""
+		case key == "object":
+			tag.Object = header.Value
+		case key == "type":
+			tag.Type = header.Value
+		case key == "tag":
+			tag.Name = header.Value
+		case key == "tagger":
+			tag.Tagger, err = ParseSignature(header.Value)
+			if err != nil {
+				return nil, fmt.Errorf("parse tag: tagger: %w", err)
+			}
+		default:
+			key = ""
+		}
+		if key == "" {
+			tag.ExtraHeaders = append(tag.ExtraHeaders, header)
+		} else if header.bare {
+			tag.bareKeys = addBareKey(tag.bareKeys, key)
+		}
+		seen[key] = true
+		tag.headerOrder = append(tag.headerOrder, key)
+	}
+	if len(tag.Object) != 40 {
+		return nil, fmt.Errorf("parse tag: missing or invalid object %q", tag.Object)
+	}
+	return tag, nil
+}
+
+// Serialize returns the body of the tag object
+func (t *Tag) Serialize() []byte {
+	var buffer bytes.Buffer
+	w := headerWriter{buffer: &buffer}
+	extras := t.ExtraHeaders
+	written := map[string]bool{}
+	writeKey := func(key string) {
+		if key == "" {
+			if len(extras) > 0 {
+				w.writeHeader(extras[0])
+				extras = extras[1:]
+			}
+			return
+		}
+		if written[key] {
+			return
+		}
+		written[key] = true
+		switch key {
+		case "object":
+			w.write("object", t.Object)
+		case "type":
+			w.writeHeader(Header{Key: "type", Value: t.Type, bare: t.bareKeys["type"]})
+		case "tag":
+			w.writeHeader(Header{Key: "tag", Value: t.Name, bare: t.bareKeys["tag"]})
+		case "tagger":
+			w.writeSignature("tagger", t.Tagger)
+		}
+	}
+
+	for _, key := range t.headerOrder {
+		writeKey(key)
+	}
+	for _, key := range []string{"object", "type", "tag", "tagger"} {
+		writeKey(key)
+	}
+	for len(extras) > 0 {
+		writeKey("")
+	}
+
+	if !t.noMessage || t.Message != "" {
+		buffer.WriteByte('\n')
+	}
+	buffer.WriteString(t.Message)
+	return buffer.Bytes()
+}
+
+// Subject is the first paragraph of the tag message joined in a single line
+func (t *Tag) Subject() string {
+	return messageSubject(t.Message)
+}
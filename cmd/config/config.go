@@ -0,0 +1,434 @@
+// Package config reads and writes the git configuration files
+//
+// A configuration file is made of sections holding `key = value` variables,
+//
+//	[core]
+//		bare = false
+//	[remote "origin"]
+//		url = https://example.com/repo.git
+//		fetch = +refs/heads/*:refs/remotes/origin/*
+//
+// A variable is named by its section, optional subsection and key joined with dots,
+// e.g. `remote.origin.url`. Section and key names are case insensitive, subsection
+// names are not. See the [git documentation](https://git-scm.com/docs/git-config#_syntax)
+//
+// Load reads the configuration of a repository the way git does, from the global files
+// to the repository one, along with the files they include. A File edits a single file
+// without losing its comments and formatting.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// Entry is a single variable of a configuration file
+type Entry struct {
+	// Section and Key are lower case, Subsection is kept as written
+	Section    string
+	Subsection string
+	Key        string
+	Value      string
+	// start and end are the offsets of the variable in the file it was parsed from,
+	// from the start of its line to the end of its value, a comment after the value is
+	// not part of it
+	start, end int
+}
+
+// Name returns the full name of the variable, `section[.subsection].key`
+func (e Entry) Name() string {
+	if e.Subsection == "" {
+		return e.Section + "." + e.Key
+	}
+	return e.Section + "." + e.Subsection + "." + e.Key
+}
+
+// Config is the list of variables of a configuration file, in the order they appear
+type Config struct {
+	Entries []Entry
+}
+
+// Read parses the configuration file at path, a missing file is the same as an empty one
+func Read(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return &Config{}, nil
+		}
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	cfg, err := Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Append adds the variables at the end of the configuration file at path, the file is
+// created when missing. The existing content is left as is, consecutive variables of the
+// same section are written under a single section header.
+func Append(path string, entries ...Entry) error {
+	var builder strings.Builder
+	for i, entry := range entries {
+		if i == 0 || entry.Section != entries[i-1].Section || entry.Subsection != entries[i-1].Subsection {
+			if entry.Subsection == "" {
+				fmt.Fprintf(&builder, "[%s]\n", entry.Section)
+			} else {
+				fmt.Fprintf(&builder, "[%s %s]\n", entry.Section, quote(entry.Subsection))
+			}
+		}
+		fmt.Fprintf(&builder, "\t%s = %s\n", entry.Key, formatValue(entry.Value))
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	if _, err := file.WriteString(builder.String()); err != nil {
+		file.Close()
+		return fmt.Errorf("write config: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	return nil
+}
+
+// formatValue quotes the value when it would not be read back as is
+func formatValue(value string) string {
+	if value != strings.TrimSpace(value) || strings.ContainsAny(value, ";#") {
+		return quote(value)
+	}
+	return escape(value)
+}
+
+func quote(value string) string {
+	return `"` + escape(value) + `"`
+}
+
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(value)
+}
+
+// Get returns the value of the variable, the last one wins when it is set more than once
+func (c *Config) Get(name string) (string, bool) {
+	values := c.GetAll(name)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// GetAll returns all the values of a multivalued variable like `remote.origin.fetch`
+func (c *Config) GetAll(name string) []string {
+	section, subsection, key, ok := SplitName(name)
+	if !ok {
+		return nil
+	}
+	var values []string
+	for _, entry := range c.Entries {
+		if entry.Section == section && entry.Subsection == subsection && entry.Key == key {
+			values = append(values, entry.Value)
+		}
+	}
+	return values
+}
+
+// Subsections returns the subsections of the section in the order they first appear,
+// e.g. the names of the remotes for the "remote" section
+func (c *Config) Subsections(section string) []string {
+	section = strings.ToLower(section)
+	var names []string
+	seen := map[string]bool{}
+	for _, entry := range c.Entries {
+		if entry.Section == section && entry.Subsection != "" && !seen[entry.Subsection] {
+			seen[entry.Subsection] = true
+			names = append(names, entry.Subsection)
+		}
+	}
+	return names
+}
+
+// SplitName splits the variable name into its section, subsection and key, the section
+// and the key are lower cased. The subsection is everything between the first and the
+// last dot, so it can contain dots itself.
+func SplitName(name string) (section, subsection, key string, ok bool) {
+	first, last := strings.Index(name, "."), strings.LastIndex(name, ".")
+	if first <= 0 || last == len(name)-1 {
+		return "", "", "", false
+	}
+	section, key = strings.ToLower(name[:first]), strings.ToLower(name[last+1:])
+	if first != last {
+		subsection = name[first+1 : last]
+	}
+	return section, subsection, key, true
+}
+
+// Parse parses the content of a configuration file
+func Parse(content []byte) (*Config, error) {
+	cfg, _, err := parse(string(content))
+	return cfg, err
+}
+
+// sectionSpan is where a section is in a file, a section can appear more than once
+type sectionSpan struct {
+	section, subsection string
+	// end is the offset after the last variable of the section, or after its header
+	// line when it has none
+	end int
+}
+
+// parse parses the content and returns where the sections are along with the variables
+func parse(content string) (*Config, []sectionSpan, error) {
+	p := &parser{content: content, line: 1}
+	cfg := &Config{}
+	var sections []sectionSpan
+	for {
+		p.skipBlank()
+		if p.done() {
+			return cfg, sections, nil
+		}
+		switch c := p.peek(); {
+		case c == '#' || c == ';':
+			p.skipLine()
+		case c == '[':
+			section, subsection, err := p.sectionHeader()
+			if err != nil {
+				return nil, nil, err
+			}
+			sections = append(sections, sectionSpan{
+				section:    section,
+				subsection: subsection,
+				end:        p.headerEnd(),
+			})
+		case isKeyChar(c):
+			if len(sections) == 0 {
+				return nil, nil, p.errorf("variable outside of a section")
+			}
+			start := p.lineStart()
+			key, value, end, err := p.variable()
+			if err != nil {
+				return nil, nil, err
+			}
+			current := &sections[len(sections)-1]
+			current.end = p.pos
+			cfg.Entries = append(cfg.Entries, Entry{
+				Section:    current.section,
+				Subsection: current.subsection,
+				Key:        key,
+				Value:      value,
+				start:      start,
+				end:        end,
+			})
+		default:
+			return nil, nil, p.errorf("unexpected character %q", c)
+		}
+	}
+}
+
+// parser reads a configuration file one character at a time
+type parser struct {
+	content string
+	pos     int
+	line    int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.content)
+}
+
+func (p *parser) peek() byte {
+	return p.content[p.pos]
+}
+
+func (p *parser) next() byte {
+	c := p.content[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+// lineStart returns the start of the current line when there is only white space before
+// the current position, or the end of what comes before it on the line, e.g. a section
+// header
+func (p *parser) lineStart() int {
+	start := p.pos
+	for start > 0 && isSpace(p.content[start-1]) {
+		start--
+	}
+	return start
+}
+
+// headerEnd returns the end of the line of the section header which was just read, when
+// the rest of it is blank or a comment, or the end of the header otherwise
+func (p *parser) headerEnd() int {
+	rest, _, _ := strings.Cut(p.content[p.pos:], "\n")
+	if trimmed := strings.TrimLeft(rest, " \t\r"); trimmed != "" && trimmed[0] != '#' && trimmed[0] != ';' {
+		return p.pos
+	}
+	return min(p.pos+len(rest)+1, len(p.content))
+}
+
+func (p *parser) errorf(format string, a ...any) error {
+	return fmt.Errorf("bad config line %d: %s", p.line, fmt.Sprintf(format, a...))
+}
+
+// skipBlank skips the white space, including the line ends
+func (p *parser) skipBlank() {
+	for !p.done() && isSpace(p.peek()) || !p.done() && p.peek() == '\n' {
+		p.next()
+	}
+}
+
+// skipSpace skips the white space on the current line
+func (p *parser) skipSpace() {
+	for !p.done() && isSpace(p.peek()) {
+		p.next()
+	}
+}
+
+func (p *parser) skipLine() {
+	for !p.done() && p.next() != '\n' {
+	}
+}
+
+// sectionHeader reads `[section]`, `[section "subsection"]` or the deprecated
+// `[section.subsection]` form
+func (p *parser) sectionHeader() (string, string, error) {
+	p.next() // '['
+	start := p.pos
+	for !p.done() && (isKeyChar(p.peek()) || p.peek() == '.') {
+		p.next()
+	}
+	name := p.content[start:p.pos]
+	if name == "" {
+		return "", "", p.errorf("empty section name")
+	}
+	subsection := ""
+	if !p.done() && isSpace(p.peek()) {
+		p.skipSpace()
+		if p.done() || p.next() != '"' {
+			return "", "", p.errorf("invalid section header")
+		}
+		var builder strings.Builder
+		for {
+			if p.done() || p.peek() == '\n' {
+				return "", "", p.errorf("unterminated subsection name")
+			}
+			c := p.next()
+			if c == '"' {
+				break
+			}
+			if c == '\\' {
+				if p.done() || p.peek() == '\n' {
+					return "", "", p.errorf("unterminated subsection name")
+				}
+				c = p.next()
+			}
+			builder.WriteByte(c)
+		}
+		subsection = builder.String()
+	} else if section, sub, found := strings.Cut(name, "."); found {
+		// the old syntax lower cases the subsection as well
+		name, subsection = section, strings.ToLower(sub)
+	}
+	if p.done() || p.next() != ']' {
+		return "", "", p.errorf("invalid section header")
+	}
+	return strings.ToLower(name), subsection, nil
+}
+
+// variable reads a `key = value` line, a key without a value is a true boolean, and
+// returns the offset of the end of the value along with it
+func (p *parser) variable() (string, string, int, error) {
+	start := p.pos
+	for !p.done() && isKeyChar(p.peek()) {
+		p.next()
+	}
+	key := strings.ToLower(p.content[start:p.pos])
+	end := p.pos
+	p.skipSpace()
+	if p.done() || p.peek() == '\n' || p.peek() == '#' || p.peek() == ';' {
+		p.skipLine()
+		return key, "true", end, nil
+	}
+	if p.next() != '=' {
+		return "", "", 0, p.errorf("invalid variable %q", key)
+	}
+	value, end, err := p.value()
+	return key, value, end, err
+}
+
+// value reads the value up to the end of the line, the surrounding white space and the
+// comments are dropped, the quoted parts are kept as is and the escape sequences are
+// decoded. A '\' at the end of the line continues the value on the next one. The offset
+// after the last character of the value is returned along with it.
+func (p *parser) value() (string, int, error) {
+	var builder strings.Builder
+	quoted := false
+	// pending is the white space which is only kept if more of the value follows
+	pending := ""
+	p.skipSpace()
+	end := p.pos
+	for !p.done() {
+		c := p.next()
+		switch {
+		case c == '\n':
+			if quoted {
+				return "", 0, p.errorf("unterminated quoted value")
+			}
+			return builder.String(), end, nil
+		case isSpace(c) && !quoted:
+			pending += string(c)
+			continue
+		case (c == '#' || c == ';') && !quoted:
+			p.skipLine()
+			return builder.String(), end, nil
+		}
+		builder.WriteString(pending)
+		pending = ""
+		switch c {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			if p.done() {
+				return "", 0, p.errorf("unterminated escape sequence")
+			}
+			switch escaped := p.next(); escaped {
+			case '\n':
+				// line continuation
+			case 'n':
+				builder.WriteByte('\n')
+			case 't':
+				builder.WriteByte('\t')
+			case 'b':
+				s := builder.String()
+				builder.Reset()
+				builder.WriteString(s[:max(len(s)-1, 0)])
+			case '\\', '"':
+				builder.WriteByte(escaped)
+			default:
+				return "", 0, p.errorf("invalid escape sequence \\%c", escaped)
+			}
+		default:
+			builder.WriteByte(c)
+		}
+		end = p.pos
+	}
+	if quoted {
+		return "", 0, p.errorf("unterminated quoted value")
+	}
+	return builder.String(), end, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r'
+}
+
+func isKeyChar(c byte) bool {
+	return c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
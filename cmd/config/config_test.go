@@ -0,0 +1,288 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	content := []byte(`# the repository configuration
+[core]
+	bare = false
+	FileMode = true ; a comment
+[remote "origin"]
+	url = https://example.com/repo.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/*
+[branch "Main"]
+	remote = origin
+[alias]
+	quoted = "  spaced  # not a comment"
+	escaped = a\tb\\c\"d
+	continued = one \
+two
+	flag
+[Section.Sub]
+	key=value
+`)
+	cfg, err := Parse(content)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	tests := map[string]string{
+		"core.bare":          "false",
+		"CORE.filemode":      "true",
+		"remote.origin.url":  "https://example.com/repo.git",
+		"branch.Main.remote": "origin",
+		"alias.quoted":       "  spaced  # not a comment",
+		"alias.escaped":      "a\tb\\c\"d",
+		"alias.continued":    "one two",
+		"alias.flag":         "true",
+		"section.sub.key":    "value",
+	}
+	for name, want := range tests {
+		if got, ok := cfg.Get(name); !ok || got != want {
+			t.Errorf("Get(%q) = %q, %v, want %q", name, got, ok, want)
+		}
+	}
+	if _, ok := cfg.Get("branch.main.remote"); ok {
+		t.Errorf("Get() found a subsection with a different case")
+	}
+	fetch := cfg.GetAll("remote.origin.fetch")
+	if !slices.Equal(fetch, []string{"+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*"}) {
+		t.Errorf("GetAll() = %q", fetch)
+	}
+	if remotes := cfg.Subsections("remote"); !slices.Equal(remotes, []string{"origin"}) {
+		t.Errorf("Subsections() = %q", remotes)
+	}
+
+	for _, invalid := range []string{"key = value\n", "[core\n", "[remote \"origin]\n", "[core]\nkey = \"open\n"} {
+		if _, err := Parse([]byte(invalid)); err == nil {
+			t.Errorf("Parse(%q) did not fail", invalid)
+		}
+	}
+}
+
+func TestAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte("# kept\n[core]\n\tbare = false\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := Append(path,
+		Entry{Section: "remote", Subsection: "origin", Key: "url", Value: "https://example.com/repo.git"},
+		Entry{Section: "remote", Subsection: "origin", Key: "promisor", Value: "true"},
+		Entry{Section: "alias", Key: "odd", Value: " a \"b\" ; c\n"},
+	)
+	if err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	cfg, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	want := []string{"core.bare", "remote.origin.url", "remote.origin.promisor", "alias.odd"}
+	var names []string
+	for _, entry := range cfg.Entries {
+		names = append(names, entry.Name())
+	}
+	if !slices.Equal(names, want) {
+		t.Errorf("Read() names = %q, want %q", names, want)
+	}
+	if value, _ := cfg.Get("alias.odd"); value != " a \"b\" ; c\n" {
+		t.Errorf("Get(alias.odd) = %q", value)
+	}
+	content, _ := os.ReadFile(path)
+	if strings.Count(string(content), "[remote \"origin\"]") != 1 || !strings.HasPrefix(string(content), "# kept\n") {
+		t.Errorf("Append() wrote %q", content)
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	original := "# top\n[core]\n\tbare = false ; keep\n  FileMode=true\n\n[x] z = 3\n" +
+		"[remote \"o\"]\n\turl = u\n\tfetch = a\n\tfetch = b\n"
+	if err := os.WriteFile(path, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"set", func() error { return file.Set("core.bare", "true") }},
+		{"set new", func() error { return file.Set("core.new", "v ;x") }},
+		{"set on header line", func() error { return file.Set("x.z", "4") }},
+		{"add", func() error { return file.Add("remote.o.fetch", "c") }},
+		{"new section", func() error { return file.Set("New.Sec.Key", "v") }},
+		{"unset", func() error { return file.Unset("core.filemode") }},
+	}
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			t.Fatalf("%s: error = %v", step.name, err)
+		}
+	}
+	if err := file.Set("remote.o.fetch", "d"); !errors.Is(err, ErrMultipleValues) {
+		t.Errorf("Set() of a multivalued variable error = %v", err)
+	}
+	if err := file.Unset("nope.x"); !errors.Is(err, ErrNotSet) {
+		t.Errorf("Unset() of a missing variable error = %v", err)
+	}
+	for _, invalid := range []string{"nosection", "a.1b", "a.b_c", "a_b.c"} {
+		if err := file.Set(invalid, "v"); err == nil {
+			t.Errorf("Set(%q) did not fail", invalid)
+		}
+	}
+	if err := file.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	content, _ := os.ReadFile(path)
+	want := "# top\n[core]\n\tbare = true ; keep\n\tnew = \"v ;x\"\n\n[x]\n\tz = 4\n" +
+		"[remote \"o\"]\n\turl = u\n\tfetch = a\n\tfetch = b\n\tfetch = c\n[New \"Sec\"]\n\tKey = v\n"
+	if string(content) != want {
+		t.Errorf("Save() wrote\n%q\nwant\n%q", content, want)
+	}
+
+	if err := file.UnsetAll("remote.o.fetch"); err != nil {
+		t.Fatalf("UnsetAll() error = %v", err)
+	}
+	if fetch := file.Config().GetAll("remote.o.fetch"); len(fetch) != 0 {
+		t.Errorf("UnsetAll() left %q", fetch)
+	}
+}
+
+func TestFileComments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	original := "[a]\n\tkey = v  # note\n\tquoted = \"x # y\" ; quoted\n\tcont = one \\\n two # cont\n" +
+		"\tgone = 1 # gone\n\tflag # flag\n[b] k = 1 # header\n"
+	if err := os.WriteFile(path, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	for _, name := range []string{"a.key", "a.quoted", "a.cont", "a.flag", "b.k"} {
+		if err := file.Set(name, "new"); err != nil {
+			t.Fatalf("Set(%s) error = %v", name, err)
+		}
+	}
+	if err := file.Unset("a.gone"); err != nil {
+		t.Fatalf("Unset() error = %v", err)
+	}
+	if err := file.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	content, _ := os.ReadFile(path)
+	want := "[a]\n\tkey = new  # note\n\tquoted = new ; quoted\n\tcont = new # cont\n" +
+		"\t# gone\n\tflag = new # flag\n[b]\n\tk = new # header\n"
+	if string(content) != want {
+		t.Errorf("Save() wrote\n%q\nwant\n%q", content, want)
+	}
+}
+
+func TestTypes(t *testing.T) {
+	bools := map[string]bool{"yes": true, "On": true, "1": true, "-2": true, "": false, "off": false, "0": false}
+	for value, want := range bools {
+		if got, err := ParseBool(value); err != nil || got != want {
+			t.Errorf("ParseBool(%q) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+	if _, err := ParseBool("maybe"); err == nil {
+		t.Errorf("ParseBool(maybe) did not fail")
+	}
+	ints := map[string]int64{"12": 12, "1k": 1024, "-2M": -2 << 20, "3g": 3 << 30}
+	for value, want := range ints {
+		if got, err := ParseInt(value); err != nil || got != want {
+			t.Errorf("ParseInt(%q) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+	for _, invalid := range []string{"", "yes", "1x", "9999999999g"} {
+		if _, err := ParseInt(invalid); err == nil {
+			t.Errorf("ParseInt(%q) did not fail", invalid)
+		}
+	}
+
+	t.Setenv("HOME", "/home/me")
+	cfg, _ := Parse([]byte("[core]\n\tbare\n\tsize = 2k\n\texcludes = ~/ignore\n\tbad = nope\n"))
+	if bare, err := cfg.GetBool("core.bare", false); err != nil || !bare {
+		t.Errorf("GetBool(core.bare) = %v, %v", bare, err)
+	}
+	if missing, err := cfg.GetBool("core.missing", true); err != nil || !missing {
+		t.Errorf("GetBool(core.missing) = %v, %v", missing, err)
+	}
+	if _, err := cfg.GetBool("core.bad", false); err == nil {
+		t.Errorf("GetBool(core.bad) did not fail")
+	}
+	if size, err := cfg.GetInt("core.size", 0); err != nil || size != 2048 {
+		t.Errorf("GetInt(core.size) = %v, %v", size, err)
+	}
+	if path, ok, err := cfg.GetPath("core.excludes"); err != nil || !ok || path != "/home/me/ignore" {
+		t.Errorf("GetPath(core.excludes) = %q, %v, %v", path, ok, err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	gitDir := filepath.Join(home, "work", "repo", ".git")
+	files := map[string]string{
+		".config/git/config": "[user]\n\tname = xdg\n\temail = x@example.com\n",
+		".gitconfig": "[user]\n\tname = home\n[include]\n\tpath = inc\n" +
+			"[includeIf \"gitdir:~/work/\"]\n\tpath = work.inc\n[includeIf \"gitdir:~/other/\"]\n\tpath = other\n" +
+			"[includeIf \"onbranch:feature/\"]\n\tpath = feature\n",
+		"inc":                   "[a]\n\tinc = 1\n",
+		"work.inc":              "[a]\n\twork = 1\n",
+		"other":                 "[a]\n\tother = 1\n",
+		"feature":               "[a]\n\tfeature = 1\n",
+		"loop":                  "[include]\n\tpath = loop\n",
+		"work/repo/.git/HEAD":   "ref: refs/heads/feature/x\n",
+		"work/repo/.git/config": "[user]\n\tname = repo\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(home, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg, err := Load(gitDir)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if names := cfg.GetAll("user.name"); !slices.Equal(names, []string{"xdg", "home", "repo"}) {
+		t.Errorf("GetAll(user.name) = %q", names)
+	}
+	if email, _ := cfg.Get("user.email"); email != "x@example.com" {
+		t.Errorf("Get(user.email) = %q", email)
+	}
+	for name, want := range map[string]bool{"a.inc": true, "a.work": true, "a.other": false, "a.feature": true} {
+		if _, ok := cfg.Get(name); ok != want {
+			t.Errorf("Get(%q) found = %v, want %v", name, ok, want)
+		}
+	}
+
+	global, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() without a repository error = %v", err)
+	}
+	if name, _ := global.Get("user.name"); name != "home" {
+		t.Errorf("Get(user.name) without a repository = %q", name)
+	}
+
+	if err := Append(filepath.Join(home, ".gitconfig"), Entry{Section: "include", Key: "path", Value: "loop"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(gitDir); err == nil {
+		t.Errorf("Load() of an include cycle did not fail")
+	}
+}
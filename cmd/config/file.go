@@ -0,0 +1,240 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+var (
+	// ErrNotSet is returned when unsetting a variable which is not in the file
+	ErrNotSet = errors.New("the variable is not set")
+	// ErrMultipleValues is returned when setting or unsetting a single value of a
+	// variable which has more than one
+	ErrMultipleValues = errors.New("the variable has multiple values")
+)
+
+// File is a configuration file being edited
+//
+// The changes only touch the lines of the variables they are about, the comments, the
+// blank lines and the indentation of the rest of the file are kept as they are. The
+// file is only written by Save.
+type File struct {
+	path     string
+	content  string
+	config   *Config
+	sections []sectionSpan
+}
+
+// Open reads the configuration file at path for editing, a missing file is the same as
+// an empty one and is created by Save
+func Open(path string) (*File, error) {
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	f := &File{path: path}
+	if err := f.update(string(content)); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// Config returns the variables of the file as they are after the changes made so far
+func (f *File) Config() *Config {
+	return f.config
+}
+
+// Set sets the variable to the value, replacing its current value or adding it to the
+// last section it belongs to
+func (f *File) Set(name, value string) error {
+	matches, err := f.find(name)
+	if err != nil {
+		return err
+	}
+	switch len(matches) {
+	case 0:
+		return f.Add(name, value)
+	case 1:
+		// the rest of the line, e.g. a comment, is kept after the new value
+		entry := matches[0]
+		key := name[strings.LastIndex(name, ".")+1:]
+		line := strings.TrimSuffix(f.variableLine(entry.start, key, value), "\n")
+		return f.replace(entry.start, entry.end, line)
+	default:
+		return fmt.Errorf("%s: %w", name, ErrMultipleValues)
+	}
+}
+
+// Add adds a value to the variable, keeping the values it already has
+func (f *File) Add(name, value string) error {
+	section, subsection, _, err := checkName(name)
+	if err != nil {
+		return err
+	}
+	key := name[strings.LastIndex(name, ".")+1:]
+	for i := len(f.sections) - 1; i >= 0; i-- {
+		span := f.sections[i]
+		if span.section == section && span.subsection == subsection {
+			return f.replace(span.end, span.end, f.variableLine(span.end, key, value))
+		}
+	}
+	// a new section is written with the section name as given, like git does
+	header := "[" + name[:strings.Index(name, ".")] + "]\n"
+	if subsection != "" {
+		header = "[" + name[:strings.Index(name, ".")] + " " + quote(subsection) + "]\n"
+	}
+	end := len(f.content)
+	if end > 0 && f.content[end-1] != '\n' {
+		header = "\n" + header
+	}
+	return f.replace(end, end, header+"\t"+key+" = "+formatValue(value)+"\n")
+}
+
+// Unset removes the variable, which must have a single value
+func (f *File) Unset(name string) error {
+	matches, err := f.find(name)
+	if err != nil {
+		return err
+	}
+	switch len(matches) {
+	case 0:
+		return fmt.Errorf("%s: %w", name, ErrNotSet)
+	case 1:
+		return f.remove(matches[0])
+	default:
+		return fmt.Errorf("%s: %w", name, ErrMultipleValues)
+	}
+}
+
+// UnsetAll removes all the values of the variable
+func (f *File) UnsetAll(name string) error {
+	matches, err := f.find(name)
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("%s: %w", name, ErrNotSet)
+	}
+	// from the last one, so that the offsets of the others stay valid
+	for i := len(matches) - 1; i >= 0; i-- {
+		if err := f.remove(matches[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Save writes the file through a lock file, so that a reader never sees it half written
+func (f *File) Save() error {
+	lockPath := f.path + ".lock"
+	lock, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	if _, err := lock.WriteString(f.content); err != nil {
+		lock.Close()
+		os.Remove(lockPath)
+		return fmt.Errorf("write config: %w", err)
+	}
+	if err := lock.Close(); err != nil {
+		os.Remove(lockPath)
+		return fmt.Errorf("write config: %w", err)
+	}
+	if err := os.Rename(lockPath, f.path); err != nil {
+		os.Remove(lockPath)
+		return fmt.Errorf("write config: %w", err)
+	}
+	return nil
+}
+
+// find returns the entries of the variable in the file
+func (f *File) find(name string) ([]Entry, error) {
+	section, subsection, key, err := checkName(name)
+	if err != nil {
+		return nil, err
+	}
+	var matches []Entry
+	for _, entry := range f.config.Entries {
+		if entry.Section == section && entry.Subsection == subsection && entry.Key == key {
+			matches = append(matches, entry)
+		}
+	}
+	return matches, nil
+}
+
+// variableLine formats the variable to be written at pos, it starts on a new line when
+// pos is not at the start of one, e.g. after a section header with a variable on its line
+func (f *File) variableLine(pos int, key, value string) string {
+	line := "\t" + key + " = " + formatValue(value) + "\n"
+	if pos > 0 && f.content[pos-1] != '\n' {
+		line = "\n" + line
+	}
+	return line
+}
+
+// remove removes the variable line, a variable following a section header on the same
+// line leaves the header on its own line, and a comment after the value is left on its
+// own line as well
+func (f *File) remove(entry Entry) error {
+	rest, _, found := strings.Cut(f.content[entry.end:], "\n")
+	if comment := strings.TrimLeft(rest, " \t\r"); comment != "" {
+		indent := f.content[entry.start:]
+		indent = indent[:len(indent)-len(strings.TrimLeft(indent, " \t"))]
+		return f.replace(entry.start, entry.end+len(rest)-len(comment), indent)
+	}
+	end := entry.end + len(rest)
+	if found {
+		end++
+	}
+	replacement := ""
+	if entry.start > 0 && f.content[entry.start-1] != '\n' {
+		replacement = "\n"
+	}
+	return f.replace(entry.start, end, replacement)
+}
+
+// replace replaces the content between start and end and parses the file again, so that
+// the offsets of the variables are up to date
+func (f *File) replace(start, end int, text string) error {
+	return f.update(f.content[:start] + text + f.content[end:])
+}
+
+func (f *File) update(content string) error {
+	cfg, sections, err := parse(content)
+	if err != nil {
+		return err
+	}
+	f.content, f.config, f.sections = content, cfg, sections
+	return nil
+}
+
+// checkName splits the variable name like SplitName, and fails when the name cannot be
+// written to a file
+func checkName(name string) (section, subsection, key string, err error) {
+	if !strings.Contains(name, ".") {
+		return "", "", "", fmt.Errorf("key does not contain a section: %s", name)
+	}
+	section, subsection, key, ok := SplitName(name)
+	if !ok || !validKey(key) || strings.Trim(section, "-.0123456789abcdefghijklmnopqrstuvwxyz") != "" ||
+		strings.Contains(subsection, "\n") {
+		return "", "", "", fmt.Errorf("invalid key: %s", name)
+	}
+	return section, subsection, key, nil
+}
+
+// validKey reports whether the key starts with a letter and only has letters, digits
+// and dashes
+func validKey(key string) bool {
+	if key == "" || key[0] < 'a' || key[0] > 'z' {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if !isKeyChar(key[i]) {
+			return false
+		}
+	}
+	return true
+}
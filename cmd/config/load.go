@@ -0,0 +1,191 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// maxIncludeDepth is the number of nested includes after which Load gives up, an include
+// cycle would go on forever otherwise
+const maxIncludeDepth = 10
+
+// GlobalPaths returns the global configuration files of the user, in the order they are
+// read: `$XDG_CONFIG_HOME/git/config` (or `~/.config/git/config`) then `~/.gitconfig`
+func GlobalPaths() []string {
+	home, _ := os.UserHomeDir()
+	xdg := os.Getenv("XDG_CONFIG_HOME")
+	if xdg == "" && home != "" {
+		xdg = filepath.Join(home, ".config")
+	}
+	var paths []string
+	if xdg != "" {
+		paths = append(paths, filepath.Join(xdg, "git", "config"))
+	}
+	if home != "" {
+		paths = append(paths, filepath.Join(home, ".gitconfig"))
+	}
+	return paths
+}
+
+// GlobalPath returns the global configuration file written by `config --global`, it is
+// `~/.gitconfig` unless only the XDG file exists
+func GlobalPath() (string, error) {
+	paths := GlobalPaths()
+	if len(paths) == 0 {
+		return "", fmt.Errorf("$HOME not set")
+	}
+	home := paths[len(paths)-1]
+	if _, err := os.Stat(home); err != nil && len(paths) > 1 {
+		if _, err := os.Stat(paths[0]); err == nil {
+			return paths[0], nil
+		}
+	}
+	return home, nil
+}
+
+// Load reads the configuration of the repository at gitDir, from the global files to the
+// repository one so that the repository wins. The files included with `include.path` or
+// a matching `includeIf.<condition>.path` are read where the include is. An empty gitDir
+// only reads the global files.
+func Load(gitDir string) (*Config, error) {
+	l := loader{cfg: &Config{}}
+	if gitDir != "" {
+		absolute, err := filepath.Abs(gitDir)
+		if err != nil {
+			return nil, fmt.Errorf("load config: %w", err)
+		}
+		l.gitDir = absolute
+	}
+	paths := GlobalPaths()
+	if gitDir != "" {
+		paths = append(paths, filepath.Join(gitDir, "config"))
+	}
+	for _, path := range paths {
+		if err := l.read(path, 0); err != nil {
+			return nil, err
+		}
+	}
+	return l.cfg, nil
+}
+
+// loader reads the configuration files and the files they include into a single Config
+type loader struct {
+	cfg    *Config
+	gitDir string
+}
+
+func (l *loader) read(path string, depth int) error {
+	file, err := Read(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range file.Entries {
+		l.cfg.Entries = append(l.cfg.Entries, entry)
+		if entry.Key != "path" || entry.Value == "" {
+			continue
+		}
+		included := entry.Section == "include" && entry.Subsection == ""
+		if entry.Section == "includeif" && entry.Subsection != "" {
+			if included, err = l.matches(entry.Subsection, path); err != nil {
+				return err
+			}
+		}
+		if !included {
+			continue
+		}
+		if depth >= maxIncludeDepth {
+			return fmt.Errorf("exceeded maximum include depth (%d) while including %s from %s",
+				maxIncludeDepth, entry.Value, path)
+		}
+		includePath, err := ExpandPath(entry.Value)
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(includePath) {
+			includePath = filepath.Join(filepath.Dir(path), includePath)
+		}
+		if err := l.read(includePath, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches evaluates the condition of an `includeIf` section found in the file at path
+//
+//	gitdir:<pattern>    the git directory matches the pattern
+//	gitdir/i:<pattern>  the same, ignoring the case
+//	onbranch:<pattern>  the checked out branch matches the pattern
+//
+// An unknown condition never matches, like in git.
+func (l *loader) matches(condition, path string) (bool, error) {
+	kind, pattern, _ := strings.Cut(condition, ":")
+	switch kind {
+	case "gitdir", "gitdir/i":
+		if l.gitDir == "" {
+			return false, nil
+		}
+		expanded, err := ExpandPath(pattern)
+		if err != nil {
+			return false, err
+		}
+		if strings.HasPrefix(expanded, "./") {
+			expanded = filepath.Join(filepath.Dir(path), expanded[2:])
+		} else if !filepath.IsAbs(expanded) {
+			expanded = "**/" + expanded
+		}
+		if strings.HasSuffix(expanded, "/") {
+			expanded += "**"
+		}
+		return matchGlob(expanded, filepath.ToSlash(l.gitDir), kind == "gitdir/i"), nil
+	case "onbranch":
+		if l.gitDir == "" {
+			return false, nil
+		}
+		head, err := os.ReadFile(filepath.Join(l.gitDir, "HEAD"))
+		if err != nil {
+			return false, nil
+		}
+		branch, ok := strings.CutPrefix(strings.TrimSpace(string(head)), "ref: refs/heads/")
+		if !ok {
+			return false, nil
+		}
+		if strings.HasSuffix(pattern, "/") {
+			pattern += "**"
+		}
+		return matchGlob(pattern, branch, false), nil
+	}
+	return false, nil
+}
+
+// matchGlob matches the name against a wildcard pattern where `*` and `?` do not match
+// a slash while `**` matches any number of directories
+func matchGlob(pattern, name string, ignoreCase bool) bool {
+	var expr strings.Builder
+	if ignoreCase {
+		expr.WriteString("(?i)")
+	}
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch rest := pattern[i:]; {
+		case strings.HasPrefix(rest, "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(rest, "**"):
+			expr.WriteString(".*")
+			i++
+		case rest[0] == '*':
+			expr.WriteString("[^/]*")
+		case rest[0] == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(rest[:1]))
+		}
+	}
+	expr.WriteString("$")
+	matched, err := regexp.MatchString(expr.String(), name)
+	return err == nil && matched
+}
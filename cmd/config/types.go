@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ParseBool parses a boolean value: true, yes, on and 1 are true while false, no, off,
+// 0 and the empty string are false, whatever their case. Like git any other number is
+// true when it is not zero.
+func ParseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off", "":
+		return false, nil
+	}
+	n, err := ParseInt(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid boolean")
+	}
+	return n != 0, nil
+}
+
+// ParseInt parses an integer value, which can end with the k, m or g unit to be
+// multiplied by 1024, 1024² or 1024³
+func ParseInt(value string) (int64, error) {
+	multiplier := int64(1)
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'k', 'K':
+			multiplier = 1 << 10
+		case 'm', 'M':
+			multiplier = 1 << 20
+		case 'g', 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier != 1 {
+			value = value[:len(value)-1]
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+			return 0, fmt.Errorf("out of range")
+		}
+		return 0, fmt.Errorf("invalid unit")
+	}
+	if n > 0 && n > math.MaxInt64/multiplier || n < 0 && n < math.MinInt64/multiplier {
+		return 0, fmt.Errorf("out of range")
+	}
+	return n * multiplier, nil
+}
+
+// ExpandPath expands a leading `~/` to the home directory of the user, other paths are
+// returned as they are
+func ExpandPath(value string) (string, error) {
+	if value != "~" && !strings.HasPrefix(value, "~/") {
+		return value, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to expand user dir in: '%s'", value)
+	}
+	return home + value[1:], nil
+}
+
+// GetBool returns the boolean value of the variable, or fallback when it is not set
+func (c *Config) GetBool(name string, fallback bool) (bool, error) {
+	value, ok := c.Get(name)
+	if !ok {
+		return fallback, nil
+	}
+	b, err := ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("bad boolean config value '%s' for '%s'", value, name)
+	}
+	return b, nil
+}
+
+// GetInt returns the integer value of the variable, or fallback when it is not set
+func (c *Config) GetInt(name string, fallback int64) (int64, error) {
+	value, ok := c.Get(name)
+	if !ok {
+		return fallback, nil
+	}
+	n, err := ParseInt(value)
+	if err != nil {
+		return 0, fmt.Errorf("bad numeric config value '%s' for '%s': %w", value, name, err)
+	}
+	return n, nil
+}
+
+// GetPath returns the value of the variable with `~/` expanded, false when it is not set
+func (c *Config) GetPath(name string) (string, bool, error) {
+	value, ok := c.Get(name)
+	if !ok {
+		return "", false, nil
+	}
+	path, err := ExpandPath(value)
+	return path, true, err
+}
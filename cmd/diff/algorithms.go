@@ -0,0 +1,249 @@
+package diff
+
+import "sort"
+
+// maxChainLength is the number of times a line can appear in the old range and still be
+// used by the histogram algorithm, more frequent lines are left to Myers
+const maxChainLength = 64
+
+// myers marks the changed lines of the ranges with the linear space variant of the
+// algorithm of "An O(ND) Difference Algorithm and Its Variations" by Eugene W. Myers: the
+// middle of a shortest edit script is found by searching from both ends at once, then
+// each half is solved the same way.
+func (d *differ) myers(a0, a1, b0, b1 int) {
+	a0, a1, b0, b1 = d.trim(a0, a1, b0, b1)
+	if a0 == a1 || b0 == b1 {
+		d.markAll(a0, a1, b0, b1)
+		return
+	}
+	x, y, ok := d.middleSnake(a0, a1, b0, b1)
+	if !ok {
+		d.markAll(a0, a1, b0, b1)
+		return
+	}
+	d.myers(a0, x, b0, y)
+	d.myers(x, a1, y, b1)
+}
+
+// middleSnake returns a point of a shortest edit script between the ranges where the
+// forward and the backward searches meet, false when the ranges have nothing in common
+//
+// forward and backward hold the furthest x reached on each diagonal k = x - y, from the
+// start of the ranges and from their end.
+func (d *differ) middleSnake(a0, a1, b0, b1 int) (int, int, bool) {
+	a, b := d.old.ids[a0:a1], d.new.ids[b0:b1]
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	offset, length := maxD, 2*maxD+2
+	forward, backward := make([]int, length), make([]int, length)
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+	delta := n - m
+	// the searches meet during the forward step when the difference of the lengths is odd
+	odd := delta%2 != 0
+	// the diagonals which went past the end of one of the ranges are not searched again
+	kStartF, kEndF, kStartB, kEndB := 0, 0, 0, 0
+	for step := 0; step < maxD; step++ {
+		for k := -step + kStartF; k <= step-kEndF; k += 2 {
+			i := offset + k
+			var x int
+			if k == -step || k != step && forward[i-1] < forward[i+1] {
+				x = forward[i+1]
+			} else {
+				x = forward[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[i] = x
+			switch {
+			case x > n:
+				kEndF += 2
+			case y > m:
+				kStartF += 2
+			case odd:
+				j := offset + delta - k
+				if j >= 0 && j < length && backward[j] != -1 && x >= n-backward[j] {
+					return a0 + x, b0 + y, true
+				}
+			}
+		}
+		for k := -step + kStartB; k <= step-kEndB; k += 2 {
+			i := offset + k
+			var x int
+			if k == -step || k != step && backward[i-1] < backward[i+1] {
+				x = backward[i+1]
+			} else {
+				x = backward[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+			backward[i] = x
+			switch {
+			case x > n:
+				kEndB += 2
+			case y > m:
+				kStartB += 2
+			case !odd:
+				j := offset + delta - k
+				if j >= 0 && j < length && forward[j] != -1 {
+					fx := forward[j]
+					fy := fx - (j - offset)
+					if fx >= n-x {
+						return a0 + fx, b0 + fy, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// patience matches the lines which appear exactly once in both ranges, keeping the
+// longest sequence of them which is in the same order on both sides. The ranges between
+// these anchors, grown by the equal lines around them, are solved the same way, and the
+// ranges without unique lines are left to Myers.
+func (d *differ) patience(a0, a1, b0, b1 int) {
+	if a0 == a1 || b0 == b1 {
+		d.markAll(a0, a1, b0, b1)
+		return
+	}
+	type occurrence struct{ countA, countB, posA, posB int }
+	occurrences := map[int]*occurrence{}
+	var order []*occurrence
+	for i := a0; i < a1; i++ {
+		o := occurrences[d.old.ids[i]]
+		if o == nil {
+			o = &occurrence{}
+			occurrences[d.old.ids[i]] = o
+			order = append(order, o)
+		}
+		o.countA++
+		o.posA = i
+	}
+	for j := b0; j < b1; j++ {
+		if o := occurrences[d.new.ids[j]]; o != nil {
+			o.countB++
+			o.posB = j
+		}
+	}
+	var anchors []anchor
+	for _, o := range order {
+		if o.countA == 1 && o.countB == 1 {
+			anchors = append(anchors, anchor{a: o.posA, b: o.posB})
+		}
+	}
+	anchors = longestIncreasing(anchors)
+	if len(anchors) == 0 {
+		d.myers(a0, a1, b0, b1)
+		return
+	}
+	for k := 0; k <= len(anchors); k++ {
+		nextA, nextB := a1, b1
+		if k < len(anchors) {
+			nextA, nextB = anchors[k].a, anchors[k].b
+			for nextA > a0 && nextB > b0 && d.old.ids[nextA-1] == d.new.ids[nextB-1] {
+				nextA--
+				nextB--
+			}
+		}
+		for a0 < nextA && b0 < nextB && d.old.ids[a0] == d.new.ids[b0] {
+			a0++
+			b0++
+		}
+		if a0 < nextA || b0 < nextB {
+			d.patience(a0, nextA, b0, nextB)
+		}
+		if k < len(anchors) {
+			a0, b0 = anchors[k].a+1, anchors[k].b+1
+		}
+	}
+}
+
+// anchor is a line of the old file matched with a line of the new one
+type anchor struct{ a, b int }
+
+// longestIncreasing returns the longest subsequence of the anchors, which are in the
+// order of the old file, that is also in the order of the new file, with patience sorting
+func longestIncreasing(anchors []anchor) []anchor {
+	if len(anchors) == 0 {
+		return nil
+	}
+	// tops[i] is the anchor ending the best subsequence of length i+1 found so far
+	var tops []int
+	previous := make([]int, len(anchors))
+	for i, current := range anchors {
+		pile := sort.Search(len(tops), func(p int) bool { return anchors[tops[p]].b > current.b })
+		previous[i] = -1
+		if pile > 0 {
+			previous[i] = tops[pile-1]
+		}
+		if pile == len(tops) {
+			tops = append(tops, i)
+		} else {
+			tops[pile] = i
+		}
+	}
+	result := make([]anchor, len(tops))
+	for i, k := len(tops)-1, tops[len(tops)-1]; i >= 0; i, k = i-1, previous[k] {
+		result[i] = anchors[k]
+	}
+	return result
+}
+
+// histogram finds the longest run of lines common to both ranges which holds the least
+// frequent line of the old range, then solves the ranges before and after it the same
+// way. The ranges whose lines are all too frequent are left to Myers.
+func (d *differ) histogram(a0, a1, b0, b1 int) {
+	a0, a1, b0, b1 = d.trim(a0, a1, b0, b1)
+	if a0 == a1 || b0 == b1 {
+		d.markAll(a0, a1, b0, b1)
+		return
+	}
+	positions := map[int][]int{}
+	for i := a0; i < a1; i++ {
+		positions[d.old.ids[i]] = append(positions[d.old.ids[i]], i)
+	}
+	count := func(i int) int { return len(positions[d.old.ids[i]]) }
+
+	bestLength, bestCount := 0, maxChainLength+1
+	var bestA, bestB int
+	for j := b0; j < b1; {
+		next := j + 1
+		occurrences := positions[d.new.ids[j]]
+		if len(occurrences) == 0 || len(occurrences) > maxChainLength || len(occurrences) > bestCount {
+			j = next
+			continue
+		}
+		for _, i := range occurrences {
+			start, end, lowest := 0, 1, len(occurrences)
+			for i-start > a0 && j-start > b0 && d.old.ids[i-start-1] == d.new.ids[j-start-1] {
+				start++
+				lowest = min(lowest, count(i-start))
+			}
+			for i+end < a1 && j+end < b1 && d.old.ids[i+end] == d.new.ids[j+end] {
+				lowest = min(lowest, count(i+end))
+				end++
+			}
+			next = max(next, j+end)
+			if length := start + end; length > bestLength || lowest < bestCount {
+				bestLength, bestCount = length, lowest
+				bestA, bestB = i-start, j-start
+			}
+		}
+		j = next
+	}
+	if bestLength == 0 {
+		d.myers(a0, a1, b0, b1)
+		return
+	}
+	d.histogram(a0, bestA, b0, bestB)
+	d.histogram(bestA+bestLength, a1, bestB+bestLength, b1)
+}
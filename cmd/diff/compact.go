@@ -0,0 +1,277 @@
+package diff
+
+// The changes are slid the way git's xdiff does: a group of changed lines which is
+// surrounded by lines equal to its own first or last line can be shifted up or down
+// without changing the meaning of the diff. Each group is shifted as far down as
+// possible, merging with the groups it runs into, then back up to line up with a change
+// of the other file if it can, or else to the position the indent heuristic scores best.
+
+const (
+	// maxIndent and maxBlanks bound the measures of the indent heuristic
+	maxIndent = 200
+	maxBlanks = 20
+	// maxSliding is the number of positions the indent heuristic scores
+	maxSliding = 100
+
+	startOfFilePenalty              = 1
+	endOfFilePenalty                = 21
+	totalBlankWeight                = -30
+	postBlankWeight                 = 6
+	relativeIndentPenalty           = -4
+	relativeIndentWithBlankPenalty  = 10
+	relativeOutdentPenalty          = 24
+	relativeOutdentWithBlankPenalty = 17
+	relativeDedentPenalty           = 23
+	relativeDedentWithBlankPenalty  = 17
+	indentWeight                    = 60
+)
+
+// group is a run of changed lines [start, end) of a file, it is empty between two
+// unchanged lines
+type group struct {
+	start, end int
+}
+
+func firstGroup(s *side) group {
+	g := group{}
+	for s.isChanged(g.end) {
+		g.end++
+	}
+	return g
+}
+
+// next moves to the following group, false at the end of the file
+func (g *group) next(s *side) bool {
+	if g.end == len(s.changed) {
+		return false
+	}
+	g.start = g.end + 1
+	for g.end = g.start; s.isChanged(g.end); g.end++ {
+	}
+	return true
+}
+
+// previous moves to the preceding group, false at the start of the file
+func (g *group) previous(s *side) bool {
+	if g.start == 0 {
+		return false
+	}
+	g.end = g.start - 1
+	for g.start = g.end; s.isChanged(g.start - 1); g.start-- {
+	}
+	return true
+}
+
+// slideDown shifts the group down by a line when the line after it is equal to its first
+// line, merging with the group that follows if they touch
+func (g *group) slideDown(s *side) bool {
+	if g.end >= len(s.changed) || s.ids[g.start] != s.ids[g.end] {
+		return false
+	}
+	s.changed[g.start], s.changed[g.end] = false, true
+	g.start++
+	g.end++
+	for s.isChanged(g.end) {
+		g.end++
+	}
+	return true
+}
+
+// slideUp shifts the group up by a line when the line before it is equal to its last
+// line, merging with the group that precedes if they touch
+func (g *group) slideUp(s *side) bool {
+	if g.start == 0 || s.ids[g.start-1] != s.ids[g.end-1] {
+		return false
+	}
+	g.start--
+	g.end--
+	s.changed[g.start], s.changed[g.end] = true, false
+	for s.isChanged(g.start - 1) {
+		g.start--
+	}
+	return true
+}
+
+// compact slides the groups of changed lines of s, other is the other file whose groups
+// are walked along so that the unchanged lines of both stay in step
+func compact(s, other *side) {
+	g, o := firstGroup(s), firstGroup(other)
+	for {
+		if g.end != g.start {
+			var earliestEnd int
+			endMatchingOther := -1
+			for {
+				size := g.end - g.start
+				endMatchingOther = -1
+				for g.slideUp(s) {
+					o.previous(other)
+				}
+				earliestEnd = g.end
+				if o.end > o.start {
+					endMatchingOther = g.end
+				}
+				for g.slideDown(s) {
+					o.next(other)
+					if o.end > o.start {
+						endMatchingOther = g.end
+					}
+				}
+				if size == g.end-g.start {
+					break
+				}
+			}
+
+			switch {
+			case g.end == earliestEnd:
+				// the group cannot be shifted
+			case endMatchingOther != -1:
+				for o.end == o.start {
+					g.slideUp(s)
+					o.previous(other)
+				}
+			default:
+				bestShift := bestIndentShift(s, g, earliestEnd)
+				for g.end > bestShift {
+					g.slideUp(s)
+					o.previous(other)
+				}
+			}
+		}
+		if !g.next(s) {
+			return
+		}
+		o.next(other)
+	}
+}
+
+// bestIndentShift returns the end the group should be shifted up to, according to the
+// indent heuristic: the split before the group and the one after it are scored by the
+// indentation and the blank lines around them, the lowest score wins
+func bestIndentShift(s *side, g group, earliestEnd int) int {
+	size := g.end - g.start
+	shift := max(earliestEnd, g.end-size-1, g.end-maxSliding)
+	bestShift := -1
+	var best splitScore
+	for ; shift <= g.end; shift++ {
+		score := splitScore{}
+		score.add(measureSplit(s, shift))
+		score.add(measureSplit(s, shift-size))
+		if bestShift == -1 || score.compare(best) <= 0 {
+			best, bestShift = score, shift
+		}
+	}
+	return bestShift
+}
+
+// splitMeasurement describes the lines around the split before a line
+type splitMeasurement struct {
+	endOfFile bool
+	// indent is the one of the line after the split, -1 for a blank line
+	indent int
+	// preBlank are the blank lines just before the split and preIndent the indent of
+	// the first line which is not blank before them, -1 at the start of the file
+	preBlank, preIndent int
+	// postBlank are the blank lines after the line after the split and postIndent the
+	// indent of the first line which is not blank after them, -1 at the end of the file
+	postBlank, postIndent int
+}
+
+func measureSplit(s *side, split int) splitMeasurement {
+	m := splitMeasurement{indent: -1, preIndent: -1, postIndent: -1}
+	if split >= len(s.lines) {
+		m.endOfFile = true
+	} else {
+		m.indent = lineIndent(s.lines[split])
+	}
+	for i := split - 1; i >= 0; i-- {
+		if m.preIndent = lineIndent(s.lines[i]); m.preIndent != -1 {
+			break
+		}
+		if m.preBlank++; m.preBlank == maxBlanks {
+			m.preIndent = 0
+			break
+		}
+	}
+	for i := split + 1; i < len(s.lines); i++ {
+		if m.postIndent = lineIndent(s.lines[i]); m.postIndent != -1 {
+			break
+		}
+		if m.postBlank++; m.postBlank == maxBlanks {
+			m.postIndent = 0
+			break
+		}
+	}
+	return m
+}
+
+// lineIndent returns the width of the leading white space of the line, with tabs of 8
+// columns, or -1 when the line is blank
+func lineIndent(line string) int {
+	indent := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			indent++
+		case '\t':
+			indent += 8 - indent%8
+		case '\n', '\r', '\v', '\f':
+		default:
+			return indent
+		}
+		if indent >= maxIndent {
+			return maxIndent
+		}
+	}
+	return -1
+}
+
+type splitScore struct {
+	effectiveIndent int
+	penalty         int
+}
+
+func (s *splitScore) add(m splitMeasurement) {
+	if m.preIndent == -1 && m.preBlank == 0 {
+		s.penalty += startOfFilePenalty
+	}
+	if m.endOfFile {
+		s.penalty += endOfFilePenalty
+	}
+	postBlank := 0
+	if m.indent == -1 {
+		postBlank = 1 + m.postBlank
+	}
+	totalBlank := m.preBlank + postBlank
+	s.penalty += totalBlankWeight*totalBlank + postBlankWeight*postBlank
+	indent := m.indent
+	if indent == -1 {
+		indent = m.postIndent
+	}
+	anyBlanks := totalBlank != 0
+	s.effectiveIndent += indent
+	pick := func(withBlank, without int) int {
+		if anyBlanks {
+			return withBlank
+		}
+		return without
+	}
+	switch {
+	case indent == -1 || m.preIndent == -1 || indent == m.preIndent:
+	case indent > m.preIndent:
+		s.penalty += pick(relativeIndentWithBlankPenalty, relativeIndentPenalty)
+	case m.postIndent != -1 && m.postIndent > indent:
+		s.penalty += pick(relativeOutdentWithBlankPenalty, relativeOutdentPenalty)
+	default:
+		s.penalty += pick(relativeDedentWithBlankPenalty, relativeDedentPenalty)
+	}
+}
+
+func (s splitScore) compare(other splitScore) int {
+	cmpIndents := 0
+	if s.effectiveIndent > other.effectiveIndent {
+		cmpIndents = 1
+	} else if s.effectiveIndent < other.effectiveIndent {
+		cmpIndents = -1
+	}
+	return indentWeight*cmpIndents + s.penalty - other.penalty
+}
@@ -0,0 +1,188 @@
+// Package diff compares the lines of two files and writes the differences in the
+// unified format of `git diff`
+//
+// The lines are matched by one of three algorithms:
+//
+//   - Myers finds a shortest edit script, it is the default of git
+//   - Patience only matches the lines which are unique on both sides first, which
+//     keeps moved blocks of code together
+//   - Histogram extends patience to lines which are not unique, matching the least
+//     frequent ones first
+//
+// Like git, the changes are then slid to where they read best, e.g. a function added
+// between two others is shown with its own blank line rather than the one of its
+// neighbour.
+//
+// The package also finds the files of a tree comparison which were renamed or copied,
+// by the similarity of their content.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Algorithm is the way the lines of the two files are matched
+type Algorithm int
+
+const (
+	Myers Algorithm = iota
+	Patience
+	Histogram
+)
+
+// ParseAlgorithm parses the name of an algorithm as given to `--diff-algorithm`
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch strings.ToLower(name) {
+	case "myers", "default", "minimal":
+		return Myers, nil
+	case "patience":
+		return Patience, nil
+	case "histogram":
+		return Histogram, nil
+	}
+	return Myers, fmt.Errorf("unknown diff algorithm '%s'", name)
+}
+
+// Op is what an edit does to its lines
+type Op byte
+
+const (
+	Equal  Op = ' '
+	Delete Op = '-'
+	Insert Op = '+'
+)
+
+// Edit is a run of lines kept, deleted or inserted, the lines are [OldStart, OldEnd) of
+// the old file and [NewStart, NewEnd) of the new one. One of the ranges is empty for a
+// deletion or an insertion.
+type Edit struct {
+	Op               Op
+	OldStart, OldEnd int
+	NewStart, NewEnd int
+}
+
+// Lines splits the content into lines which keep their "\n", only the last line can be
+// missing it
+func Lines(content []byte) []string {
+	var lines []string
+	text := string(content)
+	for text != "" {
+		end := strings.IndexByte(text, '\n') + 1
+		if end == 0 {
+			end = len(text)
+		}
+		lines = append(lines, text[:end])
+		text = text[end:]
+	}
+	return lines
+}
+
+// Diff returns the edits turning the old lines into the new ones, in order, covering
+// every line of both files
+func Diff(old, new []string, algorithm Algorithm) []Edit {
+	d := newDiffer(old, new)
+	switch algorithm {
+	case Patience:
+		d.patience(0, len(old), 0, len(new))
+	case Histogram:
+		d.histogram(0, len(old), 0, len(new))
+	default:
+		d.myers(0, len(old), 0, len(new))
+	}
+	compact(d.old, d.new)
+	compact(d.new, d.old)
+	return d.edits()
+}
+
+// side is one of the files being compared
+type side struct {
+	lines []string
+	// ids are the lines as numbers, two lines are equal when they have the same id
+	ids []int
+	// changed marks the lines deleted from the old file or inserted in the new one
+	changed []bool
+}
+
+// isChanged is changed[i], the lines before the start and after the end are unchanged
+func (s *side) isChanged(i int) bool {
+	return i >= 0 && i < len(s.changed) && s.changed[i]
+}
+
+// differ holds the two files while an algorithm marks their changed lines
+type differ struct {
+	old, new *side
+}
+
+func newDiffer(old, new []string) *differ {
+	ids := map[string]int{}
+	intern := func(lines []string) *side {
+		s := &side{lines: lines, ids: make([]int, len(lines)), changed: make([]bool, len(lines))}
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			s.ids[i] = id
+		}
+		return s
+	}
+	return &differ{old: intern(old), new: intern(new)}
+}
+
+// trim narrows the ranges to leave out their common first and last lines, which are
+// unchanged
+func (d *differ) trim(a0, a1, b0, b1 int) (int, int, int, int) {
+	for a0 < a1 && b0 < b1 && d.old.ids[a0] == d.new.ids[b0] {
+		a0++
+		b0++
+	}
+	for a0 < a1 && b0 < b1 && d.old.ids[a1-1] == d.new.ids[b1-1] {
+		a1--
+		b1--
+	}
+	return a0, a1, b0, b1
+}
+
+// markAll marks the lines of both ranges as changed
+func (d *differ) markAll(a0, a1, b0, b1 int) {
+	for i := a0; i < a1; i++ {
+		d.old.changed[i] = true
+	}
+	for j := b0; j < b1; j++ {
+		d.new.changed[j] = true
+	}
+}
+
+// edits turns the changed lines into runs of edits
+func (d *differ) edits() []Edit {
+	var edits []Edit
+	add := func(op Op, i0, i1, j0, j1 int) {
+		if i0 == i1 && j0 == j1 {
+			return
+		}
+		edits = append(edits, Edit{Op: op, OldStart: i0, OldEnd: i1, NewStart: j0, NewEnd: j1})
+	}
+	i, j := 0, 0
+	n, m := len(d.old.ids), len(d.new.ids)
+	for i < n || j < m {
+		i0, j0 := i, j
+		for i < n && j < m && !d.old.changed[i] && !d.new.changed[j] {
+			i++
+			j++
+		}
+		add(Equal, i0, i, j0, j)
+		i0 = i
+		for i < n && d.old.changed[i] {
+			i++
+		}
+		add(Delete, i0, i, j, j)
+		j0 = j
+		for j < m && d.new.changed[j] {
+			j++
+		}
+		add(Insert, i, i, j0, j)
+	}
+	return edits
+}
@@ -0,0 +1,302 @@
+package diff
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// apply rebuilds the new lines from the old ones and the edits
+func apply(t *testing.T, old, new []string, edits []Edit) []string {
+	t.Helper()
+	var result []string
+	i, j := 0, 0
+	for _, edit := range edits {
+		if edit.OldStart != i || edit.NewStart != j {
+			t.Fatalf("edit %+v does not follow line %d, %d", edit, i, j)
+		}
+		switch edit.Op {
+		case Equal:
+			if !equalLines(old[edit.OldStart:edit.OldEnd], new[edit.NewStart:edit.NewEnd]) {
+				t.Fatalf("edit %+v keeps lines which differ", edit)
+			}
+			result = append(result, old[edit.OldStart:edit.OldEnd]...)
+		case Insert:
+			result = append(result, new[edit.NewStart:edit.NewEnd]...)
+		}
+		i, j = edit.OldEnd, edit.NewEnd
+	}
+	if i != len(old) || j != len(new) {
+		t.Fatalf("edits end at %d, %d, want %d, %d", i, j, len(old), len(new))
+	}
+	return result
+}
+
+func equalLines(a, b []string) bool {
+	return strings.Join(a, "") == strings.Join(b, "") && len(a) == len(b)
+}
+
+// shortestEdit is the length of the shortest edit script, by dynamic programming
+func shortestEdit(old, new []string) int {
+	lcs := make([][]int, len(old)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(new)+1)
+	}
+	for i := len(old) - 1; i >= 0; i-- {
+		for j := len(new) - 1; j >= 0; j-- {
+			if old[i] == new[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	return len(old) + len(new) - 2*lcs[0][0]
+}
+
+func TestDiff(t *testing.T) {
+	vocabulary := []string{"a\n", "b\n", "c\n", "\n", "\tx\n", "}\n", "func f() {\n"}
+	random := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, random.Intn(30))
+		for i := range lines {
+			lines[i] = vocabulary[random.Intn(len(vocabulary))]
+		}
+		return lines
+	}
+	for _, algorithm := range []Algorithm{Myers, Patience, Histogram} {
+		for n := 0; n < 500; n++ {
+			old, new := randomLines(), randomLines()
+			if n%2 == 0 {
+				// a few changes to a file rather than two unrelated ones
+				new = append(append([]string{}, old[:len(old)/2]...), new[:len(new)/3]...)
+				new = append(new, old[len(old)/2:]...)
+			}
+			edits := Diff(old, new, algorithm)
+			if got := apply(t, old, new, edits); !equalLines(got, new) {
+				t.Fatalf("algorithm %d: edits of %q to %q give %q", algorithm, old, new, got)
+			}
+			if algorithm != Myers {
+				continue
+			}
+			length := 0
+			for _, edit := range edits {
+				if edit.Op != Equal {
+					length += edit.OldEnd - edit.OldStart + edit.NewEnd - edit.NewStart
+				}
+			}
+			if want := shortestEdit(old, new); length != want {
+				t.Fatalf("Myers edits of %q to %q have %d lines, want %d", old, new, length, want)
+			}
+		}
+	}
+}
+
+func TestDiffAlgorithms(t *testing.T) {
+	// the algorithms match different lines when several shortest edits exist, the
+	// outputs are the ones of git
+	old := Lines([]byte("a\n\nb\n"))
+	new := Lines([]byte("}\n}\nb\nb\na\n"))
+	tests := []struct {
+		algorithm Algorithm
+		want      string
+	}{
+		{Myers, "-a\n-\n+}\n+}\n+b\n b\n+a\n"},
+		{Patience, "+}\n+}\n+b\n+b\n a\n-\n-b\n"},
+		{Histogram, "-a\n-\n+}\n+}\n b\n+b\n+a\n"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		for _, hunk := range Hunks(Diff(old, new, test.algorithm), 3) {
+			if err := WriteHunk(&out, hunk, old, new); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := out.String()[strings.IndexByte(out.String(), '\n')+1:]; got != test.want {
+			t.Errorf("algorithm %d gives\n%s\nwant\n%s", test.algorithm, got, test.want)
+		}
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for name, want := range map[string]Algorithm{"myers": Myers, "default": Myers, "Patience": Patience, "histogram": Histogram} {
+		if got, err := ParseAlgorithm(name); err != nil || got != want {
+			t.Errorf("ParseAlgorithm(%q) = %d, %v, want %d", name, got, err, want)
+		}
+	}
+	if _, err := ParseAlgorithm("quick"); err == nil {
+		t.Errorf("ParseAlgorithm() accepted an unknown algorithm")
+	}
+}
+
+func TestHunks(t *testing.T) {
+	var old, new []string
+	for i := 1; i <= 20; i++ {
+		line := strings.Repeat("x", i) + "\n"
+		old = append(old, line)
+		if i == 2 || i == 9 {
+			line = "changed\n"
+		}
+		if i != 16 {
+			new = append(new, line)
+		}
+	}
+	edits := Diff(old, new, Myers)
+	tests := []struct {
+		context int
+		want    []Hunk
+	}{
+		{0, []Hunk{{OldStart: 1, OldEnd: 2, NewStart: 1, NewEnd: 2}, {OldStart: 8, OldEnd: 9, NewStart: 8, NewEnd: 9}, {OldStart: 15, OldEnd: 16, NewStart: 15, NewEnd: 15}}},
+		{2, []Hunk{{OldStart: 0, OldEnd: 4, NewStart: 0, NewEnd: 4}, {OldStart: 6, OldEnd: 11, NewStart: 6, NewEnd: 11}, {OldStart: 13, OldEnd: 18, NewStart: 13, NewEnd: 17}}},
+		// the changes 6 lines apart are in the same hunk with 3 lines of context
+		{3, []Hunk{{OldStart: 0, OldEnd: 19, NewStart: 0, NewEnd: 18}}},
+	}
+	for _, test := range tests {
+		hunks := Hunks(edits, test.context)
+		if len(hunks) != len(test.want) {
+			t.Errorf("Hunks(%d) gives %d hunks, want %d", test.context, len(hunks), len(test.want))
+			continue
+		}
+		for i, hunk := range hunks {
+			want := test.want[i]
+			if hunk.OldStart != want.OldStart || hunk.OldEnd != want.OldEnd || hunk.NewStart != want.NewStart || hunk.NewEnd != want.NewEnd {
+				t.Errorf("Hunks(%d)[%d] = %+v, want %+v", test.context, i, hunk, want)
+			}
+		}
+	}
+}
+
+func TestWritePatch(t *testing.T) {
+	hash := func(c byte) [20]byte {
+		var h [20]byte
+		for i := range h {
+			h[i] = c
+		}
+		return h
+	}
+	tests := []struct {
+		name    string
+		patch   FilePatch
+		context int
+		want    string
+	}{
+		{
+			name: "function added",
+			patch: FilePatch{
+				OldPath: "main.go", NewPath: "main.go", OldMode: 0100644, NewMode: 0100644,
+				OldHash: hash(0x2b), NewHash: hash(0x46),
+				Old: []byte("package main\n\nfunc a() {\n\tprintln(\"a\")\n}\n\nfunc c() {\n\tprintln(\"c\")\n}\n"),
+				New: []byte("package main\n\nfunc a() {\n\tprintln(\"a\")\n}\n\nfunc b() {\n\tprintln(\"b\")\n}\n\nfunc c() {\n\tprintln(\"c\")\n}\n"),
+			},
+			context: 3,
+			want: "diff --git a/main.go b/main.go\n" +
+				"index 2b2b2b2..4646464 100644\n" +
+				"--- a/main.go\n" +
+				"+++ b/main.go\n" +
+				"@@ -4,6 +4,10 @@ func a() {\n" +
+				" \tprintln(\"a\")\n" +
+				" }\n" +
+				" \n" +
+				"+func b() {\n" +
+				"+\tprintln(\"b\")\n" +
+				"+}\n" +
+				"+\n" +
+				" func c() {\n" +
+				" \tprintln(\"c\")\n" +
+				" }\n",
+		},
+		{
+			name: "no newline at end of file",
+			patch: FilePatch{
+				OldPath: "n", NewPath: "n", OldMode: 0100644, NewMode: 0100755,
+				OldHash: hash(0xc9), NewHash: hash(0xae),
+				Old: []byte("one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"),
+				New: []byte("one\ntwo\nthree\nfour\nfive\nSIX\nseven\neight\nnine\nten"),
+			},
+			context: 2,
+			want: "diff --git a/n b/n\n" +
+				"old mode 100644\n" +
+				"new mode 100755\n" +
+				"index c9c9c9c..aeaeaea\n" +
+				"--- a/n\n" +
+				"+++ b/n\n" +
+				"@@ -4,7 +4,7 @@ three\n" +
+				" four\n" +
+				" five\n" +
+				"-six\n" +
+				"+SIX\n" +
+				" seven\n" +
+				" eight\n" +
+				" nine\n" +
+				"-ten\n" +
+				"+ten\n" +
+				"\\ No newline at end of file\n",
+		},
+		{
+			name: "new file",
+			patch: FilePatch{
+				OldPath: "dir/new file", NewPath: "dir/new file", NewMode: 0100644,
+				NewHash: hash(0x12), New: []byte("a\nb\n"),
+			},
+			context: 3,
+			want: "diff --git a/dir/new file b/dir/new file\n" +
+				"new file mode 100644\n" +
+				"index 0000000..1212121\n" +
+				"--- /dev/null\n" +
+				"+++ b/dir/new file\n" +
+				"@@ -0,0 +1,2 @@\n" +
+				"+a\n" +
+				"+b\n",
+		},
+		{
+			name: "empty file deleted",
+			patch: FilePatch{
+				OldPath: "empty", NewPath: "empty", OldMode: 0100644, OldHash: hash(0xe6),
+			},
+			context: 3,
+			want: "diff --git a/empty b/empty\n" +
+				"deleted file mode 100644\n" +
+				"index e6e6e6e..0000000\n",
+		},
+		{
+			name: "mode change only",
+			patch: FilePatch{
+				OldPath: "run.sh", NewPath: "run.sh", OldMode: 0100644, NewMode: 0100755,
+				OldHash: hash(0x01), NewHash: hash(0x01), Old: []byte("echo\n"), New: []byte("echo\n"),
+			},
+			context: 3,
+			want:    "diff --git a/run.sh b/run.sh\nold mode 100644\nnew mode 100755\n",
+		},
+		{
+			name: "binary",
+			patch: FilePatch{
+				OldPath: "café.bin", NewPath: "café.bin", OldMode: 0100644, NewMode: 0100644,
+				OldHash: hash(0x01), NewHash: hash(0x02), Old: []byte("a\x00b"), New: []byte("a\x00c"),
+			},
+			context: 3,
+			want: "diff --git \"a/caf\\303\\251.bin\" \"b/caf\\303\\251.bin\"\n" +
+				"index 0101010..0202020 100644\n" +
+				"Binary files \"a/caf\\303\\251.bin\" and \"b/caf\\303\\251.bin\" differ\n",
+		},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		if err := WritePatch(&out, test.patch, Options{Context: test.context}); err != nil {
+			t.Fatalf("%s: WritePatch() error = %v", test.name, err)
+		}
+		if out.String() != test.want {
+			t.Errorf("%s: WritePatch() wrote\n%s\nwant\n%s", test.name, out.String(), test.want)
+		}
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	late := append(bytes.Repeat([]byte("a"), binaryCheckLength), 0)
+	tests := map[string]bool{"": false, "text\n": false, "a\x00b": true, string(late): false}
+	for content, want := range tests {
+		if got := IsBinary([]byte(content)); got != want {
+			t.Errorf("IsBinary(%.20q) = %v, want %v", content, got, want)
+		}
+	}
+}
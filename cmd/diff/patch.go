@@ -0,0 +1,108 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/common"
+)
+
+const (
+	// binaryCheckLength is the number of bytes looked at to tell a binary file, like git
+	binaryCheckLength = 8000
+	// abbreviatedLength is the length of the hashes on the index line
+	abbreviatedLength = 7
+)
+
+// FilePatch is the change of a file, the old side is missing for an added file and the
+// new side for a deleted one, with a mode of 0 and a zero hash. The paths differ for a
+// renamed or a copied file.
+type FilePatch struct {
+	OldPath, NewPath string
+	OldMode, NewMode uint32
+	OldHash, NewHash [20]byte
+	Old, New         []byte
+	// Copy tells a copied file from a renamed one, Similarity is the percentage of their
+	// content they share
+	Copy       bool
+	Similarity int
+}
+
+// Options are the settings of the patches
+type Options struct {
+	// Context is the number of unchanged lines shown around the changes
+	Context   int
+	Algorithm Algorithm
+}
+
+// IsBinary reports whether the content looks binary, i.e. has a NUL byte in its start
+func IsBinary(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), binaryCheckLength)], 0) != -1
+}
+
+// WritePatch writes the change of the file in the format of `git diff`, which `git apply`
+// reads back: the header with the paths, the modes and the hashes, then the hunks or a
+// line telling the files differ when one of them is binary
+func WritePatch(w io.Writer, patch FilePatch, opts Options) error {
+	oldName, newName := "a/"+patch.OldPath, "b/"+patch.NewPath
+	var header bytes.Buffer
+	fmt.Fprintf(&header, "diff --git %s %s\n", common.QuotePath(oldName, false), common.QuotePath(newName, false))
+	switch {
+	case patch.OldMode == 0:
+		fmt.Fprintf(&header, "new file mode %06o\n", patch.NewMode)
+		oldName = "/dev/null"
+	case patch.NewMode == 0:
+		fmt.Fprintf(&header, "deleted file mode %06o\n", patch.OldMode)
+		newName = "/dev/null"
+	case patch.OldMode != patch.NewMode:
+		fmt.Fprintf(&header, "old mode %06o\nnew mode %06o\n", patch.OldMode, patch.NewMode)
+	}
+	if patch.OldPath != patch.NewPath {
+		kind := "rename"
+		if patch.Copy {
+			kind = "copy"
+		}
+		fmt.Fprintf(&header, "similarity index %d%%\n%s from %s\n%s to %s\n", patch.Similarity,
+			kind, common.QuotePath(patch.OldPath, false), kind, common.QuotePath(patch.NewPath, false))
+	}
+	if patch.OldHash != patch.NewHash {
+		fmt.Fprintf(&header, "index %s..%s", abbreviate(patch.OldHash), abbreviate(patch.NewHash))
+		if patch.OldMode == patch.NewMode {
+			fmt.Fprintf(&header, " %06o", patch.NewMode)
+		}
+		header.WriteString("\n")
+	}
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+	if patch.OldHash == patch.NewHash {
+		return nil
+	}
+
+	if IsBinary(patch.Old) || IsBinary(patch.New) {
+		_, err := fmt.Fprintf(w, "Binary files %s and %s differ\n",
+			common.QuotePath(oldName, false), common.QuotePath(newName, false))
+		return err
+	}
+	old, new := Lines(patch.Old), Lines(patch.New)
+	hunks := Hunks(Diff(old, new, opts.Algorithm), opts.Context)
+	if len(hunks) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "--- %s\n+++ %s\n",
+		common.QuotePath(oldName, false), common.QuotePath(newName, false)); err != nil {
+		return err
+	}
+	for _, hunk := range hunks {
+		if err := WriteHunk(w, hunk, old, new); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func abbreviate(hash [20]byte) string {
+	return hex.EncodeToString(hash[:])[:abbreviatedLength]
+}
@@ -0,0 +1,362 @@
+package diff
+
+import (
+	"fmt"
+	"path"
+	"sort"
+)
+
+const (
+	// MaxScore is the similarity of identical files, the scores are fractions of it
+	MaxScore = 60000
+	// DefaultRenameScore is the similarity a rename needs by default, 50%
+	DefaultRenameScore = MaxScore / 2
+	// DefaultRenameLimit is the default of RenameOptions.Limit, like git
+	DefaultRenameLimit = 1000
+
+	// candidatesPerFile is the number of sources kept for each added file while scoring
+	candidatesPerFile = 4
+	// maxChunkLength is the length after which a line is cut in chunks for the scoring
+	maxChunkLength = 64
+	// chunkHashBase is the modulus of the hashes of the chunks
+	chunkHashBase = 107927
+)
+
+// Change is a file which differs between two versions of a tree, the mode is 0 and the
+// hash is zero on the side where the file is missing
+type Change struct {
+	// Status is 'A' added, 'D' deleted, 'M' modified, 'T' type changed, 'R' renamed or
+	// 'C' copied
+	Status           byte
+	OldPath, NewPath string
+	OldMode, NewMode uint32
+	OldHash, NewHash [20]byte
+	// Score is the similarity of the files of a rename or a copy, out of MaxScore
+	Score int
+}
+
+// Similarity returns the score as the percentage git shows
+func (c Change) Similarity() int {
+	return c.Score * 100 / MaxScore
+}
+
+// RenameOptions are the settings of the rename detection
+type RenameOptions struct {
+	// MinScore is the similarity a rename or a copy needs, out of MaxScore
+	MinScore int
+	// Copies also looks for the origin of the added files among the modified ones
+	Copies bool
+	// Limit skips the comparison of the contents when there are more than Limit*Limit
+	// pairs of files to score, 0 for no limit
+	Limit int
+	// Read returns the content of the version of a file with the given hash
+	Read func(hash [20]byte) ([]byte, error)
+}
+
+// ParseScore parses the similarity given to -M or -C, either a percentage like "75%" or
+// the digits after the point of a fraction, "75" being 0.75 and "5" 0.5 like in git
+func ParseScore(value string) (int, error) {
+	num, scale, dot := 0, 1, false
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '.' && !dot:
+			scale, dot = 1, true
+		case c == '%' && i == len(value)-1:
+			if dot {
+				scale *= 100
+			} else {
+				scale = 100
+			}
+		case '0' <= c && c <= '9':
+			if scale < 100000 {
+				scale *= 10
+				num = num*10 + int(c-'0')
+			}
+		default:
+			return 0, fmt.Errorf("invalid similarity '%s'", value)
+		}
+	}
+	if num >= scale {
+		return MaxScore, nil
+	}
+	return MaxScore * num / scale, nil
+}
+
+// renameSource is a deleted file, or a modified one when looking for copies, an added
+// file can come from
+type renameSource struct {
+	change int
+	// used is the number of added files which come from it, a modified file starts at 1
+	// since it stays where it is
+	used int
+}
+
+// renameCandidate is a source scored for an added file, the unused slots have no file
+type renameCandidate struct {
+	file, source int
+	score        int
+	// sameName is 1 when the source and the file have the same base name, which wins
+	// between equal scores
+	sameName int
+}
+
+// compareCandidates orders the candidates by decreasing score, the unused slots last
+func compareCandidates(a, b renameCandidate) int {
+	switch {
+	case a.file < 0 && b.file >= 0:
+		return 1
+	case a.file < 0:
+		return 0
+	case b.file < 0:
+		return -1
+	case a.score == b.score:
+		return b.sameName - a.sameName
+	}
+	return b.score - a.score
+}
+
+// DetectRenames pairs the added files with the deleted ones they were renamed from, or
+// with the deleted or modified files they were copied from, the way git does:
+//
+//   - the files with the same content are paired first, preferring a source which is
+//     not used yet and has the same base name
+//   - the other added files are scored against every source by how much of their
+//     content is found in it, and the pairs are made from the best score down
+//
+// A deleted file becomes a rename of the last added file which comes from it and a copy
+// for the others, a modified file is copied. The renamed files are returned in place of
+// the added ones, without the deleted files they come from. The second value is the
+// limit the scoring would have needed when it was skipped, 0 otherwise.
+func DetectRenames(changes []Change, opts RenameOptions) ([]Change, int, error) {
+	var sources []*renameSource
+	var files []int
+	for i, change := range changes {
+		switch {
+		case change.Status == 'A':
+			files = append(files, i)
+		case change.Status == 'D':
+			sources = append(sources, &renameSource{change: i})
+		case opts.Copies && (change.Status == 'M' || change.Status == 'T'):
+			sources = append(sources, &renameSource{change: i, used: 1})
+		}
+	}
+	if len(files) == 0 || len(sources) == 0 {
+		return changes, 0, nil
+	}
+
+	pairs := map[int]renameCandidate{}
+	pair := func(candidate renameCandidate) {
+		pairs[candidate.file] = candidate
+		sources[candidate.source].used++
+	}
+	sameName := func(file int, source *renameSource) int {
+		if path.Base(changes[file].NewPath) == path.Base(changes[source.change].OldPath) {
+			return 1
+		}
+		return 0
+	}
+	bySHA := map[[20]byte][]int{}
+	for i, source := range sources {
+		bySHA[changes[source.change].OldHash] = append(bySHA[changes[source.change].OldHash], i)
+	}
+	for _, file := range files {
+		best, bestScore := renameCandidate{file: -1}, -1
+		for _, i := range bySHA[changes[file].NewHash] {
+			oldMode, newMode := changes[sources[i].change].OldMode, changes[file].NewMode
+			if (!isRegular(oldMode) || !isRegular(newMode)) && oldMode != newMode {
+				continue
+			}
+			if sources[i].used > 0 && !opts.Copies {
+				continue
+			}
+			// an unused source is better, then one with the same name
+			score := sameName(file, sources[i])
+			if sources[i].used == 0 {
+				score++
+			}
+			if score > bestScore {
+				best, bestScore = renameCandidate{file: file, source: i, score: MaxScore}, score
+			}
+		}
+		if best.file >= 0 {
+			pair(best)
+		}
+	}
+
+	var remaining, candidates []int
+	for _, file := range files {
+		if _, ok := pairs[file]; !ok {
+			remaining = append(remaining, file)
+		}
+	}
+	for i, source := range sources {
+		if opts.Copies || source.used == 0 {
+			candidates = append(candidates, i)
+		}
+	}
+	needed := 0
+	switch {
+	case opts.MinScore >= MaxScore || len(remaining) == 0 || len(candidates) == 0:
+	case opts.Limit > 0 && len(remaining)*len(candidates) > opts.Limit*opts.Limit:
+		needed = max(len(remaining), len(candidates))
+	default:
+		scorer := newSimilarityScorer(opts)
+		slots := make([]renameCandidate, 0, len(remaining)*candidatesPerFile)
+		for _, file := range remaining {
+			best := make([]renameCandidate, candidatesPerFile)
+			for i := range best {
+				best[i].file = -1
+			}
+			for _, i := range candidates {
+				score, err := scorer.score(changes[sources[i].change], changes[file])
+				if err != nil {
+					return nil, 0, err
+				}
+				candidate := renameCandidate{file: file, source: i, score: score, sameName: sameName(file, sources[i])}
+				worst := 0
+				for k := 1; k < len(best); k++ {
+					if compareCandidates(best[k], best[worst]) > 0 {
+						worst = k
+					}
+				}
+				if compareCandidates(best[worst], candidate) > 0 {
+					best[worst] = candidate
+				}
+			}
+			slots = append(slots, best...)
+		}
+		sort.SliceStable(slots, func(i, j int) bool { return compareCandidates(slots[i], slots[j]) < 0 })
+		for _, copies := range []bool{false, true} {
+			if copies && !opts.Copies {
+				break
+			}
+			for _, candidate := range slots {
+				if candidate.file < 0 || candidate.score < opts.MinScore {
+					break
+				}
+				if _, ok := pairs[candidate.file]; ok || !copies && sources[candidate.source].used > 0 {
+					continue
+				}
+				pair(candidate)
+			}
+		}
+	}
+
+	renamed := map[int]bool{}
+	for _, source := range sources {
+		if changes[source.change].Status == 'D' && source.used > 0 {
+			renamed[source.change] = true
+		}
+	}
+	var result []Change
+	for i, change := range changes {
+		if renamed[i] {
+			continue
+		}
+		candidate, ok := pairs[i]
+		if !ok {
+			result = append(result, change)
+			continue
+		}
+		source := sources[candidate.source]
+		from := changes[source.change]
+		change.OldPath, change.OldMode, change.OldHash = from.OldPath, from.OldMode, from.OldHash
+		change.Score = candidate.score
+		if source.used--; source.used > 0 {
+			change.Status = 'C'
+		} else {
+			change.Status = 'R'
+		}
+		result = append(result, change)
+	}
+	return result, needed, nil
+}
+
+func isRegular(mode uint32) bool {
+	return mode&0170000 == 0100000
+}
+
+// similarityScorer scores the sources of the added files, keeping the chunks of the
+// contents it already read
+type similarityScorer struct {
+	opts   RenameOptions
+	chunks map[[20]byte]chunkCounts
+}
+
+// chunkCounts are the number of bytes of the chunks of a file by hash, and its size
+type chunkCounts struct {
+	counts map[uint32]int
+	size   int
+}
+
+func newSimilarityScorer(opts RenameOptions) *similarityScorer {
+	return &similarityScorer{opts: opts, chunks: map[[20]byte]chunkCounts{}}
+}
+
+func (s *similarityScorer) read(hash [20]byte) (chunkCounts, error) {
+	if chunks, ok := s.chunks[hash]; ok {
+		return chunks, nil
+	}
+	content, err := s.opts.Read(hash)
+	if err != nil {
+		return chunkCounts{}, err
+	}
+	chunks := chunkCounts{counts: countChunks(content), size: len(content)}
+	s.chunks[hash] = chunks
+	return chunks, nil
+}
+
+// score returns the similarity of the new file of the added change with the old file of
+// the source, the share of the larger file made of chunks found in both. Only regular
+// files are scored, and files whose size differs too much for the minimum score are not
+// read.
+func (s *similarityScorer) score(source, added Change) (int, error) {
+	if !isRegular(source.OldMode) || !isRegular(added.NewMode) {
+		return 0, nil
+	}
+	src, err := s.read(source.OldHash)
+	if err != nil {
+		return 0, err
+	}
+	dst, err := s.read(added.NewHash)
+	if err != nil {
+		return 0, err
+	}
+	maxSize, minSize := max(src.size, dst.size), min(src.size, dst.size)
+	if maxSize*(MaxScore-s.opts.MinScore) < (maxSize-minSize)*MaxScore || dst.size == 0 {
+		return 0, nil
+	}
+	copied := 0
+	for hash, count := range src.counts {
+		copied += min(count, dst.counts[hash])
+	}
+	return copied * MaxScore / maxSize, nil
+}
+
+// countChunks cuts the content in chunks ending at a newline or after 64 bytes and
+// returns the number of bytes of the chunks by hash, the "\r" of a "\r\n" is left out of
+// a text file
+func countChunks(content []byte) map[uint32]int {
+	counts := map[uint32]int{}
+	text := !IsBinary(content)
+	var accum1, accum2 uint32
+	n := 0
+	for i, c := range content {
+		if text && c == '\r' && i+1 < len(content) && content[i+1] == '\n' {
+			continue
+		}
+		old1 := accum1
+		accum1 = accum1<<7 ^ accum2>>25
+		accum2 = accum2<<7 ^ old1>>25
+		accum1 += uint32(c)
+		if n++; n < maxChunkLength && c != '\n' {
+			continue
+		}
+		counts[(accum1+accum2*0x61)%chunkHashBase] += n
+		accum1, accum2, n = 0, 0, 0
+	}
+	if n > 0 {
+		counts[(accum1+accum2*0x61)%chunkHashBase] += n
+	}
+	return counts
+}
@@ -0,0 +1,179 @@
+package diff
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseScore(t *testing.T) {
+	tests := map[string]int{
+		"50%":  30000,
+		"5":    30000,
+		"75":   45000,
+		"05":   3000,
+		".9":   54000,
+		"100%": MaxScore,
+		"9%":   5400,
+		"1":    6000,
+	}
+	for value, want := range tests {
+		if got, err := ParseScore(value); err != nil || got != want {
+			t.Errorf("ParseScore(%q) = %d, %v, want %d", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"abc", "5%5", "1..2"} {
+		if _, err := ParseScore(value); err == nil {
+			t.Errorf("ParseScore(%q) accepted an invalid similarity", value)
+		}
+	}
+}
+
+// renameRepository builds the changes between two versions of the files, a file missing
+// from one of them is added or deleted
+type renameRepository struct {
+	contents map[[20]byte][]byte
+}
+
+func (r *renameRepository) hash(content string) [20]byte {
+	hash := sha1.Sum([]byte(content))
+	r.contents[hash] = []byte(content)
+	return hash
+}
+
+func (r *renameRepository) changes(old, new map[string]string, names ...string) []Change {
+	var changes []Change
+	for _, name := range names {
+		change := Change{OldPath: name, NewPath: name}
+		if content, ok := old[name]; ok {
+			change.OldMode, change.OldHash = 0100644, r.hash(content)
+		}
+		if content, ok := new[name]; ok {
+			change.NewMode, change.NewHash = 0100644, r.hash(content)
+		}
+		switch {
+		case change.OldMode == 0:
+			change.Status = 'A'
+		case change.NewMode == 0:
+			change.Status = 'D'
+		default:
+			change.Status = 'M'
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func (r *renameRepository) read(hash [20]byte) ([]byte, error) {
+	content, ok := r.contents[hash]
+	if !ok {
+		return nil, fmt.Errorf("no content for %x", hash)
+	}
+	return content, nil
+}
+
+func numbers(from, to int) string {
+	var builder strings.Builder
+	for i := from; i <= to; i++ {
+		fmt.Fprintf(&builder, "%d\n", i)
+	}
+	return builder.String()
+}
+
+func TestDetectRenames(t *testing.T) {
+	// the scores are the ones git gives to the same files
+	old := map[string]string{
+		"big.txt":     numbers(1, 200),
+		"crlf.txt":    "l1\r\nl2\r\nl3\r\nl4\r\n",
+		"dir/one":     "same\n",
+		"two":         "same\n",
+		"mod.txt":     numbers(1, 100),
+		"small.txt":   numbers(300, 400),
+		"removed.txt": "something else\n",
+	}
+	new := map[string]string{
+		"big2.txt":   numbers(1, 4) + numbers(21, 200),
+		"crlf2.txt":  "l1\nl2\nl3\nl4\nl5\n",
+		"one":        "same\n",
+		"zero":       "same\n",
+		"mod.txt":    "changed\n" + numbers(2, 100),
+		"copy.txt":   numbers(1, 100),
+		"small2.txt": numbers(300, 330),
+	}
+	names := []string{"big.txt", "big2.txt", "copy.txt", "crlf.txt", "crlf2.txt", "dir/one",
+		"mod.txt", "one", "removed.txt", "small.txt", "small2.txt", "two", "zero"}
+	tests := []struct {
+		name string
+		opts RenameOptions
+		want []string
+	}{
+		{
+			name: "renames",
+			opts: RenameOptions{MinScore: DefaultRenameScore},
+			want: []string{"R093 big.txt big2.txt", "A copy.txt", "R075 crlf.txt crlf2.txt",
+				"M mod.txt", "R100 dir/one one", "D removed.txt", "D small.txt", "A small2.txt",
+				"R100 two zero"},
+		},
+		{
+			name: "copies",
+			opts: RenameOptions{MinScore: DefaultRenameScore, Copies: true},
+			want: []string{"R093 big.txt big2.txt", "C100 mod.txt copy.txt", "R075 crlf.txt crlf2.txt",
+				"M mod.txt", "R100 dir/one one", "D removed.txt", "D small.txt", "A small2.txt",
+				"R100 two zero"},
+		},
+		{
+			name: "threshold",
+			opts: RenameOptions{MinScore: 57000},
+			want: []string{"D big.txt", "A big2.txt", "A copy.txt", "D crlf.txt", "A crlf2.txt",
+				"M mod.txt", "R100 dir/one one", "D removed.txt", "D small.txt", "A small2.txt",
+				"R100 two zero"},
+		},
+		{
+			name: "limit",
+			opts: RenameOptions{MinScore: DefaultRenameScore, Limit: 2},
+			want: []string{"D big.txt", "A big2.txt", "A copy.txt", "D crlf.txt", "A crlf2.txt",
+				"M mod.txt", "R100 dir/one one", "D removed.txt", "D small.txt", "A small2.txt",
+				"R100 two zero"},
+		},
+	}
+	for _, test := range tests {
+		repository := &renameRepository{contents: map[[20]byte][]byte{}}
+		test.opts.Read = repository.read
+		changes, needed, err := DetectRenames(repository.changes(old, new, names...), test.opts)
+		if err != nil {
+			t.Fatalf("%s: DetectRenames() error = %v", test.name, err)
+		}
+		var got []string
+		for _, change := range changes {
+			if change.Status == 'R' || change.Status == 'C' {
+				got = append(got, fmt.Sprintf("%c%03d %s %s", change.Status, change.Similarity(), change.OldPath, change.NewPath))
+			} else {
+				got = append(got, fmt.Sprintf("%c %s", change.Status, change.NewPath))
+			}
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%s: DetectRenames() gives\n%s\nwant\n%s", test.name, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+		if wantNeeded := map[bool]int{true: 4}[test.opts.Limit > 0]; needed != wantNeeded {
+			t.Errorf("%s: DetectRenames() needs a limit of %d, want %d", test.name, needed, wantNeeded)
+		}
+	}
+}
+
+func TestDetectCopiesOfDeletedFile(t *testing.T) {
+	// a deleted file copied twice is a copy then a rename, in the order of the paths
+	repository := &renameRepository{contents: map[[20]byte][]byte{}}
+	changes := repository.changes(
+		map[string]string{"b": numbers(1, 10)},
+		map[string]string{"a": numbers(1, 10), "c": numbers(1, 9)},
+		"a", "b", "c")
+	changes, _, err := DetectRenames(changes, RenameOptions{MinScore: DefaultRenameScore, Copies: true, Read: repository.read})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Status != 'C' || changes[0].NewPath != "a" ||
+		changes[1].Status != 'R' || changes[1].NewPath != "c" || changes[1].OldPath != "b" {
+		t.Errorf("DetectRenames() = %+v", changes)
+	}
+}
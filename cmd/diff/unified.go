@@ -0,0 +1,125 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxFunctionLength is the number of bytes of the function line shown in a hunk header
+const maxFunctionLength = 80
+
+// Hunk is a group of changes close enough to be shown together, with the unchanged lines
+// around them, the lines are [OldStart, OldEnd) of the old file and [NewStart, NewEnd) of
+// the new one
+type Hunk struct {
+	OldStart, OldEnd int
+	NewStart, NewEnd int
+	// Edits are the edits of the hunk, cut to its lines
+	Edits []Edit
+}
+
+// Hunks groups the edits into hunks with context unchanged lines before and after their
+// changes, two changes separated by up to twice the context lines are in the same hunk
+func Hunks(edits []Edit, context int) []Hunk {
+	if len(edits) == 0 {
+		return nil
+	}
+	oldLength, newLength := edits[len(edits)-1].OldEnd, edits[len(edits)-1].NewEnd
+	var hunks []Hunk
+	for i := 0; i < len(edits); i++ {
+		if edits[i].Op == Equal {
+			continue
+		}
+		first, last := i, i
+		for j := i + 1; j < len(edits); j++ {
+			if edits[j].Op != Equal {
+				last = j
+			} else if edits[j].OldEnd-edits[j].OldStart > 2*context {
+				break
+			}
+		}
+		before := min(context, edits[first].OldStart, edits[first].NewStart)
+		after := min(context, oldLength-edits[last].OldEnd, newLength-edits[last].NewEnd)
+		hunk := Hunk{
+			OldStart: edits[first].OldStart - before,
+			OldEnd:   edits[last].OldEnd + after,
+			NewStart: edits[first].NewStart - before,
+			NewEnd:   edits[last].NewEnd + after,
+		}
+		if before > 0 {
+			hunk.Edits = append(hunk.Edits, Edit{Op: Equal,
+				OldStart: hunk.OldStart, OldEnd: edits[first].OldStart,
+				NewStart: hunk.NewStart, NewEnd: edits[first].NewStart})
+		}
+		hunk.Edits = append(hunk.Edits, edits[first:last+1]...)
+		if after > 0 {
+			hunk.Edits = append(hunk.Edits, Edit{Op: Equal,
+				OldStart: edits[last].OldEnd, OldEnd: hunk.OldEnd,
+				NewStart: edits[last].NewEnd, NewEnd: hunk.NewEnd})
+		}
+		hunks = append(hunks, hunk)
+		i = last
+	}
+	return hunks
+}
+
+// WriteHunk writes the hunk in the unified format: its header, then its lines prefixed
+// by ' ', '-' or '+'. A last line without "\n" is followed by git's marker.
+func WriteHunk(w io.Writer, hunk Hunk, old, new []string) error {
+	header := fmt.Sprintf("@@ -%s +%s @@", hunkRange(hunk.OldStart, hunk.OldEnd),
+		hunkRange(hunk.NewStart, hunk.NewEnd))
+	if function := functionLine(old, hunk.OldStart); function != "" {
+		header += " " + function
+	}
+	if _, err := io.WriteString(w, header+"\n"); err != nil {
+		return err
+	}
+	for _, edit := range hunk.Edits {
+		lines := new[edit.NewStart:edit.NewEnd]
+		if edit.Op == Delete {
+			lines = old[edit.OldStart:edit.OldEnd]
+		}
+		for _, line := range lines {
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n\\ No newline at end of file\n"
+			}
+			if _, err := io.WriteString(w, string(edit.Op)+line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hunkRange formats the lines [start, end) as in a hunk header: the first line counted
+// from 1 and the number of lines unless it is 1, an empty range gives the line before it
+func hunkRange(start, end int) string {
+	switch count := end - start; count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+}
+
+// functionLine returns the closest line before the given one of the old file which looks
+// like the start of a function, i.e. starts with a letter, '_' or '$', as git does
+// without a diff driver
+func functionLine(old []string, before int) string {
+	for i := before - 1; i >= 0; i-- {
+		line := old[i]
+		if line == "" {
+			continue
+		}
+		if c := line[0]; 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' || c == '$' {
+			if len(line) > maxFunctionLength {
+				line = line[:maxFunctionLength]
+			}
+			return strings.TrimRight(line, " \t\n\v\f\r")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/clone"
+	"github.com/codecrafters-io/git-starter-go/cmd/common"
+	"github.com/codecrafters-io/git-starter-go/cmd/config"
+	"github.com/codecrafters-io/git-starter-go/cmd/refs"
+)
+
+// cloneCmd has the logic for the clone subcommand
+//
+// The refs of the remote are mapped to local refs by the clone refspecs, which depend on
+// the options: a bare clone keeps the branches as they are, a mirror keeps every ref and a
+// single branch clone only fetches one branch. The objects of the mapped refs are fetched
+// in a single pack and HEAD is checked out unless the clone is bare or --no-checkout.
+//
+// The remote is configured as origin, and the branch checked out is created with origin as
+// its upstream, so that the clone can fetch from it later.
+func cloneCmd(opts cloneOptions) error {
+	if err := os.MkdirAll(opts.dir, 0755); err != nil {
+		return fmt.Errorf("create the dir to clone the repo: %w", err)
+	}
+	if err := os.Chdir(opts.dir); err != nil {
+		return fmt.Errorf("couldn't change the dir: %w", err)
+	}
+	// the working directory is now the new repository
+	repo := &repository{gitDir: ".git"}
+	if opts.bare {
+		repo.gitDir = "."
+	}
+	if err := initCMD(repo); err != nil {
+		return fmt.Errorf("couldn't initialize git: %w", err)
+	}
+
+	discovery, err := clone.Discover(opts.repo)
+	if err != nil {
+		return fmt.Errorf("git smart protocol for ref fetching: %w", err)
+	}
+	remoteRefs := discovery.Refs
+	if discovery.Version == 2 {
+		prefixes := []string{"HEAD", "refs/heads/", "refs/tags/"}
+		if opts.mirror {
+			prefixes = nil
+		}
+		remoteRefs, err = clone.LsRefs(opts.repo, discovery.Capabilities, prefixes...)
+		if err != nil {
+			return fmt.Errorf("git smart protocol for ref discovery: %w", err)
+		}
+	}
+	head, err := cloneHead(remoteRefs, discovery.Capabilities, opts.branch)
+	if err != nil {
+		return err
+	}
+	updates, err := matchRefspecs(remoteRefs, cloneRefspecs(opts, head.Name), false)
+	if err != nil {
+		return err
+	}
+	// like git, the clone is configured before the fetch, so that a partial clone fetches
+	// the objects it leaves out from origin
+	if err := writeCloneConfig(repo, opts, head.Name); err != nil {
+		return err
+	}
+	var wants []string
+	for _, update := range updates {
+		if !slices.Contains(wants, update.remote.Hash) {
+			wants = append(wants, update.remote.Hash)
+		}
+	}
+	if len(wants) == 0 {
+		ePrintf("warning: You appear to have cloned an empty repository.\n")
+		return nil
+	}
+	if err := fetchClonePack(repo, discovery, opts, wants); err != nil {
+		return err
+	}
+
+	for _, update := range updates {
+		if err := refs.Update(repo.gitDir, update.local, update.remote.Hash); err != nil {
+			return err
+		}
+	}
+	if opts.singleBranch {
+		// the tags of the history of the branch came with the pack thanks to include-tag
+		if err := followTags(repo, remoteRefs, updates, &fetchReport{quiet: true}); err != nil {
+			return err
+		}
+	}
+	if err := writeRemoteHead(repo, opts, clone.RemoteHead(remoteRefs, discovery.Capabilities)); err != nil {
+		return err
+	}
+	return checkoutCloneHead(repo, opts, head)
+}
+
+// cloneHead returns the remote ref to check out, the branch HEAD points to unless a
+// branch or a tag is named with --branch. It is HEAD itself when it is detached, and it
+// has no hash for an empty repository.
+func cloneHead(remoteRefs []clone.GitRef, server clone.Capabilities, branch string) (clone.GitRef, error) {
+	name := clone.RemoteHead(remoteRefs, server)
+	candidates := []string{name}
+	switch {
+	case branch != "":
+		candidates = []string{"refs/heads/" + branch, "refs/tags/" + branch}
+	case name == "":
+		candidates = []string{"HEAD"}
+	}
+	for _, candidate := range candidates {
+		for _, ref := range remoteRefs {
+			if ref.Name == candidate {
+				return ref, nil
+			}
+		}
+	}
+	if branch != "" {
+		return clone.GitRef{}, fmt.Errorf("Remote branch %s not found in upstream origin", branch)
+	}
+	return clone.GitRef{Name: name}, nil
+}
+
+// cloneRefspecs are the refspecs mapping the remote refs to the refs of the clone, head is
+// the remote ref checked out
+func cloneRefspecs(opts cloneOptions, head string) []refs.Refspec {
+	var specs []string
+	switch {
+	case opts.mirror:
+		specs = []string{"+refs/*:refs/*"}
+	case opts.singleBranch && strings.HasPrefix(head, "refs/heads/") && !opts.bare:
+		specs = []string{"+" + head + ":refs/remotes/origin/" + strings.TrimPrefix(head, "refs/heads/")}
+	case opts.singleBranch && strings.HasPrefix(head, "refs/"):
+		specs = []string{"+" + head + ":" + head}
+	case opts.singleBranch:
+	case opts.bare:
+		specs = []string{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}
+	default:
+		specs = []string{"+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*"}
+	}
+	if head == "HEAD" {
+		// a detached HEAD is fetched without a ref of its own
+		specs = append(specs, "HEAD")
+	}
+	refspecs := make([]refs.Refspec, 0, len(specs))
+	for _, spec := range specs {
+		// the refspecs are built from valid ref names
+		refspec, _ := refs.ParseRefspec(spec)
+		refspecs = append(refspecs, refspec)
+	}
+	return refspecs
+}
+
+// writeCloneConfig writes the configuration of the clone: the origin remote with the
+// refspec it is fetched with, and the upstream of the branch checked out
+//
+//	[remote "origin"]
+//		url = https://example.com/repo.git
+//		fetch = +refs/heads/*:refs/remotes/origin/*
+//	[branch "main"]
+//		remote = origin
+//		merge = refs/heads/main
+func writeCloneConfig(repo *repository, opts cloneOptions, head string) error {
+	formatVersion := "0"
+	if opts.filter != "" {
+		// the partialclone extension needs the version 1
+		formatVersion = "1"
+	}
+	entries := []config.Entry{
+		{Section: "core", Key: "repositoryformatversion", Value: formatVersion},
+		{Section: "core", Key: "filemode", Value: "true"},
+		{Section: "core", Key: "bare", Value: strconv.FormatBool(opts.bare)},
+	}
+	if !opts.bare {
+		entries = append(entries, config.Entry{Section: "core", Key: "logallrefupdates", Value: "true"})
+	}
+	if opts.filter != "" {
+		entries = append(entries, config.Entry{Section: "extensions", Key: "partialclone", Value: "origin"})
+	}
+	remote := func(key, value string) config.Entry {
+		return config.Entry{Section: "remote", Subsection: "origin", Key: key, Value: value}
+	}
+	entries = append(entries, remote("url", opts.repo))
+	for _, refspec := range cloneRefspecs(opts, head) {
+		// the tags and a detached HEAD are fetched along with the branches
+		if refspec.Src == "HEAD" || refspec.Src == "refs/tags/*" || opts.bare && !opts.mirror {
+			continue
+		}
+		entries = append(entries, remote("fetch", refspec.String()))
+	}
+	if opts.mirror {
+		entries = append(entries, remote("mirror", "true"))
+	}
+	if opts.filter != "" {
+		entries = append(entries, remote("promisor", "true"), remote("partialclonefilter", opts.filter))
+	}
+	if branch, ok := strings.CutPrefix(head, "refs/heads/"); ok && !opts.bare {
+		entries = append(entries,
+			config.Entry{Section: "branch", Subsection: branch, Key: "remote", Value: "origin"},
+			config.Entry{Section: "branch", Subsection: branch, Key: "merge", Value: head},
+		)
+	}
+	return config.Append(filepath.Join(repo.gitDir, "config"), entries...)
+}
+
+// writeRemoteHead points refs/remotes/origin/HEAD to the remote-tracking branch of the
+// remote HEAD, a clone of a single branch or a bare clone has none
+func writeRemoteHead(repo *repository, opts cloneOptions, remoteHead string) error {
+	branch, ok := strings.CutPrefix(remoteHead, "refs/heads/")
+	if !ok || opts.bare || opts.singleBranch {
+		return nil
+	}
+	target := "refs/remotes/origin/" + branch
+	if _, err := refs.Resolve(repo.gitDir, target); err != nil {
+		// HEAD points to a branch the remote does not have
+		return nil
+	}
+	return refs.WriteSymbolic(repo.gitDir, "refs/remotes/origin/HEAD", target)
+}
+
+// fetchClonePack fetches the wanted objects in a new repository, the history is cut and the
+// objects are filtered as the options say
+func fetchClonePack(repo *repository, discovery *clone.Discovery, opts cloneOptions, wants []string) error {
+	options := deepenOptions(opts.deepen)
+	options.Filter = opts.filter != ""
+	negotiated := clone.NegotiateCapabilities(discovery.Capabilities, options)
+	// a new repository has nothing to offer, so there are no haves to negotiate
+	response, err := clone.FetchPack(opts.repo, discovery, clone.FetchRequest{
+		Wants:        wants,
+		Capabilities: negotiated,
+		Deepen:       opts.deepen,
+		Filter:       opts.filter,
+	})
+	if err != nil {
+		return fmt.Errorf("git smart protocol for ref discovery: %w", err)
+	}
+	defer response.Close()
+	packPath, err := clone.StorePack(".", response, os.Stderr)
+	if err != nil {
+		return err
+	}
+	if err := common.UpdateShallow(repo.gitDir, response.Shallow, response.Unshallow); err != nil {
+		return err
+	}
+	if opts.filter == "" {
+		return nil
+	}
+	return markPromisorPack(packPath)
+}
+
+// checkoutCloneHead creates the local branch of the head and points HEAD to it, or detaches
+// HEAD at the commit of a tag, then writes the files of the commit unless the clone is bare
+// or --no-checkout. A bare clone has the remote branches as its own.
+func checkoutCloneHead(repo *repository, opts cloneOptions, head clone.GitRef) error {
+	if head.Hash == "" {
+		if !opts.bare && !opts.noCheckout {
+			ePrintf("warning: remote HEAD refers to nonexistent ref, unable to checkout\n")
+		}
+		return nil
+	}
+	commit, err := peelTag(repo, head.Hash)
+	if err != nil {
+		return err
+	}
+	switch {
+	case strings.HasPrefix(head.Name, "refs/heads/") && opts.bare:
+		err = refs.WriteSymbolic(repo.gitDir, "HEAD", head.Name)
+	case strings.HasPrefix(head.Name, "refs/heads/"):
+		// the local branch starts at the remote-tracking one, its upstream
+		if err = refs.Update(repo.gitDir, head.Name, head.Hash); err == nil {
+			err = refs.WriteSymbolic(repo.gitDir, "HEAD", head.Name)
+		}
+	default:
+		err = refs.UpdateNoDeref(repo.gitDir, "HEAD", commit)
+	}
+	if err != nil {
+		return err
+	}
+	if opts.bare || opts.noCheckout {
+		return nil
+	}
+	treeSHA, err := GetTreeHashFromCommit(commit, repo.objects())
+	if err != nil {
+		return err
+	}
+	if err := prefetchTree(repo, treeSHA); err != nil {
+		return err
+	}
+	if err := RenderTree(treeSHA, ".", repo.objects()); err != nil {
+		return err
+	}
+	idx := &common.Index{Version: 2}
+	if err := addTreeToIndex(repo, idx, treeSHA, ""); err != nil {
+		return err
+	}
+	return common.WriteIndex(repo.gitDir, idx)
+}
+
+// addTreeToIndex adds the files of the tree, as written to the working directory, to the
+// index so that they are not seen as changed
+func addTreeToIndex(repo *repository, idx *common.Index, treeHash, prefix string) error {
+	return walkTree(repo, treeHash, prefix, func(name string, entry GitTree) error {
+		info, err := os.Lstat(name)
+		if err != nil {
+			return err
+		}
+		idx.Add(common.NewIndexEntry(name, info, entry.SHA))
+		return nil
+	})
+}
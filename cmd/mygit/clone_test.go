@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/clone"
+	"github.com/codecrafters-io/git-starter-go/cmd/common"
+	"github.com/codecrafters-io/git-starter-go/cmd/config"
+	"github.com/codecrafters-io/git-starter-go/cmd/refs"
+)
+
+func TestCloneRefspecs(t *testing.T) {
+	tests := []struct {
+		name string
+		opts cloneOptions
+		head string
+		want []string
+	}{
+		{
+			name: "default",
+			head: "refs/heads/main",
+			want: []string{"+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*"},
+		},
+		{
+			name: "single branch",
+			opts: cloneOptions{singleBranch: true},
+			head: "refs/heads/dev",
+			want: []string{"+refs/heads/dev:refs/remotes/origin/dev"},
+		},
+		{
+			name: "single branch of a tag",
+			opts: cloneOptions{singleBranch: true},
+			head: "refs/tags/v1",
+			want: []string{"+refs/tags/v1:refs/tags/v1"},
+		},
+		{
+			name: "single branch of a detached HEAD",
+			opts: cloneOptions{singleBranch: true},
+			head: "HEAD",
+			want: []string{"HEAD"},
+		},
+		{
+			name: "bare",
+			opts: cloneOptions{bare: true},
+			head: "refs/heads/main",
+			want: []string{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"},
+		},
+		{
+			name: "bare single branch",
+			opts: cloneOptions{bare: true, singleBranch: true},
+			head: "refs/heads/main",
+			want: []string{"+refs/heads/main:refs/heads/main"},
+		},
+		{
+			name: "mirror",
+			opts: cloneOptions{bare: true, mirror: true},
+			head: "refs/heads/main",
+			want: []string{"+refs/*:refs/*"},
+		},
+		{
+			name: "detached HEAD",
+			head: "HEAD",
+			want: []string{"+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*", "HEAD"},
+		},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, refspec := range cloneRefspecs(test.opts, test.head) {
+			got = append(got, refspec.String())
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: cloneRefspecs() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestWriteCloneConfig(t *testing.T) {
+	const url = "https://example.com/repo.git"
+	tests := []struct {
+		name string
+		opts cloneOptions
+		head string
+		want []string
+	}{
+		{
+			name: "default",
+			opts: cloneOptions{repo: url},
+			head: "refs/heads/main",
+			want: []string{
+				"core.repositoryformatversion=0", "core.filemode=true", "core.bare=false",
+				"core.logallrefupdates=true", "remote.origin.url=" + url,
+				"remote.origin.fetch=+refs/heads/*:refs/remotes/origin/*",
+				"branch.main.remote=origin", "branch.main.merge=refs/heads/main",
+			},
+		},
+		{
+			name: "single branch",
+			opts: cloneOptions{repo: url, singleBranch: true},
+			head: "refs/heads/dev",
+			want: []string{
+				"core.repositoryformatversion=0", "core.filemode=true", "core.bare=false",
+				"core.logallrefupdates=true", "remote.origin.url=" + url,
+				"remote.origin.fetch=+refs/heads/dev:refs/remotes/origin/dev",
+				"branch.dev.remote=origin", "branch.dev.merge=refs/heads/dev",
+			},
+		},
+		{
+			name: "detached HEAD",
+			opts: cloneOptions{repo: url},
+			head: "HEAD",
+			want: []string{
+				"core.repositoryformatversion=0", "core.filemode=true", "core.bare=false",
+				"core.logallrefupdates=true", "remote.origin.url=" + url,
+				"remote.origin.fetch=+refs/heads/*:refs/remotes/origin/*",
+			},
+		},
+		{
+			name: "bare",
+			opts: cloneOptions{repo: url, bare: true},
+			head: "refs/heads/main",
+			want: []string{
+				"core.repositoryformatversion=0", "core.filemode=true", "core.bare=true",
+				"remote.origin.url=" + url,
+			},
+		},
+		{
+			name: "mirror",
+			opts: cloneOptions{repo: url, bare: true, mirror: true},
+			head: "refs/heads/main",
+			want: []string{
+				"core.repositoryformatversion=0", "core.filemode=true", "core.bare=true",
+				"remote.origin.url=" + url, "remote.origin.fetch=+refs/*:refs/*", "remote.origin.mirror=true",
+			},
+		},
+		{
+			name: "partial",
+			opts: cloneOptions{repo: url, filter: "blob:none"},
+			head: "refs/heads/main",
+			want: []string{
+				"core.repositoryformatversion=1", "core.filemode=true", "core.bare=false",
+				"core.logallrefupdates=true", "extensions.partialclone=origin", "remote.origin.url=" + url,
+				"remote.origin.fetch=+refs/heads/*:refs/remotes/origin/*",
+				"remote.origin.promisor=true", "remote.origin.partialclonefilter=blob:none",
+				"branch.main.remote=origin", "branch.main.merge=refs/heads/main",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := inTempRepository(t)
+			if err := writeCloneConfig(repo, test.opts, test.head); err != nil {
+				t.Fatalf("writeCloneConfig() error = %v", err)
+			}
+			cfg, err := config.Read(filepath.Join(repo.gitDir, "config"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, entry := range cfg.Entries {
+				got = append(got, entry.Name()+"="+entry.Value)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("writeCloneConfig() wrote\n%q\nwant\n%q", got, test.want)
+			}
+		})
+	}
+}
+
+// setUpCloneHead writes the objects of a fetched commit holding a single file, along with
+// an annotated tag of it, to the object store of the repository
+func setUpCloneHead(t *testing.T) (repo *repository, commit, tag string) {
+	repo = inTempRepository(t)
+	repo.store = common.NewObjectStore(".")
+	tree := testTree(t, repo, GitTree{GitMode: "100644", Name: "file", SHA: testBlob(t, repo, "content\n")})
+	commitHash, err := writeObject(repo, "commit", fmt.Appendf(nil,
+		"tree %s\nauthor A U Thor <author@example.com> 1700000000 +0000\n"+
+			"committer A U Thor <author@example.com> 1700000000 +0000\n\ncommit\n", hex.EncodeToString(tree[:])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit = hex.EncodeToString(commitHash[:])
+	tagHash, err := writeObject(repo, "tag", fmt.Appendf(nil,
+		"object %s\ntype commit\ntag v1\ntagger A U Thor <author@example.com> 1700000000 +0000\n\nv1\n", commit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag = hex.EncodeToString(tagHash[:])
+	// the refs the fetch wrote
+	for name, hash := range map[string]string{"refs/remotes/origin/main": commit, "refs/tags/v1": tag} {
+		if err := refs.Update(repo.gitDir, name, hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo, commit, tag
+}
+
+func TestCheckoutCloneHead(t *testing.T) {
+	t.Run("branch", func(t *testing.T) {
+		repo, commit, _ := setUpCloneHead(t)
+		var opts cloneOptions
+		if err := writeRemoteHead(repo, opts, "refs/heads/main"); err != nil {
+			t.Fatalf("writeRemoteHead() error = %v", err)
+		}
+		if target, err := refs.ReadSymbolic(repo.gitDir, "refs/remotes/origin/HEAD"); err != nil || target != "refs/remotes/origin/main" {
+			t.Errorf("refs/remotes/origin/HEAD points to %q, %v", target, err)
+		}
+		if err := checkoutCloneHead(repo, opts, clone.GitRef{Name: "refs/heads/main", Hash: commit}); err != nil {
+			t.Fatalf("checkoutCloneHead() error = %v", err)
+		}
+		if target, err := refs.ReadSymbolic(repo.gitDir, "HEAD"); err != nil || target != "refs/heads/main" {
+			t.Errorf("HEAD points to %q, %v", target, err)
+		}
+		if hash, err := refs.Resolve(repo.gitDir, "refs/heads/main"); err != nil || hash != commit {
+			t.Errorf("main is at %s, %v, want %s", hash, err, commit)
+		}
+		if content, err := os.ReadFile("file"); err != nil || string(content) != "content\n" {
+			t.Errorf("the checked out file is %q, %v", content, err)
+		}
+		want := []string{"100644 d95f3ad14dee633a758d2e331151e950dd13e4ed file"}
+		if got := indexSummary(t, repo); !slices.Equal(got, want) {
+			t.Errorf("the index after the checkout is %q, want %q", got, want)
+		}
+	})
+
+	t.Run("tag", func(t *testing.T) {
+		repo, commit, tag := setUpCloneHead(t)
+		opts := cloneOptions{branch: "v1"}
+		if err := checkoutCloneHead(repo, opts, clone.GitRef{Name: "refs/tags/v1", Hash: tag}); err != nil {
+			t.Fatalf("checkoutCloneHead() error = %v", err)
+		}
+		// HEAD is detached at the commit of the tag
+		if _, err := refs.ReadSymbolic(repo.gitDir, "HEAD"); !errors.Is(err, refs.ErrNotSymbolic) {
+			t.Errorf("HEAD is not detached: %v", err)
+		}
+		if hash, err := refs.Resolve(repo.gitDir, "HEAD"); err != nil || hash != commit {
+			t.Errorf("HEAD is at %s, %v, want %s", hash, err, commit)
+		}
+		if _, err := os.Stat("file"); err != nil {
+			t.Errorf("the file of the tag is not checked out: %v", err)
+		}
+	})
+
+	t.Run("bare", func(t *testing.T) {
+		repo, commit, _ := setUpCloneHead(t)
+		opts := cloneOptions{bare: true}
+		if err := writeRemoteHead(repo, opts, "refs/heads/main"); err != nil {
+			t.Fatalf("writeRemoteHead() error = %v", err)
+		}
+		if _, err := os.Lstat(filepath.Join(repo.gitDir, "refs", "remotes", "origin", "HEAD")); !os.IsNotExist(err) {
+			t.Errorf("a bare clone has refs/remotes/origin/HEAD: %v", err)
+		}
+		if err := checkoutCloneHead(repo, opts, clone.GitRef{Name: "refs/heads/main", Hash: commit}); err != nil {
+			t.Fatalf("checkoutCloneHead() error = %v", err)
+		}
+		if _, err := os.Stat("file"); !os.IsNotExist(err) {
+			t.Errorf("a bare clone checks out the files: %v", err)
+		}
+	})
+}
@@ -1,28 +1,29 @@
 package main
 
 import (
-	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"slices"
+	"path/filepath"
+	"strings"
 
 	"github.com/codecrafters-io/git-starter-go/cmd/clone"
 	"github.com/codecrafters-io/git-starter-go/cmd/common"
+	"github.com/codecrafters-io/git-starter-go/cmd/refs"
 )
 
 // initCMD has the logic for the init subcommand
-func initCMD() error {
-	for _, dir := range []string{".git", ".git/objects", ".git/refs"} {
+func initCMD(repo *repository) error {
+	for _, dir := range []string{repo.gitDir, filepath.Join(repo.gitDir, "objects"), filepath.Join(repo.gitDir, "refs")} {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return fmt.Errorf("creating directory: %w", err)
 		}
 	}
 
-	headFileContents := []byte("ref: refs/heads/main\n")
-	if err := os.WriteFile(".git/HEAD", headFileContents, 0644); err != nil {
-		return fmt.Errorf("writing file: %w", err)
+	if err := refs.WriteSymbolic(repo.gitDir, "HEAD", "refs/heads/main"); err != nil {
+		return fmt.Errorf("writing HEAD: %w", err)
 	}
 
 	fmt.Println("Initialized git directory")
@@ -30,15 +31,10 @@ func initCMD() error {
 }
 
 // catFileCmd has the logic for the cat-file subcommand
-func catFileCmd(hash string) error {
-	file, err := common.GetFileFromHash(".", hash)
+func catFileCmd(repo *repository, hash string) error {
+	content, objectType, err := repo.objects().Get(hash)
 	if err != nil {
-		return fmt.Errorf("cat File command: get file from hash: %w", err)
-	}
-	defer file.Close()
-	content, objectType, err := common.ReadObjectFile(file)
-	if err != nil {
-		return fmt.Errorf("error in reading the object file: %s", err)
+		return fmt.Errorf("cat File command: read object: %w", err)
 	}
 	if objectType != "blob" {
 		return fmt.Errorf("the given hash object is not of type \"blob\" is %q", objectType)
@@ -48,7 +44,7 @@ func catFileCmd(hash string) error {
 }
 
 // hashObjectCmd has the logic for the hash-object subcommand
-func hashObjectCmd(fileName string) error {
+func hashObjectCmd(repo *repository, fileName string) error {
 	file, err := os.Open(fileName)
 	if err != nil {
 		return fmt.Errorf("error in opening the given file: %w", err)
@@ -58,32 +54,18 @@ func hashObjectCmd(fileName string) error {
 	if err != nil {
 		return fmt.Errorf("error in reading the given file: %w", err)
 	}
-	contentToWrite := common.FormatGitObjectContent("blob", fileContent)
-	fileSHA, err := common.CalculateEncodedSHA(contentToWrite)
+	fileSHA, err := repo.objects().Put("blob", fileContent)
 	if err != nil {
-		return fmt.Errorf("error in calculating the SHA: %w", err)
-	}
-	nFile, err := common.CreateEmptyObjectFile(".", fileSHA)
-	if err != nil {
-		return fmt.Errorf("error in creating the object file: %w", err)
-	}
-	err = common.WriteCompactContent(nFile, bytes.NewReader(contentToWrite))
-	if err != nil {
-		return fmt.Errorf("error in writing to the object file: %w", err)
+		return fmt.Errorf("error in writing the object: %w", err)
 	}
 	fmt.Printf("%s\n", fileSHA)
 	return nil
 }
 
-func lsTreeCmd(hash string) error {
-	file, err := common.GetFileFromHash(".", hash)
+func lsTreeCmd(repo *repository, hash string) error {
+	content, objectType, err := repo.objects().Get(hash)
 	if err != nil {
-		return fmt.Errorf("ls tree command: get file from hash: %w", err)
-	}
-	defer file.Close()
-	content, objectType, err := common.ReadObjectFile(file)
-	if err != nil {
-		return fmt.Errorf("error in reading the object file: %w", err)
+		return fmt.Errorf("ls tree command: read object: %w", err)
 	}
 	if objectType != "tree" {
 		return fmt.Errorf("fatal: not a tree object: %q", objectType)
@@ -98,8 +80,38 @@ func lsTreeCmd(hash string) error {
 	return nil
 }
 
-func writeTreeCmd() error {
-	treeSHA, err := WriteTree(".")
+// addCmd has the logic for the add subcommand
+//
+// Every path matching the pathspecs is hashed into a blob and staged in the index,
+// paths that are in the index but no longer present in the working directory are removed
+func addCmd(repo *repository, pathspecs []string) error {
+	idx, err := common.ReadIndex(repo.gitDir)
+	if err != nil {
+		return fmt.Errorf("add: %w", err)
+	}
+	rules, err := newIgnoreRules(repo)
+	if err != nil {
+		return fmt.Errorf("add: %w", err)
+	}
+	for _, pathspec := range pathspecs {
+		err := stagePathspec(repo, idx, rules, pathspec)
+		if err != nil {
+			return fmt.Errorf("add: %w", err)
+		}
+	}
+	err = common.WriteIndex(repo.gitDir, idx)
+	if err != nil {
+		return fmt.Errorf("add: %w", err)
+	}
+	return nil
+}
+
+func writeTreeCmd(repo *repository) error {
+	idx, err := common.ReadIndex(repo.gitDir)
+	if err != nil {
+		return fmt.Errorf("error in reading index: %w", err)
+	}
+	treeSHA, err := WriteTreeFromIndex(repo, idx)
 	if err != nil {
 		return fmt.Errorf("error in writing tree: %w", err)
 	}
@@ -107,84 +119,260 @@ func writeTreeCmd() error {
 	return nil
 }
 
-func commitTreeCmd(treeSHA, commitSHA, commitMsg string) error {
+func commitTreeCmd(repo *repository, treeSHA, commitSHA, commitMsg string) error {
 	if len(treeSHA) != 40 {
 		return fmt.Errorf("invalid treeSHA")
 	}
 	if len(commitSHA) != 40 {
 		return fmt.Errorf("invalid commitSHA")
 	}
-	content, err := WriteCommitContent(treeSHA, commitMsg, commitSHA)
+	content, err := WriteCommitContent(repo, treeSHA, commitMsg, commitSHA)
 	if err != nil {
 		return fmt.Errorf("write commit file: %w", err)
 	}
-	fullContent := common.FormatGitObjectContent("commit", content)
-	fullContentSHA, err := common.CalculateEncodedSHA(fullContent)
+	newCommitSHA, err := repo.objects().Put("commit", content)
 	if err != nil {
-		return fmt.Errorf("calculate full content sha: %w", err)
+		return fmt.Errorf("write object: %w", err)
 	}
-	file, err := common.CreateEmptyObjectFile(".", fullContentSHA)
+	fmt.Printf("%s", newCommitSHA)
+	return nil
+}
+
+// commitCmd has the logic for the commit subcommand
+//
+// It writes the tree from the index, uses the commit HEAD points to as the parent
+// (or creates a root commit when the branch is unborn) and moves the branch to the new commit
+func commitCmd(repo *repository, commitMsg string, allowEmpty bool) error {
+	if !repo.exists() {
+		return errNotRepository
+	}
+	// only the branch HEAD points to may be missing, when it is unborn
+	headRef, parentSHA, err := refs.Follow(repo.gitDir, "HEAD")
+	if err != nil && (!errors.Is(err, refs.ErrNotFound) || headRef == "HEAD") {
+		return fmt.Errorf("commit: %w", err)
+	}
+	idx, err := common.ReadIndex(repo.gitDir)
 	if err != nil {
-		return fmt.Errorf("create empty object file: %w", err)
+		return fmt.Errorf("commit: %w", err)
 	}
-	err = common.WriteCompactContent(file, bytes.NewReader(fullContent))
+	rawTreeSHA, err := WriteTreeFromIndex(repo, idx)
 	if err != nil {
-		return fmt.Errorf("write object file: %s", err)
+		return fmt.Errorf("commit: write tree: %w", err)
 	}
-	fmt.Printf("%s", fullContentSHA)
-	return nil
-}
+	treeSHA := hex.EncodeToString(rawTreeSHA[:])
 
-func cloneCmd(repoLink, dirToCloneAt string) error {
-	err := os.MkdirAll(dirToCloneAt, 0755)
+	var parents []string
+	if parentSHA != "" {
+		parentTreeSHA, err := GetTreeHashFromCommit(parentSHA, repo.objects())
+		if err != nil {
+			return fmt.Errorf("commit: read parent commit: %w", err)
+		}
+		if parentTreeSHA == treeSHA && !allowEmpty {
+			return fmt.Errorf("nothing to commit")
+		}
+		parents = append(parents, parentSHA)
+	}
 
-	if err != nil && !os.IsExist(err) {
-		return fmt.Errorf("create the dir to clone the repo: %w", err)
+	content, err := WriteCommitContent(repo, treeSHA, commitMsg, parents...)
+	if err != nil {
+		return fmt.Errorf("commit: write commit content: %w", err)
 	}
-	err = os.Chdir(dirToCloneAt)
+	rawCommitSHA, err := writeObject(repo, "commit", content)
 	if err != nil {
-		return fmt.Errorf("couldn't change the dir: %w", err)
+		return fmt.Errorf("commit: %w", err)
 	}
-	err = initCMD()
+	commitSHA := hex.EncodeToString(rawCommitSHA[:])
+	err = refs.UpdateNoDeref(repo.gitDir, headRef, commitSHA)
 	if err != nil {
-		return fmt.Errorf("couldn't initialize git: %w", err)
+		return fmt.Errorf("commit: %w", err)
 	}
 
-	gitRefResponse, err := clone.GitSmartProtocolGetRefs(repoLink)
-	if err != nil {
-		return fmt.Errorf("git smart protocol for ref fetching: %w", err)
+	branch := strings.TrimPrefix(headRef, "refs/heads/")
+	if headRef == "HEAD" {
+		branch = "detached HEAD"
 	}
+	if parentSHA == "" {
+		branch += " (root-commit)"
+	}
+	subject, _, _ := strings.Cut(commitMsg, "\n")
+	fmt.Printf("[%s %s] %s\n", branch, commitSHA[:7], subject)
+	return nil
+}
 
-	refs, err := clone.GetRefList(gitRefResponse)
-	if err != nil {
-		return fmt.Errorf("git smart protocol for ref list parsing: %w", err)
+// deepenOptions asks for the capabilities the deepen arguments need
+func deepenOptions(deepen clone.Deepen) clone.NegotiationOptions {
+	return clone.NegotiationOptions{
+		Shallow:     !deepen.IsZero(),
+		DeepenSince: !deepen.Since.IsZero(),
+		DeepenNot:   len(deepen.Not) > 0,
 	}
-	packfileContent, err := clone.RefDiscovery(repoLink, refs)
+}
+
+// indexPackCmd writes the .idx file for the pack file and prints the pack checksum
+func indexPackCmd(packPath string) error {
+	if !strings.HasSuffix(packPath, ".pack") {
+		return fmt.Errorf("index-pack: packfile name %q does not end with '.pack'", packPath)
+	}
+	checksum, err := clone.IndexPack(packPath)
 	if err != nil {
-		return fmt.Errorf("git smart protocol for ref discovery: %w", err)
+		return fmt.Errorf("index-pack: %w", err)
 	}
-	objects, err := clone.ReadPackFile(packfileContent)
+	fmt.Println(checksum)
+	return nil
+}
+
+// updateRefCmd has the logic for the update-ref subcommand
+func updateRefCmd(repo *repository, opts updateRefOptions) error {
+	if opts.delete {
+		if err := checkOldValue(repo, opts.name, opts.oldValue); err != nil {
+			return err
+		}
+		// like git, deleting a symbolic ref deletes the ref it points to
+		name := opts.name
+		if !opts.noDeref {
+			finalName, _, err := refs.Follow(repo.gitDir, name)
+			if err != nil && !errors.Is(err, refs.ErrNotFound) {
+				return fmt.Errorf("update-ref: %w", err)
+			}
+			name = finalName
+		}
+		return refs.Delete(repo.gitDir, name)
+	}
+	newHash, err := resolveRevision(repo, opts.newValue)
 	if err != nil {
+		return fmt.Errorf("update-ref: %w", err)
+	}
+	if err := checkOldValue(repo, opts.name, opts.oldValue); err != nil {
 		return err
 	}
-	err = clone.WriteObjects(dirToCloneAt, objects)
-	if err != nil {
+	if opts.noDeref {
+		return refs.UpdateNoDeref(repo.gitDir, opts.name, newHash)
+	}
+	return refs.Update(repo.gitDir, opts.name, newHash)
+}
+
+// checkOldValue verifies that the ref currently points to oldValue,
+// an all zero oldValue means the ref must not exist
+func checkOldValue(repo *repository, name, oldValue string) error {
+	if oldValue == "" {
+		return nil
+	}
+	current, err := refs.Resolve(repo.gitDir, name)
+	if err != nil && !errors.Is(err, refs.ErrNotFound) {
 		return err
 	}
-	headIdx := slices.IndexFunc(refs, func(ref clone.GitRef) bool {
-		return ref.Name == "HEAD"
-	})
-	if headIdx == -1 {
-		return fmt.Errorf("head index not found")
+	if oldValue == strings.Repeat("0", 40) {
+		if current != "" {
+			return fmt.Errorf("cannot lock ref %q: reference already exists", name)
+		}
+		return nil
+	}
+	if current != oldValue {
+		return fmt.Errorf("cannot lock ref %q: is at %q but expected %q", name, current, oldValue)
+	}
+	return nil
+}
+
+// symbolicRefCmd has the logic for the symbolic-ref subcommand
+func symbolicRefCmd(repo *repository, opts symbolicRefOptions) error {
+	switch {
+	case opts.delete:
+		if _, err := refs.ReadSymbolic(repo.gitDir, opts.name); err != nil {
+			return err
+		}
+		return refs.Delete(repo.gitDir, opts.name)
+	case opts.target != "":
+		return refs.WriteSymbolic(repo.gitDir, opts.name, opts.target)
 	}
-	headRef := refs[headIdx]
-	treeSHA, err := GetTreeHashFromCommit(headRef.Hash, ".")
+	target, err := refs.ReadSymbolic(repo.gitDir, opts.name)
 	if err != nil {
+		if opts.quiet && errors.Is(err, refs.ErrNotSymbolic) {
+			return errSilentExit
+		}
 		return err
 	}
-	err = RenderTree(treeSHA, ".", ".")
+	if opts.short {
+		target = shortRefName(target)
+	}
+	fmt.Println(target)
+	return nil
+}
+
+// showRefCmd has the logic for the show-ref subcommand
+func showRefCmd(repo *repository, opts showRefOptions) error {
+	refList, err := refs.List(repo.gitDir, "refs/")
 	if err != nil {
 		return err
 	}
+	if opts.head {
+		head, err := refs.Resolve(repo.gitDir, "HEAD")
+		if err == nil {
+			refList = append([]refs.Ref{{Name: "HEAD", Hash: head}}, refList...)
+		}
+	}
+
+	found := false
+	for _, ref := range refList {
+		if ref.Hash == "" || !showRefMatches(opts, ref.Name) {
+			continue
+		}
+		found = true
+		printShowRef(opts, ref.Hash, ref.Name)
+		if !opts.dereference || !strings.HasPrefix(ref.Name, "refs/tags/") {
+			continue
+		}
+		peeled := ref.Peeled
+		if peeled == "" {
+			peeled, err = peelTag(repo, ref.Hash)
+			if err != nil {
+				return err
+			}
+		}
+		if peeled != ref.Hash {
+			printShowRef(opts, peeled, ref.Name+"^{}")
+		}
+	}
+	if !found {
+		return errSilentExit
+	}
 	return nil
 }
+
+func showRefMatches(opts showRefOptions, name string) bool {
+	if opts.heads || opts.tags {
+		if !(opts.heads && strings.HasPrefix(name, "refs/heads/")) &&
+			!(opts.tags && strings.HasPrefix(name, "refs/tags/")) {
+			return false
+		}
+	}
+	if len(opts.patterns) == 0 {
+		return true
+	}
+	for _, pattern := range opts.patterns {
+		if name == pattern {
+			return true
+		}
+		if !opts.verify && strings.HasSuffix(name, "/"+pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func printShowRef(opts showRefOptions, hash, name string) {
+	if opts.hashOnly {
+		fmt.Println(hash)
+		return
+	}
+	fmt.Printf("%s %s\n", hash, name)
+}
+
+// shortRefName strips the well known prefixes from the ref name e.g. "refs/heads/main" -> "main"
+func shortRefName(name string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/", "refs/"} {
+		if short, ok := strings.CutPrefix(name, prefix); ok {
+			return short
+		}
+	}
+	return name
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/common"
+	"github.com/codecrafters-io/git-starter-go/cmd/refs"
+)
+
+// addTree is the tree git writes for the files of setUpAdd
+const addTree = "e25df6b73078c83dfe4e967c43beef40954a1504"
+
+// memoryRepository returns a repository in the current working directory with its objects
+// in memory
+func memoryRepository() *repository {
+	return &repository{gitDir: ".git", store: common.NewMemoryStore()}
+}
+
+// inTempRepository runs the test in a new repository with its objects in memory, and
+// without the global configuration of the user
+func inTempRepository(t *testing.T) *repository {
+	dir := t.TempDir()
+	previousDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(previousDir) })
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	repo := memoryRepository()
+	if err := os.MkdirAll(filepath.Join(repo.gitDir, "refs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := refs.WriteSymbolic(repo.gitDir, "HEAD", "refs/heads/main"); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func testBlob(t *testing.T, repo *repository, content string) [20]byte {
+	t.Helper()
+	hash, err := writeObject(repo, "blob", []byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func testTree(t *testing.T, repo *repository, entries ...GitTree) [20]byte {
+	t.Helper()
+	var content bytes.Buffer
+	if _, err := GitTrees(entries).WriteTo(&content); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := writeObject(repo, "tree", content.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func writeTestFile(t *testing.T, name, content string, mtime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setUpAdd writes files of every mode in nested directories and adds them all
+func setUpAdd(t *testing.T) *repository {
+	repo := inTempRepository(t)
+	past := time.Now().Add(-time.Hour)
+	for name, content := range map[string]string{
+		"a": "a\n", "dir/b": "b\n", "dir/sub/c": "c\n", "dir.txt": "t\n", "dir-z": "z\n",
+	} {
+		writeTestFile(t, name, content, past)
+	}
+	if err := os.Chmod("dir/sub/c", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a", "link"); err != nil {
+		t.Fatal(err)
+	}
+	if err := addCmd(repo, []string{"."}); err != nil {
+		t.Fatalf("add . error = %v", err)
+	}
+	return repo
+}
+
+// indexSummary lists the entries of the index as `mode hash name`, like `git ls-files -s`
+func indexSummary(t *testing.T, repo *repository) []string {
+	t.Helper()
+	idx, err := common.ReadIndex(repo.gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []string
+	for _, entry := range idx.Entries {
+		entries = append(entries, fmt.Sprintf("%o %x %s", entry.Mode, entry.SHA, entry.Name))
+	}
+	return entries
+}
+
+func TestAdd(t *testing.T) {
+	repo := setUpAdd(t)
+	want := []string{
+		"100644 78981922613b2afb6025042ff6bd878ac1994e85 a",
+		"100644 b68025345d5301abad4d9ec9166f455243a0d746 dir-z",
+		"100644 718f4d2ff533cf8ead8d3556cf43912bd245fbc4 dir.txt",
+		"100644 61780798228d17af2d34fce4cfbdf35556832472 dir/b",
+		"100755 f2ad6c76f0115a6ba5b00456a849810e7ec0af20 dir/sub/c",
+		"120000 2e65efe2a145dda7ee51d1741299f848e5bf752e link",
+	}
+	if got := indexSummary(t, repo); !slices.Equal(got, want) {
+		t.Fatalf("the index after add . is\n%q\nwant\n%q", got, want)
+	}
+
+	// git writes the same tree for the same index
+	idx, err := common.ReadIndex(repo.gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := WriteTreeFromIndex(repo, idx)
+	if err != nil {
+		t.Fatalf("WriteTreeFromIndex() error = %v", err)
+	}
+	if hash := hex.EncodeToString(tree[:]); hash != addTree {
+		t.Errorf("WriteTreeFromIndex() = %s, want the tree of git", hash)
+	}
+	sub := "61cec55b70920bc6c67aa1f5217bf1bbc49699d3"
+	if found, err := repo.objects().Has(sub); err != nil || !found {
+		t.Errorf("the tree dir/sub %s is not written: %v", sub, err)
+	}
+}
+
+func TestAddUnchanged(t *testing.T) {
+	repo := setUpAdd(t)
+	before, err := common.ReadIndex(repo.gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addCmd(repo, []string{"a", "dir"}); err != nil {
+		t.Fatalf("add a dir error = %v", err)
+	}
+	after, err := common.ReadIndex(repo.gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(after.Entries, before.Entries) {
+		t.Errorf("adding the unchanged files again changes the index from\n%+v\nto\n%+v", before.Entries, after.Entries)
+	}
+}
+
+func TestAddDeleted(t *testing.T) {
+	repo := setUpAdd(t)
+	for _, name := range []string{"a", "dir/b", "dir/sub/c"} {
+		if err := os.Remove(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a deleted file is matched by its index entry, and a directory by the entries under it
+	if err := addCmd(repo, []string{"a", "dir"}); err != nil {
+		t.Fatalf("add of the deleted paths error = %v", err)
+	}
+	want := []string{
+		"100644 b68025345d5301abad4d9ec9166f455243a0d746 dir-z",
+		"100644 718f4d2ff533cf8ead8d3556cf43912bd245fbc4 dir.txt",
+		"120000 2e65efe2a145dda7ee51d1741299f848e5bf752e link",
+	}
+	if got := indexSummary(t, repo); !slices.Equal(got, want) {
+		t.Errorf("the index after adding the deleted paths is\n%q\nwant\n%q", got, want)
+	}
+
+	for _, pathspec := range []string{"a", "missing", "../outside"} {
+		if err := addCmd(repo, []string{pathspec}); err == nil {
+			t.Errorf("add %s does not fail", pathspec)
+		}
+	}
+}
+
+func TestAddIgnored(t *testing.T) {
+	repo := setUpAdd(t)
+	past := time.Now().Add(-time.Hour)
+	writeTestFile(t, ".gitignore", "*.log\nbuild/\ndir/sub/\n", past)
+	for _, name := range []string{"x.log", "dir/y.log", "build/out", "dir/sub/d"} {
+		writeTestFile(t, name, name+"\n", past)
+	}
+	// a tracked file under an ignored directory is still staged
+	writeTestFile(t, "dir/sub/c", "changed\n", time.Now())
+	if err := addCmd(repo, []string{"."}); err != nil {
+		t.Fatalf("add . error = %v", err)
+	}
+	want := []string{
+		"100644 11a4c024a5440f2627edbf3b7f8fd1fb18011b64 .gitignore",
+		"100644 78981922613b2afb6025042ff6bd878ac1994e85 a",
+		"100644 b68025345d5301abad4d9ec9166f455243a0d746 dir-z",
+		"100644 718f4d2ff533cf8ead8d3556cf43912bd245fbc4 dir.txt",
+		"100644 61780798228d17af2d34fce4cfbdf35556832472 dir/b",
+		"100755 5ea2ed416fbd4a4cbe227b75fe255dd7fa6bd4d6 dir/sub/c",
+		"120000 2e65efe2a145dda7ee51d1741299f848e5bf752e link",
+	}
+	if got := indexSummary(t, repo); !slices.Equal(got, want) {
+		t.Errorf("the index after add . is\n%q\nwant\n%q", got, want)
+	}
+
+	// the ignored paths cannot be named, the tracked ones can
+	for _, pathspec := range []string{"x.log", "build", "build/out", "dir/sub", "dir/sub/d"} {
+		if err := addCmd(repo, []string{pathspec}); err == nil {
+			t.Errorf("add of the ignored %s does not fail", pathspec)
+		}
+	}
+	if err := addCmd(repo, []string{"dir/sub/c"}); err != nil {
+		t.Errorf("add of the tracked dir/sub/c error = %v", err)
+	}
+}
+
+// headCommit returns the commit the ref points to, parsed
+func headCommit(t *testing.T, repo *repository, name string) (string, *common.Commit) {
+	t.Helper()
+	hash, err := refs.Resolve(repo.gitDir, name)
+	if err != nil {
+		t.Fatalf("resolve %s: %v", name, err)
+	}
+	commit, err := readCommit(repo.objects(), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash, commit
+}
+
+func TestCommit(t *testing.T) {
+	repo := setUpAdd(t)
+	t.Setenv("GIT_AUTHOR_NAME", "A U Thor")
+	t.Setenv("GIT_AUTHOR_EMAIL", "author@example.com")
+
+	// the unborn branch gets a root commit
+	if err := commitCmd(repo, "first", false); err != nil {
+		t.Fatalf("commit on an unborn branch error = %v", err)
+	}
+	first, commit := headCommit(t, repo, "refs/heads/main")
+	if commit.Tree != addTree || len(commit.Parents) != 0 {
+		t.Errorf("the root commit has the tree %s and the parents %q", commit.Tree, commit.Parents)
+	}
+	if commit.Author.Name != "A U Thor" || commit.Message != "first\n" {
+		t.Errorf("the root commit is by %s with the message %q", commit.Author.Name, commit.Message)
+	}
+
+	// without a change there is nothing to commit unless empty commits are allowed
+	if err := commitCmd(repo, "nothing", false); err == nil || err.Error() != "nothing to commit" {
+		t.Errorf("commit without a change error = %v, want nothing to commit", err)
+	}
+	if hash, _ := headCommit(t, repo, "refs/heads/main"); hash != first {
+		t.Errorf("a failed commit moves main from %s to %s", first, hash)
+	}
+	if err := commitCmd(repo, "empty", true); err != nil {
+		t.Fatalf("commit --allow-empty error = %v", err)
+	}
+	empty, commit := headCommit(t, repo, "refs/heads/main")
+	if commit.Tree != addTree || !slices.Equal(commit.Parents, []string{first}) {
+		t.Errorf("the empty commit has the tree %s and the parents %q", commit.Tree, commit.Parents)
+	}
+
+	// the next commit has HEAD as its parent and moves the branch HEAD points to
+	writeTestFile(t, "a", "changed\n", time.Now())
+	if err := addCmd(repo, []string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := commitCmd(repo, "second", false); err != nil {
+		t.Fatalf("commit of a change error = %v", err)
+	}
+	second, commit := headCommit(t, repo, "refs/heads/main")
+	if !slices.Equal(commit.Parents, []string{empty}) || commit.Tree == addTree {
+		t.Errorf("the second commit has the tree %s and the parents %q", commit.Tree, commit.Parents)
+	}
+	if target, err := refs.ReadSymbolic(repo.gitDir, "HEAD"); err != nil || target != "refs/heads/main" {
+		t.Errorf("HEAD points to %q, %v after a commit", target, err)
+	}
+
+	// on a detached HEAD, HEAD moves and the branch stays
+	if err := refs.UpdateNoDeref(repo.gitDir, "HEAD", first); err != nil {
+		t.Fatal(err)
+	}
+	if err := commitCmd(repo, "detached", true); err != nil {
+		t.Fatalf("commit on a detached HEAD error = %v", err)
+	}
+	detached, commit := headCommit(t, repo, "HEAD")
+	if !slices.Equal(commit.Parents, []string{first}) || detached == first {
+		t.Errorf("the commit on a detached HEAD %s has the parents %q", detached, commit.Parents)
+	}
+	if hash, _ := headCommit(t, repo, "refs/heads/main"); hash != second {
+		t.Errorf("a commit on a detached HEAD moves main from %s to %s", second, hash)
+	}
+	if _, err := refs.ReadSymbolic(repo.gitDir, "HEAD"); !errors.Is(err, refs.ErrNotSymbolic) {
+		t.Errorf("HEAD is not detached after a commit on it: %v", err)
+	}
+}
+
+func TestCommitNotRepository(t *testing.T) {
+	repo := inTempRepository(t)
+	if err := os.RemoveAll(repo.gitDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := commitCmd(repo, "outside", true); !errors.Is(err, errNotRepository) {
+		t.Errorf("commit outside of a repository error = %v, want %v", err, errNotRepository)
+	}
+	if _, err := os.Stat(repo.gitDir); !os.IsNotExist(err) {
+		t.Errorf("commit outside of a repository creates .git: %v", err)
+	}
+}
+
+func TestCommitBrokenHead(t *testing.T) {
+	for name, content := range map[string]string{"missing": "", "broken": "not a ref\n"} {
+		t.Run(name, func(t *testing.T) {
+			repo := setUpAdd(t)
+			head := filepath.Join(repo.gitDir, "HEAD")
+			if err := os.Remove(head); err != nil {
+				t.Fatal(err)
+			}
+			if content != "" {
+				if err := os.WriteFile(head, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// unlike an unborn branch, a HEAD which is not a ref is not a root commit
+			if err := commitCmd(repo, "first", true); err == nil {
+				t.Errorf("commit with a %s HEAD does not fail", name)
+			}
+			if got, _ := os.ReadFile(head); string(got) != content {
+				t.Errorf("commit with a %s HEAD writes HEAD %q", name, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/config"
+)
+
+// configCmd has the logic for the config subcommand
+//
+// The values are read from the global files and the repository one, the last one wins,
+// while the changes go to the repository file unless --global or a file is given. Like
+// git, reading a variable which is not set exits with 1 and unsetting one exits with 5.
+func configCmd(repo *repository, opts configOptions) error {
+	switch opts.action {
+	case "get", "get-all", "list":
+		return readConfig(repo, opts)
+	}
+	path, err := configPath(repo, opts)
+	if err != nil {
+		return err
+	}
+	file, err := config.Open(path)
+	if err != nil {
+		return err
+	}
+	value := opts.value
+	if opts.valueType != "" && (opts.action == "set" || opts.action == "add") {
+		if value, err = typedValue(opts.name, value, opts.valueType); err != nil {
+			return err
+		}
+	}
+	switch opts.action {
+	case "set":
+		err = file.Set(opts.name, value)
+	case "add":
+		err = file.Add(opts.name, value)
+	case "unset":
+		err = file.Unset(opts.name)
+	case "unset-all":
+		err = file.UnsetAll(opts.name)
+	}
+	switch {
+	case errors.Is(err, config.ErrNotSet):
+		return exitStatus(5)
+	case errors.Is(err, config.ErrMultipleValues):
+		ePrintf("warning: %s has multiple values\n", opts.name)
+		if opts.action == "set" {
+			ePrintf("error: cannot overwrite multiple values with a single value\n"+
+				"       Use --add or --unset-all to change %s.\n", opts.name)
+		}
+		return exitStatus(5)
+	case err != nil:
+		return err
+	}
+	return file.Save()
+}
+
+// readConfig prints the value of the variable, all its values or all the variables
+func readConfig(repo *repository, opts configOptions) error {
+	var cfg *config.Config
+	var err error
+	switch {
+	case opts.file != "" || opts.global || opts.local:
+		path, pathErr := configPath(repo, opts)
+		if pathErr != nil {
+			return pathErr
+		}
+		cfg, err = config.Read(path)
+	default:
+		cfg, err = repo.config()
+	}
+	if err != nil {
+		return err
+	}
+
+	if opts.action == "list" {
+		for _, entry := range cfg.Entries {
+			fmt.Printf("%s=%s\n", entry.Name(), entry.Value)
+		}
+		return nil
+	}
+	values := cfg.GetAll(opts.name)
+	if len(values) == 0 {
+		return exitStatus(1)
+	}
+	if opts.action == "get" {
+		values = values[len(values)-1:]
+	}
+	for _, value := range values {
+		if opts.valueType != "" {
+			if value, err = typedValue(opts.name, value, opts.valueType); err != nil {
+				return err
+			}
+		}
+		fmt.Println(value)
+	}
+	return nil
+}
+
+// configPath returns the file the command writes to
+func configPath(repo *repository, opts configOptions) (string, error) {
+	switch {
+	case opts.file != "":
+		return opts.file, nil
+	case opts.global:
+		return config.GlobalPath()
+	case !repo.exists():
+		return "", fmt.Errorf("not in a git directory")
+	}
+	return filepath.Join(repo.gitDir, "config"), nil
+}
+
+// typedValue checks the value is of the type and returns its canonical form: true or
+// false for a bool, the number with its unit applied for an int, and the path with `~/`
+// expanded for a path
+func typedValue(name, value, valueType string) (string, error) {
+	switch valueType {
+	case "bool":
+		b, err := config.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("bad boolean config value '%s' for '%s'", value, name)
+		}
+		return strconv.FormatBool(b), nil
+	case "int":
+		n, err := config.ParseInt(value)
+		if err != nil {
+			return "", fmt.Errorf("bad numeric config value '%s' for '%s': %w", value, name, err)
+		}
+		return strconv.FormatInt(n, 10), nil
+	case "path":
+		return config.ExpandPath(value)
+	}
+	return value, nil
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/common"
+	"github.com/codecrafters-io/git-starter-go/cmd/diff"
+	"github.com/codecrafters-io/git-starter-go/cmd/refs"
+)
+
+// diffFile is a version of a file compared by diff
+type diffFile struct {
+	mode uint32
+	hash [20]byte
+	// content is the content of a working tree file, the other files are read from the
+	// object store when they are shown
+	content  []byte
+	worktree bool
+}
+
+// read returns the content of the file, a submodule shows the commit it is at
+func (f diffFile) read(repo *repository) ([]byte, error) {
+	switch {
+	case f.worktree:
+		return f.content, nil
+	case f.mode == 0160000:
+		return []byte(fmt.Sprintf("Subproject commit %s\n", hex.EncodeToString(f.hash[:]))), nil
+	}
+	content, _, err := repo.objects().Get(hex.EncodeToString(f.hash[:]))
+	return content, err
+}
+
+// diffCmd has the logic for the diff subcommand
+//
+// It compares the index with the working tree, a commit (HEAD by default) with the index
+// for --cached, a commit with the working tree, or two commits, and prints the changes as
+// a patch `git apply` takes. A file whose type changed is shown deleted then added, like
+// git does.
+func diffCmd(repo *repository, opts diffOptions) error {
+	out := bufio.NewWriter(os.Stdout)
+	if err := writeRepoDiff(out, repo, opts); err != nil {
+		return fmt.Errorf("diff: %w", err)
+	}
+	return out.Flush()
+}
+
+// writeRepoDiff writes the patches between the two sides the options compare
+func writeRepoDiff(out *bufio.Writer, repo *repository, opts diffOptions) error {
+	settings, err := diffSettings(repo, opts)
+	if err != nil {
+		return err
+	}
+	var old, new map[string]diffFile
+	switch {
+	case len(opts.revisions) == 2:
+		old, new, err = changedTreeFiles(repo, opts.revisions[0], opts.revisions[1])
+	case opts.cached:
+		revision := "HEAD"
+		if len(opts.revisions) == 1 {
+			revision = opts.revisions[0]
+		}
+		if old, err = treeFiles(repo, revision); err == nil {
+			new, err = indexFiles(repo)
+		}
+	case repo.gitDir == ".":
+		return fmt.Errorf("this operation must be run in a work tree")
+	case len(opts.revisions) == 1:
+		if old, err = treeFiles(repo, opts.revisions[0]); err == nil {
+			new, err = worktreeFiles(repo)
+		}
+	default:
+		if old, err = indexFiles(repo); err == nil {
+			new, err = worktreeFiles(repo)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	renames, detect, err := renameOptions(repo, opts.renames, true)
+	if err != nil {
+		return err
+	}
+	return writeDiff(repo, out, old, new, settings, renames, detect)
+}
+
+// diffSettings returns the options of the patches, the ones not given on the command
+// line come from diff.context and diff.algorithm
+func diffSettings(repo *repository, opts diffOptions) (diff.Options, error) {
+	cfg, err := repo.config()
+	if err != nil {
+		return diff.Options{}, err
+	}
+	settings := diff.Options{Context: opts.context}
+	if settings.Context < 0 {
+		context, err := cfg.GetInt("diff.context", 3)
+		if err != nil {
+			return settings, err
+		}
+		settings.Context = int(max(context, 0))
+	}
+	algorithm := opts.algorithm
+	if algorithm == "" {
+		algorithm, _ = cfg.Get("diff.algorithm")
+	}
+	if algorithm != "" {
+		if settings.Algorithm, err = diff.ParseAlgorithm(algorithm); err != nil {
+			return settings, err
+		}
+	}
+	return settings, nil
+}
+
+// renameOptions returns the settings of the rename detection, reading the blobs from the
+// object store, false when the renames are not detected. The flags win over the
+// configuration, diff.renames is only read for the porcelain commands and
+// diff.renameLimit when -l is not given.
+func renameOptions(repo *repository, flags renameFlags, porcelain bool) (diff.RenameOptions, bool, error) {
+	cfg, err := repo.config()
+	if err != nil {
+		return diff.RenameOptions{}, false, err
+	}
+	detect := flags.detect
+	if detect == "" && porcelain {
+		value, _ := cfg.Get("diff.renames")
+		switch strings.ToLower(value) {
+		case "copies", "copy":
+			detect = "copies"
+		default:
+			on, err := cfg.GetBool("diff.renames", true)
+			if err != nil {
+				return diff.RenameOptions{}, false, err
+			}
+			if on {
+				detect = "renames"
+			}
+		}
+	}
+	if detect != "renames" && detect != "copies" {
+		return diff.RenameOptions{}, false, nil
+	}
+	opts := diff.RenameOptions{
+		MinScore: diff.DefaultRenameScore,
+		Copies:   detect == "copies",
+		Limit:    flags.limit,
+		Read:     func(hash [20]byte) ([]byte, error) { return readBlob(repo, hash) },
+	}
+	if flags.score >= 0 {
+		opts.MinScore = flags.score
+	}
+	if opts.Limit < 0 {
+		limit, err := cfg.GetInt("diff.renameLimit", diff.DefaultRenameLimit)
+		if err != nil {
+			return opts, false, err
+		}
+		opts.Limit = int(limit)
+	}
+	return opts, true, nil
+}
+
+// detectRenames pairs the added files with the files they were renamed or copied from,
+// warning like git when there were too many files to compare their contents
+func detectRenames(changes []diff.Change, opts diff.RenameOptions) ([]diff.Change, error) {
+	changes, needed, err := diff.DetectRenames(changes, opts)
+	if err != nil {
+		return nil, err
+	}
+	if needed > 0 {
+		ePrintf("warning: exhaustive rename detection was skipped due to too many files.\n")
+		ePrintf("warning: you may want to set your diff.renameLimit variable to at least %d and retry the command.\n", needed)
+	}
+	return changes, nil
+}
+
+// readBlob returns the content of the blob with the given hash
+func readBlob(repo *repository, hash [20]byte) ([]byte, error) {
+	content, _, err := repo.objects().Get(hex.EncodeToString(hash[:]))
+	return content, err
+}
+
+// writeDiff writes the patches turning the old files into the new ones, by path, the
+// renamed and copied files are shown at their new path when they are detected
+func writeDiff(repo *repository, out *bufio.Writer, old, new map[string]diffFile, opts diff.Options, renames diff.RenameOptions, detect bool) error {
+	var names []string
+	for name := range old {
+		names = append(names, name)
+	}
+	for name := range new {
+		if _, ok := old[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	var changes []diff.Change
+	for _, name := range names {
+		oldFile, inOld := old[name]
+		newFile, inNew := new[name]
+		if inOld && inNew && oldFile.hash == newFile.hash && oldFile.mode == newFile.mode {
+			continue
+		}
+		changes = append(changes, diff.Change{
+			Status:  fileChange(oldFile.mode, newFile.mode, true),
+			OldPath: name, NewPath: name,
+			OldMode: oldFile.mode, NewMode: newFile.mode,
+			OldHash: oldFile.hash, NewHash: newFile.hash,
+		})
+	}
+	if detect {
+		worktree := map[[20]byte][]byte{}
+		for _, file := range new {
+			if file.worktree {
+				worktree[file.hash] = file.content
+			}
+		}
+		renames.Read = func(hash [20]byte) ([]byte, error) {
+			if content, ok := worktree[hash]; ok {
+				return content, nil
+			}
+			return readBlob(repo, hash)
+		}
+		var err error
+		if changes, err = detectRenames(changes, renames); err != nil {
+			return err
+		}
+	}
+
+	for _, change := range changes {
+		oldFile, newFile := old[change.OldPath], new[change.NewPath]
+		if change.Status == 'T' {
+			if err := writeFilePatch(repo, out, change, oldFile, diffFile{}, opts); err != nil {
+				return err
+			}
+			oldFile = diffFile{}
+		}
+		if err := writeFilePatch(repo, out, change, oldFile, newFile, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFilePatch writes the patch of a change, the zero diffFile stands for a missing side
+func writeFilePatch(repo *repository, out *bufio.Writer, change diff.Change, oldFile, newFile diffFile, opts diff.Options) error {
+	patch := diff.FilePatch{
+		OldPath: change.OldPath, NewPath: change.NewPath,
+		OldMode: oldFile.mode, NewMode: newFile.mode,
+		OldHash: oldFile.hash, NewHash: newFile.hash,
+		Copy: change.Status == 'C', Similarity: change.Similarity(),
+	}
+	var err error
+	if oldFile.hash != newFile.hash {
+		if oldFile.mode != 0 {
+			if patch.Old, err = oldFile.read(repo); err != nil {
+				return err
+			}
+		}
+		if newFile.mode != 0 {
+			if patch.New, err = newFile.read(repo); err != nil {
+				return err
+			}
+		}
+	}
+	return diff.WritePatch(out, patch, opts)
+}
+
+// treeFiles returns the files of the tree of the revision, none for the HEAD of an
+// unborn branch
+func treeFiles(repo *repository, revision string) (map[string]diffFile, error) {
+	files := map[string]diffFile{}
+	if revision == "HEAD" {
+		_, hash, err := refs.Follow(repo.gitDir, "HEAD")
+		if err != nil && !errors.Is(err, refs.ErrNotFound) {
+			return nil, err
+		}
+		if hash == "" {
+			return files, nil
+		}
+	}
+	treeHash, err := resolveTree(repo, revision)
+	if err != nil {
+		return nil, err
+	}
+	err = walkTree(repo, treeHash, "", func(name string, entry GitTree) error {
+		files[name] = diffFile{mode: gitModeValue(entry.GitMode), hash: entry.SHA}
+		return nil
+	})
+	return files, err
+}
+
+// changedTreeFiles returns the files which differ between the trees of the revisions,
+// the subtrees which are the same on both sides are not read
+func changedTreeFiles(repo *repository, oldRevision, newRevision string) (map[string]diffFile, map[string]diffFile, error) {
+	oldTree, err := resolveTree(repo, oldRevision)
+	if err != nil {
+		return nil, nil, err
+	}
+	newTree, err := resolveTree(repo, newRevision)
+	if err != nil {
+		return nil, nil, err
+	}
+	changes, err := diffTrees(repo, oldTree, newTree, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	old, new := map[string]diffFile{}, map[string]diffFile{}
+	for _, change := range changes {
+		if change.OldMode != 0 {
+			old[change.OldPath] = diffFile{mode: change.OldMode, hash: change.OldHash}
+		}
+		if change.NewMode != 0 {
+			new[change.NewPath] = diffFile{mode: change.NewMode, hash: change.NewHash}
+		}
+	}
+	return old, new, nil
+}
+
+// indexFiles returns the files staged in the index, the unmerged ones are left out
+func indexFiles(repo *repository) (map[string]diffFile, error) {
+	idx, err := common.ReadIndex(repo.gitDir)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]diffFile{}
+	for _, entry := range idx.Entries {
+		if entry.Stage() == 0 {
+			files[entry.Name] = diffFile{mode: entry.Mode, hash: entry.SHA}
+		}
+	}
+	return files, nil
+}
+
+// worktreeFiles returns the files of the working tree which are in the index, the ones
+// whose stat data did not change since they were staged are taken from the index
+func worktreeFiles(repo *repository) (map[string]diffFile, error) {
+	idx, err := common.ReadIndex(repo.gitDir)
+	if err != nil {
+		return nil, err
+	}
+	checker, err := newWorktreeChecker(repo)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]diffFile{}
+	for _, entry := range idx.Entries {
+		if entry.Stage() != 0 {
+			continue
+		}
+		change, mode, err := checker.check(entry)
+		switch {
+		case err != nil:
+			return nil, err
+		case change == 'D':
+			continue
+		case change == ' ':
+			files[entry.Name] = diffFile{mode: entry.Mode, hash: entry.SHA}
+			continue
+		}
+		info, err := os.Lstat(entry.Name)
+		if err != nil {
+			return nil, err
+		}
+		content, hash, err := readWorktreeFile(entry.Name, info)
+		if err != nil {
+			return nil, err
+		}
+		files[entry.Name] = diffFile{mode: mode, hash: hash, content: content, worktree: true}
+	}
+	return files, nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/common"
+	"github.com/codecrafters-io/git-starter-go/cmd/refs"
+)
+
+// setUpDiff builds a repository whose HEAD, index and working tree all differ, and a
+// branch next whose tree is the one of the index
+//
+// HEAD has the regular files kept, link and removed. The index changes kept, adds added,
+// turns link into a symlink and drops removed. In the working tree kept changes again and
+// added is deleted. The type change of link is shown as a delete then an add.
+func setUpDiff(t *testing.T) *repository {
+	repo := inTempRepository(t)
+	past := time.Now().Add(-time.Hour)
+	testCommit(t, repo, testTree(t, repo,
+		GitTree{GitMode: "100644", Name: "kept", SHA: testBlob(t, repo, "one\ntwo\n")},
+		GitTree{GitMode: "100644", Name: "link", SHA: testBlob(t, repo, "kept\n")},
+		GitTree{GitMode: "100644", Name: "removed", SHA: testBlob(t, repo, "gone\n")},
+	))
+
+	idx := &common.Index{Version: 2}
+	writeTestFile(t, "added", "new\n", past)
+	stageTestFile(t, repo, idx, "added", "new\n")
+	writeTestFile(t, "kept", "one\n2\n", past)
+	stageTestFile(t, repo, idx, "kept", "one\n2\n")
+	if err := os.Symlink("kept", "link"); err != nil {
+		t.Fatal(err)
+	}
+	stageTestFile(t, repo, idx, "link", "kept")
+	if err := common.WriteIndex(repo.gitDir, idx); err != nil {
+		t.Fatal(err)
+	}
+	tree := testTree(t, repo,
+		GitTree{GitMode: "100644", Name: "added", SHA: testBlob(t, repo, "new\n")},
+		GitTree{GitMode: "100644", Name: "kept", SHA: testBlob(t, repo, "one\n2\n")},
+		GitTree{GitMode: "120000", Name: "link", SHA: testBlob(t, repo, "kept")},
+	)
+	next, err := writeObject(repo, "commit", fmt.Appendf(nil,
+		"tree %s\nauthor A U Thor <author@example.com> 1700000000 +0000\n"+
+			"committer A U Thor <author@example.com> 1700000000 +0000\n\nnext\n", hex.EncodeToString(tree[:])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := refs.Update(repo.gitDir, "refs/heads/next", hex.EncodeToString(next[:])); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, "kept", "one\n2\nthree\n", past)
+	if err := os.Remove("added"); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestDiff(t *testing.T) {
+	repo := setUpDiff(t)
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{
+			// the index with the working tree
+			args: nil,
+			want: `diff --git a/added b/added
+deleted file mode 100644
+index 3e75765..0000000
+--- a/added
++++ /dev/null
+@@ -1 +0,0 @@
+-new
+diff --git a/kept b/kept
+index 99b356d..f04eb26 100644
+--- a/kept
++++ b/kept
+@@ -1,2 +1,3 @@
+ one
+ 2
++three
+`,
+		},
+		{
+			// HEAD with the index
+			args: []string{"--cached"},
+			want: `diff --git a/added b/added
+new file mode 100644
+index 0000000..3e75765
+--- /dev/null
++++ b/added
+@@ -0,0 +1 @@
++new
+diff --git a/kept b/kept
+index 814f4a4..99b356d 100644
+--- a/kept
++++ b/kept
+@@ -1,2 +1,2 @@
+ one
+-two
++2
+diff --git a/link b/link
+deleted file mode 100644
+index bd93009..0000000
+--- a/link
++++ /dev/null
+@@ -1 +0,0 @@
+-kept
+diff --git a/link b/link
+new file mode 120000
+index 0000000..1cff52e
+--- /dev/null
++++ b/link
+@@ -0,0 +1 @@
++kept
+\ No newline at end of file
+diff --git a/removed b/removed
+deleted file mode 100644
+index 286c5f5..0000000
+--- a/removed
++++ /dev/null
+@@ -1 +0,0 @@
+-gone
+`,
+		},
+		{
+			// a commit with the working tree
+			args: []string{"HEAD"},
+			want: `diff --git a/kept b/kept
+index 814f4a4..f04eb26 100644
+--- a/kept
++++ b/kept
+@@ -1,2 +1,3 @@
+ one
+-two
++2
++three
+diff --git a/link b/link
+deleted file mode 100644
+index bd93009..0000000
+--- a/link
++++ /dev/null
+@@ -1 +0,0 @@
+-kept
+diff --git a/link b/link
+new file mode 120000
+index 0000000..1cff52e
+--- /dev/null
++++ b/link
+@@ -0,0 +1 @@
++kept
+\ No newline at end of file
+diff --git a/removed b/removed
+deleted file mode 100644
+index 286c5f5..0000000
+--- a/removed
++++ /dev/null
+@@ -1 +0,0 @@
+-gone
+`,
+		},
+		{
+			// two commits
+			args: []string{"next", "HEAD"},
+			want: `diff --git a/added b/added
+deleted file mode 100644
+index 3e75765..0000000
+--- a/added
++++ /dev/null
+@@ -1 +0,0 @@
+-new
+diff --git a/kept b/kept
+index 99b356d..814f4a4 100644
+--- a/kept
++++ b/kept
+@@ -1,2 +1,2 @@
+ one
+-2
++two
+diff --git a/link b/link
+deleted file mode 120000
+index 1cff52e..0000000
+--- a/link
++++ /dev/null
+@@ -1 +0,0 @@
+-kept
+\ No newline at end of file
+diff --git a/link b/link
+new file mode 100644
+index 0000000..bd93009
+--- /dev/null
++++ b/link
+@@ -0,0 +1 @@
++kept
+diff --git a/removed b/removed
+new file mode 100644
+index 0000000..286c5f5
+--- /dev/null
++++ b/removed
+@@ -0,0 +1 @@
++gone
+`,
+		},
+	}
+	for _, test := range tests {
+		opts, err := parseDiffArgs(test.args)
+		if err != nil {
+			t.Fatalf("parseDiffArgs(%q) error = %v", test.args, err)
+		}
+		var out bytes.Buffer
+		w := bufio.NewWriter(&out)
+		if err := writeRepoDiff(w, repo, opts); err != nil {
+			t.Fatalf("diff %s error = %v", strings.Join(test.args, " "), err)
+		}
+		w.Flush()
+		if out.String() != test.want {
+			t.Errorf("diff %s gives\n%s\nwant\n%s", strings.Join(test.args, " "), out.String(), test.want)
+		}
+	}
+}
+
+func TestDiffUnbornBranch(t *testing.T) {
+	repo := inTempRepository(t)
+	idx := &common.Index{Version: 2}
+	writeTestFile(t, "file", "content\n", time.Now().Add(-time.Hour))
+	stageTestFile(t, repo, idx, "file", "content\n")
+	if err := common.WriteIndex(repo.gitDir, idx); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := parseDiffArgs([]string{"--cached"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	if err := writeRepoDiff(w, repo, opts); err != nil {
+		t.Fatalf("diff --cached error = %v", err)
+	}
+	w.Flush()
+	want := `diff --git a/file b/file
+new file mode 100644
+index 0000000..d95f3ad
+--- /dev/null
++++ b/file
+@@ -0,0 +1 @@
++content
+`
+	if out.String() != want {
+		t.Errorf("diff --cached on an unborn branch gives\n%s\nwant\n%s", out.String(), want)
+	}
+}
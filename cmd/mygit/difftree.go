@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/common"
+	"github.com/codecrafters-io/git-starter-go/cmd/diff"
+)
+
+// treeDiffer compares two trees, recursive descends into the subtrees which differ
+// rather than reporting them as a whole
+type treeDiffer struct {
+	repo      *repository
+	recursive bool
+	changes   []diff.Change
+}
+
+// diffTrees returns the entries which differ between the trees, in the tree order, an
+// empty hash stands for the empty tree. The changes have the same old and new path.
+//
+// The trees are walked in parallel like git does, so the subtrees with the same hash on
+// both sides are skipped without being read. A file replaced by a directory is reported
+// as deleted then added, since they do not sort at the same place.
+func diffTrees(repo *repository, oldTree, newTree string, recursive bool) ([]diff.Change, error) {
+	differ := &treeDiffer{repo: repo, recursive: recursive}
+	if err := differ.compareTrees(oldTree, newTree, ""); err != nil {
+		return nil, err
+	}
+	return differ.changes, nil
+}
+
+func (d *treeDiffer) compareTrees(oldTree, newTree, prefix string) error {
+	var oldEntries, newEntries []GitTree
+	var err error
+	if oldTree != "" {
+		if oldEntries, err = readTree(d.repo, oldTree); err != nil {
+			return err
+		}
+	}
+	if newTree != "" {
+		if newEntries, err = readTree(d.repo, newTree); err != nil {
+			return err
+		}
+	}
+	for i, j := 0, 0; i < len(oldEntries) || j < len(newEntries); {
+		var oldEntry, newEntry *GitTree
+		switch {
+		case j == len(newEntries) || i < len(oldEntries) && oldEntries[i].sortName() < newEntries[j].sortName():
+			oldEntry = &oldEntries[i]
+			i++
+		case i == len(oldEntries) || newEntries[j].sortName() < oldEntries[i].sortName():
+			newEntry = &newEntries[j]
+			j++
+		default:
+			oldEntry, newEntry = &oldEntries[i], &newEntries[j]
+			i++
+			j++
+		}
+		if err := d.compareEntries(prefix, oldEntry, newEntry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compareEntries compares the entries of the same name, either of them can be missing
+// but when both are there they are both trees or both not trees
+func (d *treeDiffer) compareEntries(prefix string, oldEntry, newEntry *GitTree) error {
+	if oldEntry != nil && newEntry != nil && oldEntry.SHA == newEntry.SHA && oldEntry.GitMode == newEntry.GitMode {
+		return nil
+	}
+	entry := oldEntry
+	if entry == nil {
+		entry = newEntry
+	}
+	name := path.Join(prefix, entry.Name)
+	change, isTree := diff.Change{OldPath: name, NewPath: name}, false
+	var oldTree, newTree string
+	if oldEntry != nil {
+		change.OldMode, change.OldHash = gitModeValue(oldEntry.GitMode), oldEntry.SHA
+		if isTree = oldEntry.GitMode == "40000"; isTree {
+			oldTree = hex.EncodeToString(oldEntry.SHA[:])
+		}
+	}
+	if newEntry != nil {
+		change.NewMode, change.NewHash = gitModeValue(newEntry.GitMode), newEntry.SHA
+		if isTree = newEntry.GitMode == "40000"; isTree {
+			newTree = hex.EncodeToString(newEntry.SHA[:])
+		}
+	}
+	if isTree && d.recursive {
+		return d.compareTrees(oldTree, newTree, name)
+	}
+	change.Status = fileChange(change.OldMode, change.NewMode, true)
+	d.changes = append(d.changes, change)
+	return nil
+}
+
+// diffTreeCmd has the logic for the diff-tree subcommand
+//
+// It prints the entries which differ between the two trees, commits or tags, in the raw
+// format by default: the old and new modes and hashes, the status and the path. Without
+// -r a subtree which differs is printed as one entry. With -M or -C the renamed and the
+// copied files are printed with their similarity and both their paths.
+func diffTreeCmd(repo *repository, opts diffTreeOptions) error {
+	oldTree, err := resolveTree(repo, opts.trees[0])
+	if err != nil {
+		return fmt.Errorf("diff-tree: %w", err)
+	}
+	newTree, err := resolveTree(repo, opts.trees[1])
+	if err != nil {
+		return fmt.Errorf("diff-tree: %w", err)
+	}
+	changes, err := diffTrees(repo, oldTree, newTree, opts.recursive)
+	if err != nil {
+		return fmt.Errorf("diff-tree: %w", err)
+	}
+	renames, detect, err := renameOptions(repo, opts.renames, false)
+	if err != nil {
+		return fmt.Errorf("diff-tree: %w", err)
+	}
+	if detect {
+		if changes, err = detectRenames(changes, renames); err != nil {
+			return fmt.Errorf("diff-tree: %w", err)
+		}
+	}
+	out := bufio.NewWriter(os.Stdout)
+	for _, change := range changes {
+		status := string(change.Status)
+		name := common.QuotePath(change.NewPath, false)
+		if change.Status == 'R' || change.Status == 'C' {
+			status = fmt.Sprintf("%c%03d", change.Status, change.Similarity())
+			name = common.QuotePath(change.OldPath, false) + "\t" + name
+		}
+		if opts.format == "name-status" {
+			fmt.Fprintf(out, "%s\t%s\n", status, name)
+			continue
+		}
+		fmt.Fprintf(out, ":%06o %06o %s %s %s\t%s\n", change.OldMode, change.NewMode,
+			hex.EncodeToString(change.OldHash[:]), hex.EncodeToString(change.NewHash[:]), status, name)
+	}
+	return out.Flush()
+}
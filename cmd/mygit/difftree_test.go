@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDiffTrees(t *testing.T) {
+	repo := memoryRepository()
+	one, two := testBlob(t, repo, "one\n"), testBlob(t, repo, "two\n")
+	// the subtree is the same on both sides and missing from the store, reading it fails
+	unchanged := [20]byte{0xde, 0xad}
+	oldTree := testTree(t, repo,
+		GitTree{GitMode: "100644", Name: "deleted", SHA: one},
+		GitTree{GitMode: "40000", Name: "dir", SHA: testTree(t, repo,
+			GitTree{GitMode: "100644", Name: "a", SHA: one},
+			GitTree{GitMode: "100644", Name: "b", SHA: one},
+		)},
+		GitTree{GitMode: "100644", Name: "exec", SHA: one},
+		GitTree{GitMode: "100644", Name: "link", SHA: one},
+		GitTree{GitMode: "100644", Name: "x", SHA: one},
+		GitTree{GitMode: "40000", Name: "same", SHA: unchanged},
+	)
+	newTree := testTree(t, repo,
+		GitTree{GitMode: "100644", Name: "added", SHA: two},
+		GitTree{GitMode: "40000", Name: "dir", SHA: testTree(t, repo,
+			GitTree{GitMode: "100644", Name: "a", SHA: two},
+			GitTree{GitMode: "100644", Name: "b", SHA: one},
+		)},
+		GitTree{GitMode: "100755", Name: "exec", SHA: one},
+		GitTree{GitMode: "120000", Name: "link", SHA: one},
+		GitTree{GitMode: "40000", Name: "same", SHA: unchanged},
+		GitTree{GitMode: "40000", Name: "x", SHA: testTree(t, repo,
+			GitTree{GitMode: "100644", Name: "y", SHA: two},
+		)},
+	)
+
+	tests := []struct {
+		recursive bool
+		want      []string
+	}{
+		{
+			recursive: false,
+			want: []string{
+				"A 000000 100644 added", "D 100644 000000 deleted", "M 040000 040000 dir",
+				"M 100644 100755 exec", "T 100644 120000 link", "D 100644 000000 x",
+				"A 000000 040000 x",
+			},
+		},
+		{
+			recursive: true,
+			want: []string{
+				"A 000000 100644 added", "D 100644 000000 deleted", "M 100644 100644 dir/a",
+				"M 100644 100755 exec", "T 100644 120000 link", "D 100644 000000 x",
+				"A 000000 100644 x/y",
+			},
+		},
+	}
+	for _, test := range tests {
+		changes, err := diffTrees(repo, hex.EncodeToString(oldTree[:]), hex.EncodeToString(newTree[:]), test.recursive)
+		if err != nil {
+			t.Fatalf("diffTrees(recursive=%v) error = %v", test.recursive, err)
+		}
+		var got []string
+		for _, change := range changes {
+			got = append(got, fmt.Sprintf("%c %06o %06o %s", change.Status, change.OldMode, change.NewMode, change.NewPath))
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("diffTrees(recursive=%v) gives\n%s\nwant\n%s", test.recursive,
+				strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+}
+
+func TestDiffTreesEmpty(t *testing.T) {
+	repo := memoryRepository()
+	tree := testTree(t, repo, GitTree{GitMode: "40000", Name: "dir", SHA: testTree(t, repo,
+		GitTree{GitMode: "100644", Name: "file", SHA: testBlob(t, repo, "content\n")},
+	)})
+	changes, err := diffTrees(repo, "", hex.EncodeToString(tree[:]), true)
+	if err != nil || len(changes) != 1 || changes[0].Status != 'A' || changes[0].NewPath != "dir/file" {
+		t.Errorf("diffTrees() from the empty tree = %+v, %v", changes, err)
+	}
+	changes, err = diffTrees(repo, hex.EncodeToString(tree[:]), hex.EncodeToString(tree[:]), true)
+	if err != nil || len(changes) != 0 {
+		t.Errorf("diffTrees() of the same tree = %+v, %v", changes, err)
+	}
+}
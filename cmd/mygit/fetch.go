@@ -0,0 +1,551 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/clone"
+	"github.com/codecrafters-io/git-starter-go/cmd/common"
+	"github.com/codecrafters-io/git-starter-go/cmd/config"
+	"github.com/codecrafters-io/git-starter-go/cmd/refs"
+)
+
+type fetchOptions struct {
+	// remote is the name of a configured remote or a URL, empty for the default remote
+	remote   string
+	refspecs []string
+	prune    bool
+	quiet    bool
+	// deepen changes the depth of the history of a shallow repository
+	deepen clone.Deepen
+}
+
+// refUpdate is a remote ref matched by a refspec
+type refUpdate struct {
+	remote clone.GitRef
+	// local is the ref the remote one is stored to, empty when it is only written to
+	// FETCH_HEAD
+	local string
+	force bool
+	// merge marks the ref to merge in FETCH_HEAD
+	merge bool
+}
+
+// fetchCmd has the logic for the fetch subcommand
+//
+// The refs of the remote matching the refspecs are fetched, only the objects missing from
+// the repository are downloaded: the local commits are offered as haves so that the server
+// can leave out what is already here. The local refs are then updated as the refspecs say,
+// a ref which does not fast-forward is only updated with a `+` refspec.
+func fetchCmd(repo *repository, opts fetchOptions) error {
+	cfg, err := repo.config()
+	if err != nil {
+		return fmt.Errorf("fetch: %w", err)
+	}
+	remoteName, repoLink, refspecs, err := fetchRemote(repo, cfg, opts)
+	if err != nil {
+		return fmt.Errorf("fetch: %w", err)
+	}
+
+	discovery, err := clone.Discover(repoLink)
+	if err != nil {
+		return fmt.Errorf("fetch: %w", err)
+	}
+	remoteRefs := discovery.Refs
+	if discovery.Version == 2 {
+		remoteRefs, err = clone.LsRefs(repoLink, discovery.Capabilities, refPrefixes(refspecs)...)
+		if err != nil {
+			return fmt.Errorf("fetch: %w", err)
+		}
+	}
+	// the refs named on the command line are merged, as is HEAD when fetching from a URL
+	updates, err := matchRefspecs(remoteRefs, refspecs, len(opts.refspecs) > 0 || remoteName == "")
+	if err != nil {
+		return fmt.Errorf("fetch: %w", err)
+	}
+	if len(opts.refspecs) == 0 {
+		markMergeRef(repo, cfg, remoteName, updates)
+	}
+
+	shallow, err := common.ReadShallow(repo.gitDir)
+	if err != nil {
+		return fmt.Errorf("fetch: %w", err)
+	}
+	// the fetches from the promisor remote of a partial clone are filtered like the clone
+	promisor, err := cfg.GetBool("remote."+remoteName+".promisor", false)
+	if err != nil {
+		return fmt.Errorf("fetch: %w", err)
+	}
+	filter := ""
+	if promisor {
+		filter, _ = cfg.Get("remote." + remoteName + ".partialclonefilter")
+	}
+	var wants []string
+	for _, update := range updates {
+		has, err := repo.objects().Has(update.remote.Hash)
+		if err != nil {
+			return fmt.Errorf("fetch: %w", err)
+		}
+		// deepening also asks for the refs which are here, to get more of their history
+		if (!has || !opts.deepen.IsZero()) && !slices.Contains(wants, update.remote.Hash) {
+			wants = append(wants, update.remote.Hash)
+		}
+	}
+	if len(wants) > 0 {
+		progressOut := io.Writer(os.Stderr)
+		if opts.quiet {
+			progressOut = io.Discard
+		}
+		options := deepenOptions(opts.deepen)
+		options.Shallow = options.Shallow || len(shallow) > 0
+		options.NoProgress = opts.quiet
+		options.Filter = filter != ""
+		negotiated := clone.NegotiateCapabilities(discovery.Capabilities, options)
+		haves, err := newLocalHaves(repo)
+		if err != nil {
+			return fmt.Errorf("fetch: %w", err)
+		}
+		response, err := clone.FetchPack(repoLink, discovery, clone.FetchRequest{
+			Wants:        wants,
+			Haves:        haves,
+			Capabilities: negotiated,
+			Shallow:      shallow,
+			Deepen:       opts.deepen,
+			Filter:       filter,
+		})
+		if err != nil {
+			return fmt.Errorf("fetch: %w", err)
+		}
+		defer response.Close()
+		packPath, err := clone.StorePack(".", response, progressOut)
+		if err != nil {
+			return fmt.Errorf("fetch: %w", err)
+		}
+		if promisor {
+			if err := markPromisorPack(packPath); err != nil {
+				return fmt.Errorf("fetch: %w", err)
+			}
+		}
+		if err := common.UpdateShallow(repo.gitDir, response.Shallow, response.Unshallow); err != nil {
+			return fmt.Errorf("fetch: %w", err)
+		}
+	}
+
+	report := &fetchReport{url: repoLink, quiet: opts.quiet}
+	rejected := false
+	for _, update := range updates {
+		ok, err := applyRefUpdate(repo, update, report)
+		if err != nil {
+			return fmt.Errorf("fetch: %w", err)
+		}
+		rejected = rejected || !ok
+	}
+	// like git, the tags are only followed when the fetched refs are stored somewhere
+	storesRefs := slices.ContainsFunc(updates, func(update refUpdate) bool {
+		return update.local != ""
+	})
+	if storesRefs {
+		if err := followTags(repo, remoteRefs, updates, report); err != nil {
+			return fmt.Errorf("fetch: %w", err)
+		}
+	}
+	if opts.prune {
+		if err := pruneRefs(repo, remoteRefs, refspecs, report); err != nil {
+			return fmt.Errorf("fetch: %w", err)
+		}
+	}
+	if err := writeFetchHead(repo, repoLink, updates); err != nil {
+		return fmt.Errorf("fetch: %w", err)
+	}
+	if rejected {
+		return fmt.Errorf("error: some local refs could not be updated")
+	}
+	return nil
+}
+
+// fetchRemote finds the remote to fetch from and the refspecs to use
+//
+// Without a remote on the command line it is the remote of the current branch, or origin.
+// A remote which is not configured can be given as a URL, only HEAD is fetched from it
+// unless refspecs are given.
+func fetchRemote(repo *repository, cfg *config.Config, opts fetchOptions) (string, string, []refs.Refspec, error) {
+	remoteName := opts.remote
+	if remoteName == "" {
+		remoteName = "origin"
+		if branch, err := refs.ReadSymbolic(repo.gitDir, "HEAD"); err == nil {
+			short := strings.TrimPrefix(branch, "refs/heads/")
+			if remote, ok := cfg.Get("branch." + short + ".remote"); ok {
+				remoteName = remote
+			}
+		}
+	}
+
+	specs := opts.refspecs
+	repoLink, configured := cfg.Get("remote." + remoteName + ".url")
+	switch {
+	case configured && len(specs) == 0:
+		specs = cfg.GetAll("remote." + remoteName + ".fetch")
+	case !configured && strings.Contains(remoteName, "://"):
+		repoLink, remoteName = remoteName, ""
+		if len(specs) == 0 {
+			specs = []string{"HEAD"}
+		}
+	case !configured:
+		return "", "", nil, fmt.Errorf("'%s' does not appear to be a git repository", remoteName)
+	}
+
+	refspecs := make([]refs.Refspec, 0, len(specs))
+	for _, spec := range specs {
+		refspec, err := refs.ParseRefspec(spec)
+		if err != nil {
+			return "", "", nil, err
+		}
+		refspecs = append(refspecs, refspec)
+	}
+	return remoteName, strings.TrimSuffix(repoLink, "/"), refspecs, nil
+}
+
+// refPrefixes are the ref-prefix arguments of ls-refs for the refspecs, along with the
+// tags which are followed
+func refPrefixes(refspecs []refs.Refspec) []string {
+	prefixes := []string{"refs/tags/"}
+	for _, refspec := range refspecs {
+		switch {
+		case refspec.Negative:
+			// the refs it excludes are still listed
+		case refspec.IsGlob():
+			prefix, _, _ := strings.Cut(refspec.Src, "*")
+			prefixes = append(prefixes, prefix)
+		case strings.HasPrefix(refspec.Src, "refs/") || refspec.Src == "HEAD":
+			prefixes = append(prefixes, refspec.Src)
+		default:
+			prefixes = append(prefixes,
+				"refs/"+refspec.Src, "refs/heads/"+refspec.Src, "refs/tags/"+refspec.Src)
+		}
+	}
+	return prefixes
+}
+
+// matchRefspecs maps the remote refs to local refs, a refspec without a pattern must
+// match a remote ref. With merge, the refs of the refspecs without a pattern are the
+// ones to merge. The remote refs matched by a negative refspec are left out.
+func matchRefspecs(remoteRefs []clone.GitRef, refspecs []refs.Refspec, merge bool) ([]refUpdate, error) {
+	var updates []refUpdate
+	for _, refspec := range refspecs {
+		if refspec.Negative {
+			continue
+		}
+		matched := false
+		for _, remote := range remoteRefs {
+			if strings.HasSuffix(remote.Name, "^{}") || refs.Excluded(refspecs, remote.Name) {
+				continue
+			}
+			local, ok := refspec.Match(remote.Name)
+			if !ok {
+				continue
+			}
+			matched = true
+			updates = append(updates, refUpdate{
+				remote: remote,
+				local:  local,
+				force:  refspec.Force,
+				merge:  merge && !refspec.IsGlob(),
+			})
+			if !refspec.IsGlob() {
+				// `main` matches a single ref even if both a tag and a branch exist
+				break
+			}
+		}
+		if !matched && !refspec.IsGlob() {
+			return nil, fmt.Errorf("couldn't find remote ref %s", refspec.Src)
+		}
+	}
+	return updates, nil
+}
+
+// markMergeRef marks the upstream of the current branch as the ref to merge when the
+// refspecs come from the configuration
+func markMergeRef(repo *repository, cfg *config.Config, remoteName string, updates []refUpdate) {
+	branch, err := refs.ReadSymbolic(repo.gitDir, "HEAD")
+	if err != nil || remoteName == "" {
+		return
+	}
+	short := strings.TrimPrefix(branch, "refs/heads/")
+	merge, ok := cfg.Get("branch." + short + ".merge")
+	remote, _ := cfg.Get("branch." + short + ".remote")
+	if !ok || remote != remoteName {
+		return
+	}
+	for i := range updates {
+		if updates[i].remote.Name == merge {
+			updates[i].merge = true
+		}
+	}
+}
+
+// applyRefUpdate updates the local ref, it returns false when the update is rejected
+func applyRefUpdate(repo *repository, update refUpdate, report *fetchReport) (bool, error) {
+	if update.local == "" {
+		return true, nil
+	}
+	newHash := update.remote.Hash
+	oldHash, err := refs.Resolve(repo.gitDir, update.local)
+	if err != nil && !errors.Is(err, refs.ErrNotFound) {
+		return false, err
+	}
+	switch {
+	case oldHash == newHash:
+		return true, nil
+	case oldHash == "":
+		kind := "[new ref]"
+		switch {
+		case strings.HasPrefix(update.remote.Name, "refs/heads/"):
+			kind = "[new branch]"
+		case strings.HasPrefix(update.remote.Name, "refs/tags/"):
+			kind = "[new tag]"
+		}
+		report.line('*', kind, update.remote.Name, update.local, "")
+	case strings.HasPrefix(update.local, "refs/tags/") && !update.force:
+		report.line('!', "[rejected]", update.remote.Name, update.local, "(would clobber existing tag)")
+		return false, nil
+	default:
+		fastForward, err := isAncestor(repo, oldHash, newHash)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case fastForward:
+			report.line(' ', oldHash[:7]+".."+newHash[:7], update.remote.Name, update.local, "")
+		case update.force:
+			report.line('+', oldHash[:7]+"..."+newHash[:7], update.remote.Name, update.local, "(forced update)")
+		default:
+			report.line('!', "[rejected]", update.remote.Name, update.local, "(non-fast-forward)")
+			return false, nil
+		}
+	}
+	return true, refs.Update(repo.gitDir, update.local, newHash)
+}
+
+// followTags creates the local tags of the remote which point to objects the repository
+// now has, the server sends these tags along with the pack thanks to include-tag
+func followTags(repo *repository, remoteRefs []clone.GitRef, updates []refUpdate, report *fetchReport) error {
+	for _, remote := range remoteRefs {
+		if !strings.HasPrefix(remote.Name, "refs/tags/") || strings.HasSuffix(remote.Name, "^{}") {
+			continue
+		}
+		matched := slices.ContainsFunc(updates, func(update refUpdate) bool {
+			return update.remote.Name == remote.Name
+		})
+		if matched {
+			continue
+		}
+		if _, err := refs.Resolve(repo.gitDir, remote.Name); !errors.Is(err, refs.ErrNotFound) {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		has, err := repo.objects().Has(remote.Hash)
+		if err != nil {
+			return err
+		}
+		if !has {
+			continue
+		}
+		if err := refs.Update(repo.gitDir, remote.Name, remote.Hash); err != nil {
+			return err
+		}
+		report.line('*', "[new tag]", remote.Name, remote.Name, "")
+	}
+	return nil
+}
+
+// pruneRefs deletes the local refs of the refspecs whose remote ref is gone
+func pruneRefs(repo *repository, remoteRefs []clone.GitRef, refspecs []refs.Refspec, report *fetchReport) error {
+	for _, refspec := range refspecs {
+		if !refspec.IsGlob() || refspec.Negative {
+			continue
+		}
+		prefix, _, _ := strings.Cut(refspec.Dst, "*")
+		localRefs, err := refs.List(repo.gitDir, prefix)
+		if err != nil {
+			return err
+		}
+		for _, local := range localRefs {
+			src, ok := refspec.Reverse(local.Name)
+			if !ok || local.IsSymbolic() || refs.Excluded(refspecs, src) {
+				continue
+			}
+			exists := slices.ContainsFunc(remoteRefs, func(remote clone.GitRef) bool {
+				return remote.Name == src
+			})
+			if exists {
+				continue
+			}
+			if err := refs.Delete(repo.gitDir, local.Name); err != nil {
+				return err
+			}
+			report.line('-', "[deleted]", "(none)", local.Name, "")
+		}
+	}
+	return nil
+}
+
+// writeFetchHead records the fetched refs in FETCH_HEAD, the one to merge first
+//
+//	<hash>\t\tbranch 'main' of <url>
+//	<hash>\tnot-for-merge\ttag 'v1' of <url>
+func writeFetchHead(repo *repository, repoLink string, updates []refUpdate) error {
+	var merge, others strings.Builder
+	for _, update := range updates {
+		description := "'" + update.remote.Name + "'"
+		if short, ok := strings.CutPrefix(update.remote.Name, "refs/heads/"); ok {
+			description = "branch '" + short + "'"
+		} else if short, ok := strings.CutPrefix(update.remote.Name, "refs/tags/"); ok {
+			description = "tag '" + short + "'"
+		} else if update.remote.Name == "HEAD" {
+			description = ""
+		}
+		if description != "" {
+			description += " of "
+		}
+		if update.merge {
+			fmt.Fprintf(&merge, "%s\t\t%s%s\n", update.remote.Hash, description, repoLink)
+		} else {
+			fmt.Fprintf(&others, "%s\tnot-for-merge\t%s%s\n", update.remote.Hash, description, repoLink)
+		}
+	}
+	content := merge.String() + others.String()
+	return os.WriteFile(filepath.Join(repo.gitDir, "FETCH_HEAD"), []byte(content), 0644)
+}
+
+// isAncestor reports whether the commit ancestor is reachable from the commit descendant
+func isAncestor(repo *repository, ancestor, descendant string) (bool, error) {
+	for _, hash := range []string{ancestor, descendant} {
+		info, err := repo.objects().Stat(hash)
+		if err != nil {
+			return false, err
+		}
+		if info.Type != "commit" {
+			return false, nil
+		}
+	}
+	walker, err := newCommitWalker(repo, false)
+	if err != nil {
+		return false, err
+	}
+	if err := walker.push(descendant); err != nil {
+		return false, err
+	}
+	for {
+		commit, ok, err := walker.next()
+		if err != nil || !ok {
+			return false, err
+		}
+		if commit.hash == ancestor {
+			return true, nil
+		}
+	}
+}
+
+// fetchReport prints the updated refs the way git does
+//
+//	From https://example.com/repo.git
+//	 * [new branch]      main       -> origin/main
+//	   1a2b3c4..5d6e7f8  dev        -> origin/dev
+type fetchReport struct {
+	url     string
+	quiet   bool
+	started bool
+}
+
+func (r *fetchReport) line(flag byte, summary, from, to, reason string) {
+	if r.quiet {
+		return
+	}
+	if !r.started {
+		ePrintf("From %s\n", r.url)
+		r.started = true
+	}
+	line := fmt.Sprintf(" %c %-17s %-10s -> %s", flag, summary, shortRefName(from), shortRefName(to))
+	if reason != "" {
+		line += "  " + reason
+	}
+	ePrintf("%s\n", line)
+}
+
+// localHaves offers the commits of the repository to the server, starting from the tips
+// of all the refs, newest first
+type localHaves struct {
+	walker *commitWalker
+	// common are the commits the server has, along with their ancestors as they are found
+	common map[string]bool
+}
+
+func newLocalHaves(repo *repository) (*localHaves, error) {
+	walker, err := newCommitWalker(repo, false)
+	if err != nil {
+		return nil, err
+	}
+	haves := &localHaves{walker: walker, common: map[string]bool{}}
+	tips, err := refs.List(repo.gitDir, "refs/")
+	if err != nil {
+		return nil, err
+	}
+	if head, err := refs.Resolve(repo.gitDir, "HEAD"); err == nil {
+		tips = append(tips, refs.Ref{Name: "HEAD", Hash: head})
+	}
+	for _, tip := range tips {
+		if tip.Hash == "" {
+			continue
+		}
+		hash, err := peelTag(repo, tip.Hash)
+		if err != nil {
+			return nil, err
+		}
+		info, err := repo.objects().Stat(hash)
+		if errors.Is(err, common.ErrObjectNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if info.Type != "commit" {
+			continue
+		}
+		if err := haves.walker.push(hash); err != nil {
+			return nil, err
+		}
+	}
+	return haves, nil
+}
+
+func (h *localHaves) Next() (string, bool, error) {
+	for {
+		commit, ok, err := h.walker.next()
+		if err != nil || !ok {
+			return "", false, err
+		}
+		if !h.common[commit.hash] {
+			return commit.hash, true, nil
+		}
+		// the server has all the ancestors of a common commit
+		for _, parent := range commit.Parents {
+			h.common[parent] = true
+		}
+	}
+}
+
+func (h *localHaves) Common(hash string) {
+	h.common[hash] = true
+	if commit, err := readCommit(h.walker.store, hash); err == nil {
+		for _, parent := range commit.Parents {
+			h.common[parent] = true
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/clone"
+	"github.com/codecrafters-io/git-starter-go/cmd/refs"
+)
+
+func TestMatchRefspecs(t *testing.T) {
+	remoteRefs := []clone.GitRef{
+		{Name: "HEAD", Hash: "1111111111111111111111111111111111111111"},
+		{Name: "refs/heads/main", Hash: "1111111111111111111111111111111111111111"},
+		{Name: "refs/heads/tmp/wip", Hash: "2222222222222222222222222222222222222222"},
+		{Name: "refs/tags/main", Hash: "3333333333333333333333333333333333333333"},
+		{Name: "refs/tags/main^{}", Hash: "1111111111111111111111111111111111111111"},
+	}
+	tests := []struct {
+		name     string
+		refspecs []string
+		merge    bool
+		// want are the updates as `[+]<remote>:<local>`, with a trailing ` merge` for the refs to merge
+		want []string
+	}{
+		{
+			name:     "glob",
+			refspecs: []string{"refs/heads/*:refs/remotes/origin/*"},
+			want:     []string{"refs/heads/main:refs/remotes/origin/main", "refs/heads/tmp/wip:refs/remotes/origin/tmp/wip"},
+		},
+		{
+			name:     "forced glob",
+			refspecs: []string{"+refs/heads/*:refs/remotes/origin/*"},
+			want:     []string{"+refs/heads/main:refs/remotes/origin/main", "+refs/heads/tmp/wip:refs/remotes/origin/tmp/wip"},
+		},
+		{
+			name:     "negative glob",
+			refspecs: []string{"+refs/heads/*:refs/remotes/origin/*", "^refs/heads/tmp/*"},
+			want:     []string{"+refs/heads/main:refs/remotes/origin/main"},
+		},
+		{
+			name:     "negative exact",
+			refspecs: []string{"refs/*:refs/mirror/*", "^refs/heads/main"},
+			want:     []string{"refs/heads/tmp/wip:refs/mirror/heads/tmp/wip", "refs/tags/main:refs/mirror/tags/main"},
+		},
+		{
+			// a short name matching a branch and a tag fetches the first one advertised
+			name:     "short name",
+			refspecs: []string{"main"},
+			merge:    true,
+			want:     []string{"refs/heads/main: merge"},
+		},
+		{
+			name:     "short name with a destination",
+			refspecs: []string{"+refs/heads/main:refs/remotes/origin/main"},
+			merge:    true,
+			want:     []string{"+refs/heads/main:refs/remotes/origin/main merge"},
+		},
+		{
+			name:     "HEAD",
+			refspecs: []string{"HEAD"},
+			want:     []string{"HEAD:"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var refspecs []refs.Refspec
+			for _, spec := range test.refspecs {
+				refspec, err := refs.ParseRefspec(spec)
+				if err != nil {
+					t.Fatal(err)
+				}
+				refspecs = append(refspecs, refspec)
+			}
+			updates, err := matchRefspecs(remoteRefs, refspecs, test.merge)
+			if err != nil {
+				t.Fatalf("matchRefspecs() error = %v", err)
+			}
+			var got []string
+			for _, update := range updates {
+				line := update.remote.Name + ":" + update.local
+				if update.force {
+					line = "+" + line
+				}
+				if update.merge {
+					line += " merge"
+				}
+				got = append(got, line)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("matchRefspecs() = %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	t.Run("missing ref", func(t *testing.T) {
+		refspec, _ := refs.ParseRefspec("refs/heads/gone:refs/remotes/origin/gone")
+		if _, err := matchRefspecs(remoteRefs, []refs.Refspec{refspec}, false); err == nil {
+			t.Errorf("matchRefspecs() of a missing ref did not fail")
+		}
+	})
+}
+
+// testCommitOf writes a commit of an empty tree with the given parents
+func testCommitOf(t *testing.T, repo *repository, message string, parents ...string) string {
+	t.Helper()
+	tree := testTree(t, repo)
+	content := fmt.Sprintf("tree %s\n", hex.EncodeToString(tree[:]))
+	for _, parent := range parents {
+		content += "parent " + parent + "\n"
+	}
+	content += "author A U Thor <author@example.com> 1700000000 +0000\n" +
+		"committer A U Thor <author@example.com> 1700000000 +0000\n\n" + message + "\n"
+	hash, err := writeObject(repo, "commit", []byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(hash[:])
+}
+
+func TestApplyRefUpdate(t *testing.T) {
+	repo := inTempRepository(t)
+	base := testCommitOf(t, repo, "base")
+	next := testCommitOf(t, repo, "next", base)
+	other := testCommitOf(t, repo, "other", base)
+	tests := []struct {
+		name  string
+		local string
+		old   string
+		new   string
+		force bool
+		// want is the hash of the local ref after the update
+		want     string
+		accepted bool
+	}{
+		{name: "new ref", local: "refs/remotes/origin/new", new: base, want: base, accepted: true},
+		{name: "fast-forward", local: "refs/remotes/origin/ff", old: base, new: next, want: next, accepted: true},
+		{name: "non-fast-forward", local: "refs/remotes/origin/nff", old: next, new: other, want: next},
+		{name: "forced update", local: "refs/remotes/origin/forced", old: next, new: other, force: true, want: other, accepted: true},
+		{name: "existing tag", local: "refs/tags/v1", old: base, new: next, want: base},
+		{name: "forced tag", local: "refs/tags/v2", old: base, new: next, force: true, want: next, accepted: true},
+		{name: "unchanged", local: "refs/remotes/origin/same", old: base, new: base, want: base, accepted: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.old != "" {
+				if err := refs.Update(repo.gitDir, test.local, test.old); err != nil {
+					t.Fatal(err)
+				}
+			}
+			update := refUpdate{
+				remote: clone.GitRef{Name: test.local, Hash: test.new},
+				local:  test.local,
+				force:  test.force,
+			}
+			accepted, err := applyRefUpdate(repo, update, &fetchReport{quiet: true})
+			if err != nil {
+				t.Fatalf("applyRefUpdate() error = %v", err)
+			}
+			if accepted != test.accepted {
+				t.Errorf("applyRefUpdate() = %v, want %v", accepted, test.accepted)
+			}
+			if hash, err := refs.Resolve(repo.gitDir, test.local); err != nil || hash != test.want {
+				t.Errorf("%s is at %s, %v, want %s", test.local, hash, err, test.want)
+			}
+		})
+	}
+}
+
+func TestPruneRefs(t *testing.T) {
+	repo := inTempRepository(t)
+	commit := testCommitOf(t, repo, "commit")
+	for _, name := range []string{
+		"refs/remotes/origin/main", "refs/remotes/origin/gone", "refs/remotes/origin/tmp/kept",
+		"refs/remotes/other/gone", "refs/heads/gone", "refs/tags/gone",
+	} {
+		if err := refs.Update(repo.gitDir, name, commit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	remoteRefs := []clone.GitRef{{Name: "refs/heads/main", Hash: commit}}
+	var refspecs []refs.Refspec
+	for _, spec := range []string{"+refs/heads/*:refs/remotes/origin/*", "^refs/heads/tmp/*"} {
+		refspec, err := refs.ParseRefspec(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refspecs = append(refspecs, refspec)
+	}
+	if err := pruneRefs(repo, remoteRefs, refspecs, &fetchReport{quiet: true}); err != nil {
+		t.Fatalf("pruneRefs() error = %v", err)
+	}
+	localRefs, err := refs.List(repo.gitDir, "refs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, local := range localRefs {
+		got = append(got, local.Name)
+	}
+	// only the ref of the destination namespace whose remote ref is gone is deleted, the
+	// excluded ones are kept
+	want := []string{
+		"refs/heads/gone", "refs/remotes/origin/main", "refs/remotes/origin/tmp/kept",
+		"refs/remotes/other/gone", "refs/tags/gone",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("the refs after pruneRefs() are %q, want %q", got, want)
+	}
+}
+
+func TestWriteFetchHead(t *testing.T) {
+	repo := inTempRepository(t)
+	const url = "https://example.com/repo.git"
+	updates := []refUpdate{
+		{remote: clone.GitRef{Name: "refs/tags/v1", Hash: "1111111111111111111111111111111111111111"}},
+		{remote: clone.GitRef{Name: "refs/heads/main", Hash: "2222222222222222222222222222222222222222"}, merge: true},
+		{remote: clone.GitRef{Name: "refs/heads/dev", Hash: "3333333333333333333333333333333333333333"}},
+		{remote: clone.GitRef{Name: "refs/pull/1/head", Hash: "4444444444444444444444444444444444444444"}},
+		{remote: clone.GitRef{Name: "HEAD", Hash: "2222222222222222222222222222222222222222"}},
+	}
+	if err := writeFetchHead(repo, url, updates); err != nil {
+		t.Fatalf("writeFetchHead() error = %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(repo.gitDir, "FETCH_HEAD"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "2222222222222222222222222222222222222222\t\tbranch 'main' of " + url + "\n" +
+		"1111111111111111111111111111111111111111\tnot-for-merge\ttag 'v1' of " + url + "\n" +
+		"3333333333333333333333333333333333333333\tnot-for-merge\tbranch 'dev' of " + url + "\n" +
+		"4444444444444444444444444444444444444444\tnot-for-merge\t'refs/pull/1/head' of " + url + "\n" +
+		"2222222222222222222222222222222222222222\tnot-for-merge\t" + url + "\n"
+	if string(content) != want {
+		t.Errorf("FETCH_HEAD is\n%s\nwant\n%s", content, want)
+	}
+}
@@ -0,0 +1,658 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/clone"
+	"github.com/codecrafters-io/git-starter-go/cmd/common"
+	"github.com/codecrafters-io/git-starter-go/cmd/diff"
+)
+
+// parseCommitArgs parses `-m <msg>` (can be repeated, each one is a paragraph)
+// and `--allow-empty` for the commit subcommand
+func parseCommitArgs(args []string) (string, bool, error) {
+	usage := fmt.Errorf("usage: mygit commit [--allow-empty] -m <msg>")
+	var paragraphs []string
+	allowEmpty := false
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-m" || arg == "--message":
+			if i+1 >= len(args) {
+				return "", false, usage
+			}
+			i++
+			paragraphs = append(paragraphs, args[i])
+		case strings.HasPrefix(arg, "--message="):
+			paragraphs = append(paragraphs, strings.TrimPrefix(arg, "--message="))
+		case arg == "--allow-empty":
+			allowEmpty = true
+		default:
+			return "", false, usage
+		}
+	}
+	commitMsg := strings.TrimSpace(strings.Join(paragraphs, "\n\n"))
+	if commitMsg == "" {
+		return "", false, fmt.Errorf("aborting commit due to empty commit message")
+	}
+	return commitMsg, allowEmpty, nil
+}
+
+type updateRefOptions struct {
+	name     string
+	newValue string
+	oldValue string
+	delete   bool
+	noDeref  bool
+}
+
+// parseUpdateRefArgs parses the arguments of
+// `update-ref [--no-deref] <ref> <new> [<old>]` and `update-ref [--no-deref] -d <ref> [<old>]`
+func parseUpdateRefArgs(args []string) (updateRefOptions, error) {
+	usage := fmt.Errorf(
+		"usage: mygit update-ref [--no-deref] (-d <ref> [<old>] | <ref> <new> [<old>])",
+	)
+	opts, positional := updateRefOptions{}, []string{}
+	for _, arg := range args {
+		switch arg {
+		case "-d":
+			opts.delete = true
+		case "--no-deref":
+			opts.noDeref = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return opts, usage
+			}
+			positional = append(positional, arg)
+		}
+	}
+	switch {
+	case opts.delete && (len(positional) == 1 || len(positional) == 2):
+		opts.name = positional[0]
+		if len(positional) == 2 {
+			opts.oldValue = positional[1]
+		}
+	case !opts.delete && (len(positional) == 2 || len(positional) == 3):
+		opts.name, opts.newValue = positional[0], positional[1]
+		if len(positional) == 3 {
+			opts.oldValue = positional[2]
+		}
+	default:
+		return opts, usage
+	}
+	return opts, nil
+}
+
+type symbolicRefOptions struct {
+	name   string
+	target string
+	delete bool
+	quiet  bool
+	short  bool
+}
+
+// parseSymbolicRefArgs parses the arguments of
+// `symbolic-ref [-q] [--short] <name>`, `symbolic-ref <name> <ref>` and `symbolic-ref -d <name>`
+func parseSymbolicRefArgs(args []string) (symbolicRefOptions, error) {
+	usage := fmt.Errorf("usage: mygit symbolic-ref [-q] [--short] [-d] <name> [<ref>]")
+	opts, positional := symbolicRefOptions{}, []string{}
+	for _, arg := range args {
+		switch arg {
+		case "-d", "--delete":
+			opts.delete = true
+		case "-q", "--quiet":
+			opts.quiet = true
+		case "--short":
+			opts.short = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return opts, usage
+			}
+			positional = append(positional, arg)
+		}
+	}
+	switch {
+	case len(positional) == 1:
+		opts.name = positional[0]
+	case len(positional) == 2 && !opts.delete:
+		opts.name, opts.target = positional[0], positional[1]
+	default:
+		return opts, usage
+	}
+	return opts, nil
+}
+
+type showRefOptions struct {
+	patterns    []string
+	head        bool
+	heads       bool
+	tags        bool
+	dereference bool
+	hashOnly    bool
+	verify      bool
+}
+
+// parseShowRefArgs parses the arguments of
+// `show-ref [--head] [--heads] [--tags] [-d] [-s] [--verify] [<pattern>...]`
+func parseShowRefArgs(args []string) (showRefOptions, error) {
+	opts := showRefOptions{}
+	for _, arg := range args {
+		switch arg {
+		case "--head":
+			opts.head = true
+		case "--heads", "--branches":
+			opts.heads = true
+		case "--tags":
+			opts.tags = true
+		case "-d", "--dereference":
+			opts.dereference = true
+		case "-s", "--hash":
+			opts.hashOnly = true
+		case "--verify":
+			opts.verify = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return opts, fmt.Errorf(
+					"usage: mygit show-ref [--head] [--heads] [--tags] [-d] [-s] [--verify] [<pattern>...]",
+				)
+			}
+			opts.patterns = append(opts.patterns, arg)
+		}
+	}
+	return opts, nil
+}
+
+// parseLogArgs parses the arguments of
+// `log [--oneline] [-n <count>] [--first-parent] [--format=<format> | --pretty[=<format>]] [<rev>]`
+func parseLogArgs(args []string) (logOptions, error) {
+	usage := fmt.Errorf(
+		"usage: mygit log [--oneline] [-n <count>] [--first-parent] " +
+			"[--format=<format> | --pretty[=<format>]] [<rev>]",
+	)
+	opts := logOptions{revision: "HEAD", maxCount: -1}
+	revisionSet := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		count := ""
+		switch {
+		case arg == "--oneline":
+			opts.pretty, opts.format = "oneline", ""
+		case arg == "--first-parent":
+			opts.firstParent = true
+		case arg == "-n" || arg == "--max-count":
+			if i+1 >= len(args) {
+				return opts, usage
+			}
+			i++
+			count = args[i]
+		case strings.HasPrefix(arg, "--max-count="):
+			count = strings.TrimPrefix(arg, "--max-count=")
+		case strings.HasPrefix(arg, "-n"):
+			count = strings.TrimPrefix(arg, "-n")
+		case arg == "--pretty" || strings.HasPrefix(arg, "--pretty=") || strings.HasPrefix(arg, "--format="):
+			_, value, _ := strings.Cut(arg, "=")
+			if err := parseLogFormat(value, &opts); err != nil {
+				return opts, err
+			}
+		case len(arg) > 1 && arg[0] == '-' && arg[1] >= '0' && arg[1] <= '9':
+			count = arg[1:]
+		case strings.HasPrefix(arg, "-") || revisionSet:
+			return opts, usage
+		default:
+			opts.revision, revisionSet = arg, true
+		}
+		if count != "" {
+			n, err := strconv.Atoi(count)
+			if err != nil {
+				return opts, fmt.Errorf("log: invalid count %q", count)
+			}
+			opts.maxCount = n
+		}
+	}
+	return opts, nil
+}
+
+// parseLogFormat sets the format of log from the value of --pretty or --format, either the
+// name of a built-in format or a format string, which needs a placeholder unless it comes
+// after `format:` or `tformat:`. Like git, --pretty alone is the medium format.
+func parseLogFormat(value string, opts *logOptions) error {
+	opts.pretty, opts.format = "", ""
+	switch {
+	case value == "":
+		opts.pretty = "medium"
+	case value == "oneline":
+		// unlike --oneline, the hashes are not abbreviated
+		opts.format = "%H %s"
+	case value == "short" || value == "medium" || value == "full":
+		opts.pretty = value
+	case strings.HasPrefix(value, "format:"):
+		opts.format = strings.TrimPrefix(value, "format:")
+	case strings.HasPrefix(value, "tformat:"):
+		opts.format = strings.TrimPrefix(value, "tformat:")
+	case strings.Contains(value, "%"):
+		opts.format = value
+	default:
+		return fmt.Errorf("invalid --pretty format: %s", value)
+	}
+	return nil
+}
+
+// parseFetchArgs parses the arguments of
+// `fetch [-p] [-q] [--depth <n>] [--shallow-since <date>] [--shallow-exclude <ref>] [<remote> [<refspec>...]]`
+func parseFetchArgs(args []string) (fetchOptions, error) {
+	usage := fmt.Errorf(
+		"usage: mygit fetch [-p] [-q] [--depth <n>] [--shallow-since <date>] " +
+			"[--shallow-exclude <ref>] [<remote> [<refspec>...]]",
+	)
+	opts := fetchOptions{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		ok, err := parseDeepenArg(args, &i, &opts.deepen)
+		switch {
+		case err != nil:
+			return opts, err
+		case ok:
+		case arg == "-p" || arg == "--prune":
+			opts.prune = true
+		case arg == "-q" || arg == "--quiet":
+			opts.quiet = true
+		case strings.HasPrefix(arg, "-"):
+			return opts, usage
+		case opts.remote == "":
+			opts.remote = arg
+		default:
+			opts.refspecs = append(opts.refspecs, arg)
+		}
+	}
+	return opts, nil
+}
+
+type cloneOptions struct {
+	repo   string
+	dir    string
+	deepen clone.Deepen
+	// filter makes a partial clone, see clone.CheckFilter
+	filter string
+	// branch is the branch or the tag to check out instead of the remote HEAD
+	branch       string
+	singleBranch bool
+	noCheckout   bool
+	bare         bool
+	// mirror maps every ref of the remote to the same ref, it implies bare
+	mirror bool
+}
+
+// parseCloneArgs parses the arguments of
+// `clone [--depth <n>] [--shallow-since <date>] [--shallow-exclude <ref>] [--filter=<spec>]
+// [-b <name>] [--[no-]single-branch] [-n] [--bare | --mirror] <repo> <dir>`
+//
+// Like git, a shallow clone only fetches a single branch unless --no-single-branch is given
+func parseCloneArgs(args []string) (cloneOptions, error) {
+	usage := fmt.Errorf(
+		"usage: mygit clone [--depth <n>] [--shallow-since <date>] [--shallow-exclude <ref>] " +
+			"[--filter=<spec>] [-b <name>] [--[no-]single-branch] [-n] [--bare | --mirror] <repo> <dir>",
+	)
+	opts, positional := cloneOptions{}, []string{}
+	singleBranch := ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		ok, err := parseDeepenArg(args, &i, &opts.deepen)
+		switch {
+		case err != nil:
+			return opts, err
+		case ok:
+		case (arg == "--filter" || arg == "-b" || arg == "--branch") && i+1 < len(args):
+			i++
+			if arg == "--filter" {
+				opts.filter = args[i]
+			} else {
+				opts.branch = args[i]
+			}
+		case strings.HasPrefix(arg, "--filter="):
+			opts.filter = strings.TrimPrefix(arg, "--filter=")
+		case strings.HasPrefix(arg, "--branch="):
+			opts.branch = strings.TrimPrefix(arg, "--branch=")
+		case arg == "--single-branch" || arg == "--no-single-branch":
+			singleBranch = arg
+		case arg == "-n" || arg == "--no-checkout":
+			opts.noCheckout = true
+		case arg == "--bare":
+			opts.bare = true
+		case arg == "--mirror":
+			opts.mirror, opts.bare = true, true
+		case strings.HasPrefix(arg, "-"):
+			return opts, usage
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) != 2 {
+		return opts, usage
+	}
+	opts.singleBranch = singleBranch == "--single-branch" || (singleBranch == "" && !opts.deepen.IsZero())
+	if opts.filter != "" {
+		if err := clone.CheckFilter(opts.filter); err != nil {
+			return opts, err
+		}
+	}
+	opts.repo, opts.dir = positional[0], positional[1]
+	return opts, nil
+}
+
+// parseDeepenArg parses the shallow options of clone and fetch at args[i], which are
+// `--depth <n>`, `--shallow-since <date>` and `--shallow-exclude <ref>` (can be repeated),
+// the value can also follow a `=`. It returns false when args[i] is another argument.
+func parseDeepenArg(args []string, i *int, deepen *clone.Deepen) (bool, error) {
+	name, value, hasValue := strings.Cut(args[*i], "=")
+	if name != "--depth" && name != "--shallow-since" && name != "--shallow-exclude" {
+		return false, nil
+	}
+	if !hasValue {
+		if *i+1 >= len(args) {
+			return false, fmt.Errorf("option '%s' requires a value", strings.TrimPrefix(name, "--"))
+		}
+		*i++
+		value = args[*i]
+	}
+	switch name {
+	case "--depth":
+		depth, err := strconv.Atoi(value)
+		if err != nil || depth <= 0 {
+			return false, fmt.Errorf("depth %s is not a positive number", value)
+		}
+		deepen.Depth = depth
+	case "--shallow-since":
+		since, err := common.ParseDate(value, time.Now())
+		if err != nil {
+			return false, err
+		}
+		deepen.Since = since
+	case "--shallow-exclude":
+		deepen.Not = append(deepen.Not, value)
+	}
+	return true, nil
+}
+
+type configOptions struct {
+	// action is get, get-all, set, add, unset, unset-all or list
+	action string
+	name   string
+	value  string
+	// file is the only file read or written, the layered configuration is read and the
+	// repository file is written when it is empty
+	file   string
+	global bool
+	// local reads or writes the repository file alone
+	local bool
+	// valueType is bool, int or path to check and convert the values, empty for strings
+	valueType string
+}
+
+// parseConfigArgs parses the arguments of
+// `config [--global | -f <file>] [--bool | --int | --path | --type=<type>]
+// [--get | --get-all | --unset | --unset-all | --add | --list] [<name> [<value>]]`
+//
+// Like git, a name alone is read and a name with a value is set
+func parseConfigArgs(args []string) (configOptions, error) {
+	usage := fmt.Errorf(
+		"usage: mygit config [--global | -f <file>] [--bool | --int | --path | --type=<type>] " +
+			"[--get | --get-all | --unset | --unset-all | --add | --list] [<name> [<value>]]",
+	)
+	opts, positional := configOptions{}, []string{}
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--get" || arg == "--get-all" || arg == "--unset" || arg == "--unset-all" ||
+			arg == "--add" || arg == "--list" || arg == "-l":
+			if opts.action != "" {
+				return opts, fmt.Errorf("only one action at a time")
+			}
+			opts.action = strings.TrimLeft(arg, "-")
+			if arg == "-l" {
+				opts.action = "list"
+			}
+		case arg == "--global":
+			opts.global = true
+		case arg == "--local":
+			opts.local = true
+		case (arg == "-f" || arg == "--file" || arg == "--type") && i+1 < len(args):
+			i++
+			if arg == "--type" {
+				opts.valueType = args[i]
+			} else {
+				opts.file = args[i]
+			}
+		case strings.HasPrefix(arg, "--file="):
+			opts.file = strings.TrimPrefix(arg, "--file=")
+		case strings.HasPrefix(arg, "--type="):
+			opts.valueType = strings.TrimPrefix(arg, "--type=")
+		case arg == "--bool" || arg == "--int" || arg == "--path":
+			opts.valueType = strings.TrimPrefix(arg, "--")
+		case strings.HasPrefix(arg, "-"):
+			return opts, usage
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if opts.valueType != "" && opts.valueType != "bool" && opts.valueType != "int" && opts.valueType != "path" {
+		return opts, fmt.Errorf("unrecognized --type argument, %s", opts.valueType)
+	}
+	if opts.global && opts.file != "" {
+		return opts, fmt.Errorf("only one config file at a time")
+	}
+	if opts.action == "" {
+		opts.action = "get"
+		if len(positional) == 2 {
+			opts.action = "set"
+		}
+	}
+	want := map[string]int{"get": 1, "get-all": 1, "unset": 1, "unset-all": 1, "set": 2, "add": 2, "list": 0}
+	if len(positional) != want[opts.action] {
+		return opts, usage
+	}
+	if len(positional) > 0 {
+		opts.name = positional[0]
+	}
+	if len(positional) > 1 {
+		opts.value = positional[1]
+	}
+	return opts, nil
+}
+
+type statusOptions struct {
+	// format is long, short, porcelain or porcelain=v2
+	format        string
+	nulTerminated bool
+}
+
+// parseStatusArgs parses the arguments of
+// `status [-s | --short | --long | --porcelain[=v1|v2]] [-z]`
+//
+// Like git, -z alone implies --porcelain
+func parseStatusArgs(args []string) (statusOptions, error) {
+	usage := fmt.Errorf("usage: mygit status [-s | --short | --long | --porcelain[=v1|v2]] [-z]")
+	opts := statusOptions{}
+	for _, arg := range args {
+		switch arg {
+		case "-s", "--short":
+			opts.format = "short"
+		case "--long":
+			opts.format = "long"
+		case "--porcelain", "--porcelain=v1", "--porcelain=1":
+			opts.format = "porcelain"
+		case "--porcelain=v2", "--porcelain=2":
+			opts.format = "porcelain=v2"
+		case "-z":
+			opts.nulTerminated = true
+		default:
+			if strings.HasPrefix(arg, "--porcelain=") {
+				return opts, fmt.Errorf("unsupported porcelain version '%s'", strings.TrimPrefix(arg, "--porcelain="))
+			}
+			return opts, usage
+		}
+	}
+	if opts.format == "" {
+		opts.format = "long"
+		if opts.nulTerminated {
+			opts.format = "porcelain"
+		}
+	}
+	return opts, nil
+}
+
+// renameFlags are the options of diff and diff-tree detecting the renamed files
+type renameFlags struct {
+	// detect is "renames", "copies" or "none", empty when not given
+	detect string
+	// score is the similarity a rename needs out of diff.MaxScore, -1 for the default
+	score int
+	// limit is the rename limit, -1 for the configured one
+	limit int
+}
+
+// parseRenameArg parses `-M[<n>]`, `-C[<n>]`, `--find-renames[=<n>]`,
+// `--find-copies[=<n>]`, `-l<n>` and `--no-renames`, it returns false for the other
+// arguments
+func parseRenameArg(arg string, flags *renameFlags) (bool, error) {
+	var score string
+	switch {
+	case arg == "--no-renames":
+		flags.detect = "none"
+		return true, nil
+	case strings.HasPrefix(arg, "-l") && len(arg) > 2:
+		limit, err := strconv.Atoi(arg[2:])
+		if err != nil {
+			return true, fmt.Errorf("invalid rename limit %q", arg[2:])
+		}
+		flags.limit = limit
+		return true, nil
+	case strings.HasPrefix(arg, "-M"):
+		flags.detect, score = "renames", arg[2:]
+	case arg == "--find-renames" || strings.HasPrefix(arg, "--find-renames="):
+		flags.detect, score = "renames", strings.TrimPrefix(arg[len("--find-renames"):], "=")
+	case strings.HasPrefix(arg, "-C"):
+		flags.detect, score = "copies", arg[2:]
+	case arg == "--find-copies" || strings.HasPrefix(arg, "--find-copies="):
+		flags.detect, score = "copies", strings.TrimPrefix(arg[len("--find-copies"):], "=")
+	default:
+		return false, nil
+	}
+	if score != "" {
+		value, err := diff.ParseScore(score)
+		if err != nil {
+			return true, err
+		}
+		flags.score = value
+	}
+	return true, nil
+}
+
+type diffOptions struct {
+	cached    bool
+	revisions []string
+	// context is the number of lines around the changes, -1 for the configured one
+	context int
+	// algorithm is the name of the diff algorithm, empty for the configured one
+	algorithm string
+	renames   renameFlags
+}
+
+// parseDiffArgs parses the arguments of
+// `diff [-U<n> | --unified=<n>] [--diff-algorithm=<algorithm>] [-M[<n>] | -C[<n>] | --no-renames] [-l<n>]
+// [--cached] [<commit> [<commit>]]`
+func parseDiffArgs(args []string) (diffOptions, error) {
+	usage := fmt.Errorf("usage: mygit diff [-U<n>] [--diff-algorithm=<algorithm>] [-M[<n>] | -C[<n>] | --no-renames] [-l<n>] [--cached] [<commit> [<commit>]]")
+	opts := diffOptions{context: -1, renames: renameFlags{score: -1, limit: -1}}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if ok, err := parseRenameArg(arg, &opts.renames); ok || err != nil {
+			if err != nil {
+				return opts, fmt.Errorf("diff: %w", err)
+			}
+			continue
+		}
+		context := ""
+		switch {
+		case arg == "--cached" || arg == "--staged":
+			opts.cached = true
+		case arg == "-U" || arg == "--unified":
+			if i+1 >= len(args) {
+				return opts, usage
+			}
+			i++
+			context = args[i]
+		case strings.HasPrefix(arg, "--unified="):
+			context = strings.TrimPrefix(arg, "--unified=")
+		case strings.HasPrefix(arg, "-U"):
+			context = strings.TrimPrefix(arg, "-U")
+		case strings.HasPrefix(arg, "--diff-algorithm="):
+			opts.algorithm = strings.TrimPrefix(arg, "--diff-algorithm=")
+		case arg == "--diff-algorithm":
+			if i+1 >= len(args) {
+				return opts, usage
+			}
+			i++
+			opts.algorithm = args[i]
+		case arg == "--patience" || arg == "--histogram" || arg == "--minimal":
+			opts.algorithm = strings.TrimPrefix(arg, "--")
+		case arg == "--" && i == len(args)-1:
+		case strings.HasPrefix(arg, "-") || len(opts.revisions) == 2:
+			return opts, usage
+		default:
+			opts.revisions = append(opts.revisions, arg)
+		}
+		if context != "" {
+			n, err := strconv.Atoi(context)
+			if err != nil || n < 0 {
+				return opts, fmt.Errorf("diff: invalid context length %q", context)
+			}
+			opts.context = n
+		}
+	}
+	if opts.cached && len(opts.revisions) > 1 {
+		return opts, usage
+	}
+	return opts, nil
+}
+
+type diffTreeOptions struct {
+	recursive bool
+	// format is raw or name-status
+	format  string
+	renames renameFlags
+	trees   [2]string
+}
+
+// parseDiffTreeArgs parses the arguments of
+// `diff-tree [-r] [--name-status | --raw] [-M[<n>] | -C[<n>]] [-l<n>] <tree-ish> <tree-ish>`
+func parseDiffTreeArgs(args []string) (diffTreeOptions, error) {
+	usage := fmt.Errorf("usage: mygit diff-tree [-r] [--name-status | --raw] [-M[<n>] | -C[<n>]] [-l<n>] <tree-ish> <tree-ish>")
+	opts := diffTreeOptions{format: "raw", renames: renameFlags{score: -1, limit: -1}}
+	var trees []string
+	for _, arg := range args {
+		if ok, err := parseRenameArg(arg, &opts.renames); ok || err != nil {
+			if err != nil {
+				return opts, fmt.Errorf("diff-tree: %w", err)
+			}
+			continue
+		}
+		switch {
+		case arg == "-r":
+			opts.recursive = true
+		case arg == "--name-status":
+			opts.format = "name-status"
+		case arg == "--raw":
+			opts.format = "raw"
+		case strings.HasPrefix(arg, "-") || len(trees) == 2:
+			return opts, usage
+		default:
+			trees = append(trees, arg)
+		}
+	}
+	if len(trees) != 2 {
+		return opts, usage
+	}
+	opts.trees = [2]string(trees)
+	return opts, nil
+}
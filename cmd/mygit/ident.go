@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/common"
+	"github.com/codecrafters-io/git-starter-go/cmd/config"
+)
+
+// identity returns the signature of the author or the committer, role is "author" or
+// "committer"
+//
+// Like git, the name and the email come from GIT_AUTHOR_NAME and GIT_AUTHOR_EMAIL (or
+// the GIT_COMMITTER_ ones), then from author.name and author.email (or committer.),
+// then from user.name and user.email, and the email lastly from EMAIL. The date comes
+// from GIT_AUTHOR_DATE (or GIT_COMMITTER_DATE) in any format common.ParseDate accepts,
+// and is now otherwise. Without any identity configured the test identity is used.
+func identity(cfg *config.Config, role string, now time.Time) (common.Signature, error) {
+	prefix := "GIT_" + strings.ToUpper(role) + "_"
+	lookup := func(key, fallback string) string {
+		if value, ok := os.LookupEnv(prefix + strings.ToUpper(key)); ok {
+			return value
+		}
+		if value, ok := cfg.Get(role + "." + key); ok {
+			return value
+		}
+		if value, ok := cfg.Get("user." + key); ok {
+			return value
+		}
+		if key == "email" && os.Getenv("EMAIL") != "" {
+			return os.Getenv("EMAIL")
+		}
+		return fallback
+	}
+	sig := common.Signature{
+		Name:  lookup("name", defaultName),
+		Email: lookup("email", defaultEmailID),
+		When:  now,
+	}
+	if strings.TrimSpace(sig.Name) == "" {
+		return common.Signature{}, fmt.Errorf("empty ident name (for <%s>) not allowed", sig.Email)
+	}
+	if date := os.Getenv(prefix + "DATE"); date != "" {
+		when, err := common.ParseDate(date, now)
+		if err != nil {
+			return common.Signature{}, fmt.Errorf("invalid date format: %s", date)
+		}
+		sig.When = when
+	}
+	return sig, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/config"
+)
+
+func TestIdentityPrecedence(t *testing.T) {
+	const (
+		user     = "[user]\n\tname = User\n\temail = user@example.com\n"
+		author   = "[author]\n\tname = Author\n\temail = author@example.com\n"
+		nameOnly = "[user]\n\tname = User\n"
+	)
+	tests := []struct {
+		name      string
+		config    string
+		env       map[string]string
+		wantName  string
+		wantEmail string
+	}{
+		{name: "default", wantName: defaultName, wantEmail: defaultEmailID},
+		{name: "user", config: user, wantName: "User", wantEmail: "user@example.com"},
+		{name: "role over user", config: user + author, wantName: "Author", wantEmail: "author@example.com"},
+		{
+			name:      "environment over config",
+			config:    user + author,
+			env:       map[string]string{"GIT_AUTHOR_NAME": "Env", "GIT_AUTHOR_EMAIL": "env@example.com"},
+			wantName:  "Env",
+			wantEmail: "env@example.com",
+		},
+		{
+			name:      "EMAIL after config",
+			config:    user,
+			env:       map[string]string{"EMAIL": "mail@example.com"},
+			wantName:  "User",
+			wantEmail: "user@example.com",
+		},
+		{
+			name:      "EMAIL last",
+			config:    nameOnly,
+			env:       map[string]string{"EMAIL": "mail@example.com"},
+			wantName:  "User",
+			wantEmail: "mail@example.com",
+		},
+		{
+			name:      "other role ignored",
+			config:    user,
+			env:       map[string]string{"GIT_COMMITTER_NAME": "Committer"},
+			wantName:  "User",
+			wantEmail: "user@example.com",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, key := range []string{"GIT_AUTHOR_NAME", "GIT_AUTHOR_EMAIL", "GIT_AUTHOR_DATE", "EMAIL"} {
+				// Setenv restores the variable after the test, even when it is unset
+				t.Setenv(key, "")
+				os.Unsetenv(key)
+			}
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+			cfg, err := config.Parse([]byte(test.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig, err := identity(cfg, "author", time.Unix(1700000000, 0))
+			if err != nil {
+				t.Fatalf("identity() error = %v", err)
+			}
+			if sig.Name != test.wantName || sig.Email != test.wantEmail {
+				t.Errorf("identity() = %s <%s>, want %s <%s>", sig.Name, sig.Email, test.wantName, test.wantEmail)
+			}
+		})
+	}
+}
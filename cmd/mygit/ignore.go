@@ -0,0 +1,310 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignorePattern is a line of a .gitignore file, of .git/info/exclude or of the file
+// named by core.excludesFile
+type ignorePattern struct {
+	// dir is the directory of the .gitignore file the pattern comes from, the patterns
+	// are relative to it, it is empty for the top of the working tree
+	dir  string
+	expr *regexp.Regexp
+	// negated is set for `!pattern`, which includes again what an earlier pattern excluded
+	negated bool
+	// dirOnly is set for `pattern/`, which only matches directories
+	dirOnly bool
+	// basename is set when the pattern has no slash, it matches the name at any depth
+	basename bool
+}
+
+// matches reports whether the pattern matches the path, relative to the top of the
+// working tree
+func (p ignorePattern) matches(name string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.dir != "" {
+		var found bool
+		if name, found = strings.CutPrefix(name, p.dir+"/"); !found {
+			return false
+		}
+	}
+	if p.basename {
+		name = path.Base(name)
+	}
+	return p.expr.MatchString(name)
+}
+
+// ignoreRules tells which paths of the working tree are ignored, like git the patterns of
+// the .gitignore files win over the ones of .git/info/exclude, which win over the ones of
+// core.excludesFile, and the .gitignore of a directory wins over the ones of its parents
+type ignoreRules struct {
+	// global has the patterns of core.excludesFile followed by the ones of info/exclude
+	global []ignorePattern
+	// dirs has the patterns of the .gitignore file of the directories already seen
+	dirs map[string][]ignorePattern
+}
+
+func newIgnoreRules(repo *repository) (*ignoreRules, error) {
+	cfg, err := repo.config()
+	if err != nil {
+		return nil, err
+	}
+	excludesFile, ok, err := cfg.GetPath("core.excludesfile")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// git reads `$XDG_CONFIG_HOME/git/ignore` (or `~/.config/git/ignore`) by default
+		if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+			excludesFile = filepath.Join(xdg, "git", "ignore")
+		} else if home, err := os.UserHomeDir(); err == nil {
+			excludesFile = filepath.Join(home, ".config", "git", "ignore")
+		}
+	}
+	rules := &ignoreRules{dirs: map[string][]ignorePattern{}}
+	for _, file := range []string{excludesFile, filepath.Join(repo.gitDir, "info", "exclude")} {
+		if file == "" {
+			continue
+		}
+		patterns, err := readIgnoreFile(file, "")
+		if err != nil {
+			return nil, err
+		}
+		rules.global = append(rules.global, patterns...)
+	}
+	return rules, nil
+}
+
+// ignored reports whether the path is ignored, the last pattern matching it decides
+//
+// The parents of the path are expected to not be ignored, git does not look into an
+// ignored directory and nothing in it can be included again.
+func (r *ignoreRules) ignored(name string, isDir bool) (bool, error) {
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		if dir == "." {
+			dir = ""
+		}
+		patterns, err := r.dirPatterns(dir)
+		if err != nil {
+			return false, err
+		}
+		if ignored, found := lastMatch(patterns, name, isDir); found {
+			return ignored, nil
+		}
+		if dir == "" {
+			break
+		}
+	}
+	ignored, _ := lastMatch(r.global, name, isDir)
+	return ignored, nil
+}
+
+// ignoredPath reports whether the path or one of its parents is ignored, for a path whose
+// parents were not checked before
+func (r *ignoreRules) ignoredPath(name string, isDir bool) (bool, error) {
+	parts := strings.Split(name, "/")
+	for i := 1; i <= len(parts); i++ {
+		ignored, err := r.ignored(strings.Join(parts[:i], "/"), isDir || i < len(parts))
+		if err != nil || ignored {
+			return ignored, err
+		}
+	}
+	return false, nil
+}
+
+// hasFiles reports whether there is a file which is not ignored somewhere under the
+// directory
+func (r *ignoreRules) hasFiles(dir string) (bool, error) {
+	found := errors.New("found")
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || name == dir {
+			return err
+		}
+		ignored, err := r.ignored(filepath.ToSlash(name), d.IsDir())
+		switch {
+		case err != nil:
+			return err
+		case ignored && d.IsDir():
+			return filepath.SkipDir
+		case ignored || d.IsDir():
+			return nil
+		}
+		return found
+	})
+	if err == found {
+		return true, nil
+	}
+	return false, err
+}
+
+// dirPatterns returns the patterns of the .gitignore file of the directory
+func (r *ignoreRules) dirPatterns(dir string) ([]ignorePattern, error) {
+	if patterns, ok := r.dirs[dir]; ok {
+		return patterns, nil
+	}
+	patterns, err := readIgnoreFile(filepath.Join(filepath.FromSlash(dir), ".gitignore"), dir)
+	if err != nil {
+		return nil, err
+	}
+	r.dirs[dir] = patterns
+	return patterns, nil
+}
+
+// lastMatch returns whether the last pattern matching the path ignores it, found is false
+// when no pattern matches
+func lastMatch(patterns []ignorePattern, name string, isDir bool) (ignored, found bool) {
+	for i := len(patterns) - 1; i >= 0; i-- {
+		if patterns[i].matches(name, isDir) {
+			return !patterns[i].negated, true
+		}
+	}
+	return false, false
+}
+
+// readIgnoreFile reads the patterns of the file, a missing file has none
+func readIgnoreFile(file, dir string) ([]ignorePattern, error) {
+	content, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var patterns []ignorePattern
+	for _, line := range strings.Split(string(content), "\n") {
+		pattern, ok, err := parseIgnorePattern(strings.TrimSuffix(line, "\r"), dir)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns, nil
+}
+
+// parseIgnorePattern parses a line of an ignore file, ok is false for the blank lines and
+// the comments
+func parseIgnorePattern(line, dir string) (ignorePattern, bool, error) {
+	// the trailing spaces are dropped unless they are escaped with a backslash
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || line[0] == '#' {
+		return ignorePattern{}, false, nil
+	}
+	pattern := ignorePattern{dir: dir}
+	if line[0] == '!' {
+		pattern.negated, line = true, line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		pattern.dirOnly, line = true, strings.TrimRight(line, "/")
+	}
+	// a slash at the start or in the middle makes the pattern relative to the directory
+	pattern.basename = !strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return ignorePattern{}, false, nil
+	}
+	expr, err := regexp.Compile(wildmatchExpr(line))
+	if err != nil {
+		return ignorePattern{}, false, fmt.Errorf("invalid pattern %q: %w", line, err)
+	}
+	pattern.expr = expr
+	return pattern, true, nil
+}
+
+// wildmatchExpr returns the regular expression of a wildcard pattern where `*`, `?` and
+// the `[...]` classes do not match a slash, while `**/` at the start, `/**/` in the
+// middle and `/**` at the end match any number of directories. A backslash escapes the
+// next character.
+func wildmatchExpr(pattern string) string {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch rest := pattern[i:]; {
+		case i == 0 && strings.HasPrefix(rest, "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case rest == "/**":
+			expr.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(rest, "/**/"):
+			expr.WriteString("/(.*/)?")
+			i += 3
+		case rest[0] == '*':
+			// any other run of stars is a single one
+			for i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+			}
+			expr.WriteString("[^/]*")
+		case rest[0] == '?':
+			expr.WriteString("[^/]")
+		case rest[0] == '\\' && len(rest) > 1:
+			expr.WriteString(regexp.QuoteMeta(rest[1:2]))
+			i++
+		case rest[0] == '[':
+			class, used := wildmatchClass(rest)
+			if used == 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+			expr.WriteString(class)
+			i += used - 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(rest[:1]))
+		}
+	}
+	expr.WriteString("$")
+	return expr.String()
+}
+
+// wildmatchClass returns the regular expression of the `[...]` class at the start of the
+// pattern along with its length, 0 when the class is not closed
+func wildmatchClass(pattern string) (string, int) {
+	i := 1
+	negated := i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^')
+	if negated {
+		i++
+	}
+	var class strings.Builder
+	class.WriteString("[")
+	if negated {
+		class.WriteString("^/")
+	}
+	// a ']' right after the opening bracket is a part of the class
+	for first := true; i < len(pattern); i, first = i+1, false {
+		c := pattern[i]
+		switch {
+		case c == ']' && !first:
+			class.WriteString("]")
+			return class.String(), i + 1
+		case c == '-':
+			class.WriteByte(c)
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			class.WriteString(classChar(pattern[i]))
+		default:
+			class.WriteString(classChar(c))
+		}
+	}
+	return "", 0
+}
+
+// classChar returns the character escaped for a class of a regular expression
+func classChar(c byte) string {
+	if c < 0x80 && !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+		return `\` + string(c)
+	}
+	return string(c)
+}
@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestIgnorePatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		dir     string
+		name    string
+		isDir   bool
+		want    bool
+	}{
+		{pattern: "*.o", name: "a.o", want: true},
+		{pattern: "*.o", name: "src/deep/a.o", want: true},
+		{pattern: "*.o", name: "a.oo", want: false},
+		{pattern: "build/", name: "src/build", isDir: true, want: true},
+		{pattern: "build/", name: "build", want: false},
+		{pattern: "/build", name: "build", want: true},
+		{pattern: "/build", name: "src/build", want: false},
+		{pattern: "src/*.go", name: "src/a.go", want: true},
+		{pattern: "src/*.go", name: "src/x/a.go", want: false},
+		{pattern: "**/gen", name: "a/b/gen", isDir: true, want: true},
+		{pattern: "**/gen", name: "gen", want: true},
+		{pattern: "docs/**", name: "docs/a/b", want: true},
+		{pattern: "docs/**", name: "docs", isDir: true, want: false},
+		{pattern: "a/**/b", name: "a/b", want: true},
+		{pattern: "a/**/b", name: "a/x/y/b", want: true},
+		{pattern: "x[0-9]", name: "x1", want: true},
+		{pattern: "x[!0-9]", name: "xa", want: true},
+		{pattern: "x[!0-9]", name: "x1", want: false},
+		{pattern: "x[]]", name: "x]", want: true},
+		{pattern: "?.txt", name: "a.txt", want: true},
+		{pattern: "?.txt", name: "ab.txt", want: false},
+		{pattern: `\#hash`, name: "#hash", want: true},
+		{pattern: `\!bang`, name: "!bang", want: true},
+		{pattern: `sp\ `, name: "sp ", want: true},
+		{pattern: "trailing  ", name: "trailing", want: true},
+		{pattern: "a.(b)+", name: "a.(b)+", want: true},
+		{pattern: "*.log", dir: "logs", name: "logs/1.log", want: true},
+		{pattern: "*.log", dir: "logs", name: "other/1.log", want: false},
+		{pattern: "/1.log", dir: "logs", name: "logs/1.log", want: true},
+		{pattern: "/1.log", dir: "logs", name: "logs/x/1.log", want: false},
+	}
+	for _, test := range tests {
+		pattern, ok, err := parseIgnorePattern(test.pattern, test.dir)
+		if err != nil || !ok {
+			t.Errorf("parseIgnorePattern(%q) = %v, %v", test.pattern, ok, err)
+			continue
+		}
+		if got := pattern.matches(test.name, test.isDir); got != test.want {
+			t.Errorf("pattern %q in %q matches %q = %v, want %v", test.pattern, test.dir, test.name, got, test.want)
+		}
+	}
+
+	for _, line := range []string{"", "# comment", "   ", "/", "!"} {
+		if _, ok, err := parseIgnorePattern(line, ""); ok || err != nil {
+			t.Errorf("parseIgnorePattern(%q) = %v, %v, expected no pattern", line, ok, err)
+		}
+	}
+}
@@ -3,22 +3,32 @@ package main
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/codecrafters-io/git-starter-go/cmd/common"
+	"github.com/codecrafters-io/git-starter-go/cmd/refs"
 )
 
+// defaultName and defaultEmailID are the identity of the commits when none is configured
 const (
 	defaultName    = "TestUser"
 	defaultEmailID = "testuser@example.com"
 )
 
+// errNotRepository is returned by the commands which need a repository when the current
+// working directory is not one
+var errNotRepository = errors.New("fatal: not a git repository (or any of the parent directories): .git")
+
 type GitTree struct {
 	Mode os.FileMode
 	// GitMode is the stringification of the Mode by git standard
@@ -34,9 +44,10 @@ type GitTrees []GitTree
 // WriteTo will write the tree according to the git format
 // it will also sort the entries by name
 func (t GitTrees) WriteTo(w io.Writer) (int64, error) {
-	// Sort entries lexicographically by name
+	// Sort entries the way git does, lexicographically by name where the
+	// directories are compared as if their name had a trailing '/'
 	sort.Slice(t, func(i, j int) bool {
-		return t[i].Name < t[j].Name
+		return t[i].sortName() < t[j].sortName()
 	})
 	var n int64
 	for _, entry := range t {
@@ -59,6 +70,13 @@ func (t GitTrees) WriteTo(w io.Writer) (int64, error) {
 	return n, nil
 }
 
+func (t GitTree) sortName() string {
+	if t.GitMode == "40000" {
+		return t.Name + "/"
+	}
+	return t.Name
+}
+
 // ParseTreeObjectBody unmarshal the byte array into GitTree object
 // it is expected that the header would already been stripped from the content
 // and we are indeed only getting the body of the tree object
@@ -127,7 +145,7 @@ func ParseTreeObjectBody(content []byte) ([]GitTree, error) {
 //		log.Fatalf("failed to write tree: %v", err)
 //	}
 //	fmt.Printf("Tree SHA: %x\n", sha)
-func WriteTree(dirPath string) ([20]byte, error) {
+func WriteTree(repo *repository, dirPath string) ([20]byte, error) {
 	var buffer bytes.Buffer
 	entries := []GitTree{}
 
@@ -146,7 +164,7 @@ func WriteTree(dirPath string) ([20]byte, error) {
 				return nil
 			}
 			// Process subdirectories
-			subTreeSHA, err := WriteTree(path)
+			subTreeSHA, err := WriteTree(repo, path)
 			if err != nil {
 				return err
 			}
@@ -201,116 +219,171 @@ func WriteTree(dirPath string) ([20]byte, error) {
 		return [20]byte{}, err
 	}
 
-	return bufferToFile(&buffer)
+	return bufferToFile(repo, &buffer)
 }
 
-func bufferToFile(buffer *bytes.Buffer) ([20]byte, error) {
-	// Compute the tree's SHA and write it to the object directory
-	treeContent := buffer.Bytes()
-	treeRawSHA, err := common.CalculateSHA(common.FormatGitObjectContent("tree", treeContent))
+// WriteTreeFromIndex generates the tree objects for the entries staged in the index
+// and returns the raw SHA of the root tree
+//
+// This is how `git write-tree` works, the working directory is never looked at, so the
+// resulting tree is the same as the one stock git writes for the same index.
+func WriteTreeFromIndex(repo *repository, idx *common.Index) ([20]byte, error) {
+	return writeIndexTree(repo, idx.Entries, "")
+}
+
+// writeIndexTree writes the tree for the entries under the prefix directory, the entries
+// are expected to be sorted by name, so all the entries of a sub directory are contiguous
+func writeIndexTree(repo *repository, entries []common.IndexEntry, prefix string) ([20]byte, error) {
+	var buffer bytes.Buffer
+	trees := []GitTree{}
+
+	for i := 0; i < len(entries); {
+		entry := entries[i]
+		if entry.Stage() != 0 {
+			return [20]byte{}, fmt.Errorf("%s: unmerged entry in the index", entry.Name)
+		}
+		name := strings.TrimPrefix(entry.Name, prefix)
+		dirName, _, isNested := strings.Cut(name, "/")
+		if !isNested {
+			gitMode := strconv.FormatUint(uint64(entry.Mode), 8)
+			trees = append(trees, GitTree{
+				Mode:    modeFromGit(gitMode),
+				GitMode: gitMode,
+				Name:    name,
+				SHA:     entry.SHA,
+			})
+			i++
+			continue
+		}
+
+		subPrefix := prefix + dirName + "/"
+		end := i
+		for end < len(entries) && strings.HasPrefix(entries[end].Name, subPrefix) {
+			end++
+		}
+		subTreeSHA, err := writeIndexTree(repo, entries[i:end], subPrefix)
+		if err != nil {
+			return [20]byte{}, err
+		}
+		trees = append(trees, GitTree{
+			Mode:    modeFromGit("40000"),
+			GitMode: "40000",
+			Name:    dirName,
+			SHA:     subTreeSHA,
+		})
+		i = end
+	}
+
+	_, err := GitTrees(trees).WriteTo(&buffer)
 	if err != nil {
 		return [20]byte{}, err
 	}
-	treeSHA := hex.EncodeToString(treeRawSHA[:])
-	treeFile, err := common.CreateEmptyObjectFile(".", treeSHA)
+	return bufferToFile(repo, &buffer)
+}
+
+func bufferToFile(repo *repository, buffer *bytes.Buffer) ([20]byte, error) {
+	// Compute the tree's SHA and write it to the object directory
+	treeRawSHA, err := writeObject(repo, "tree", buffer.Bytes())
 	if err != nil {
-		// the tree has been created and return the sha
-		if os.IsExist(err) {
-			return treeRawSHA, nil
-		}
-		return [20]byte{}, fmt.Errorf("couldn't create tree object file: %w", err)
+		return [20]byte{}, fmt.Errorf("couldn't write tree object: %w", err)
 	}
-	defer treeFile.Close()
-	err = common.WriteCompactContent(
-		treeFile,
-		bytes.NewReader(common.FormatGitObjectContent("tree", treeContent)),
-	)
+	return treeRawSHA, nil
+}
+
+// writeObject writes the content as an object of the given type to the object store
+// and returns the raw SHA of the object, existing objects are not rewritten
+func writeObject(repo *repository, objType string, content []byte) ([20]byte, error) {
+	hash, err := repo.objects().Put(objType, content)
 	if err != nil {
 		return [20]byte{}, err
 	}
-	return treeRawSHA, nil
+	rawSHA, err := hex.DecodeString(hash)
+	if err != nil {
+		return [20]byte{}, fmt.Errorf("object store returned an invalid hash %q", hash)
+	}
+	return [20]byte(rawSHA), nil
 }
 
 // WriteCommitContent writes the content in the expected commit object form
-func WriteCommitContent(treeSHA, commitMsg string, parentSHA ...string) ([]byte, error) {
-	var buffer bytes.Buffer
-	_, err := buffer.WriteString(fmt.Sprintf("tree %s\n", treeSHA))
+func WriteCommitContent(repo *repository, treeSHA, commitMsg string, parentSHA ...string) ([]byte, error) {
+	cfg, err := repo.config()
 	if err != nil {
-		return nil, fmt.Errorf("write tree: %w", err)
-	}
-	for i := range parentSHA {
-		_, err = buffer.WriteString(fmt.Sprintf("parent %s\n", parentSHA[i]))
-		if err != nil {
-			return nil, fmt.Errorf("write parent: %w", err)
-		}
+		return nil, err
 	}
 	now := time.Now()
-	_, err = buffer.WriteString(getAuthorCommiterString("author", now))
+	author, err := identity(cfg, "author", now)
 	if err != nil {
-		return nil, fmt.Errorf("write author: %w", err)
+		return nil, err
 	}
-	_, err = buffer.WriteString(getAuthorCommiterString("committer", now))
+	committer, err := identity(cfg, "committer", now)
 	if err != nil {
-		return nil, fmt.Errorf("write committer: %w", err)
+		return nil, err
+	}
+	commit := common.Commit{
+		Tree:      treeSHA,
+		Parents:   parentSHA,
+		Author:    author,
+		Committer: committer,
+		Message:   commitMsg + "\n",
 	}
-	err = buffer.WriteByte('\n')
+	return commit.Serialize(), nil
+}
+
+// readCommit reads and parses the commit object with the given hash
+func readCommit(store common.ObjectStore, commitHash string) (*common.Commit, error) {
+	content, objType, err := store.Get(commitHash)
 	if err != nil {
-		return nil, fmt.Errorf("write new line: %w", err)
+		return nil, fmt.Errorf("read object: %w", err)
 	}
-	_, err = buffer.WriteString(commitMsg + "\n")
+	if objType != "commit" {
+		return nil, fmt.Errorf("expected commit, got %s", objType)
+	}
+	return common.ParseCommit(content)
+}
+
+func GetTreeHashFromCommit(commitHash string, store common.ObjectStore) (string, error) {
+	commit, err := readCommit(store, commitHash)
 	if err != nil {
-		return nil, fmt.Errorf("write commitMsg: %w", err)
+		return "", fmt.Errorf("GetTreeHashFromCommit: %w", err)
 	}
-	return buffer.Bytes(), nil
+	return commit.Tree, nil
 }
 
-func getAuthorCommiterString(role string, time time.Time) string {
-	timeUnix := time.Unix()
-	_, offset := time.Zone()
-	offsetHours := offset / 3600
-	offsetMinutes := (offset % 3600) / 60
-	tzSign := "+"
-	if offset < 0 {
-		tzSign = "-"
-	}
-	return fmt.Sprintf(
-		"%s %s <%s> %d %s%02d%02d\n",
-		role,
-		defaultName,
-		defaultEmailID,
-		timeUnix,
-		tzSign,
-		offsetHours,
-		offsetMinutes,
-	)
+// walkTree calls fn for every file of the tree and of its subtrees in the tree order, the
+// name is the slash separated path of the file below prefix
+func walkTree(repo *repository, treeHash, prefix string, fn func(name string, entry GitTree) error) error {
+	entries, err := readTree(repo, treeHash)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := path.Join(prefix, entry.Name)
+		if entry.GitMode == "40000" {
+			err = walkTree(repo, hex.EncodeToString(entry.SHA[:]), name, fn)
+		} else {
+			err = fn(name, entry)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func GetTreeHashFromCommit(commitHash, gitDir string) (string, error) {
-	objFile, err := common.GetFileFromHash(gitDir, commitHash)
+// readTree reads and parses the tree object with the given hash
+func readTree(repo *repository, treeHash string) ([]GitTree, error) {
+	content, objType, err := repo.objects().Get(treeHash)
 	if err != nil {
-		return "", fmt.Errorf("GetTreeHashFromCommit: get file from hash: %w", err)
+		return nil, fmt.Errorf("read tree %s: %w", treeHash, err)
 	}
-	content, objType, err := common.ReadObjectFile(objFile)
+	if objType != "tree" {
+		return nil, fmt.Errorf("%s is a %s, not a tree", treeHash, objType)
+	}
+	entries, err := ParseTreeObjectBody(content)
 	if err != nil {
-		return "", fmt.Errorf("GetTreeHashFromCommit: read object file: %w", err)
+		return nil, fmt.Errorf("parse tree %s: %w", treeHash, err)
 	}
-	if objType != "commit" {
-		return "", fmt.Errorf("GetTreeHashFromCommit: expected commit, got %s", objType)
-	}
-	// Commit object content is like:
-	// tree <tree-hash>
-	// parent <parent-hash>
-	// author ...
-	// committer ...
-	// <blank line>
-	// Commit message
-	lines := strings.Split(string(content), "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, "tree ") {
-			return strings.TrimSpace(strings.TrimPrefix(line, "tree ")), nil
-		}
-	}
-	return "", fmt.Errorf("tree hash not found in commit object")
+	return entries, nil
 }
 
 // RenderTree reconstructs the working directory structure from a Git tree object.
@@ -323,7 +396,7 @@ func GetTreeHashFromCommit(commitHash, gitDir string) (string, error) {
 // Parameters:
 //   - hash: The SHA-1 hash (in hexadecimal) of the Git tree object to render.
 //   - workingDir: The target directory path where the files and folders should be created.
-//   - repoRoot: The root directory of the Git repository (i.e., where `.git` resides).
+//   - store: The object store of the repository the objects are read from.
 //
 // Behavior:
 //   - For each entry in the tree:
@@ -343,14 +416,10 @@ func GetTreeHashFromCommit(commitHash, gitDir string) (string, error) {
 //
 //	This function is typically invoked after unpacking Git objects during a clone operation
 //	to populate the working directory with the initial checkout.
-func RenderTree(hash, workingDir, repoRoot string) error {
-	objFile, err := common.GetFileFromHash(repoRoot, hash)
-	if err != nil {
-		return fmt.Errorf("RenderTree: get file from hash: %w", err)
-	}
-	fileContent, objType, err := common.ReadObjectFile(objFile)
+func RenderTree(hash, workingDir string, store common.ObjectStore) error {
+	fileContent, objType, err := store.Get(hash)
 	if err != nil {
-		return fmt.Errorf("RenderTree: read the object file: %w", err)
+		return fmt.Errorf("RenderTree: read the tree object: %w", err)
 	}
 	if objType != "tree" {
 		return fmt.Errorf("RenderTree: got the object type %q for render Tree", objType)
@@ -369,18 +438,14 @@ func RenderTree(hash, workingDir, repoRoot string) error {
 			if err != nil {
 				return fmt.Errorf("RenderTree: mkdir %s: %w", entryPath, err)
 			}
-			err = RenderTree(shaHex, entryPath, repoRoot)
+			err = RenderTree(shaHex, entryPath, store)
 			if err != nil {
 				return err
 			}
 		case "100644", "100755":
-			objFile, err := common.GetFileFromHash(repoRoot, shaHex)
+			content, objType, err := store.Get(shaHex)
 			if err != nil {
-				return fmt.Errorf("RenderTree: get file for blob %s: %w", shaHex, err)
-			}
-			content, objType, err := common.ReadObjectFile(objFile)
-			if err != nil {
-				return fmt.Errorf("RenderTree: read blob file: %w", err)
+				return fmt.Errorf("RenderTree: read blob %s: %w", shaHex, err)
 			}
 			if objType != "blob" {
 				return fmt.Errorf("RenderTree: expected blob, got %s", objType)
@@ -399,3 +464,244 @@ func RenderTree(hash, workingDir, repoRoot string) error {
 	}
 	return nil
 }
+
+// stagePathspec adds every file matching the pathspec to the index
+//
+// The pathspec is either a file or a directory (relative to the repository root), in case
+// of a directory all the files under it are staged. Index entries under the pathspec which
+// are missing from the working directory are removed from the index. Like git, the ignored
+// files are only staged when they are already tracked, and naming an ignored path fails.
+func stagePathspec(repo *repository, idx *common.Index, rules *ignoreRules, pathspec string) error {
+	cleaned := filepath.ToSlash(filepath.Clean(pathspec))
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") || filepath.IsAbs(pathspec) {
+		return fmt.Errorf("%s: %q is outside repository", pathspec, pathspec)
+	}
+	prefix := cleaned + "/"
+	if cleaned == "." {
+		prefix = ""
+	}
+	// tracked has the index entries along with the directories holding them
+	tracked := map[string]bool{}
+	for _, entry := range idx.Entries {
+		tracked[entry.Name] = true
+		for dir := path.Dir(entry.Name); dir != "."; dir = path.Dir(dir) {
+			tracked[dir] = true
+		}
+	}
+
+	matched := false
+	seen := map[string]bool{}
+	info, err := os.Lstat(pathspec)
+	if err == nil {
+		// an ignored path cannot be named, unless it is a tracked file
+		if cleaned != "." && (info.IsDir() || !tracked[cleaned]) {
+			ignored, err := rules.ignoredPath(cleaned, info.IsDir())
+			if err != nil {
+				return err
+			}
+			if ignored {
+				return fmt.Errorf("%s: path is ignored by one of your .gitignore files", pathspec)
+			}
+		}
+		// ignoredDir is the ignored directory walked for the tracked files it holds
+		ignoredDir := ""
+		err = filepath.WalkDir(cleaned, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return fmt.Errorf("error accessing %s: %w", path, err)
+			}
+			if d.IsDir() && d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			name := filepath.ToSlash(path)
+			if ignoredDir != "" && !strings.HasPrefix(name, ignoredDir+"/") {
+				ignoredDir = ""
+			}
+			ignored := ignoredDir != ""
+			if !ignored && name != cleaned {
+				if ignored, err = rules.ignored(name, d.IsDir()); err != nil {
+					return err
+				}
+			}
+			switch {
+			case !ignored:
+			case d.IsDir() && !tracked[name]:
+				return filepath.SkipDir
+			case d.IsDir():
+				if ignoredDir == "" {
+					ignoredDir = name
+				}
+			case !tracked[name]:
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+			if err := stageFile(repo, idx, name); err != nil {
+				return err
+			}
+			seen[name] = true
+			matched = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("stat %s: %w", pathspec, err)
+	}
+
+	// the files which got deleted from the working directory
+	for _, entry := range slices.Clone(idx.Entries) {
+		if entry.Name != cleaned && !strings.HasPrefix(entry.Name, prefix) {
+			continue
+		}
+		matched = true
+		if seen[entry.Name] {
+			continue
+		}
+		if _, err := os.Lstat(entry.Name); os.IsNotExist(err) {
+			idx.Remove(entry.Name)
+		}
+	}
+
+	if !matched {
+		return fmt.Errorf("pathspec %q did not match any files", pathspec)
+	}
+	return nil
+}
+
+// stageFile writes the blob for the file at name and adds it to the index
+func stageFile(repo *repository, idx *common.Index, name string) error {
+	info, err := os.Lstat(name)
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", name, err)
+	}
+	var content []byte
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		// symlinks are stored as a blob with the link target as the content
+		target, err := os.Readlink(name)
+		if err != nil {
+			return fmt.Errorf("read link %s: %w", name, err)
+		}
+		content = []byte(target)
+	case info.Mode().IsRegular():
+		content, err = os.ReadFile(name)
+		if err != nil {
+			return fmt.Errorf("read file %s: %w", name, err)
+		}
+	default:
+		return fmt.Errorf("%s: unsupported file type %s", name, info.Mode().Type())
+	}
+	blobSHA, err := writeObject(repo, "blob", content)
+	if err != nil {
+		return fmt.Errorf("write blob for %s: %w", name, err)
+	}
+	idx.Add(common.NewIndexEntry(name, info, blobSHA))
+	return nil
+}
+
+// resolveRevision resolves the revision (a full object hash or a ref name) to an object hash
+//
+// Short ref names are looked up the same way git does, e.g. "main" is tried as
+// "refs/main", "refs/tags/main", "refs/heads/main" and so on
+func resolveRevision(repo *repository, rev string) (string, error) {
+	if len(rev) == 40 {
+		if _, err := hex.DecodeString(rev); err == nil {
+			return strings.ToLower(rev), nil
+		}
+	}
+	if hash, err := resolveRevisionName(repo, rev); err == nil || !errors.Is(err, refs.ErrNotFound) {
+		return hash, err
+	}
+	if len(rev) >= 4 && len(rev) < 40 {
+		if _, err := hex.DecodeString(rev[:len(rev)&^1]); err == nil {
+			return findObjectByPrefix(strings.ToLower(rev))
+		}
+	}
+	return "", fmt.Errorf("ambiguous argument %q: unknown revision", rev)
+}
+
+// resolveRevisionName looks up the ref name the same way git does
+func resolveRevisionName(repo *repository, rev string) (string, error) {
+	candidates := []string{
+		rev,
+		"refs/" + rev,
+		"refs/tags/" + rev,
+		"refs/heads/" + rev,
+		"refs/remotes/" + rev,
+		"refs/remotes/" + rev + "/HEAD",
+	}
+	for _, name := range candidates {
+		hash, err := refs.Resolve(repo.gitDir, name)
+		if err == nil {
+			return hash, nil
+		}
+		if !errors.Is(err, refs.ErrNotFound) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("%s: %w", rev, refs.ErrNotFound)
+}
+
+// findObjectByPrefix finds the loose or packed object whose hash starts with the abbreviated hash
+func findObjectByPrefix(prefix string) (string, error) {
+	matches, err := common.FindObjectsByPrefix(".", prefix)
+	if err != nil {
+		return "", err
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("ambiguous argument %q: unknown revision", prefix)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("short object ID %s is ambiguous", prefix)
+	}
+}
+
+// peelTag returns the object an annotated tag points to, other objects are returned as is
+func peelTag(repo *repository, hash string) (string, error) {
+	for range 10 {
+		content, objType, err := repo.objects().Get(hash)
+		if err != nil {
+			return "", fmt.Errorf("peel tag: %w", err)
+		}
+		if objType != "tag" {
+			return hash, nil
+		}
+		tag, err := common.ParseTag(content)
+		if err != nil {
+			return "", fmt.Errorf("peel tag %s: %w", hash, err)
+		}
+		hash = tag.Object
+	}
+	return "", fmt.Errorf("peel tag: too many nested tags")
+}
+
+// resolveTree resolves the tree-ish revision to the hash of a tree, a commit gives its
+// tree and a tag the tree of the object it points to
+func resolveTree(repo *repository, rev string) (string, error) {
+	hash, err := resolveRevision(repo, rev)
+	if err != nil {
+		return "", err
+	}
+	if hash, err = peelTag(repo, hash); err != nil {
+		return "", err
+	}
+	content, objType, err := repo.objects().Get(hash)
+	if err != nil {
+		return "", fmt.Errorf("read object %s: %w", hash, err)
+	}
+	switch objType {
+	case "tree":
+		return hash, nil
+	case "commit":
+		commit, err := common.ParseCommit(content)
+		if err != nil {
+			return "", fmt.Errorf("parse commit %s: %w", hash, err)
+		}
+		return commit.Tree, nil
+	}
+	return "", fmt.Errorf("%s is a %s, not a tree", rev, objType)
+}
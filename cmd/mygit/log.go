@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/common"
+	"github.com/codecrafters-io/git-starter-go/cmd/refs"
+)
+
+// gitDateLayout is the default date format git uses in `git log`
+const gitDateLayout = "Mon Jan 2 15:04:05 2006 -0700"
+
+type logOptions struct {
+	revision    string
+	maxCount    int
+	firstParent bool
+	// pretty is the built-in format: oneline (with the abbreviated hashes of --oneline),
+	// short, medium or full, medium when empty
+	pretty string
+	// format is the format string of --format, it wins over pretty
+	format string
+}
+
+// logCmd has the logic for the log subcommand
+//
+// It walks the parent links starting at the revision (HEAD by default) and prints the commits
+// newest first (by committer date), the same order `git log` uses without any sorting options
+func logCmd(repo *repository, opts logOptions) error {
+	if opts.revision == "HEAD" {
+		// git tells an unborn branch apart from an unknown revision
+		headRef, _, err := refs.Follow(repo.gitDir, "HEAD")
+		if errors.Is(err, refs.ErrNotFound) && headRef != "HEAD" {
+			return fmt.Errorf(
+				"fatal: your current branch '%s' does not have any commits yet",
+				strings.TrimPrefix(headRef, "refs/heads/"),
+			)
+		}
+	}
+	out := bufio.NewWriter(os.Stdout)
+	if err := writeLog(out, repo, opts); err != nil {
+		return fmt.Errorf("log: %w", err)
+	}
+	return out.Flush()
+}
+
+// writeLog writes the commits of the history of the revision in the format of the options
+func writeLog(w io.Writer, repo *repository, opts logOptions) error {
+	start, err := resolveRevision(repo, opts.revision)
+	if err != nil {
+		return err
+	}
+	walker, err := newCommitWalker(repo, opts.firstParent)
+	if err != nil {
+		return err
+	}
+	if err := walker.push(start); err != nil {
+		return err
+	}
+	for shown := 0; opts.maxCount < 0 || shown < opts.maxCount; shown++ {
+		commit, ok, err := walker.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		switch {
+		case opts.format != "":
+			fmt.Fprintln(w, formatCommit(opts.format, commit))
+		case opts.pretty == "oneline":
+			fmt.Fprintf(w, "%s %s\n", commit.hash[:7], commit.Subject())
+		default:
+			if shown > 0 {
+				fmt.Fprintln(w)
+			}
+			writeCommit(w, opts.pretty, commit)
+		}
+	}
+	return nil
+}
+
+// writeCommit writes the commit in the short, medium (the default of `git log`, when
+// pretty is empty) or full format
+func writeCommit(w io.Writer, pretty string, commit *logCommit) {
+	fmt.Fprintf(w, "commit %s\n", commit.hash)
+	if len(commit.Parents) > 1 {
+		short := make([]string, len(commit.Parents))
+		for i, parent := range commit.Parents {
+			short[i] = parent[:7]
+		}
+		fmt.Fprintf(w, "Merge: %s\n", strings.Join(short, " "))
+	}
+	fmt.Fprintf(w, "Author: %s <%s>\n", commit.Author.Name, commit.Author.Email)
+	switch pretty {
+	case "short":
+	case "full":
+		fmt.Fprintf(w, "Commit: %s <%s>\n", commit.Committer.Name, commit.Committer.Email)
+	default:
+		fmt.Fprintf(w, "Date:   %s\n", commit.Author.When.Format(gitDateLayout))
+	}
+	fmt.Fprintln(w)
+	message := strings.TrimRight(commit.Message, "\n")
+	if pretty == "short" {
+		// only the subject paragraph, with its lines as they are
+		message, _, _ = strings.Cut(strings.TrimLeft(message, "\n"), "\n\n")
+	}
+	for _, line := range strings.Split(message, "\n") {
+		fmt.Fprintf(w, "    %s\n", line)
+	}
+}
+
+// formatCommit expands the `--format` placeholders for the commit
+//
+// Supported placeholders are %H, %h, %T, %t, %P, %p, %an, %ae, %ad, %cn, %ce, %cd,
+// %s, %b, %n and %%. Unknown placeholders are printed as is, like git does.
+func formatCommit(format string, commit *logCommit) string {
+	var builder strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			builder.WriteByte(format[i])
+			continue
+		}
+		placeholder := format[i+1:]
+		replacement, used := expandPlaceholder(placeholder, commit)
+		if used == 0 {
+			builder.WriteByte('%')
+			continue
+		}
+		builder.WriteString(replacement)
+		i += used
+	}
+	return builder.String()
+}
+
+// expandPlaceholder expands the placeholder at the start of the string (without the '%'),
+// it returns the replacement along with the number of characters used
+func expandPlaceholder(placeholder string, commit *logCommit) (string, int) {
+	shortParents := make([]string, len(commit.Parents))
+	for i, parent := range commit.Parents {
+		shortParents[i] = parent[:7]
+	}
+	twoLetters := map[string]string{
+		"an": commit.Author.Name,
+		"ae": commit.Author.Email,
+		"ad": commit.Author.When.Format(gitDateLayout),
+		"cn": commit.Committer.Name,
+		"ce": commit.Committer.Email,
+		"cd": commit.Committer.When.Format(gitDateLayout),
+	}
+	if len(placeholder) >= 2 {
+		if replacement, ok := twoLetters[placeholder[:2]]; ok {
+			return replacement, 2
+		}
+	}
+	switch placeholder[0] {
+	case 'H':
+		return commit.hash, 1
+	case 'h':
+		return commit.hash[:7], 1
+	case 'T':
+		return commit.Tree, 1
+	case 't':
+		return commit.Tree[:7], 1
+	case 'P':
+		return strings.Join(commit.Parents, " "), 1
+	case 'p':
+		return strings.Join(shortParents, " "), 1
+	case 's':
+		return commit.Subject(), 1
+	case 'b':
+		return commit.Body(), 1
+	case 'n':
+		return "\n", 1
+	case '%':
+		return "%", 1
+	}
+	return "", 0
+}
+
+// logCommit is a commit along with its hash, as shown by log
+type logCommit struct {
+	*common.Commit
+	hash string
+	// seq is the order in which the commit was queued, it breaks the ties between
+	// commits with the same committer date
+	seq int
+}
+
+// commitWalker returns commits in decreasing committer date order
+//
+// The shallow commits of a shallow clone are roots, their parents are not in the repository.
+type commitWalker struct {
+	store       common.ObjectStore
+	queue       commitQueue
+	seen        map[string]bool
+	firstParent bool
+	shallow     map[string]bool
+}
+
+func newCommitWalker(repo *repository, firstParent bool) (*commitWalker, error) {
+	shallow, err := common.ReadShallow(repo.gitDir)
+	if err != nil {
+		return nil, err
+	}
+	walker := &commitWalker{store: repo.objects(), seen: map[string]bool{}, firstParent: firstParent, shallow: map[string]bool{}}
+	for _, hash := range shallow {
+		walker.shallow[hash] = true
+	}
+	return walker, nil
+}
+
+// push adds the commit to the queue unless it has already been seen
+func (w *commitWalker) push(hash string) error {
+	if w.seen[hash] {
+		return nil
+	}
+	w.seen[hash] = true
+	commit, err := readCommit(w.store, hash)
+	if err != nil {
+		return fmt.Errorf("read commit %s: %w", hash, err)
+	}
+	heap.Push(&w.queue, &logCommit{Commit: commit, hash: hash, seq: len(w.seen)})
+	return nil
+}
+
+// next pops the newest commit and queues its parents
+func (w *commitWalker) next() (*logCommit, bool, error) {
+	if w.queue.Len() == 0 {
+		return nil, false, nil
+	}
+	commit := heap.Pop(&w.queue).(*logCommit)
+	parents := commit.Parents
+	if w.shallow[commit.hash] {
+		parents = nil
+	}
+	if w.firstParent && len(parents) > 1 {
+		parents = parents[:1]
+	}
+	for _, parent := range parents {
+		if err := w.push(parent); err != nil {
+			return nil, false, err
+		}
+	}
+	return commit, true, nil
+}
+
+// commitQueue is a max heap of commits by committer date
+type commitQueue []*logCommit
+
+func (q commitQueue) Len() int { return len(q) }
+func (q commitQueue) Less(i, j int) bool {
+	if q[i].Committer.When.Equal(q[j].Committer.When) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].Committer.When.After(q[j].Committer.When)
+}
+func (q commitQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x any)   { *q = append(*q, x.(*logCommit)) }
+func (q *commitQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/refs"
+)
+
+// setUpLog builds a history where a side branch is merged into main
+//
+//	a715136 root <- 81df65c second <- 5f2e8e5 merge
+//	              <- 17c7a8b side  <-
+func setUpLog(t *testing.T) *repository {
+	repo := inTempRepository(t)
+	emptyTree, err := writeObject(repo, "tree", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := hex.EncodeToString(emptyTree[:])
+	commit := func(message string, when int64, parents ...string) string {
+		t.Helper()
+		content := "tree " + tree + "\n"
+		for _, parent := range parents {
+			content += "parent " + parent + "\n"
+		}
+		content += fmt.Sprintf("author A U Thor <author@example.com> %d +0100\n", when) +
+			fmt.Sprintf("committer C O Mitter <committer@example.com> %d +0100\n", when) +
+			"\n" + message
+		hash, err := writeObject(repo, "commit", []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hex.EncodeToString(hash[:])
+	}
+	root := commit("root\n", 1700000000)
+	second := commit("second\nline\n\nbody one\n\nbody two\n", 1700000100, root)
+	side := commit("side\n", 1700000150, root)
+	merge := commit("merge\n", 1700000200, second, side)
+	if err := refs.Update(repo.gitDir, "HEAD", merge); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestLog(t *testing.T) {
+	repo := setUpLog(t)
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{
+			args: []string{"--oneline"},
+			want: "5f2e8e5 merge\n17c7a8b side\n81df65c second line\na715136 root\n",
+		},
+		{
+			args: []string{"--oneline", "-n", "2"},
+			want: "5f2e8e5 merge\n17c7a8b side\n",
+		},
+		{
+			args: []string{"--oneline", "-3", "--first-parent"},
+			want: "5f2e8e5 merge\n81df65c second line\na715136 root\n",
+		},
+		{
+			args: []string{"--oneline", "--max-count=1", "17c7a8b4a80b41f7904b17275167f6e551517511"},
+			want: "17c7a8b side\n",
+		},
+		{
+			args: []string{"--pretty=oneline", "-n2"},
+			want: "5f2e8e5a5b5a7719297f3ea81dc878cf58633071 merge\n" +
+				"17c7a8b4a80b41f7904b17275167f6e551517511 side\n",
+		},
+		{
+			args: []string{"-3", "--format=%H|%h|%an|%ae|%ad|%s|%b|"},
+			want: "5f2e8e5a5b5a7719297f3ea81dc878cf58633071|5f2e8e5|A U Thor|author@example.com|Tue Nov 14 23:16:40 2023 +0100|merge||\n" +
+				"17c7a8b4a80b41f7904b17275167f6e551517511|17c7a8b|A U Thor|author@example.com|Tue Nov 14 23:15:50 2023 +0100|side||\n" +
+				"81df65c97186ea7ef29f28b0292dd68880352bae|81df65c|A U Thor|author@example.com|Tue Nov 14 23:15:00 2023 +0100|second line|body one\n\nbody two\n|\n",
+		},
+		{
+			args: []string{"-1", "--pretty=format:%p %%"},
+			want: "81df65c 17c7a8b %\n",
+		},
+		{
+			args: []string{"-n", "2", "--first-parent", "--pretty=short"},
+			want: "commit 5f2e8e5a5b5a7719297f3ea81dc878cf58633071\n" +
+				"Merge: 81df65c 17c7a8b\n" +
+				"Author: A U Thor <author@example.com>\n" +
+				"\n" +
+				"    merge\n" +
+				"\n" +
+				"commit 81df65c97186ea7ef29f28b0292dd68880352bae\n" +
+				"Author: A U Thor <author@example.com>\n" +
+				"\n" +
+				"    second\n" +
+				"    line\n",
+		},
+		{
+			args: []string{"-1", "--format=full"},
+			want: "commit 5f2e8e5a5b5a7719297f3ea81dc878cf58633071\n" +
+				"Merge: 81df65c 17c7a8b\n" +
+				"Author: A U Thor <author@example.com>\n" +
+				"Commit: C O Mitter <committer@example.com>\n" +
+				"\n" +
+				"    merge\n",
+		},
+		{
+			args: []string{"--first-parent", "-2", "HEAD"},
+			want: "commit 5f2e8e5a5b5a7719297f3ea81dc878cf58633071\n" +
+				"Merge: 81df65c 17c7a8b\n" +
+				"Author: A U Thor <author@example.com>\n" +
+				"Date:   Tue Nov 14 23:16:40 2023 +0100\n" +
+				"\n" +
+				"    merge\n" +
+				"\n" +
+				"commit 81df65c97186ea7ef29f28b0292dd68880352bae\n" +
+				"Author: A U Thor <author@example.com>\n" +
+				"Date:   Tue Nov 14 23:15:00 2023 +0100\n" +
+				"\n" +
+				"    second\n" +
+				"    line\n" +
+				"    \n" +
+				"    body one\n" +
+				"    \n" +
+				"    body two\n",
+		},
+	}
+	for _, test := range tests {
+		opts, err := parseLogArgs(test.args)
+		if err != nil {
+			t.Fatalf("parseLogArgs(%q) error = %v", test.args, err)
+		}
+		var out bytes.Buffer
+		if err := writeLog(&out, repo, opts); err != nil {
+			t.Fatalf("log %s error = %v", strings.Join(test.args, " "), err)
+		}
+		if out.String() != test.want {
+			t.Errorf("log %s gives\n%s\nwant\n%s", strings.Join(test.args, " "), out.String(), test.want)
+		}
+	}
+}
+
+func TestLogUnbornBranch(t *testing.T) {
+	repo := inTempRepository(t)
+	want := "fatal: your current branch 'main' does not have any commits yet"
+	if err := logCmd(repo, logOptions{revision: "HEAD", maxCount: -1}); err == nil || err.Error() != want {
+		t.Errorf("log on an unborn branch error = %v, want %s", err, want)
+	}
+}
+
+func TestLogFormatNames(t *testing.T) {
+	for _, arg := range []string{"--pretty", "--pretty=medium", "--format=medium"} {
+		opts, err := parseLogArgs([]string{"--oneline", arg})
+		if err != nil || opts.pretty != "medium" || opts.format != "" {
+			t.Errorf("parseLogArgs(%s) = %+v, %v, want the medium format", arg, opts, err)
+		}
+	}
+	for _, arg := range []string{"--format=fuller", "--pretty=x", "--format=x"} {
+		if _, err := parseLogArgs([]string{arg}); err == nil {
+			t.Errorf("parseLogArgs(%s) takes an unknown format", arg)
+		}
+	}
+}
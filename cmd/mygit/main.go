@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 )
@@ -11,9 +12,11 @@ func main() {
 		os.Exit(1)
 	}
 
-	switch command := os.Args[1]; command {
+	command := os.Args[1]
+	repo := openRepository()
+	switch command {
 	case "init":
-		must(initCMD())
+		must(initCMD(repo))
 	case "cat-file":
 		if len(os.Args) != 4 {
 			must(fmt.Errorf("usage: mygit cat-file <flag> <file>"))
@@ -21,7 +24,7 @@ func main() {
 		if os.Args[2] != "-p" {
 			must(fmt.Errorf("usage: mygit cat-file -p <file>"))
 		}
-		must(catFileCmd(os.Args[3]))
+		must(catFileCmd(repo, os.Args[3]))
 	case "hash-object":
 		if len(os.Args) != 4 {
 			must(fmt.Errorf("usage: mygit hash-object <flag> <file>"))
@@ -29,7 +32,7 @@ func main() {
 		if os.Args[2] != "-w" {
 			must(fmt.Errorf("usage: mygit hash-object -w <file>"))
 		}
-		must(hashObjectCmd(os.Args[3]))
+		must(hashObjectCmd(repo, os.Args[3]))
 	case "ls-tree":
 		if len(os.Args) != 4 {
 			must(fmt.Errorf("usage: mygit ls-tree <flag> <file>"))
@@ -37,12 +40,17 @@ func main() {
 		if os.Args[2] != "--name-only" {
 			must(fmt.Errorf("usage: mygit cat-file --name-only <tree_sha>"))
 		}
-		must(lsTreeCmd(os.Args[3]))
+		must(lsTreeCmd(repo, os.Args[3]))
+	case "add":
+		if len(os.Args) < 3 {
+			must(fmt.Errorf("usage: mygit add <pathspec>..."))
+		}
+		must(addCmd(repo, os.Args[2:]))
 	case "write-tree":
 		if len(os.Args) != 2 {
 			must(fmt.Errorf("usage: mygit write-tree"))
 		}
-		must(writeTreeCmd())
+		must(writeTreeCmd(repo))
 	case "commit-tree":
 		if len(os.Args) != 7 {
 			must(fmt.Errorf("usage: mygit commit-tree <tree-sha> -p <commit-sha> -m <msg>"))
@@ -50,24 +58,85 @@ func main() {
 		if os.Args[3] != "-p" || os.Args[5] != "-m" {
 			must(fmt.Errorf("usage: mygit commit-tree <tree-sha> -p <commit-sha> -m <msg>"))
 		}
-		must(commitTreeCmd(os.Args[2], os.Args[4], os.Args[6]))
+		must(commitTreeCmd(repo, os.Args[2], os.Args[4], os.Args[6]))
+	case "commit":
+		commitMsg, allowEmpty, err := parseCommitArgs(os.Args[2:])
+		must(err)
+		must(commitCmd(repo, commitMsg, allowEmpty))
+	case "log":
+		opts, err := parseLogArgs(os.Args[2:])
+		must(err)
+		must(logCmd(repo, opts))
+	case "update-ref":
+		opts, err := parseUpdateRefArgs(os.Args[2:])
+		must(err)
+		must(updateRefCmd(repo, opts))
+	case "symbolic-ref":
+		opts, err := parseSymbolicRefArgs(os.Args[2:])
+		must(err)
+		must(symbolicRefCmd(repo, opts))
+	case "show-ref":
+		opts, err := parseShowRefArgs(os.Args[2:])
+		must(err)
+		must(showRefCmd(repo, opts))
 	case "clone":
-		if len(os.Args) != 4 {
-			must(fmt.Errorf("usage: mygit clone <repo_uri> <some_dir>"))
+		opts, err := parseCloneArgs(os.Args[2:])
+		must(err)
+		must(cloneCmd(opts))
+	case "fetch":
+		opts, err := parseFetchArgs(os.Args[2:])
+		must(err)
+		must(fetchCmd(repo, opts))
+	case "status":
+		opts, err := parseStatusArgs(os.Args[2:])
+		must(err)
+		must(statusCmd(repo, opts))
+	case "config":
+		opts, err := parseConfigArgs(os.Args[2:])
+		must(err)
+		must(configCmd(repo, opts))
+	case "diff":
+		opts, err := parseDiffArgs(os.Args[2:])
+		must(err)
+		must(diffCmd(repo, opts))
+	case "diff-tree":
+		opts, err := parseDiffTreeArgs(os.Args[2:])
+		must(err)
+		must(diffTreeCmd(repo, opts))
+	case "index-pack":
+		if len(os.Args) != 3 {
+			must(fmt.Errorf("usage: mygit index-pack <pack-file>"))
 		}
-		must(cloneCmd(os.Args[2], os.Args[3]))
+		must(indexPackCmd(os.Args[2]))
 	default:
 		must(fmt.Errorf("unknown command: %s", command))
 	}
 }
 
+// errSilentExit makes the command exit with a non zero status without printing anything
+var errSilentExit = errors.New("")
+
+// exitStatus makes the command exit with the status without printing anything, for the
+// commands whose status tells the outcome like `config --get`
+type exitStatus int
+
+func (s exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(s))
+}
+
 func ePrintf(format string, a ...any) {
 	fmt.Fprintf(os.Stderr, format, a...)
 }
 
 func must(err error) {
 	if err != nil {
-		ePrintf("%s\n", err)
+		var status exitStatus
+		if errors.As(err, &status) {
+			os.Exit(int(status))
+		}
+		if err != errSilentExit {
+			ePrintf("%s\n", err)
+		}
 		os.Exit(1)
 	}
 }
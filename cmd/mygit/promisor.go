@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/clone"
+	"github.com/codecrafters-io/git-starter-go/cmd/common"
+)
+
+// fetchPromisedObjects fetches the objects from the promisor remote, like git the trees
+// come without their blobs, which are fetched when they are needed
+func fetchPromisedObjects(repoLink string, hashes []string) error {
+	if repoLink == "" {
+		return fmt.Errorf("the promisor remote has no url")
+	}
+	discovery, err := clone.Discover(repoLink)
+	if err != nil {
+		return err
+	}
+	negotiated := clone.NegotiateCapabilities(
+		discovery.Capabilities, clone.NegotiationOptions{NoProgress: true, Filter: true},
+	)
+	response, err := clone.FetchPack(repoLink, discovery, clone.FetchRequest{
+		Wants:        hashes,
+		Capabilities: negotiated,
+		Filter:       "blob:none",
+	})
+	if err != nil {
+		return err
+	}
+	defer response.Close()
+	packPath, err := clone.StorePack(".", response, io.Discard)
+	if err != nil {
+		return err
+	}
+	return markPromisorPack(packPath)
+}
+
+// markPromisorPack writes the .promisor file next to a pack fetched from the promisor
+// remote, the objects its objects refer to may be missing without the repository being
+// corrupt
+func markPromisorPack(packPath string) error {
+	return os.WriteFile(strings.TrimSuffix(packPath, ".pack")+".promisor", nil, 0644)
+}
+
+// prefetchTree fetches the missing blobs of the tree at once, so that a checkout of a
+// partial clone does not fetch them one at a time
+func prefetchTree(repo *repository, treeHash string) error {
+	cfg, err := repo.config()
+	if err != nil {
+		return err
+	}
+	if _, partial := cfg.Get("extensions.partialclone"); !partial {
+		return nil
+	}
+	store, ok := repo.objects().(common.PromisorStore)
+	if !ok {
+		return nil
+	}
+	var blobs []string
+	var walk func(hash string) error
+	walk = func(hash string) error {
+		content, _, err := store.Get(hash)
+		if err != nil {
+			return err
+		}
+		entries, err := ParseTreeObjectBody(content)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			switch entry.GitMode {
+			case "40000":
+				if err := walk(hex.EncodeToString(entry.SHA[:])); err != nil {
+					return err
+				}
+			case "160000":
+				// a submodule commit is not an object of the repository
+			default:
+				blobs = append(blobs, hex.EncodeToString(entry.SHA[:]))
+			}
+		}
+		return nil
+	}
+	if err := walk(treeHash); err != nil {
+		return err
+	}
+	return store.Prefetch(blobs)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/common"
+	"github.com/codecrafters-io/git-starter-go/cmd/config"
+)
+
+// repository is the repository the commands work in, its working tree is the current
+// working directory
+//
+// The object store is opened the first time a command reads or writes an object, and the
+// configuration is read by the commands which need it, so that a broken configuration
+// only fails the commands which read it.
+type repository struct {
+	// gitDir is `.git`, or the current directory itself for a bare repository
+	gitDir string
+	store  common.ObjectStore
+}
+
+// openRepository returns the repository in the current working directory
+func openRepository() *repository {
+	return &repository{gitDir: common.GitDir(".")}
+}
+
+// objects returns the object store of the repository, the objects a partial clone left
+// out are fetched from its promisor remote
+func (r *repository) objects() common.ObjectStore {
+	if r.store == nil {
+		r.store = common.PromisorStore{ObjectStore: common.NewObjectStore("."), Fetch: r.fetchPromised}
+	}
+	return r.store
+}
+
+// fetchPromised fetches the objects from the promisor remote, the configuration naming it
+// is only read once an object is missing, and nothing is fetched outside of a partial clone
+func (r *repository) fetchPromised(hashes []string) error {
+	cfg, err := r.config()
+	if err != nil {
+		return err
+	}
+	remoteName, ok := cfg.Get("extensions.partialclone")
+	if !ok {
+		// the objects stay missing
+		return nil
+	}
+	repoLink, _ := cfg.Get("remote." + remoteName + ".url")
+	return fetchPromisedObjects(strings.TrimSuffix(repoLink, "/"), hashes)
+}
+
+// config reads the configuration of the repository along with the global one, or only
+// the global one outside of a repository
+func (r *repository) config() (*config.Config, error) {
+	if !r.exists() {
+		return config.Load("")
+	}
+	return config.Load(r.gitDir)
+}
+
+// exists reports whether the git directory of the repository exists
+func (r *repository) exists() bool {
+	_, err := os.Stat(r.gitDir)
+	return err == nil
+}
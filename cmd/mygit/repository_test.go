@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/common"
+)
+
+func TestRepositoryBrokenConfig(t *testing.T) {
+	inTempRepository(t)
+	if err := os.WriteFile(filepath.Join(os.Getenv("HOME"), ".gitconfig"), []byte("[broken\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the objects are read without the configuration
+	repo := openRepository()
+	hash, err := repo.objects().Put("blob", []byte("content\n"))
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if content, _, err := repo.objects().Get(hash); err != nil || string(content) != "content\n" {
+		t.Errorf("Get(%s) = %q, %v", hash, content, err)
+	}
+	// a missing object is only fetched in a partial clone, which needs the configuration
+	if _, _, err := repo.objects().Get(strings.Repeat("ab", 20)); err == nil || errors.Is(err, common.ErrObjectNotFound) {
+		t.Errorf("Get() of a missing object error = %v, want the configuration error", err)
+	}
+	if _, err := repo.config(); err == nil {
+		t.Error("config() reads a broken configuration")
+	}
+}
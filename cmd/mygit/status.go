@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/common"
+	"github.com/codecrafters-io/git-starter-go/cmd/refs"
+)
+
+// statusEntry is a tracked path which differs between HEAD, the index and the working tree
+type statusEntry struct {
+	path string
+	// staged is the change from HEAD to the index and unstaged the change from the index
+	// to the working tree: 'A' added, 'M' modified, 'D' deleted, 'T' type changed or
+	// ' ' unchanged
+	staged, unstaged byte
+	// the modes are 0 where the path is missing, like the hashes
+	headMode, indexMode, worktreeMode uint32
+	headSHA, indexSHA                 [20]byte
+}
+
+// repoStatus is what status found, the entries and the untracked paths are sorted
+type repoStatus struct {
+	// branch is the checked out branch, empty when HEAD is detached at head
+	branch  string
+	head    string
+	entries []statusEntry
+	// untracked are the paths which are not in the index, a directory without tracked
+	// files is listed once with a trailing slash
+	untracked []string
+}
+
+// statusCmd has the logic for the status subcommand
+//
+// It compares the tree of HEAD with the index for the changes to be committed, and the
+// index with the working tree for the changes not staged. A file whose stat data did not
+// change since it was staged is not read again, the others are hashed to tell whether
+// their content changed. The untracked files matched by the .gitignore files,
+// .git/info/exclude or core.excludesFile are left out.
+func statusCmd(repo *repository, opts statusOptions) error {
+	if !repo.exists() {
+		return errNotRepository
+	}
+	if repo.gitDir == "." {
+		return fmt.Errorf("status: this operation must be run in a work tree")
+	}
+	status, err := readStatus(repo)
+	if err != nil {
+		return fmt.Errorf("status: %w", err)
+	}
+	out := bufio.NewWriter(os.Stdout)
+	switch opts.format {
+	case "short", "porcelain":
+		printShortStatus(out, status, opts.nulTerminated)
+	case "porcelain=v2":
+		printPorcelainV2Status(out, status, opts.nulTerminated)
+	default:
+		cfg, err := repo.config()
+		if err != nil {
+			return fmt.Errorf("status: %w", err)
+		}
+		hints, err := cfg.GetBool("advice.statushints", true)
+		if err != nil {
+			return fmt.Errorf("status: %w", err)
+		}
+		printLongStatus(out, status, hints)
+	}
+	return out.Flush()
+}
+
+func readStatus(repo *repository) (*repoStatus, error) {
+	status := &repoStatus{}
+	headRef, headHash, err := refs.Follow(repo.gitDir, "HEAD")
+	// only the branch HEAD points to may be missing, when it is unborn
+	if err != nil && (!errors.Is(err, refs.ErrNotFound) || headRef == "HEAD") {
+		return nil, err
+	}
+	status.head = headHash
+	status.branch, _ = strings.CutPrefix(headRef, "refs/heads/")
+	if headRef == "HEAD" {
+		status.branch = ""
+	}
+	headFiles := map[string]GitTree{}
+	if headHash != "" {
+		treeHash, err := GetTreeHashFromCommit(headHash, repo.objects())
+		if err != nil {
+			return nil, err
+		}
+		err = walkTree(repo, treeHash, "", func(name string, entry GitTree) error {
+			headFiles[name] = entry
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	idx, err := common.ReadIndex(repo.gitDir)
+	if err != nil {
+		return nil, err
+	}
+	checker, err := newWorktreeChecker(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]*statusEntry{}
+	entry := func(name string) *statusEntry {
+		if entries[name] == nil {
+			entries[name] = &statusEntry{path: name, staged: ' ', unstaged: ' '}
+		}
+		return entries[name]
+	}
+	for _, indexEntry := range idx.Entries {
+		if indexEntry.Stage() != 0 {
+			continue
+		}
+		headFile, inHead := headFiles[indexEntry.Name]
+		headMode := uint32(0)
+		if inHead {
+			headMode = gitModeValue(headFile.GitMode)
+		}
+		staged := fileChange(headMode, indexEntry.Mode, !inHead || headFile.SHA != indexEntry.SHA)
+		unstaged, worktreeMode, err := checker.check(indexEntry)
+		if err != nil {
+			return nil, err
+		}
+		if staged == ' ' && unstaged == ' ' {
+			continue
+		}
+		e := entry(indexEntry.Name)
+		e.staged, e.unstaged = staged, unstaged
+		e.headMode, e.indexMode, e.worktreeMode = headMode, indexEntry.Mode, worktreeMode
+		e.headSHA, e.indexSHA = headFile.SHA, indexEntry.SHA
+	}
+	for name, headFile := range headFiles {
+		if _, staged := idx.Entry(name); !staged {
+			e := entry(name)
+			e.staged, e.headMode, e.headSHA = 'D', gitModeValue(headFile.GitMode), headFile.SHA
+		}
+	}
+	for _, e := range entries {
+		status.entries = append(status.entries, *e)
+	}
+	slices.SortFunc(status.entries, func(a, b statusEntry) int { return strings.Compare(a.path, b.path) })
+
+	if status.untracked, err = untrackedFiles(repo, idx); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// fileChange returns the change between two versions of a file given their modes, 0 when
+// the file is missing, and whether their content differs
+func fileChange(fromMode, toMode uint32, contentChanged bool) byte {
+	switch {
+	case fromMode == 0 && toMode == 0:
+		return ' '
+	case fromMode == 0:
+		return 'A'
+	case toMode == 0:
+		return 'D'
+	case fromMode&0170000 != toMode&0170000:
+		return 'T'
+	case contentChanged || fromMode != toMode:
+		return 'M'
+	}
+	return ' '
+}
+
+// gitModeValue parses the octal mode of a tree entry
+func gitModeValue(gitMode string) uint32 {
+	mode, _ := strconv.ParseUint(gitMode, 8, 32)
+	return uint32(mode)
+}
+
+// worktreeChecker compares the index entries with the files of the working tree
+type worktreeChecker struct {
+	// indexInfo is the stat data of the index file, for the racy entries
+	indexInfo os.FileInfo
+	// fileMode is false when the executable bit of the files is not trusted
+	fileMode bool
+}
+
+func newWorktreeChecker(repo *repository) (*worktreeChecker, error) {
+	cfg, err := repo.config()
+	if err != nil {
+		return nil, err
+	}
+	fileMode, err := cfg.GetBool("core.filemode", true)
+	if err != nil {
+		return nil, err
+	}
+	indexInfo, err := os.Stat(filepath.Join(repo.gitDir, "index"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return &worktreeChecker{indexInfo: indexInfo, fileMode: fileMode}, nil
+}
+
+// check returns the change of the working tree file from the index entry, along with the
+// mode of the file, 0 when it is missing
+func (c *worktreeChecker) check(entry common.IndexEntry) (byte, uint32, error) {
+	if entry.Mode == 0160000 {
+		// a submodule is not a file of this repository
+		return ' ', entry.Mode, nil
+	}
+	info, err := os.Lstat(entry.Name)
+	if err != nil || !info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0 {
+		// a missing file, a file under what is now a file, or a directory in its place
+		return 'D', 0, nil
+	}
+	mode := common.GitModeFromFileInfo(info)
+	if !c.fileMode && mode&0170000 == 0100000 && entry.Mode&0170000 == 0100000 {
+		mode = entry.Mode
+	}
+	if entry.StatMatches(info) && (c.indexInfo == nil || !entry.IsRacy(c.indexInfo.ModTime())) {
+		return fileChange(entry.Mode, mode, false), mode, nil
+	}
+	_, sha, err := readWorktreeFile(entry.Name, info)
+	if err != nil {
+		return 0, 0, err
+	}
+	return fileChange(entry.Mode, mode, sha != entry.SHA), mode, nil
+}
+
+// readWorktreeFile returns the content of the file or the target of the symbolic link,
+// along with the hash of the blob holding it
+func readWorktreeFile(name string, info os.FileInfo) ([]byte, [20]byte, error) {
+	var content []byte
+	var err error
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(name)
+		if err != nil {
+			return nil, [20]byte{}, err
+		}
+		content = []byte(target)
+	} else if content, err = os.ReadFile(name); err != nil {
+		return nil, [20]byte{}, err
+	}
+	sha, err := common.CalculateSHA(common.FormatGitObjectContent("blob", content))
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+	return content, sha, nil
+}
+
+// untrackedFiles returns the files of the working tree which are neither in the index nor
+// ignored, a directory holding no tracked file is returned instead of its files
+func untrackedFiles(repo *repository, idx *common.Index) ([]string, error) {
+	tracked, trackedDirs := map[string]bool{}, map[string]bool{}
+	for _, entry := range idx.Entries {
+		tracked[entry.Name] = true
+		for dir := path.Dir(entry.Name); dir != "."; dir = path.Dir(dir) {
+			trackedDirs[dir] = true
+		}
+	}
+	rules, err := newIgnoreRules(repo)
+	if err != nil {
+		return nil, err
+	}
+	var untracked []string
+	err = filepath.WalkDir(".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		switch {
+		case name == "." || tracked[name]:
+			return nil
+		case d.IsDir() && d.Name() == ".git":
+			return filepath.SkipDir
+		}
+		// nothing under an ignored directory is listed, even when it holds tracked files
+		ignored, err := rules.ignored(name, d.IsDir())
+		switch {
+		case err != nil:
+			return err
+		case ignored && d.IsDir():
+			return filepath.SkipDir
+		case ignored:
+			return nil
+		case d.IsDir() && !trackedDirs[name]:
+			found, err := rules.hasFiles(name)
+			if err != nil {
+				return err
+			}
+			if found {
+				untracked = append(untracked, name+"/")
+			}
+			return filepath.SkipDir
+		case !d.IsDir() && (d.Type().IsRegular() || d.Type()&fs.ModeSymlink != 0):
+			untracked = append(untracked, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(untracked)
+	return untracked, nil
+}
+
+// printLongStatus prints the status the way `git status` does, the hints telling which
+// commands to run are left out when advice.statusHints is false
+func printLongStatus(w io.Writer, status *repoStatus, hints bool) {
+	hint := func(text string) {
+		if hints {
+			fmt.Fprintf(w, "  (%s)\n", text)
+		}
+	}
+	switch {
+	case status.branch != "":
+		fmt.Fprintf(w, "On branch %s\n", status.branch)
+	case len(status.head) >= 7:
+		fmt.Fprintf(w, "HEAD detached at %s\n", status.head[:7])
+	default:
+		fmt.Fprintln(w, "Not currently on any branch.")
+	}
+	if status.head == "" {
+		fmt.Fprintf(w, "\nNo commits yet\n\n")
+	}
+
+	labels := map[byte]string{'A': "new file:", 'M': "modified:", 'D': "deleted:", 'T': "typechange:"}
+	var staged, unstaged []statusEntry
+	unstagedDeletion := false
+	for _, entry := range status.entries {
+		if entry.staged != ' ' {
+			staged = append(staged, entry)
+		}
+		if entry.unstaged != ' ' {
+			unstaged = append(unstaged, entry)
+			unstagedDeletion = unstagedDeletion || entry.unstaged == 'D'
+		}
+	}
+	if len(staged) > 0 {
+		fmt.Fprintln(w, "Changes to be committed:")
+		if status.head == "" {
+			hint(`use "git rm --cached <file>..." to unstage`)
+		} else {
+			hint(`use "git restore --staged <file>..." to unstage`)
+		}
+		for _, entry := range staged {
+			fmt.Fprintf(w, "\t%-12s%s\n", labels[entry.staged], common.QuotePath(entry.path, false))
+		}
+		fmt.Fprintln(w)
+	}
+	if len(unstaged) > 0 {
+		fmt.Fprintln(w, "Changes not staged for commit:")
+		if unstagedDeletion {
+			hint(`use "git add/rm <file>..." to update what will be committed`)
+		} else {
+			hint(`use "git add <file>..." to update what will be committed`)
+		}
+		hint(`use "git restore <file>..." to discard changes in working directory`)
+		for _, entry := range unstaged {
+			fmt.Fprintf(w, "\t%-12s%s\n", labels[entry.unstaged], common.QuotePath(entry.path, false))
+		}
+		fmt.Fprintln(w)
+	}
+	if len(status.untracked) > 0 {
+		fmt.Fprintln(w, "Untracked files:")
+		hint(`use "git add <file>..." to include in what will be committed`)
+		for _, name := range status.untracked {
+			fmt.Fprintf(w, "\t%s\n", common.QuotePath(name, false))
+		}
+		fmt.Fprintln(w)
+	}
+
+	withHint := func(message, text string) string {
+		if hints {
+			return message + " (" + text + ")"
+		}
+		return message
+	}
+	switch {
+	case len(staged) > 0:
+	case len(unstaged) > 0:
+		fmt.Fprintln(w, withHint("no changes added to commit", `use "git add" and/or "git commit -a"`))
+	case len(status.untracked) > 0:
+		fmt.Fprintln(w, withHint("nothing added to commit but untracked files present", `use "git add" to track`))
+	case status.head == "":
+		fmt.Fprintln(w, withHint("nothing to commit", `create/copy files and use "git add" to track`))
+	default:
+		fmt.Fprintln(w, "nothing to commit, working tree clean")
+	}
+}
+
+// printShortStatus prints the `XY <path>` lines of `status --short` and `--porcelain`,
+// X is the staged change and Y the unstaged one, the untracked files are `?? <path>`
+func printShortStatus(w io.Writer, status *repoStatus, nulTerminated bool) {
+	printLine := func(line, name string) {
+		if nulTerminated {
+			fmt.Fprintf(w, "%s%s\x00", line, name)
+		} else {
+			fmt.Fprintf(w, "%s%s\n", line, common.QuotePath(name, true))
+		}
+	}
+	for _, entry := range status.entries {
+		printLine(string([]byte{entry.staged, entry.unstaged, ' '}), entry.path)
+	}
+	for _, name := range status.untracked {
+		printLine("?? ", name)
+	}
+}
+
+// printPorcelainV2Status prints the lines of `status --porcelain=v2`
+//
+//	1 <XY> N... <mH> <mI> <mW> <hH> <hI> <path>
+//	? <path>
+//
+// where the unchanged side of XY is a '.', the modes are the ones of HEAD, the index and
+// the working tree, and the hashes the ones of HEAD and the index
+func printPorcelainV2Status(w io.Writer, status *repoStatus, nulTerminated bool) {
+	end, quote := "\n", func(name string) string { return common.QuotePath(name, false) }
+	if nulTerminated {
+		end, quote = "\x00", func(name string) string { return name }
+	}
+	dot := func(change byte) byte {
+		if change == ' ' {
+			return '.'
+		}
+		return change
+	}
+	for _, entry := range status.entries {
+		fmt.Fprintf(w, "1 %c%c N... %06o %06o %06o %s %s %s%s",
+			dot(entry.staged), dot(entry.unstaged),
+			entry.headMode, entry.indexMode, entry.worktreeMode,
+			hex.EncodeToString(entry.headSHA[:]), hex.EncodeToString(entry.indexSHA[:]),
+			quote(entry.path), end)
+	}
+	for _, name := range status.untracked {
+		fmt.Fprintf(w, "? %s%s", quote(name), end)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/cmd/common"
+	"github.com/codecrafters-io/git-starter-go/cmd/refs"
+)
+
+// testCommit makes the tree the one of a new commit of the current branch
+func testCommit(t *testing.T, repo *repository, tree [20]byte) {
+	t.Helper()
+	content := fmt.Sprintf("tree %s\nauthor A U Thor <author@example.com> 1700000000 +0000\n"+
+		"committer A U Thor <author@example.com> 1700000000 +0000\n\ncommit\n", hex.EncodeToString(tree[:]))
+	hash, err := writeObject(repo, "commit", []byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := refs.Update(repo.gitDir, "HEAD", hex.EncodeToString(hash[:])); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// stageTestFile adds the file to the index as it is in the working tree, but with the
+// hash of the given content
+func stageTestFile(t *testing.T, repo *repository, idx *common.Index, name, content string) {
+	t.Helper()
+	info, err := os.Lstat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.Add(common.NewIndexEntry(name, info, testBlob(t, repo, content)))
+}
+
+// setUpStatus builds a repository with every kind of change status reports
+func setUpStatus(t *testing.T) *repoStatus {
+	repo := inTempRepository(t)
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	one := testBlob(t, repo, "one\n")
+	testCommit(t, repo, testTree(t, repo,
+		GitTree{GitMode: "100644", Name: "deleted-staged", SHA: one},
+		GitTree{GitMode: "100644", Name: "deleted-worktree", SHA: one},
+		GitTree{GitMode: "40000", Name: "dir", SHA: testTree(t, repo,
+			GitTree{GitMode: "100644", Name: "c", SHA: one},
+		)},
+		GitTree{GitMode: "100644", Name: "exec", SHA: one},
+		GitTree{GitMode: "100644", Name: "link", SHA: one},
+		GitTree{GitMode: "100644", Name: "modified", SHA: one},
+		GitTree{GitMode: "100644", Name: "racy", SHA: one},
+		GitTree{GitMode: "100644", Name: "stale", SHA: one},
+	))
+
+	idx := &common.Index{Version: 2}
+	for _, name := range []string{"deleted-worktree", "dir/c", "exec", "link"} {
+		writeTestFile(t, name, "one\n", past)
+		stageTestFile(t, repo, idx, name, "one\n")
+	}
+	writeTestFile(t, "added", "two\n", past)
+	stageTestFile(t, repo, idx, "added", "two\n")
+	writeTestFile(t, "modified", "two\n", past)
+	stageTestFile(t, repo, idx, "modified", "two\n")
+	// both files changed without their size or time changing, only the one modified
+	// after the index was written is hashed again
+	writeTestFile(t, "stale", "two\n", past)
+	stageTestFile(t, repo, idx, "stale", "one\n")
+	writeTestFile(t, "racy", "two\n", future)
+	stageTestFile(t, repo, idx, "racy", "one\n")
+	if err := common.WriteIndex(repo.gitDir, idx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove("deleted-worktree"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod("exec", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove("link"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("exec", "link"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"dir/untracked", "new file", "newdir/sub/file"} {
+		writeTestFile(t, name, "new\n", past)
+	}
+	if err := os.MkdirAll("emptydir/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := readStatus(repo)
+	if err != nil {
+		t.Fatalf("readStatus() error = %v", err)
+	}
+	return status
+}
+
+func TestStatusShort(t *testing.T) {
+	status := setUpStatus(t)
+	var out bytes.Buffer
+	printShortStatus(&out, status, false)
+	want := `A  added
+D  deleted-staged
+ D deleted-worktree
+ M exec
+ T link
+M  modified
+ M racy
+?? dir/untracked
+?? "new file"
+?? newdir/
+`
+	if out.String() != want {
+		t.Errorf("short status is\n%s\nwant\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	printShortStatus(&out, status, true)
+	if !strings.HasSuffix(out.String(), "?? new file\x00?? newdir/\x00") {
+		t.Errorf("short status with -z ends with %q", out.String()[max(0, out.Len()-30):])
+	}
+}
+
+func TestStatusPorcelainV2(t *testing.T) {
+	status := setUpStatus(t)
+	var out bytes.Buffer
+	printPorcelainV2Status(&out, status, false)
+	repo := memoryRepository()
+	one, two := testBlob(t, repo, "one\n"), testBlob(t, repo, "two\n")
+	hash := func(sha [20]byte) string { return hex.EncodeToString(sha[:]) }
+	zero := strings.Repeat("0", 40)
+	want := strings.Join([]string{
+		"1 A. N... 000000 100644 100644 " + zero + " " + hash(two) + " added",
+		"1 D. N... 100644 000000 000000 " + hash(one) + " " + zero + " deleted-staged",
+		"1 .D N... 100644 100644 000000 " + hash(one) + " " + hash(one) + " deleted-worktree",
+		"1 .M N... 100644 100644 100755 " + hash(one) + " " + hash(one) + " exec",
+		"1 .T N... 100644 100644 120000 " + hash(one) + " " + hash(one) + " link",
+		"1 M. N... 100644 100644 100644 " + hash(one) + " " + hash(two) + " modified",
+		"1 .M N... 100644 100644 100644 " + hash(one) + " " + hash(one) + " racy",
+		"? dir/untracked",
+		"? new file",
+		"? newdir/",
+	}, "\n") + "\n"
+	if out.String() != want {
+		t.Errorf("porcelain v2 status is\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestStatusLong(t *testing.T) {
+	status := setUpStatus(t)
+	var out bytes.Buffer
+	printLongStatus(&out, status, true)
+	want := `On branch main
+Changes to be committed:
+  (use "git restore --staged <file>..." to unstage)
+	new file:   added
+	deleted:    deleted-staged
+	modified:   modified
+
+Changes not staged for commit:
+  (use "git add/rm <file>..." to update what will be committed)
+  (use "git restore <file>..." to discard changes in working directory)
+	deleted:    deleted-worktree
+	modified:   exec
+	typechange: link
+	modified:   racy
+
+Untracked files:
+  (use "git add <file>..." to include in what will be committed)
+	dir/untracked
+	new file
+	newdir/
+
+`
+	if out.String() != want {
+		t.Errorf("long status is\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestStatusLongMessages(t *testing.T) {
+	tests := []struct {
+		status repoStatus
+		hints  bool
+		want   string
+	}{
+		{
+			status: repoStatus{branch: "main"},
+			hints:  true,
+			want:   "On branch main\n\nNo commits yet\n\nnothing to commit (create/copy files and use \"git add\" to track)\n",
+		},
+		{
+			status: repoStatus{branch: "main", head: strings.Repeat("a", 40)},
+			want:   "On branch main\nnothing to commit, working tree clean\n",
+		},
+		{
+			status: repoStatus{head: strings.Repeat("a", 40), untracked: []string{"new"}},
+			want:   "HEAD detached at aaaaaaa\nUntracked files:\n\tnew\n\nnothing added to commit but untracked files present\n",
+		},
+		{
+			status: repoStatus{},
+			want:   "Not currently on any branch.\n\nNo commits yet\n\nnothing to commit\n",
+		},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		printLongStatus(&out, &test.status, test.hints)
+		if out.String() != test.want {
+			t.Errorf("long status of %+v is\n%q\nwant\n%q", test.status, out.String(), test.want)
+		}
+	}
+}
+
+func TestStatusNotRepository(t *testing.T) {
+	repo := inTempRepository(t)
+	if err := os.Remove(filepath.Join(repo.gitDir, "HEAD")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readStatus(repo); !errors.Is(err, refs.ErrNotFound) {
+		t.Errorf("readStatus() without HEAD error = %v, want %v", err, refs.ErrNotFound)
+	}
+	if err := os.RemoveAll(repo.gitDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := statusCmd(repo, statusOptions{}); !errors.Is(err, errNotRepository) {
+		t.Errorf("status outside of a repository error = %v, want %v", err, errNotRepository)
+	}
+}
+
+func TestStatusIgnored(t *testing.T) {
+	repo := inTempRepository(t)
+	past := time.Now().Add(-time.Hour)
+	ignores := map[string]string{
+		".gitignore":           "build/\n*.o\n!keep.o\nlogs/*\n!logs/keep.log\n",
+		"src/.gitignore":       "/gen\n",
+		".git/info/exclude":    "*.local\n",
+		".git/config":          "[core]\n\texcludesFile = global-ignore\n",
+		"global-ignore":        "*.tmp\nglobal-ignore\n",
+		"tracked/.gitignore":   "*\n",
+		"untracked/.gitignore": "*\n",
+	}
+	for name, content := range ignores {
+		writeTestFile(t, name, content, past)
+	}
+	for _, name := range []string{
+		"a.o", "keep.o", "build/x", "src/gen/x.go", "src/main.go", "src/gen.go",
+		"logs/1.log", "logs/keep.log", "a.local", "a.tmp", "tracked/file", "tracked/other",
+		"untracked/file", "onlyignored/a.o",
+	} {
+		writeTestFile(t, name, "x\n", past)
+	}
+	idx := &common.Index{Version: 2}
+	stageTestFile(t, repo, idx, "tracked/file", "x\n")
+	if err := common.WriteIndex(repo.gitDir, idx); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := readStatus(repo)
+	if err != nil {
+		t.Fatalf("readStatus() error = %v", err)
+	}
+	want := []string{".gitignore", "keep.o", "logs/", "src/"}
+	if !slices.Equal(status.untracked, want) {
+		t.Errorf("untracked files are %q, want %q", status.untracked, want)
+	}
+}
@@ -33,6 +33,8 @@ func modeFromGit(gitMode string) os.FileMode {
 		return 0755
 	case "40000":
 		return os.ModeDir | 0755
+	case "120000":
+		return os.ModeSymlink | 0777
 	default:
 		return 0644 // fallback
 	}
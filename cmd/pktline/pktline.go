@@ -0,0 +1,163 @@
+// Package pktline reads and writes the pkt-line framing of the git wire protocol
+//
+// Every packet starts with its length (including the 4 length bytes) as 4 lowercase hex
+// digits, followed by the payload. A few lengths are special packets without a payload,
+//
+//	0000  flush-pkt, ends a message
+//	0001  delim-pkt, separates the sections of a message (protocol v2)
+//	0002  response-end-pkt, ends the response of a stateless connection (protocol v2)
+//
+// See the [git documentation](https://git-scm.com/docs/protocol-common#_pkt_line_format)
+package pktline
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	// MaxLength is the largest packet, including the 4 length bytes
+	MaxLength = 65520
+	// MaxPayload is the largest payload of a single packet
+	MaxPayload = MaxLength - 4
+)
+
+// PacketType tells a data packet from the special packets
+type PacketType int
+
+const (
+	Data PacketType = iota
+	Flush
+	Delim
+	ResponseEnd
+)
+
+func (t PacketType) String() string {
+	switch t {
+	case Data:
+		return "data"
+	case Flush:
+		return "flush-pkt"
+	case Delim:
+		return "delim-pkt"
+	case ResponseEnd:
+		return "response-end-pkt"
+	default:
+		return fmt.Sprintf("invalid(%d)", int(t))
+	}
+}
+
+// ErrInvalidLength is returned for a length prefix which is not a valid packet length
+var ErrInvalidLength = errors.New("invalid pkt-line length")
+
+// Reader reads one packet at a time
+//
+// It never reads past the end of the packet it returns, so the underlying reader can
+// be used again directly, e.g. for the pack following the pkt-lines.
+type Reader struct {
+	r      io.Reader
+	buffer [MaxLength]byte
+}
+
+// NewReader returns a Reader reading the packets from r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// ReadPacket reads the next packet, the payload of a data packet is only valid until
+// the next call. A clean end of the input before a packet is reported as io.EOF.
+func (r *Reader) ReadPacket() (PacketType, []byte, error) {
+	header := r.buffer[:4]
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, nil, fmt.Errorf("read pkt-line length: %w", err)
+		}
+		return 0, nil, err
+	}
+	length, err := strconv.ParseUint(string(header), 16, 16)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %q", ErrInvalidLength, header)
+	}
+	switch {
+	case length == 0:
+		return Flush, nil, nil
+	case length == 1:
+		return Delim, nil, nil
+	case length == 2:
+		return ResponseEnd, nil, nil
+	case length < 4 || length > MaxLength:
+		return 0, nil, fmt.Errorf("%w: %q", ErrInvalidLength, header)
+	}
+	payload := r.buffer[4:length]
+	if _, err := io.ReadFull(r.r, payload); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, fmt.Errorf("read pkt-line payload: %w", err)
+	}
+	return Data, payload, nil
+}
+
+// ReadLine reads the next packet and returns the payload without the trailing '\n',
+// special packets are returned with an empty line
+func (r *Reader) ReadLine() (PacketType, string, error) {
+	packetType, payload, err := r.ReadPacket()
+	if err != nil {
+		return 0, "", err
+	}
+	if n := len(payload); n > 0 && payload[n-1] == '\n' {
+		payload = payload[:n-1]
+	}
+	return packetType, string(payload), nil
+}
+
+// Writer writes packets, nothing is buffered
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter returns a Writer writing the packets to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WritePacket writes the payload as a single data packet, an empty payload is not
+// allowed as it would be a flush-pkt
+func (w *Writer) WritePacket(payload []byte) error {
+	if len(payload) == 0 {
+		return fmt.Errorf("write pkt-line: empty payload")
+	}
+	if len(payload) > MaxPayload {
+		return fmt.Errorf("write pkt-line: payload of %d bytes is too long", len(payload))
+	}
+	packet := make([]byte, 0, 4+len(payload))
+	packet = fmt.Appendf(packet, "%04x", len(payload)+4)
+	packet = append(packet, payload...)
+	_, err := w.w.Write(packet)
+	return err
+}
+
+// WriteLine writes the formatted line as a data packet ending with '\n'
+func (w *Writer) WriteLine(format string, a ...any) error {
+	return w.WritePacket([]byte(fmt.Sprintf(format, a...) + "\n"))
+}
+
+// Flush writes a flush-pkt
+func (w *Writer) Flush() error {
+	_, err := io.WriteString(w.w, "0000")
+	return err
+}
+
+// Delim writes a delim-pkt
+func (w *Writer) Delim() error {
+	_, err := io.WriteString(w.w, "0001")
+	return err
+}
+
+// ResponseEnd writes a response-end-pkt
+func (w *Writer) ResponseEnd() error {
+	_, err := io.WriteString(w.w, "0002")
+	return err
+}
@@ -0,0 +1,80 @@
+package pktline
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWriteAndRead(t *testing.T) {
+	var buffer bytes.Buffer
+	writer := NewWriter(&buffer)
+	binary := []byte{0x01, 0x00, 'P', 'A', 'C', 'K', 0xff}
+	steps := []error{
+		writer.WriteLine("command=%s", "ls-refs"),
+		writer.Delim(),
+		writer.WritePacket(binary),
+		writer.Flush(),
+		writer.ResponseEnd(),
+	}
+	for i, err := range steps {
+		if err != nil {
+			t.Fatalf("write step %d error = %v", i, err)
+		}
+	}
+	expected := "0014command=ls-refs\n0001000b\x01\x00PACK\xff00000002"
+	if buffer.String() != expected {
+		t.Fatalf("written %q, expected %q", buffer.String(), expected)
+	}
+
+	reader := NewReader(&buffer)
+	packetType, line, err := reader.ReadLine()
+	if err != nil || packetType != Data || line != "command=ls-refs" {
+		t.Errorf("ReadLine() = %s, %q, %v", packetType, line, err)
+	}
+	for _, expected := range []PacketType{Delim, Data, Flush, ResponseEnd} {
+		packetType, payload, err := reader.ReadPacket()
+		if err != nil || packetType != expected {
+			t.Errorf("ReadPacket() = %s, %v, expected %s", packetType, err, expected)
+		}
+		if packetType == Data && !bytes.Equal(payload, binary) {
+			t.Errorf("ReadPacket() payload = %x, expected %x", payload, binary)
+		}
+	}
+	if _, _, err := reader.ReadPacket(); !errors.Is(err, io.EOF) {
+		t.Errorf("ReadPacket() at the end error = %v, expected io.EOF", err)
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	tests := map[string]string{
+		"not hex":           "zzzzhello",
+		"length 3":          "0003",
+		"too long":          "fff1",
+		"truncated payload": "000ahel",
+		"truncated length":  "00",
+	}
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := NewReader(strings.NewReader(input)).ReadPacket()
+			if err == nil || errors.Is(err, io.EOF) {
+				t.Errorf("ReadPacket(%q) error = %v, expected a malformed input error", input, err)
+			}
+		})
+	}
+}
+
+func TestWriteInvalid(t *testing.T) {
+	writer := NewWriter(io.Discard)
+	if err := writer.WritePacket(nil); err == nil {
+		t.Errorf("WritePacket() of an empty payload did not fail")
+	}
+	if err := writer.WritePacket(make([]byte, MaxPayload+1)); err == nil {
+		t.Errorf("WritePacket() of a too long payload did not fail")
+	}
+	if err := writer.WritePacket(make([]byte, MaxPayload)); err != nil {
+		t.Errorf("WritePacket() of the largest payload error = %v", err)
+	}
+}
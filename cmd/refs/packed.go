@@ -0,0 +1,130 @@
+package refs
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const packedRefsHeader = "# pack-refs with: sorted \n"
+
+// ReadPackedRefs parses the `.git/packed-refs` file
+//
+// The file has a `<hash> <name>` line per ref, an annotated tag can be followed by
+// a `^<hash>` line with the object the tag points to (the peeled value).
+// A missing file is the same as an empty one.
+func ReadPackedRefs(gitDir string) ([]Ref, error) {
+	content, err := os.ReadFile(filepath.Join(gitDir, "packed-refs"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read packed-refs: %w", err)
+	}
+	return ParsePackedRefs(content)
+}
+
+// ParsePackedRefs parses the content of a packed-refs file
+func ParsePackedRefs(content []byte) ([]Ref, error) {
+	var result []Ref
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "^"):
+			if len(result) == 0 || !isHash(line[1:]) {
+				return nil, fmt.Errorf("packed-refs line %d: invalid peeled line %q", lineNum, line)
+			}
+			result[len(result)-1].Peeled = line[1:]
+		default:
+			hash, name, ok := strings.Cut(line, " ")
+			if !ok || !isHash(hash) || name == "" {
+				return nil, fmt.Errorf("packed-refs line %d: invalid ref line %q", lineNum, line)
+			}
+			result = append(result, Ref{Name: name, Hash: hash})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("parse packed-refs: %w", err)
+	}
+	return result, nil
+}
+
+// WritePackedRefs replaces the `.git/packed-refs` file with the given refs
+//
+// The refs are sorted by name, symbolic refs can not be packed and are rejected
+func WritePackedRefs(gitDir string, refList []Ref) error {
+	refList = append([]Ref(nil), refList...)
+	sort.Slice(refList, func(i, j int) bool {
+		return refList[i].Name < refList[j].Name
+	})
+	var buffer bytes.Buffer
+	buffer.WriteString(packedRefsHeader)
+	for _, ref := range refList {
+		if ref.IsSymbolic() {
+			return fmt.Errorf("write packed-refs: can not pack symbolic ref %s", ref.Name)
+		}
+		if !isHash(ref.Hash) {
+			return fmt.Errorf("write packed-refs: invalid object id %q for %s", ref.Hash, ref.Name)
+		}
+		fmt.Fprintf(&buffer, "%s %s\n", ref.Hash, ref.Name)
+		if ref.Peeled != "" {
+			fmt.Fprintf(&buffer, "^%s\n", ref.Peeled)
+		}
+	}
+	if err := writeLocked(filepath.Join(gitDir, "packed-refs"), buffer.Bytes()); err != nil {
+		return fmt.Errorf("write packed-refs: %w", err)
+	}
+	return nil
+}
+
+// deletePacked removes the ref from the packed-refs file at path, reporting whether it was
+// there, the lock of the file taken by the caller is released
+//
+// Only the lines of the ref are dropped, the header keeps the traits telling git whether
+// to trust the peeled lines.
+func deletePacked(lockFile *os.File, path, name string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		unlock(lockFile)
+		return false, nil
+	}
+	if err == nil {
+		_, err = ParsePackedRefs(content)
+	}
+	if err != nil {
+		unlock(lockFile)
+		return false, fmt.Errorf("read packed-refs: %w", err)
+	}
+	var kept []byte
+	found, inRef := false, false
+	for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("^")) {
+			// the peeled line goes along with the ref before it
+			if !inRef {
+				kept = append(kept, line...)
+			}
+			continue
+		}
+		_, lineName, _ := strings.Cut(strings.TrimSuffix(string(line), "\n"), " ")
+		inRef = !bytes.HasPrefix(line, []byte("#")) && lineName == name
+		if inRef {
+			found = true
+			continue
+		}
+		kept = append(kept, line...)
+	}
+	if !found {
+		unlock(lockFile)
+		return false, nil
+	}
+	return true, commitLock(lockFile, path, kept)
+}
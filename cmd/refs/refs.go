@@ -0,0 +1,348 @@
+// Package refs reads and writes git references
+//
+// A reference is either stored as a loose file under `.git/refs` (or `.git/HEAD`),
+// or inside the `.git/packed-refs` file. A loose ref always takes precedence over
+// the packed one with the same name. A symbolic ref (e.g. HEAD) contains
+// `ref: <other-ref>` instead of an object hash.
+//
+// All the functions take the path of the git directory (usually `.git`).
+package refs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const (
+	symbolicPrefix = "ref: "
+	// maxSymbolicDepth is the number of symbolic refs we follow before giving up,
+	// it is the same limit git uses
+	maxSymbolicDepth = 5
+)
+
+var (
+	// ErrNotFound is returned when the ref (or the ref a symbolic ref points to) does not exist
+	ErrNotFound = errors.New("ref not found")
+	// ErrNotSymbolic is returned when reading a symbolic ref which contains a hash
+	ErrNotSymbolic = errors.New("not a symbolic ref")
+)
+
+// Ref is a single reference
+type Ref struct {
+	// Name is the full name of the ref e.g. "refs/heads/main" or "HEAD"
+	Name string
+	// Hash is the 40 character hex object id the ref resolves to, it is empty for
+	// a symbolic ref pointing to an unborn branch
+	Hash string
+	// Peeled is the object an annotated tag points to, it is only known for refs
+	// read from the packed-refs file
+	Peeled string
+	// Target is the ref a symbolic ref points to, empty for regular refs
+	Target string
+}
+
+// IsSymbolic reports whether the ref points to another ref
+func (r Ref) IsSymbolic() bool {
+	return r.Target != ""
+}
+
+// Resolve returns the hash the ref resolves to, following symbolic refs
+func Resolve(gitDir, name string) (string, error) {
+	_, hash, err := Follow(gitDir, name)
+	return hash, err
+}
+
+// Follow follows the chain of symbolic refs starting at name and returns the name of the
+// final (non symbolic) ref along with its hash
+//
+// When the final ref does not exist (e.g. HEAD pointing to an unborn branch) its name is
+// returned with ErrNotFound
+func Follow(gitDir, name string) (string, string, error) {
+	for range maxSymbolicDepth {
+		ref, err := readRef(gitDir, name)
+		if err != nil {
+			return name, "", err
+		}
+		if !ref.IsSymbolic() {
+			return name, ref.Hash, nil
+		}
+		name = ref.Target
+	}
+	return "", "", fmt.Errorf("%s: too many levels of symbolic refs", name)
+}
+
+// ReadSymbolic returns the ref the symbolic ref name points to
+func ReadSymbolic(gitDir, name string) (string, error) {
+	content, err := os.ReadFile(loosePath(gitDir, name))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("%s: %w", name, ErrNotFound)
+		}
+		return "", fmt.Errorf("read ref %s: %w", name, err)
+	}
+	target, ok := strings.CutPrefix(strings.TrimSpace(string(content)), symbolicPrefix)
+	if !ok {
+		return "", fmt.Errorf("%s: %w", name, ErrNotSymbolic)
+	}
+	return target, nil
+}
+
+// WriteSymbolic makes name a symbolic ref pointing to target
+func WriteSymbolic(gitDir, name, target string) error {
+	if !ValidName(target) {
+		return fmt.Errorf("refusing to point %s to invalid ref %q", name, target)
+	}
+	return writeLoose(gitDir, name, symbolicPrefix+target+"\n")
+}
+
+// Update points the ref to the hash, if name is a symbolic ref the ref it points to is updated
+func Update(gitDir, name, hash string) error {
+	finalName, _, err := Follow(gitDir, name)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return UpdateNoDeref(gitDir, finalName, hash)
+}
+
+// UpdateNoDeref writes the hash to the loose ref name, even when name is a symbolic ref
+func UpdateNoDeref(gitDir, name, hash string) error {
+	if !isHash(hash) {
+		return fmt.Errorf("update ref %s: invalid object id %q", name, hash)
+	}
+	return writeLoose(gitDir, name, hash+"\n")
+}
+
+// Delete removes the ref from both the loose refs and the packed-refs file
+//
+// The packed-refs file is locked first, so that a concurrent writer can not bring the
+// packed ref back once the loose one is gone.
+func Delete(gitDir, name string) error {
+	packedPath := filepath.Join(gitDir, "packed-refs")
+	lockFile, err := lock(packedPath)
+	if err != nil {
+		return fmt.Errorf("delete ref %s: %w", name, err)
+	}
+	found := false
+	err = os.Remove(loosePath(gitDir, name))
+	switch {
+	case err == nil:
+		found = true
+	case !errors.Is(err, fs.ErrNotExist):
+		unlock(lockFile)
+		return fmt.Errorf("delete ref %s: %w", name, err)
+	}
+
+	packed, err := deletePacked(lockFile, packedPath, name)
+	if err != nil {
+		return fmt.Errorf("delete ref %s: %w", name, err)
+	}
+	if !found && !packed {
+		return fmt.Errorf("delete ref %s: %w", name, ErrNotFound)
+	}
+	return nil
+}
+
+// List returns the refs whose name starts with prefix sorted by name, symbolic refs are
+// resolved and returned with both the Target and Hash set
+func List(gitDir, prefix string) ([]Ref, error) {
+	packed, err := ReadPackedRefs(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]Ref{}
+	for _, ref := range packed {
+		byName[ref.Name] = ref
+	}
+
+	refsDir := filepath.Join(gitDir, "refs")
+	err = filepath.WalkDir(refsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == refsDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+		rel, err := filepath.Rel(gitDir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		ref, err := readLoose(gitDir, name)
+		if err != nil {
+			return err
+		}
+		byName[name] = ref
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list refs: %w", err)
+	}
+
+	result := make([]Ref, 0, len(byName))
+	for name, ref := range byName {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if ref.IsSymbolic() {
+			hash, err := Resolve(gitDir, ref.Target)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				return nil, err
+			}
+			ref.Hash = hash
+		}
+		result = append(result, ref)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+// ValidName does a basic sanity check of the ref name similar to `git check-ref-format`
+func ValidName(name string) bool {
+	if name == "" || name == "@" || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") {
+		return false
+	}
+	if strings.Contains(name, "..") || strings.Contains(name, "//") || strings.Contains(name, "@{") {
+		return false
+	}
+	if strings.HasSuffix(name, ".") || strings.HasSuffix(name, ".lock") {
+		return false
+	}
+	for _, component := range strings.Split(name, "/") {
+		if strings.HasPrefix(component, ".") {
+			return false
+		}
+	}
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f || strings.ContainsRune(" ~^:?*[\\", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// readRef reads the ref from the loose file falling back to the packed-refs file
+func readRef(gitDir, name string) (Ref, error) {
+	ref, err := readLoose(gitDir, name)
+	if err == nil {
+		return ref, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return Ref{}, err
+	}
+	packed, err := ReadPackedRefs(gitDir)
+	if err != nil {
+		return Ref{}, err
+	}
+	for _, ref := range packed {
+		if ref.Name == name {
+			return ref, nil
+		}
+	}
+	return Ref{}, fmt.Errorf("%s: %w", name, ErrNotFound)
+}
+
+func readLoose(gitDir, name string) (Ref, error) {
+	content, err := os.ReadFile(loosePath(gitDir, name))
+	if err != nil {
+		// a directory (e.g. refs/heads) with the same name is not a ref either
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) ||
+			isDirError(gitDir, name) {
+			return Ref{}, fmt.Errorf("%s: %w", name, ErrNotFound)
+		}
+		return Ref{}, fmt.Errorf("read ref %s: %w", name, err)
+	}
+	value := strings.TrimSpace(string(content))
+	if target, ok := strings.CutPrefix(value, symbolicPrefix); ok {
+		return Ref{Name: name, Target: target}, nil
+	}
+	if !isHash(value) {
+		return Ref{}, fmt.Errorf("read ref %s: invalid content %q", name, value)
+	}
+	return Ref{Name: name, Hash: value}, nil
+}
+
+func isDirError(gitDir, name string) bool {
+	info, err := os.Stat(loosePath(gitDir, name))
+	return err == nil && info.IsDir()
+}
+
+// writeLoose writes the content to the loose ref through a lock file
+func writeLoose(gitDir, name, content string) error {
+	if !ValidName(name) {
+		return fmt.Errorf("invalid ref name %q", name)
+	}
+	refPath := loosePath(gitDir, name)
+	if err := os.MkdirAll(filepath.Dir(refPath), 0755); err != nil {
+		return fmt.Errorf("write ref %s: %w", name, err)
+	}
+	return writeLocked(refPath, []byte(content))
+}
+
+// writeLocked writes the content to `<path>.lock` and renames it to path once done
+func writeLocked(path string, content []byte) error {
+	lockFile, err := lock(path)
+	if err != nil {
+		return err
+	}
+	return commitLock(lockFile, path, content)
+}
+
+// lock creates `<path>.lock`, the other writers of path fail until the lock is committed
+// with commitLock or removed with unlock
+func lock(path string) (*os.File, error) {
+	lockFile, err := os.OpenFile(path+".lock", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("create lock file: %w", err)
+	}
+	return lockFile, nil
+}
+
+// commitLock writes the content to the lock file and renames it to path
+func commitLock(lockFile *os.File, path string, content []byte) error {
+	_, err := lockFile.Write(content)
+	closeErr := lockFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(lockFile.Name(), path)
+	}
+	if err != nil {
+		os.Remove(lockFile.Name())
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+// unlock removes the lock file, leaving path as it was
+func unlock(lockFile *os.File) {
+	lockFile.Close()
+	os.Remove(lockFile.Name())
+}
+
+func loosePath(gitDir, name string) string {
+	return filepath.Join(gitDir, filepath.FromSlash(name))
+}
+
+func isHash(value string) bool {
+	if len(value) != 40 {
+		return false
+	}
+	for _, c := range []byte(value) {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+package refs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	hashA = "47b37f1a82bfe85f6d8df52b6258b75e4343b7fd"
+	hashB = "915afa45210c408ad4f05cc19fe18acb4375128a"
+)
+
+func TestParsePackedRefs(t *testing.T) {
+	content := []byte("# pack-refs with: peeled fully-peeled sorted \n" +
+		hashA + " refs/heads/main\n" +
+		hashB + " refs/tags/v1\n" +
+		"^" + hashA + "\n")
+	refList, err := ParsePackedRefs(content)
+	if err != nil {
+		t.Fatalf("ParsePackedRefs() error = %v", err)
+	}
+	expected := []Ref{
+		{Name: "refs/heads/main", Hash: hashA},
+		{Name: "refs/tags/v1", Hash: hashB, Peeled: hashA},
+	}
+	if len(refList) != len(expected) {
+		t.Fatalf("ParsePackedRefs() got %d refs, expected %d", len(refList), len(expected))
+	}
+	for i := range expected {
+		if refList[i] != expected[i] {
+			t.Errorf("ref %d = %+v, expected %+v", i, refList[i], expected[i])
+		}
+	}
+
+	if _, err := ParsePackedRefs([]byte("^" + hashA + "\n")); err == nil {
+		t.Errorf("ParsePackedRefs() error = nil, expected error for a dangling peeled line")
+	}
+}
+
+func TestSymbolicAndPackedResolution(t *testing.T) {
+	gitDir := t.TempDir()
+	if err := WriteSymbolic(gitDir, "HEAD", "refs/heads/main"); err != nil {
+		t.Fatalf("WriteSymbolic() error = %v", err)
+	}
+	name, _, err := Follow(gitDir, "HEAD")
+	if !errors.Is(err, ErrNotFound) || name != "refs/heads/main" {
+		t.Fatalf("Follow() on unborn branch = %q, %v", name, err)
+	}
+
+	if err := WritePackedRefs(gitDir, []Ref{{Name: "refs/heads/main", Hash: hashA}}); err != nil {
+		t.Fatalf("WritePackedRefs() error = %v", err)
+	}
+	if hash, err := Resolve(gitDir, "HEAD"); err != nil || hash != hashA {
+		t.Fatalf("Resolve() = %q, %v, expected packed value %q", hash, err, hashA)
+	}
+
+	// the loose ref takes precedence over the packed one
+	if err := Update(gitDir, "HEAD", hashB); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if hash, err := Resolve(gitDir, "refs/heads/main"); err != nil || hash != hashB {
+		t.Fatalf("Resolve() = %q, %v, expected loose value %q", hash, err, hashB)
+	}
+
+	refList, err := List(gitDir, "refs/heads/")
+	if err != nil || len(refList) != 1 || refList[0].Hash != hashB {
+		t.Fatalf("List() = %+v, %v", refList, err)
+	}
+
+	if err := Delete(gitDir, "refs/heads/main"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := Resolve(gitDir, "refs/heads/main"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve() after Delete() error = %v, expected ErrNotFound", err)
+	}
+}
+
+func TestDeletePacked(t *testing.T) {
+	gitDir := t.TempDir()
+	packedPath := filepath.Join(gitDir, "packed-refs")
+	content := "# pack-refs with: peeled fully-peeled sorted \n" +
+		hashA + " refs/heads/main\n" +
+		hashA + " refs/tags/v1\n^" + hashB + "\n" +
+		hashB + " refs/tags/v2\n^" + hashA + "\n"
+	if err := os.WriteFile(packedPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a writer holding the lock keeps the file as it is
+	if err := os.WriteFile(packedPath+".lock", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Delete(gitDir, "refs/tags/v1"); err == nil {
+		t.Errorf("Delete() while packed-refs is locked did not fail")
+	}
+	if err := os.Remove(packedPath + ".lock"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the ref goes with its peeled line, the traits of the header stay
+	if err := Delete(gitDir, "refs/tags/v1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	want := "# pack-refs with: peeled fully-peeled sorted \n" +
+		hashA + " refs/heads/main\n" +
+		hashB + " refs/tags/v2\n^" + hashA + "\n"
+	if got, _ := os.ReadFile(packedPath); string(got) != want {
+		t.Errorf("packed-refs after Delete() is\n%s\nwant\n%s", got, want)
+	}
+	if err := Delete(gitDir, "refs/tags/v1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete() of a missing ref error = %v, expected ErrNotFound", err)
+	}
+	if _, err := os.Stat(packedPath + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("Delete() leaves the lock file: %v", err)
+	}
+}
+
+func TestRefspec(t *testing.T) {
+	spec, err := ParseRefspec("+refs/heads/*:refs/remotes/origin/*")
+	if err != nil {
+		t.Fatalf("ParseRefspec() error = %v", err)
+	}
+	if !spec.Force || spec.String() != "+refs/heads/*:refs/remotes/origin/*" {
+		t.Errorf("ParseRefspec() = %+v", spec)
+	}
+	if dst, ok := spec.Match("refs/heads/feature/x"); !ok || dst != "refs/remotes/origin/feature/x" {
+		t.Errorf("Match() = %q, %v", dst, ok)
+	}
+	if _, ok := spec.Match("refs/tags/v1"); ok {
+		t.Errorf("Match() matched a tag")
+	}
+	if src, ok := spec.Reverse("refs/remotes/origin/main"); !ok || src != "refs/heads/main" {
+		t.Errorf("Reverse() = %q, %v", src, ok)
+	}
+
+	short, err := ParseRefspec("main:refs/remotes/origin/main")
+	if err != nil {
+		t.Fatalf("ParseRefspec() error = %v", err)
+	}
+	if dst, ok := short.Match("refs/heads/main"); short.Force || !ok || dst != "refs/remotes/origin/main" {
+		t.Errorf("Match() = %q, %v", dst, ok)
+	}
+
+	negative, err := ParseRefspec("^refs/heads/tmp/*")
+	if err != nil {
+		t.Fatalf("ParseRefspec() error = %v", err)
+	}
+	if !negative.Negative || negative.String() != "^refs/heads/tmp/*" {
+		t.Errorf("ParseRefspec() = %+v", negative)
+	}
+	if !Excluded([]Refspec{spec, negative}, "refs/heads/tmp/x") || Excluded([]Refspec{spec, negative}, "refs/heads/main") {
+		t.Errorf("Excluded() does not follow the negative refspec")
+	}
+
+	for _, invalid := range []string{"", "+", ":refs/heads/x", "refs/heads/*", "refs/heads/*:refs/x", "a*b*:c*",
+		"^", "+^refs/heads/x", "^refs/heads/x:refs/heads/y"} {
+		if _, err := ParseRefspec(invalid); err == nil {
+			t.Errorf("ParseRefspec(%q) did not fail", invalid)
+		}
+	}
+}
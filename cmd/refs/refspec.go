@@ -0,0 +1,132 @@
+package refs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Refspec maps the refs of a remote to local refs, it is written `[+]<src>[:<dst>]`
+//
+// Both sides can have a single `*` which matches any part of the name, as in the
+// default `+refs/heads/*:refs/remotes/origin/*`. The `+` allows the local ref to be
+// updated even when it is not a fast-forward. A negative refspec `^<src>` has no
+// destination, it excludes the refs its source matches from the other refspecs.
+type Refspec struct {
+	Force    bool
+	Negative bool
+	Src      string
+	// Dst is empty when the remote ref is fetched without being stored in a local ref
+	Dst string
+}
+
+// ParseRefspec parses a fetch refspec
+func ParseRefspec(spec string) (Refspec, error) {
+	refspec := Refspec{}
+	rest, force := strings.CutPrefix(spec, "+")
+	refspec.Force = force
+	rest, refspec.Negative = strings.CutPrefix(rest, "^")
+	refspec.Src, refspec.Dst, _ = strings.Cut(rest, ":")
+	if refspec.Src == "" {
+		return Refspec{}, fmt.Errorf("invalid refspec %q: missing source", spec)
+	}
+	if refspec.Negative {
+		if refspec.Force || refspec.Dst != "" || strings.Count(refspec.Src, "*") > 1 {
+			return Refspec{}, fmt.Errorf("invalid refspec %q: a negative refspec only has a source", spec)
+		}
+		return refspec, nil
+	}
+	srcGlob, dstGlob := strings.Count(refspec.Src, "*"), strings.Count(refspec.Dst, "*")
+	if srcGlob > 1 || dstGlob > 1 || (refspec.Dst != "" && srcGlob != dstGlob) {
+		return Refspec{}, fmt.Errorf("invalid refspec %q: invalid pattern", spec)
+	}
+	if srcGlob == 1 && refspec.Dst == "" {
+		return Refspec{}, fmt.Errorf("invalid refspec %q: a pattern needs a destination", spec)
+	}
+	if refspec.Dst != "" && !ValidName(strings.Replace(refspec.Dst, "*", "x", 1)) {
+		return Refspec{}, fmt.Errorf("invalid refspec %q: invalid destination", spec)
+	}
+	return refspec, nil
+}
+
+// IsGlob reports whether the refspec is a pattern matching several refs
+func (r Refspec) IsGlob() bool {
+	return strings.Contains(r.Src, "*")
+}
+
+// Match reports whether the remote ref name matches the source of the refspec and
+// returns the local ref it maps to
+//
+// Like git, a source which does not start with "refs/" also matches the branch or the
+// tag with that name, e.g. `main` matches `refs/heads/main`. A negative refspec matches
+// the names it excludes, with an empty local ref.
+func (r Refspec) Match(name string) (string, bool) {
+	if r.IsGlob() {
+		matched, ok := matchGlob(r.Src, name)
+		if !ok {
+			return "", false
+		}
+		return strings.Replace(r.Dst, "*", matched, 1), true
+	}
+	if name == r.Src {
+		return r.Dst, true
+	}
+	if !strings.HasPrefix(r.Src, "refs/") {
+		for _, prefix := range []string{"refs/", "refs/tags/", "refs/heads/"} {
+			if name == prefix+r.Src {
+				return r.Dst, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Reverse maps a local ref back to the remote ref it comes from, it is the remote ref
+// the local one would be pruned for
+func (r Refspec) Reverse(local string) (string, bool) {
+	if r.Dst == "" || r.Negative {
+		return "", false
+	}
+	if !r.IsGlob() {
+		return r.Src, local == r.Dst
+	}
+	matched, ok := matchGlob(r.Dst, local)
+	if !ok {
+		return "", false
+	}
+	return strings.Replace(r.Src, "*", matched, 1), true
+}
+
+func (r Refspec) String() string {
+	spec := r.Src
+	if r.Dst != "" {
+		spec += ":" + r.Dst
+	}
+	if r.Negative {
+		spec = "^" + spec
+	}
+	if r.Force {
+		spec = "+" + spec
+	}
+	return spec
+}
+
+// matchGlob matches the name against a pattern with a single `*`, and returns the part
+// of the name matched by the `*`
+func matchGlob(pattern, name string) (string, bool) {
+	prefix, suffix, _ := strings.Cut(pattern, "*")
+	if len(name) < len(prefix)+len(suffix) ||
+		!strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+	return name[len(prefix) : len(name)-len(suffix)], true
+}
+
+// Excluded reports whether a negative refspec among the refspecs matches the remote ref
+func Excluded(refspecs []Refspec, name string) bool {
+	for _, refspec := range refspecs {
+		if _, ok := refspec.Match(name); ok && refspec.Negative {
+			return true
+		}
+	}
+	return false
+}